@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"prun/internal/config"
+	"prun/internal/runner"
+)
+
+// runCheck implements `prun check`: it validates the config, with --fix
+// corrects what can be fixed safely, and with --simulate runs the tasks
+// against scripted fakes and prints the timeline of what prun did
+func runCheck(configPath, profile string, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	simulate := fs.Bool("simulate", false, "run the tasks as scripted fakes from the [simulate] table")
+	fix := fs.Bool("fix", false, "rewrite the config to fix what can be fixed safely")
+	yes := fs.Bool("yes", false, "with --fix, apply fixes without asking")
+	sortTasks := fs.Bool("sort", false, "with --fix, sort the tasks array")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeRunFailed
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %s: %v\n", configPath, err)
+		if os.IsNotExist(err) {
+			return exitCodeConfigNotFound
+		}
+		return exitCodeParseFailed
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "prun: warning: %s\n", warning)
+	}
+
+	unlisted := cfg.Unlisted()
+	if *fix {
+		fixed, err := fixConfig(configPath, unlisted, *yes, *sortTasks)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "prun: %s: %v\n", configPath, err)
+			return exitCodeParseFailed
+		}
+		if fixed {
+			if cfg, err = config.Load(configPath); err != nil {
+				fmt.Fprintf(os.Stderr, "prun: %s: %v\n", configPath, err)
+				return exitCodeParseFailed
+			}
+		}
+	} else {
+		for _, name := range unlisted {
+			fmt.Printf("%s: task '%s' is not in tasks and only runs when named (fix with --fix)\n", configPath, name)
+		}
+	}
+
+	tasks, _, err := cfg.GetTasksToRun(fs.Args(), profile, config.OrderArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+
+	if err := checkWatch(cfg, tasks); err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %s: %v\n", configPath, err)
+		return exitCodeParseFailed
+	}
+
+	if !*simulate {
+		fmt.Printf("%s: ok (%d tasks)\n", configPath, len(tasks))
+		return 0
+	}
+
+	// Run the real runner against the fakes, collecting its events
+	r := runner.New(cfg.Simulated(), tasks, false)
+	events := make(chan runner.LogEvent, 100)
+	r.SetEventChannel(events)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- r.Run(context.Background())
+		close(events)
+	}()
+
+	start := time.Now()
+	for ev := range events {
+		elapsed := ev.Time.Sub(start).Seconds()
+		switch {
+		case ev.Status == runner.StatusFailed:
+			fmt.Printf("%+7.2fs  %-16s failed (exit %d)\n", elapsed, ev.Task, ev.ExitCode)
+		case ev.IsStatus():
+			fmt.Printf("%+7.2fs  %-16s %s\n", elapsed, ev.Task, ev.Status)
+		default:
+			fmt.Printf("%+7.2fs  %-16s | %s\n", elapsed, ev.Task, ev.Line)
+		}
+	}
+
+	if err := <-errChan; err != nil {
+		fmt.Printf("result: %v\n", err)
+		return exitCodeRunFailed
+	}
+	fmt.Println("result: all tasks succeeded")
+	return 0
+}
+
+// fixConfig adds the unlisted tasks to the tasks array, after asking unless
+// yes is set, and sorts the array if requested. It reports whether the file
+// was rewritten.
+func fixConfig(configPath string, unlisted []string, yes, sortTasks bool) (bool, error) {
+	opts := config.FixOptions{Sort: sortTasks}
+	if len(unlisted) > 0 {
+		question := fmt.Sprintf("Add %s to tasks?", strings.Join(unlisted, ", "))
+		if yes || confirm(question) {
+			opts.Add = unlisted
+		}
+	}
+	if len(opts.Add) == 0 && !opts.Sort {
+		fmt.Printf("%s: nothing to fix\n", configPath)
+		return false, nil
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return false, err
+	}
+	fixed, droppedComments, err := config.FixTasks(data, opts)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(fixed, data) {
+		fmt.Printf("%s: nothing to fix\n", configPath)
+		return false, nil
+	}
+	if err := os.WriteFile(configPath, fixed, info.Mode().Perm()); err != nil {
+		return false, err
+	}
+
+	for _, name := range opts.Add {
+		fmt.Printf("%s: added '%s' to tasks\n", configPath, name)
+	}
+	if opts.Sort {
+		fmt.Printf("%s: sorted tasks\n", configPath)
+	}
+	if droppedComments {
+		fmt.Fprintf(os.Stderr, "prun: warning: comments inside the tasks array were removed\n")
+	}
+	return true, nil
+}
+
+// confirm asks a yes/no question on the terminal; anything but y or yes,
+// including no terminal to ask on, is a no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// checkWatch makes sure every task with watch = true watches some directory,
+// as the watcher would find them at startup
+func checkWatch(cfg *config.Config, tasks []string) error {
+	for _, name := range tasks {
+		task := cfg.TaskDefs[name]
+		if !task.Watch {
+			continue
+		}
+		dir := task.Path
+		if dir == "" {
+			dir = "."
+		}
+		if _, err := runner.WatchedDirs(dir); err != nil {
+			return fmt.Errorf("task '%s' can't be watched: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"prun/internal/runner"
+	"prun/internal/ui"
+)
+
+// exitCodePanic is prun's exit code after a panic of its own
+const exitCodePanic = 5
+
+// crashDir is where panic logs and goroutine dumps are written: .prun next
+// to the config file
+func crashDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), ".prun")
+}
+
+// reportPanic gives the terminal back if the TUI has it, prints a panic and
+// its stack, saves them to dir/panic-<time>.log and exits with exitCodePanic
+func reportPanic(dir string, v any, stack []byte) {
+	ui.ReleaseTerminal()
+	report := fmt.Sprintf("panic: %v\n\n%s", v, stack)
+	fmt.Fprintf(os.Stderr, "prun: %s\n", report)
+	if path, err := writeCrashLog(dir, "panic", report); err != nil {
+		fmt.Fprintf(os.Stderr, "prun: failed to save the panic: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "prun: panic saved to %s\n", path)
+	}
+	exit(exitCodePanic)
+}
+
+// writeCrashLog writes a report to dir/<kind>-<time>.log and returns its path
+func writeCrashLog(dir, kind, report string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.log", kind, time.Now().Format("20060102-150405.000")))
+	return path, os.WriteFile(path, []byte(report), 0o644)
+}
+
+// dumpOnQuit writes the stacks of all goroutines to dir/goroutines-<time>.log
+// on SIGQUIT until ctx is cancelled, reporting where under prun. It replaces
+// Go's default of printing them and exiting, which under the TUI would print
+// over the screen and leave the terminal in raw mode.
+func dumpOnQuit(ctx context.Context, dir string, publish func(runner.LogEvent)) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+	go func() {
+		defer signal.Stop(quit)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-quit:
+			}
+			level, line := runner.LevelInfo, ""
+			if path, err := writeCrashLog(dir, "goroutines", goroutineStacks()); err != nil {
+				level, line = runner.LevelError, fmt.Sprintf("failed to save goroutine stacks: %v", err)
+			} else {
+				line = "SIGQUIT: goroutine stacks saved to " + path
+			}
+			publish(runner.LogEvent{Task: runner.SystemTask, Line: line, IsErr: level == runner.LevelError, Time: time.Now(), Level: level})
+		}
+	}()
+}
+
+// goroutineStacks returns the stacks of every goroutine
+func goroutineStacks() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"prun/internal/runner"
+)
+
+// panicDirEnv makes the test binary, run again by TestReportPanic, panic
+// the way prun does and report it to this directory
+const panicDirEnv = "PRUN_CRASH_TEST_DIR"
+
+func TestReportPanic(t *testing.T) {
+	if dir := os.Getenv(panicDirEnv); dir != "" {
+		runner.PanicHandler = func(v any, stack []byte) {
+			reportPanic(dir, v, stack)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer runner.RecoverPanic()
+			defer close(done)
+			panickingGoroutine()
+		}()
+		<-done
+		t.Fatal("the panic was survived")
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestReportPanic$")
+	cmd.Env = append(os.Environ(), panicDirEnv+"="+dir)
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitCodePanic {
+		t.Fatalf("exited with %v, want code %d:\n%s", err, exitCodePanic, out)
+	}
+	if !strings.Contains(string(out), "prun: panic: boom") || !strings.Contains(string(out), "prun: panic saved to "+dir) {
+		t.Errorf("output doesn't report the panic and its log:\n%s", out)
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "panic-*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("panic logs = %v, want one", logs)
+	}
+	report, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(report), "panic: boom\n") || !strings.Contains(string(report), "panickingGoroutine") {
+		t.Errorf("panic log lacks the panic or its stack:\n%s", report)
+	}
+}
+
+// panickingGoroutine panics, under a name to find in the stack
+func panickingGoroutine() {
+	panic("boom")
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// execBinary replaces the current process with path
+func execBinary(path string, args []string) error {
+	return syscall.Exec(path, args, os.Environ())
+}
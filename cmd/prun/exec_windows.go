@@ -0,0 +1,9 @@
+package main
+
+import "errors"
+
+// execBinary replaces the current process with path. Windows cannot replace
+// a running process image.
+func execBinary(path string, args []string) error {
+	return errors.New("re-executing prun is not supported on Windows")
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 
 	"prun/internal/config"
 	"prun/internal/runner"
 	"prun/internal/ui"
+	"prun/internal/web"
+
+	"github.com/mattn/go-isatty"
 )
 
 const (
@@ -20,6 +29,11 @@ const (
 )
 
 func main() {
+	// prun re-executed to set up a task's sandbox runs the task, not tasks
+	if len(os.Args) > 1 && os.Args[1] == runner.SandboxHelper {
+		os.Exit(runner.RunSandboxHelper(os.Args[2:]))
+	}
+
 	// Parse CLI flags
 	configPath := flag.String("c", "prun.toml", "path to config file")
 	flag.StringVar(configPath, "config", "prun.toml", "path to config file")
@@ -30,6 +44,9 @@ func main() {
 	list := flag.Bool("l", false, "list tasks and exit")
 	flag.BoolVar(list, "list", false, "list tasks and exit")
 
+	profile := flag.String("p", "", "run the tasks of a profile from [profiles]")
+	flag.StringVar(profile, "profile", "", "run the tasks of a profile from [profiles]")
+
 	showHelp := flag.Bool("h", false, "show help")
 	flag.BoolVar(showHelp, "help", false, "show help")
 
@@ -38,161 +55,656 @@ func main() {
 
 	watch := flag.Bool("w", false, "watch files and restart all tasks on changes")
 	flag.BoolVar(watch, "watch", false, "watch files and restart all tasks on changes")
+	watchEvents := flag.Bool("watch-events", false, "report every file system event and whether it restarted a task")
+	watchBlock := flag.Bool("watch-block", false, "register every watched directory before starting tasks")
+	watchAutoConfirm := flag.Bool("watch-auto-confirm", false, "restart without asking however many tasks a change affects")
+
+	dryRun := flag.Bool("dry-run", false, "print the commands that would run and exit")
+
+	fromPackageJSON := flag.Bool("from-package-json", false, "add package.json scripts as tasks")
+
+	order := flag.String("order", config.OrderArgs, "order of tasks named on the command line: args or config")
+
+	groupOutput := flag.Bool("group-output", false, "print each task's output as one block when it exits")
+	failFast := flag.Bool("fail-fast", false, "stop every task as soon as one fails (the default)")
+	continueOnError := flag.Bool("continue-on-error", false, "let the other tasks run to completion when one fails, then print each task's result")
+	quiet := flag.Bool("quiet", false, "leave lifecycle lines (started, exited, …) out of console output")
+	killTimeout := flag.Duration("kill-timeout", 0, "how long stopped tasks have to exit after SIGTERM before being killed (overrides shutdown_timeout)")
+	logFormat := flag.String("log-format", "text", "console output format: text or json (NDJSON)")
+	colorMode := flag.String("color", "auto", "color console output: auto, always or never")
+	timestamps := flag.Bool("timestamps", false, "put the time in front of each line of output")
+	noPrefix := flag.Bool("no-prefix", false, "leave out the [task] prefix when a single task runs")
+	logDir := flag.String("log-dir", "", "write the output of each task without a log_file to <dir>/<task>.log")
+
+	selfWatch := flag.String("self-watch", "", "rebuild and re-exec prun when its Go source in this directory changes")
+	resumeState := flag.String("resume-state", "", "session state passed to a re-executed prun (internal)")
+
+	webAddr := flag.String("web", "", "serve a web UI on this address, e.g. :7777")
+
+	tuiMode := flag.String("tui", "auto", "when -i uses the TUI: auto, always or never")
+	fps := flag.Int("fps", 0, "redraws per second of the TUI while something animates (default 5)")
+
+	envOverrides := envFlag{}
+	flag.Var(envOverrides, "e", "set an environment variable for all tasks (KEY=VALUE, repeatable)")
+	flag.Var(envOverrides, "env", "set an environment variable for all tasks (KEY=VALUE, repeatable)")
+
+	var excludes listFlag
+	flag.Var(&excludes, "exclude", "leave out a task, group or glob of tasks (repeatable)")
+
+	// Flags for profiling prun itself
+	cpuProfile := flag.String("profile-cpu", "", "write a CPU profile of prun to this file, and a heap profile next to it")
+	memProfile := flag.String("profile-mem", "", "write a heap profile of prun to this file on exit")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address, e.g. :6060")
 
 	flag.Parse()
 
+	// Tasks and prun share the terminal through one console, so their lines
+	// never tear
+	console := runner.NewConsole(os.Stdout, os.Stderr)
+	flushConsole = console.Flush
+	defer console.Flush()
+
+	// `prun @<name>` replays a saved session's flags, config and tasks
+	taskArgs := flag.Args()
+	replay, err := replaySession(taskArgs, console)
+	if err != nil {
+		console.Errorf("%v", err)
+		exit(exitCodeRunFailed)
+	}
+	if replay != nil {
+		taskArgs = replay.tasks
+	}
+
+	if err := startProfiles(*cpuProfile, *memProfile); err != nil {
+		console.Errorf("failed to start profiling: %v", err)
+		exit(exitCodeRunFailed)
+	}
+	defer stopProfiles()
+
+	// A panic anywhere in prun gives the terminal back before it is reported
+	crashes := crashDir(*configPath)
+	runner.PanicHandler = func(v any, stack []byte) {
+		reportPanic(crashes, v, stack)
+	}
+	defer runner.RecoverPanic()
+
+	// `prun status` queries a running instance instead of starting tasks
+	if replay == nil {
+		switch flag.Arg(0) {
+		case "status":
+			exit(runStatus(*configPath, flag.Args()[1:]))
+		case "check":
+			exit(runCheck(*configPath, *profile, flag.Args()[1:]))
+		case "watch-plan":
+			exit(runWatchPlan(*configPath, *profile, *watch, flag.Args()[1:]))
+		case "session":
+			exit(runSession(*configPath, *profile, flag.Args()[1:]))
+		}
+	}
+
 	if *showHelp {
 		printHelp()
-		os.Exit(0)
+		exit(0)
+	}
+
+	if *logFormat != "text" && *logFormat != "json" {
+		console.Errorf("invalid --log-format '%s' (expected text or json)", *logFormat)
+		exit(exitCodeRunFailed)
+	}
+	jsonOutput := *logFormat == "json"
+	if jsonOutput && *groupOutput {
+		console.Errorf("--group-output can't be combined with --log-format json")
+		exit(exitCodeRunFailed)
+	}
+	console.SetJSON(jsonOutput)
+	switch *colorMode {
+	case "auto", "always", "never":
+		console.SetColor(*colorMode)
+	default:
+		console.Errorf("invalid --color '%s' (expected auto, always or never)", *colorMode)
+		exit(exitCodeRunFailed)
+	}
+	console.SetTimestamps(*timestamps)
+	if *failFast && *continueOnError {
+		console.Errorf("--fail-fast can't be combined with --continue-on-error")
+		exit(exitCodeRunFailed)
+	}
+
+	useTUI, err := resolveTUI(*tuiMode, *interactive, isTerminal(os.Stdin), isTerminal(os.Stdout))
+	if err != nil {
+		console.Errorf("%v", err)
+		exit(exitCodeRunFailed)
+	}
+	if *interactive && !useTUI && *tuiMode == "auto" {
+		console.Infof("not attached to a terminal, running without the TUI (use --tui=always to force it)")
 	}
 
-	// Check if config file exists
-	if _, err := os.Stat(*configPath); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "prun: no %s found — run `prun --help` to see usage\n", *configPath)
-		os.Exit(exitCodeConfigNotFound)
+	// Check if config file exists; package.json scripts can stand in for it
+	if _, err := os.Stat(*configPath); os.IsNotExist(err) && !*fromPackageJSON {
+		console.Errorf("no %s found — run `prun --help` to see usage", *configPath)
+		exit(exitCodeConfigNotFound)
 	}
 
 	// Load and parse config
-	cfg, err := config.Load(*configPath)
+	cfg, err := config.LoadWithOptions(*configPath, config.LoadOptions{
+		ImportNpm: *fromPackageJSON,
+		Optional:  *fromPackageJSON,
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "prun: failed to parse config: %v\n", err)
-		os.Exit(exitCodeParseFailed)
+		console.Errorf("failed to parse config: %v", err)
+		exit(exitCodeParseFailed)
+	}
+	cfg.Overrides = envOverrides
+	if *logDir != "" {
+		dir, err := filepath.Abs(*logDir)
+		if err != nil {
+			console.Errorf("invalid --log-dir: %v", err)
+			exit(exitCodeRunFailed)
+		}
+		cfg.LogDir = dir
+	}
+	console.Configure(cfg.Markers, cfg.UI.Theme())
+	// The prun of a {task:name} step leaves warnings to the one that started it
+	if runner.TaskRefDepth() == 0 {
+		for _, warning := range cfg.Warnings {
+			console.Warnf("%s", warning)
+		}
+		if *verbose {
+			for _, notice := range cfg.Notices {
+				console.Infof("%s", notice)
+			}
+		}
 	}
 
 	// List tasks if requested
 	if *list {
-		fmt.Println("Configured tasks:")
-		for _, taskName := range cfg.Tasks {
+		listed := cfg.Tasks
+		if *profile != "" {
+			if listed, err = cfg.ProfileTasks(*profile); err != nil {
+				console.Errorf("%v", err)
+				exit(exitCodeRunFailed)
+			}
+			fmt.Printf("Tasks of profile %s:\n", *profile)
+		} else {
+			fmt.Println("Configured tasks:")
+		}
+		for _, taskName := range listed {
 			taskDef := cfg.TaskDefs[taskName]
-			fmt.Printf("  %s: %s\n", taskName, taskDef.Cmd)
+			line := fmt.Sprintf("  %s: %s", taskName, strings.Join(taskDef.Commands(), " → "))
+			done, started := cfg.Dependencies(taskName)
+			for i := range started {
+				started[i] += " started"
+			}
+			ready := cfg.WaitFor(taskName)
+			for i := range ready {
+				ready[i] += " ready"
+			}
+			if deps := slices.Concat(done, started, ready); len(deps) > 0 {
+				line += " (after " + strings.Join(deps, ", ") + ")"
+			}
+			fmt.Println(line)
+		}
+		if len(cfg.Groups) > 0 {
+			fmt.Println("Groups:")
+			for _, group := range slices.Sorted(maps.Keys(cfg.Groups)) {
+				fmt.Printf("  %s: %s\n", group, strings.Join(cfg.Groups[group], ", "))
+			}
+		}
+		if len(cfg.Profiles) > 0 && *profile == "" {
+			fmt.Println("Profiles:")
+			for _, name := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+				fmt.Printf("  %s: %s\n", name, strings.Join(cfg.Profiles[name], ", "))
+			}
 		}
-		os.Exit(0)
+		exit(0)
 	}
 
 	// Get tasks to run
-	tasksToRun, err := cfg.GetTasksToRun(flag.Args())
+	if replay != nil {
+		if taskArgs, err = replay.keepDefined(cfg, *profile, *order, console); err != nil {
+			console.Errorf("%v", err)
+			exit(exitCodeRunFailed)
+		}
+	}
+	tasksToRun, duplicates, err := cfg.GetTasksToRun(taskArgs, *profile, *order)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
-		os.Exit(exitCodeRunFailed)
+		console.Errorf("%v", err)
+		exit(exitCodeRunFailed)
+	}
+	if len(duplicates) > 0 {
+		console.Warnf("ignoring repeated task(s): %s", strings.Join(duplicates, ", "))
+	}
+	if len(excludes) > 0 {
+		if tasksToRun, err = cfg.Exclude(tasksToRun, excludes); err != nil {
+			console.Errorf("%v", err)
+			exit(exitCodeRunFailed)
+		}
+	}
+	var groups []string
+	for _, arg := range taskArgs {
+		if cfg.IsGroup(arg) && !slices.Contains(groups, arg) {
+			groups = append(groups, arg)
+		}
 	}
 
 	if len(tasksToRun) == 0 {
-		fmt.Fprintln(os.Stderr, "prun: no tasks to run")
-		os.Exit(0)
+		console.Infof("no tasks to run")
+		exit(0)
+	}
+	if *noPrefix {
+		if len(tasksToRun) > 1 {
+			console.Warnf("--no-prefix has no effect with more than one task")
+		} else {
+			console.SetNoPrefix(true)
+		}
 	}
 
-	// Create runner
-	var r *runner.Runner
-	var watcher *runner.Watcher
-
-	// Check if any task has watch enabled or global watch flag is set
-	needsWatcher := *watch
-	if !needsWatcher {
+	// Print effective commands if requested
+	if *dryRun {
 		for _, taskName := range tasksToRun {
-			if cfg.TaskDefs[taskName].Watch {
-				needsWatcher = true
-				break
+			steps, err := runner.StepArgs(cfg, taskName)
+			if err != nil {
+				console.Errorf("task '%s': %v", taskName, err)
+				exit(exitCodeRunFailed)
+			}
+			for i, args := range steps {
+				label := taskName
+				if len(cfg.TaskDefs[taskName].Steps) > 0 {
+					label = fmt.Sprintf("%s:%d/%d", taskName, i+1, len(steps))
+				}
+				fmt.Printf("%s: %s\n", label, config.QuoteArgs(args))
 			}
 		}
+		exit(0)
 	}
 
-	// If interactive mode, launch TUI
-	if *interactive {
-		eventChan := make(chan runner.LogEvent, 100)
-
-		// Setup signal handling
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		// Use watcher if needed, otherwise regular runner
-		if needsWatcher {
-			var watcherErr error
-			watcher, watcherErr = runner.NewWatcher(cfg, tasksToRun, *verbose, *watch)
-			if watcherErr != nil {
-				fmt.Fprintf(os.Stderr, "prun: failed to create watcher: %v\n", watcherErr)
-				os.Exit(exitCodeRunFailed)
-			}
-			defer watcher.Close()
-			watcher.SetEventChannel(eventChan)
-
-			// Run tasks with watching in background
-			go func() {
-				_ = watcher.Start(ctx)
-				close(eventChan)
-			}()
-		} else {
-			r = runner.New(cfg, tasksToRun, *verbose)
-			r.SetEventChannel(eventChan)
+	// Prefixes are sized once for the tasks being run
+	var prefixTasks []string
+	for _, taskName := range tasksToRun {
+		prefixTasks = append(prefixTasks, runner.StepLabels(taskName, len(cfg.TaskDefs[taskName].Steps))...)
+	}
+	if *selfWatch != "" {
+		prefixTasks = append(slices.Clip(prefixTasks), selfBuildTask)
+	}
+	prefixes := runner.NewPrefixes(prefixTasks, cfg.PrefixWidth())
+	console.SetPrefixes(prefixes)
+
+	// A prun running a {task:name} step runs it once and stays out of the way
+	refDepth := runner.TaskRefDepth()
+	if refDepth > config.MaxTaskRefDepth {
+		console.Errorf("{task:...} references nest deeper than %d", config.MaxTaskRefDepth)
+		exit(exitCodeRunFailed)
+	}
 
-			// Run tasks in background
-			go func() {
-				_ = r.Run(ctx)
-				close(eventChan)
-			}()
+	// Write the render files before anything reads them
+	if refDepth == 0 {
+		touched, err := cfg.RenderFiles(tasksToRun)
+		if err != nil {
+			console.Errorf("failed to render: %v", err)
+			exit(exitCodeRunFailed)
 		}
-
-		// Start TUI
-		if err := ui.Start(tasksToRun, eventChan); err != nil {
-			fmt.Fprintf(os.Stderr, "prun: TUI error: %v\n", err)
-			os.Exit(exitCodeRunFailed)
+		if len(touched) > 0 {
+			console.Infof("rendered %s", strings.Join(touched, ", "))
+		}
+		if err := truncateLogDir(cfg, tasksToRun); err != nil {
+			console.Errorf("failed to prepare --log-dir: %v", err)
+			exit(exitCodeRunFailed)
 		}
-		return
 	}
 
-	// Non-interactive mode
+	// The orchestrator runs the tasks under a watcher or a plain runner. With
+	// the TUI or --web, events go to a store they read from.
+	orch, err := runner.NewOrchestrator(cfg, tasksToRun, runner.OrchestratorOptions{
+		Watch:       *watch,
+		NoWatch:     refDepth > 0,
+		TraceEvents: *watchEvents,
+		WatchBlock:  *watchBlock,
+		AutoConfirm: *watchAutoConfirm,
+		Interactive: useTUI,
+		Stdin:       useTUI,
+		Verbose:     *verbose,
+		Quiet:       *quiet,
+		GroupOutput: *groupOutput,
+		KillTimeout: *killTimeout,
+		Console:     consoleUnlessTUI(console, useTUI),
+		Events:      useTUI || *webAddr != "",
+
+		ContinueOnError: *continueOnError,
+	})
+	if err != nil {
+		console.Errorf("failed to create watcher: %v", err)
+		exit(exitCodeRunFailed)
+	}
+	defer orch.Shutdown()
+	// Tasks run in process groups of their own, which exiting wouldn't stop
+	runner.PanicHandler = func(v any, stack []byte) {
+		orch.Signal(syscall.SIGTERM)
+		reportPanic(crashes, v, stack)
+	}
+	if *watchEvents && !orch.Watching() {
+		console.Warnf("--watch-events has no effect, no task is watched (use -w or watch = true)")
+	}
+	if *continueOnError && orch.Watching() {
+		console.Warnf("--continue-on-error has no effect while watching, where a failed task never stops the others")
+	}
+
+	// State handed over by a previous prun that re-executed itself
+	var resume sessionState
+	if *resumeState != "" {
+		if err := json.Unmarshal([]byte(*resumeState), &resume); err != nil {
+			console.Warnf("ignoring invalid --resume-state: %v", err)
+		}
+		removeOldBuild(resume.Binary)
+	}
 
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	if *webAddr != "" {
+		server := web.New(tasksToRun, orch.EventSource(), cfg.Web.Token)
+		server.SetController(orch)
+		if err := server.Start(ctx, *webAddr); err != nil {
+			console.Errorf("failed to start web UI: %v", err)
+			exit(exitCodeRunFailed)
+		}
+	}
+	if *pprofAddr != "" {
+		if err := startPprof(ctx, *pprofAddr); err != nil {
+			console.Errorf("failed to start pprof server: %v", err)
+			exit(exitCodeRunFailed)
+		}
+	}
 
-	// Run tasks in a goroutine
-	errChan := make(chan error, 1)
+	// If interactive mode, launch TUI
+	if useTUI {
+		// The store keeps everything emitted before the TUI attaches
+		store := orch.EventSource()
+		orch.Start(ctx)
+
+		markers := cfg.Markers
+		markers.Enabled = true
+		opts := ui.Options{
+			CompactList:  cfg.UI.CompactList,
+			NoSpinner:    cfg.UI.NoSpinner,
+			Icons:        cfg.UI.Theme(),
+			Prefixes:     prefixes,
+			Groups:       groups,
+			FPS:          *fps,
+			ShowMarkers:  cfg.Markers.Enabled,
+			StderrMarker: markers.Marker(true),
+			StdoutMarker: markers.Marker(false),
+			Describe:     orch.Describe,
+			RestartTask:  orch.RestartTask,
+			StopTask:     orch.StopTask,
+			StartTask:    orch.StartTask,
+			SendInput:    orch.SendInput,
+			CloseInput:   orch.CloseInput,
+		}
+		// The view settings of a re-executed prun take over from the config
+		if *resumeState != "" {
+			opts.Resume = &resume.UI
+		}
+		if orch.Watching() {
+			opts.ResolveRestart = orch.ResolveRestart
+		}
+		uiTasks := tasksToRun
+
+		// A successful rebuild of prun quits the TUI to hand over to the new binary
+		var sw *selfWatcher
+		if *selfWatch != "" {
+			var err error
+			sw, err = newSelfWatcher(*selfWatch, store.Publish)
+			if err != nil {
+				console.Errorf("failed to watch prun's source: %v", err)
+				exit(exitCodeRunFailed)
+			}
+			go sw.Run()
+			opts.Quit = sw.Ready()
+			uiTasks = append(slices.Clip(uiTasks), selfBuildTask)
+		}
+
+		// Under the TUI, SIGQUIT dumps goroutine stacks to a file instead
+		// of over the screen
+		dumpOnQuit(ctx, crashes, store.Publish)
 
-	if needsWatcher {
-		var watcherErr error
-		watcher, watcherErr = runner.NewWatcher(cfg, tasksToRun, *verbose, *watch)
-		if watcherErr != nil {
-			fmt.Fprintf(os.Stderr, "prun: failed to create watcher: %v\n", watcherErr)
-			os.Exit(exitCodeRunFailed)
+		// Start TUI
+		console.Flush()
+		state, err := ui.Start(uiTasks, store, opts)
+		var panicked *ui.PanicError
+		if errors.As(err, &panicked) {
+			runner.PanicHandler(panicked.Value, panicked.Stack)
+		}
+		if err != nil {
+			console.Errorf("TUI error: %v", err)
+			exit(exitCodeRunFailed)
 		}
-		defer watcher.Close()
 
-		if *verbose {
-			fmt.Fprintln(os.Stderr, "prun: watch mode enabled")
+		if sw != nil && isClosed(sw.Ready()) {
+			// Stop the tasks, then wait for the store to drain as the runner exits
+			cancel()
+			_, events := store.Subscribe()
+			for range events {
+			}
+			orch.Shutdown()
+			err := sw.reexec(sessionState{UI: state})
+			console.Errorf("failed to restart: %v", err)
+			exit(exitCodeRunFailed)
 		}
 
+		// The TUI took its screen with it; leave what became of the tasks
+		switch len(groups) {
+		case 0:
+		case 1:
+			console.Infof("summary of group %s", groups[0])
+		default:
+			console.Infof("summary of groups %s", strings.Join(groups, ", "))
+		}
+		if runner.PrintSummary(console, tasksToRun, store.Snapshot()) {
+			cancel()
+			_, events := store.Subscribe()
+			for range events {
+			}
+			orch.Shutdown()
+			exit(exitCodeRunFailed)
+		}
+		return
+	}
+
+	// Non-interactive mode
+
+	// Route signals: some stop prun, others are passed on to the tasks
+	shutdownSignals, forwardSignals := cfg.SignalRoutes()
+	sigChan := make(chan os.Signal, 1)
+	if routed := append(shutdownSignals, forwardSignals...); len(routed) > 0 {
+		signal.Notify(sigChan, routed...)
+	}
+
+	// With --web, output flows through the event store shared by the web UI
+	// and the console printer
+	printed := make(chan struct{})
+	if store := orch.EventSource(); store != nil {
+		_, events := store.Subscribe()
 		go func() {
-			errChan <- watcher.Start(ctx)
+			runner.PrintEvents(console, events, runner.PrintOptions{
+				Group: *groupOutput,
+				Quiet: *quiet,
+			})
+			close(printed)
 		}()
 	} else {
-		r = runner.New(cfg, tasksToRun, *verbose)
-		go func() {
-			errChan <- r.Run(ctx)
-		}()
+		close(printed)
 	}
 
-	// Wait for completion or signal
-	select {
-	case <-sigChan:
-		if *verbose {
-			fmt.Fprintln(os.Stderr, "\nprun: received interrupt signal, shutting down...")
+	if *verbose && orch.Watching() {
+		console.Infof("watch mode enabled")
+	}
+	orch.Start(ctx)
+
+	// Rebuild prun when its source changes; rebuild output goes to the console
+	var rebuilt chan struct{}
+	var sw *selfWatcher
+	if *selfWatch != "" {
+		var err error
+		sw, err = newSelfWatcher(*selfWatch, func(ev runner.LogEvent) {
+			if !ev.IsStatus() {
+				console.WriteLine(ev)
+			}
+		})
+		if err != nil {
+			console.Errorf("failed to watch prun's source: %v", err)
+			exit(exitCodeRunFailed)
 		}
-		cancel()
-		// Wait a bit for graceful shutdown
-		err := <-errChan
-		if err != nil && *verbose {
-			fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		go sw.Run()
+		rebuilt = sw.Ready()
+	}
+
+	// Wait for completion or a shutdown signal, forwarding the others
+	for {
+		select {
+		case <-rebuilt:
+			cancel()
+			orch.Wait()
+			<-printed
+			orch.Shutdown()
+			err := sw.reexec(sessionState{})
+			console.Errorf("failed to restart: %v", err)
+			exit(exitCodeRunFailed)
+		case sig := <-sigChan:
+			if slices.Contains(forwardSignals, sig) {
+				if *verbose {
+					console.Infof("forwarding %v to tasks", sig)
+				}
+				orch.Signal(sig.(syscall.Signal))
+				continue
+			}
+			if *verbose {
+				console.Infof("received %v, shutting down...", sig)
+			}
+			cancel()
+			// Wait a bit for graceful shutdown
+			err := orch.Wait()
+			<-printed
+			if err != nil && *verbose {
+				console.Errorf("%v", err)
+			}
+			orch.Shutdown() // flush log files before exiting
+			exit(130)       // Standard exit code for SIGINT
+		case <-orch.Done():
+			<-printed
+			err := orch.Wait()
+			if results := orch.Results(); *continueOnError && results != nil {
+				runner.PrintResults(console, results)
+			}
+			if err != nil {
+				orch.Shutdown()
+				console.Errorf("%v", err)
+				// A single failed task passes on its exit code
+				var runErr *runner.RunError
+				if errors.As(err, &runErr) {
+					exit(runErr.ExitCode())
+				}
+				exit(exitCodeRunFailed)
+			}
+			return
 		}
-		os.Exit(130) // Standard exit code for SIGINT
-	case err := <-errChan:
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "prun: %v\n", err)
-			os.Exit(exitCodeRunFailed)
+	}
+}
+
+// consoleUnlessTUI returns the console for the tasks' output, or nil under
+// the TUI, which gets everything as events
+func consoleUnlessTUI(console *runner.Console, useTUI bool) *runner.Console {
+	if useTUI {
+		return nil
+	}
+	return console
+}
+
+// truncateLogDir empties the --log-dir files of the tasks about to run, so
+// each prun starts them afresh while watch restarts append to them
+func truncateLogDir(cfg *config.Config, tasks []string) error {
+	if cfg.LogDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.LogDir, 0o755); err != nil {
+		return err
+	}
+	for _, taskName := range tasks {
+		if cfg.TaskDefs[taskName].LogFile != "" {
+			continue
+		}
+		if err := os.WriteFile(cfg.LogFilePath(taskName), nil, 0o644); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// isClosed reports whether ch has been closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveTUI decides whether to run the TUI given the --tui mode, the -i flag,
+// and whether stdin and stdout are terminals. In auto mode the TUI needs both,
+// since bubbletea renders to stdout and reads keys from stdin.
+func resolveTUI(mode string, interactive, stdinTTY, stdoutTTY bool) (bool, error) {
+	switch mode {
+	case "auto":
+		return interactive && stdinTTY && stdoutTTY, nil
+	case "always":
+		return interactive, nil
+	case "never":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid --tui value %q (expected auto, always or never)", mode)
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	fd := f.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+// listFlag collects the values of a repeated flag
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func (f *listFlag) Get() any {
+	return []string(*f)
+}
+
+// envFlag collects repeated KEY=VALUE flags
+type envFlag map[string]string
+
+func (f envFlag) String() string {
+	return ""
+}
+
+func (f envFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	f[key] = val
+	return nil
+}
+
+func (f envFlag) Get() any {
+	return map[string]string(f)
 }
 
 func printHelp() {
@@ -205,23 +717,99 @@ Flags:
   -c, --config <path>   Path to config file (default: prun.toml)
   -v, --verbose         Enable verbose logging
   -l, --list            List configured tasks and exit
+  -p, --profile <name>  Run the tasks of a profile from [profiles]; tasks
+                        named as well must belong to it
   -i, --interactive     Run in interactive TUI mode
+      --tui <mode>      When -i uses the TUI: auto (only on a terminal), always, never
+      --fps <n>         Cap TUI redraws while a spinner or countdown animates (default 5)
   -w, --watch           Watch files and restart all tasks on changes
+  -e, --env KEY=VALUE   Set an environment variable for all tasks (repeatable)
+      --exclude <name>  Leave out a task, group or glob of tasks (repeatable)
+      --dry-run         Print the commands that would run and exit
+      --group-output    Print each task's output in one block when it exits
+      --watch-events    Report every file system event the watcher sees and
+                        whether it restarted a task (for debugging watch mode)
+      --watch-block     Register every watched directory before starting
+                        tasks, instead of in the background
+      --watch-auto-confirm
+                        Restart without asking when a change affects more
+                        than [watch] confirm_above tasks
+      --fail-fast       Stop every task as soon as one fails (the default)
+      --continue-on-error
+                        Let the other tasks run to completion when one
+                        fails, then print each task's result and exit code
+      --quiet           Leave lifecycle lines (▶ started, ✗ exited, …) out of console output
+      --kill-timeout <d>
+                        How long stopped tasks have to exit after SIGTERM
+                        before being killed, e.g. 10s (overrides each
+                        task's shutdown_timeout, default 5s)
+      --color <when>    Color prefixes and lifecycle lines: auto (on a
+                        terminal without NO_COLOR), always or never
+      --timestamps      Put the time (HH:MM:SS.mmm) in front of each line
+      --no-prefix       Leave out the [task] prefix when a single task runs
+      --log-format <f>  Console output as text (default) or json: one object
+                        per line with task, stream, line, time, pid and run
+      --log-dir <dir>   Also write each task's output to <dir>/<task>.log,
+                        emptied when prun starts and appended to across
+                        restarts (tasks with a log_file keep theirs)
+      --order <order>   Start named tasks in command-line (args) or config order
+      --self-watch <dir>
+                        Rebuild prun from the Go module in dir when its
+                        source changes, and re-exec into the new binary
+      --web <addr>      Serve a read-only web UI on addr (e.g. :7777)
+      --from-package-json
+                        Add package.json scripts as tasks (npm run <script>)
   -h, --help            Show this help message
 
+Debugging:
+      --profile-cpu <file>
+                        Write a CPU profile of prun itself for the whole run,
+                        and a heap profile on exit (cpu.pb.gz -> cpu.heap.pb.gz)
+      --profile-mem <file>
+                        Write the heap profile on exit to this file instead
+      --pprof <addr>    Serve the net/http/pprof endpoints on addr (e.g.
+                        :6060) under /debug/pprof/ while prun runs
+
+Commands:
+  prun status --web <addr> [--json]
+                        Query a prun started with --web; exits 0 if every
+                        task is running or done, 1 if not, 4 if unreachable
+
+  prun check [--simulate] [--fix [--yes] [--sort]] [task ...]
+                        Validate the config; with --simulate, run every
+                        task as a scripted fake from the [simulate] table
+                        and print the resulting timeline; with --fix, add
+                        tasks missing from the tasks array (--sort sorts it)
+
+  prun [-w] watch-plan [--json] [--explain <path>] [task ...]
+                        Print what watch mode watches for each task (roots,
+                        include/exclude patterns, debounce, restart policy);
+                        with --explain, whether a change to <path> would
+                        restart each task and why (exit 0 if one would)
+
+  prun [flags] session save <name> [task ...]
+                        Save the flags, config and tasks of this command
+                        line as a session in .prun/sessions.toml
+  prun session list     List the saved sessions and what they run
+  prun [flags] @<name> [flags] [task ...]
+                        Replay a session (also: prun session run <name>);
+                        flags and tasks given here win over the saved ones
+
 Examples:
   prun                  Run all tasks defined in prun.toml
   prun -i               Run in interactive mode with TUI
   prun -w               Run with file watching enabled for all tasks
   prun -i -w            Run in interactive mode with file watching
   prun app server       Run only 'app' and 'server' tasks
+  prun --exclude db full
+                        Run the tasks of group 'full' except 'db'
   prun -c dev.toml      Use dev.toml instead of prun.toml
   prun --list           List all configured tasks
+  prun -p test          Run the tasks of profile 'test'
 
 Config format (prun.toml):
-  [tasks]
-  - app
-  - server
+  tasks = ["app", "server"]
+  env_file = ".env"     # Loaded for every task
 
   [task.app]
   cmd = "npm run dev"
@@ -231,6 +819,13 @@ Config format (prun.toml):
   cmd = "./server"
   path = "/path/to/server"
   watch = false         # Don't watch this task
+  wrapper = "time"      # Prefix the command (overrides top-level wrapper)
+
+  [groups]
+  dev = ["app", "server"]  # prun dev runs both
+
+  [profiles]
+  ci = ["server"]          # prun -p ci runs server only
   
 For more information, see PROJECT_SPEC.md`)
 }
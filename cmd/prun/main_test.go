@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestResolveTUI(t *testing.T) {
+	tests := []struct {
+		mode                string
+		interactive         bool
+		stdinTTY, stdoutTTY bool
+		want                bool
+	}{
+		{"auto", true, true, true, true},
+		{"auto", true, false, true, false},
+		{"auto", true, true, false, false},
+		{"auto", true, false, false, false},
+		{"auto", false, true, true, false},
+		{"always", true, false, false, true},
+		{"always", true, true, true, true},
+		{"always", false, true, true, false},
+		{"never", true, true, true, false},
+		{"never", true, false, false, false},
+	}
+	for _, tt := range tests {
+		got, err := resolveTUI(tt.mode, tt.interactive, tt.stdinTTY, tt.stdoutTTY)
+		if err != nil {
+			t.Fatalf("resolveTUI(%q): %v", tt.mode, err)
+		}
+		if got != tt.want {
+			t.Errorf("resolveTUI(%q, interactive %t, stdin tty %t, stdout tty %t) = %t, want %t",
+				tt.mode, tt.interactive, tt.stdinTTY, tt.stdoutTTY, got, tt.want)
+		}
+	}
+
+	if _, err := resolveTUI("sometimes", true, true, true); err == nil {
+		t.Error("resolveTUI accepted an invalid mode")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+)
+
+// stopProfiles finishes any profiles started by startProfiles
+var stopProfiles = func() {}
+
+// startProfiles starts profiling prun itself (not its tasks) for the
+// --profile-cpu and --profile-mem flags. The memory profile is written when
+// profiles are stopped; with only a CPU profile requested, a heap profile is
+// written next to it.
+func startProfiles(cpuPath, memPath string) error {
+	if memPath == "" && cpuPath != "" {
+		memPath = heapProfilePath(cpuPath)
+	}
+
+	var cpuFile *os.File
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return err
+		}
+		if err := runtimepprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+		cpuFile = f
+	}
+
+	stopProfiles = func() {
+		stopProfiles = func() {}
+		if cpuFile != nil {
+			runtimepprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+		if memPath != "" {
+			if err := writeMemProfile(memPath); err != nil {
+				fmt.Fprintf(os.Stderr, "prun: failed to write memory profile: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// heapProfilePath names the heap profile matching a CPU profile:
+// cpu.pb.gz gets cpu.heap.pb.gz
+func heapProfilePath(cpuPath string) string {
+	for _, ext := range []string{".pb.gz", ".pprof", ".prof"} {
+		if base, ok := strings.CutSuffix(cpuPath, ext); ok {
+			return base + ".heap" + ext
+		}
+	}
+	return cpuPath + ".heap"
+}
+
+func writeMemProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	runtime.GC() // get up-to-date statistics
+	return runtimepprof.WriteHeapProfile(f)
+}
+
+// flushConsole waits for output queued on the console to be written; main
+// sets it once the console exists
+var flushConsole = func() {}
+
+// exit writes out the console, stops profiling and exits. main uses it
+// instead of os.Exit, which would skip writing the profiles and could lose
+// the last lines of output.
+func exit(code int) {
+	flushConsole()
+	stopProfiles()
+	os.Exit(code)
+}
+
+// startPprof serves the net/http/pprof endpoints on addr under
+// /debug/pprof/ until ctx is cancelled, for --pprof
+func startPprof(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	return nil
+}
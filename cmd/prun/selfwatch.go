@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"prun/internal/runner"
+	"prun/internal/ui"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// selfBuildTask is the pseudo-task showing prun's own rebuilds
+const selfBuildTask = "prun-rebuild"
+
+// sessionState is handed to a re-executed prun so it resumes where the old
+// process left off
+type sessionState struct {
+	UI     ui.State `json:"ui"`
+	Binary string   `json:"binary,omitempty"` // of the prun that re-executed, for removeOldBuild
+}
+
+// selfWatcher rebuilds prun when its source changes. Build output is
+// reported as the selfBuildTask pseudo-task; a successful build closes Ready.
+type selfWatcher struct {
+	dir     string
+	pkg     string
+	publish func(runner.LogEvent)
+	fs      *fsnotify.Watcher
+	ready   chan struct{}
+	binary  string // path of the new binary, once Ready is closed
+}
+
+// newSelfWatcher watches the Go source under dir, the module prun is built from
+func newSelfWatcher(dir string, publish func(runner.LogEvent)) (*selfWatcher, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Path == "" {
+		return nil, fmt.Errorf("cannot determine prun's main package")
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if name := d.Name(); path != dir && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+			return filepath.SkipDir
+		}
+		return fsw.Add(path)
+	})
+	if err != nil {
+		fsw.Close()
+		return nil, err
+	}
+
+	return &selfWatcher{
+		dir:     dir,
+		pkg:     info.Path,
+		publish: publish,
+		fs:      fsw,
+		ready:   make(chan struct{}),
+	}, nil
+}
+
+// Ready is closed once a new binary has been built
+func (s *selfWatcher) Ready() chan struct{} {
+	return s.ready
+}
+
+// Run rebuilds after Go files change, debounced, until a build succeeds
+func (s *selfWatcher) Run() {
+	defer s.fs.Close()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-s.fs.Events:
+			if !ok {
+				return
+			}
+			if strings.HasSuffix(ev.Name, ".go") && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce = time.After(500 * time.Millisecond)
+			}
+		case <-s.fs.Errors:
+		case <-debounce:
+			debounce = nil
+			if s.build() {
+				close(s.ready)
+				return
+			}
+		}
+	}
+}
+
+// build compiles prun into a temporary file and reports the outcome
+func (s *selfWatcher) build() bool {
+	s.publish(runner.LogEvent{Task: selfBuildTask, Status: runner.StatusRunning, Time: time.Now()})
+	s.line("rebuilding "+s.pkg, false)
+
+	out, err := os.CreateTemp("", "prun-*")
+	if err != nil {
+		s.fail(err.Error())
+		return false
+	}
+	out.Close()
+
+	var output bytes.Buffer
+	cmd := exec.Command("go", "build", "-o", out.Name(), s.pkg)
+	cmd.Dir = s.dir
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		os.Remove(out.Name())
+		for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+			s.line(line, true)
+		}
+		s.fail(err.Error())
+		return false
+	}
+
+	s.binary = out.Name()
+	s.line("build succeeded, restarting prun", false)
+	s.publish(runner.LogEvent{Task: selfBuildTask, Status: runner.StatusDone, Time: time.Now()})
+	return true
+}
+
+func (s *selfWatcher) line(text string, isErr bool) {
+	s.publish(runner.LogEvent{Task: selfBuildTask, Line: text, IsErr: isErr, Time: time.Now()})
+}
+
+func (s *selfWatcher) fail(msg string) {
+	s.line("build failed, keeping the running prun: "+msg, true)
+	s.publish(runner.LogEvent{Task: selfBuildTask, Status: runner.StatusFailed, ExitCode: 1, Time: time.Now()})
+}
+
+// removeOldBuild removes the binary of the prun that re-executed this one,
+// once nothing runs it, if build wrote it to the temporary directory. The
+// prun the user started first is never removed.
+func removeOldBuild(path string) {
+	self, _ := os.Executable()
+	if path == "" || path == self || filepath.Dir(path) != filepath.Clean(os.TempDir()) || !strings.HasPrefix(filepath.Base(path), "prun-") {
+		return
+	}
+	os.Remove(path)
+}
+
+// reexec replaces the process with the rebuilt binary, passing the same
+// arguments plus the session state. It only returns on failure.
+func (s *selfWatcher) reexec(state sessionState) error {
+	state.Binary, _ = os.Executable()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Flags must come before task names, so the state goes first
+	args := []string{os.Args[0], "--resume-state=" + string(data)}
+	for _, arg := range os.Args[1:] {
+		if !strings.HasPrefix(arg, "--resume-state=") {
+			args = append(args, arg)
+		}
+	}
+	flushConsole()
+	stopProfiles()
+	return execBinary(s.binary, args)
+}
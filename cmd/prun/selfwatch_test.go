@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveOldBuild(t *testing.T) {
+	tempFile := func(dir, pattern string) string {
+		t.Helper()
+		f, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		t.Cleanup(func() { os.Remove(f.Name()) })
+		return f.Name()
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	rebuilt := tempFile("", "prun-*")
+	removeOldBuild(rebuilt)
+	if exists(rebuilt) {
+		t.Errorf("rebuilt binary %s was kept", rebuilt)
+	}
+
+	// Binaries the user built or installed stay
+	installed := filepath.Join(t.TempDir(), "prun")
+	if err := os.WriteFile(installed, nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	other := tempFile("", "other-*")
+	for _, path := range []string{installed, other} {
+		removeOldBuild(path)
+		if !exists(path) {
+			t.Errorf("%s was removed", path)
+		}
+	}
+	removeOldBuild("")
+}
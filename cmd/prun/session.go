@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"prun/internal/config"
+	"prun/internal/runner"
+
+	"github.com/BurntSushi/toml"
+)
+
+// sessionsFile is where `prun session save` keeps sessions, relative to the
+// directory prun runs in
+const sessionsFile = ".prun/sessions.toml"
+
+// Session is a saved prun invocation: its config, tasks and flags, replayed
+// by `prun @<name>`
+type Session struct {
+	Config string         `toml:"config,omitempty"`
+	Tasks  []string       `toml:"tasks,omitempty"`
+	Flags  map[string]any `toml:"flags,omitempty"` // by short name; bool, int, string, array or table, as the flag takes
+}
+
+// sessionFile is the layout of sessionsFile
+type sessionFile struct {
+	Sessions map[string]Session `toml:"session"`
+}
+
+// flagAliases maps the long spelling of a flag to the short name sessions
+// record it under
+var flagAliases = map[string]string{
+	"config":      "c",
+	"verbose":     "v",
+	"list":        "l",
+	"help":        "h",
+	"interactive": "i",
+	"watch":       "w",
+	"env":         "e",
+	"profile":     "p",
+}
+
+// unsavedFlags are not part of a session: the config is saved on its own,
+// and the others are about this one invocation
+var unsavedFlags = []string{"c", "h", "l", "resume-state"}
+
+// canonicalFlag returns the name a session records a flag under
+func canonicalFlag(name string) string {
+	if short, ok := flagAliases[name]; ok {
+		return short
+	}
+	return name
+}
+
+// loadSessions reads the saved sessions; a missing file holds none
+func loadSessions(path string) (map[string]Session, error) {
+	var file sessionFile
+	if _, err := toml.DecodeFile(path, &file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if file.Sessions == nil {
+		file.Sessions = make(map[string]Session)
+	}
+	return file.Sessions, nil
+}
+
+// saveSessions writes the sessions, replacing the file atomically
+func saveSessions(path string, sessions map[string]Session) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Sessions saved by `prun session save`, replayed with `prun @<name>`\n\n")
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(sessionFile{Sessions: sessions}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runSession implements `prun session save|list`
+func runSession(configPath, profile string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "prun: session needs a command: save <name> [task ...], list, or run <name>")
+		return exitCodeRunFailed
+	}
+	switch args[0] {
+	case "save":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "prun: session save needs a name, e.g. prun -i -w session save dev web api")
+			return exitCodeRunFailed
+		}
+		return saveSession(configPath, profile, args[1], args[2:])
+	case "list":
+		return listSessions()
+	}
+	fmt.Fprintf(os.Stderr, "prun: unknown session command '%s' (expected save, list or run)\n", args[0])
+	return exitCodeRunFailed
+}
+
+// saveSession records the flags given before `session save`, the config and
+// tasks under name, replacing a session of that name. profile is -p, which
+// the tasks are checked against.
+func saveSession(configPath, profile, name string, tasks []string) int {
+	if name == "" || strings.ContainsAny(name, " \t@") {
+		fmt.Fprintf(os.Stderr, "prun: invalid session name '%s'\n", name)
+		return exitCodeRunFailed
+	}
+
+	// Check the tasks now rather than on every replay
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: failed to parse config: %v\n", err)
+		return exitCodeParseFailed
+	}
+	if _, _, err := cfg.GetTasksToRun(tasks, profile, config.OrderArgs); err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+
+	session := Session{Config: configPath, Tasks: tasks, Flags: make(map[string]any)}
+	var visitErr error
+	flag.Visit(func(f *flag.Flag) {
+		short := canonicalFlag(f.Name)
+		if slices.Contains(unsavedFlags, short) {
+			return
+		}
+		switch v := f.Value.(flag.Getter).Get().(type) {
+		case bool, int, string, []string:
+			session.Flags[short] = v
+		case time.Duration:
+			session.Flags[short] = v.String()
+		case map[string]string:
+			session.Flags[short] = maps.Clone(v)
+		default:
+			visitErr = fmt.Errorf("can't save --%s, of type %T", f.Name, v)
+		}
+	})
+	if visitErr != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", visitErr)
+		return exitCodeRunFailed
+	}
+
+	sessions, err := loadSessions(sessionsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+	sessions[name] = session
+	if err := saveSessions(sessionsFile, sessions); err != nil {
+		fmt.Fprintf(os.Stderr, "prun: failed to save session: %v\n", err)
+		return exitCodeRunFailed
+	}
+	fmt.Printf("saved session %s: %s\n", name, session.commandLine())
+	return 0
+}
+
+// listSessions prints each saved session with the command it replays
+func listSessions() int {
+	sessions, err := loadSessions(sessionsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+	if len(sessions) == 0 {
+		fmt.Println("no saved sessions (save one with prun [flags] session save <name> [task ...])")
+		return 0
+	}
+	names := slices.Sorted(maps.Keys(sessions))
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name)+1)
+	}
+	for _, name := range names {
+		fmt.Printf("%-*s  %s\n", width, "@"+name, sessions[name].commandLine())
+	}
+	return 0
+}
+
+// commandLine returns the prun command the session replays
+func (s Session) commandLine() string {
+	args := []string{"prun"}
+	if s.Config != "" && s.Config != "prun.toml" {
+		args = append(args, "-c", s.Config)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Flags)) {
+		dash := "--"
+		if len(name) == 1 {
+			dash = "-"
+		}
+		switch v := s.Flags[name].(type) {
+		case bool:
+			if v {
+				args = append(args, dash+name)
+			} else {
+				args = append(args, dash+name+"=false")
+			}
+		case []any:
+			for _, item := range v {
+				args = append(args, dash+name, fmt.Sprint(item))
+			}
+		case []string:
+			for _, item := range v {
+				args = append(args, dash+name, item)
+			}
+		case map[string]any:
+			for _, key := range slices.Sorted(maps.Keys(v)) {
+				args = append(args, dash+name, fmt.Sprintf("%s=%v", key, v[key]))
+			}
+		case map[string]string:
+			for _, key := range slices.Sorted(maps.Keys(v)) {
+				args = append(args, dash+name, key+"="+v[key])
+			}
+		default:
+			args = append(args, dash+name, fmt.Sprint(v))
+		}
+	}
+	return config.QuoteArgs(append(args, s.Tasks...))
+}
+
+// sessionReplay is a session being replayed by `prun @<name>` or
+// `prun session run <name>`
+type sessionReplay struct {
+	name  string
+	tasks []string
+	saved bool // the tasks are the session's, not given on the command line
+}
+
+// replaySession applies the session named by args, if they start with
+// @<name> or `session run <name>`, and returns nil otherwise. Flags after
+// the name are parsed too, and flags given on the command line win over the
+// saved ones; for --env, key by key. Tasks after the name replace the
+// session's. A saved flag prun no longer knows is skipped with a warning.
+func replaySession(args []string, console *runner.Console) (*sessionReplay, error) {
+	var name string
+	switch {
+	case len(args) > 0 && strings.HasPrefix(args[0], "@"):
+		name, args = args[0][1:], args[1:]
+	case len(args) > 1 && args[0] == "session" && args[1] == "run":
+		if len(args) < 3 {
+			return nil, errors.New("session run needs a session name")
+		}
+		name, args = args[2], args[3:]
+	default:
+		return nil, nil
+	}
+
+	sessions, err := loadSessions(sessionsFile)
+	if err != nil {
+		return nil, err
+	}
+	session, ok := sessions[name]
+	if !ok {
+		if len(sessions) == 0 {
+			return nil, fmt.Errorf("no session named '%s', and none saved", name)
+		}
+		return nil, fmt.Errorf("no session named '%s' (saved: %s)", name, strings.Join(slices.Sorted(maps.Keys(sessions)), ", "))
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return nil, err
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[canonicalFlag(f.Name)] = true
+	})
+	for _, flagName := range slices.Sorted(maps.Keys(session.Flags)) {
+		if given[flagName] && !isMapFlag(flagName) {
+			continue
+		}
+		if err := setSessionFlag(flagName, session.Flags[flagName]); err != nil {
+			console.Warnf("session '%s': skipping saved flag %s: %v", name, flagName, err)
+		}
+	}
+	if !given["c"] && session.Config != "" {
+		flag.Set("c", session.Config)
+	}
+
+	replay := &sessionReplay{name: name, tasks: flag.Args()}
+	if len(replay.tasks) == 0 {
+		replay.tasks, replay.saved = session.Tasks, true
+	}
+	return replay, nil
+}
+
+// isMapFlag reports whether a flag collects KEY=VALUE pairs, which a replay
+// merges with those given on the command line
+func isMapFlag(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+	_, ok := f.Value.(flag.Getter).Get().(map[string]string)
+	return ok
+}
+
+// setSessionFlag sets a flag to the value a session saved for it, checking
+// that the value has the type the flag takes
+func setSessionFlag(name string, value any) error {
+	f := flag.Lookup(name)
+	if f == nil {
+		return errors.New("no such flag")
+	}
+	current := f.Value.(flag.Getter).Get()
+	switch v := value.(type) {
+	case bool:
+		if _, ok := current.(bool); !ok {
+			return fmt.Errorf("expected %T, got a boolean", current)
+		}
+		return f.Value.Set(strconv.FormatBool(v))
+	case int64:
+		if _, ok := current.(int); !ok {
+			return fmt.Errorf("expected %T, got an integer", current)
+		}
+		return f.Value.Set(strconv.FormatInt(v, 10))
+	case string:
+		switch current.(type) {
+		case string, time.Duration:
+			return f.Value.Set(v)
+		}
+		return fmt.Errorf("expected %T, got a string", current)
+	case []any:
+		if _, ok := current.([]string); !ok {
+			return fmt.Errorf("expected %T, got an array", current)
+		}
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected an array of strings, got %T in it", item)
+			}
+			if err := f.Value.Set(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		existing, ok := current.(map[string]string)
+		if !ok {
+			return fmt.Errorf("expected %T, got a table", current)
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			s, ok := v[key].(string)
+			if !ok {
+				return fmt.Errorf("expected a table of strings, got %T for %s", v[key], key)
+			}
+			if _, set := existing[key]; set {
+				continue
+			}
+			if err := f.Value.Set(key + "=" + s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported value of type %T", value)
+}
+
+// keepDefined drops the session's saved tasks that the config no longer
+// selects, with a warning, and fails if none are left. Tasks given on the
+// command line are left to the usual checks.
+func (r *sessionReplay) keepDefined(cfg *config.Config, profile, order string, console *runner.Console) ([]string, error) {
+	if !r.saved || len(r.tasks) == 0 {
+		return r.tasks, nil
+	}
+	var kept []string
+	for _, task := range r.tasks {
+		if _, _, err := cfg.GetTasksToRun([]string{task}, profile, order); err != nil {
+			console.Warnf("session '%s': skipping %v", r.name, err)
+			continue
+		}
+		kept = append(kept, task)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("none of the tasks of session '%s' exist anymore", r.name)
+	}
+	return kept, nil
+}
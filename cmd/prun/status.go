@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"prun/internal/config"
+	"prun/internal/runner"
+	"prun/internal/theme"
+	"prun/internal/web"
+)
+
+// exitCodeNotRunning is returned by `prun status` when no instance answers
+const exitCodeNotRunning = 4
+
+// runStatus implements `prun status`: it asks a prun started with --web for
+// its task statuses and returns 0 only if every task is running or finished
+// successfully
+func runStatus(configPath string, args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	addr := fs.String("web", "", "address the running prun serves its web UI on")
+	asJSON := fs.Bool("json", false, "print the statuses as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeRunFailed
+	}
+	if *addr == "" {
+		fmt.Fprintln(os.Stderr, "prun: status needs the address given to --web, e.g. prun status --web :7777")
+		return exitCodeRunFailed
+	}
+
+	// The token and glyphs come from the same config the running instance uses
+	var token string
+	icons := theme.Default()
+	if cfg, err := config.LoadWithOptions(configPath, config.LoadOptions{Optional: true}); err == nil {
+		token = cfg.Web.Token
+		icons = cfg.UI.Theme()
+	}
+
+	statuses, err := fetchStatus(*addr, token)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		fmt.Fprintf(os.Stderr, "prun: not running at %s: %v\n", *addr, urlErr.Err)
+		return exitCodeNotRunning
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: status: %v\n", err)
+		return exitCodeRunFailed
+	}
+
+	healthy := true
+	for _, st := range statuses {
+		if !isHealthy(st) {
+			healthy = false
+		}
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"healthy": healthy,
+			"tasks":   statuses,
+		}, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, st := range statuses {
+			detail := ""
+			if st.Status == runner.StatusFailed {
+				detail = fmt.Sprintf("exit %d", st.ExitCode)
+			}
+			if st.Allowed {
+				detail += " (allowed)"
+			}
+			if st.Processes > 0 {
+				detail = fmt.Sprintf("%d processes, %d fds", st.Processes, st.FDs)
+			}
+			fmt.Fprintf(tw, "%s %s\t%s\t%s\n", icons.Padded(st.Status), st.Name, st.Status, detail)
+		}
+		tw.Flush()
+	}
+
+	if !healthy {
+		return exitCodeRunFailed
+	}
+	return 0
+}
+
+// isHealthy reports whether a task counts as up for `prun status`; one
+// with allow_failure may have failed
+func isHealthy(st web.TaskStatus) bool {
+	return runner.IsRunning(st.Status) || st.Status == runner.StatusDone || st.Allowed
+}
+
+// fetchStatus queries the /api/status endpoint of a running prun
+func fetchStatus(addr, token string) ([]web.TaskStatus, error) {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/api/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	var statuses []web.TaskStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"prun/internal/config"
+	"prun/internal/runner"
+)
+
+// runWatchPlan implements `prun watch-plan`: it prints what watch mode would
+// watch for each task and how it would react, or with --explain whether a
+// change to a path would restart anything. profile and watchAll are -p and
+// -w, given before the subcommand.
+func runWatchPlan(configPath, profile string, watchAll bool, args []string) int {
+	fs := flag.NewFlagSet("watch-plan", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the plan or verdicts as JSON")
+	explain := fs.String("explain", "", "say whether a change to this path would restart each task, and why")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeRunFailed
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %s: %v\n", configPath, err)
+		if os.IsNotExist(err) {
+			return exitCodeConfigNotFound
+		}
+		return exitCodeParseFailed
+	}
+	tasks, _, err := cfg.GetTasksToRun(fs.Args(), profile, config.OrderArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+
+	if *explain == "" {
+		plan := runner.PlanWatch(cfg, tasks, watchAll)
+		if *asJSON {
+			return printJSON(plan)
+		}
+		printWatchPlan(plan)
+		return 0
+	}
+
+	verdicts, err := runner.ExplainChange(cfg, tasks, watchAll, *explain)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+	if *asJSON {
+		printJSON(verdicts)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, v := range verdicts {
+			verdict := "no"
+			if v.Restarts {
+				verdict = "restarts"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Task, verdict, v.Reason)
+		}
+		tw.Flush()
+	}
+
+	// Scripts can tell whether the change would do anything
+	for _, v := range verdicts {
+		if v.Restarts {
+			return 0
+		}
+	}
+	return exitCodeRunFailed
+}
+
+// printWatchPlan prints a watch plan as text, the settings shared by every
+// task first
+func printWatchPlan(plan runner.WatchPlan) {
+	debounce := plan.Debounce
+	if plan.AdaptiveDebounce {
+		debounce += ", widened for files saved in bursts"
+	}
+	fmt.Printf("debounce: %s\n", debounce)
+	if plan.ConfirmAbove > 0 {
+		fmt.Printf("confirm_above: %d\n", plan.ConfirmAbove)
+	}
+	fmt.Printf("skipped directories: %s\n", strings.Join(plan.SkippedDirs, ", "))
+	if len(plan.Exclude) > 0 {
+		fmt.Printf("exclude ([watch], before each task's watch_exclude): %s\n", strings.Join(plan.Exclude, ", "))
+	}
+	if len(plan.ConfigFiles) > 0 {
+		fmt.Printf("config files (watch_config): %s\n", strings.Join(plan.ConfigFiles, ", "))
+	}
+
+	for _, tp := range plan.Tasks {
+		fmt.Println()
+		if !tp.Watched {
+			fmt.Printf("%s: not watched\n", tp.Task)
+			continue
+		}
+		fmt.Printf("%s: watched\n", tp.Task)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, field := range []struct {
+			name   string
+			values []string
+		}{
+			{"roots", tp.Roots},
+			{"problems", tp.Problems},
+			{"watch_include", tp.Include},
+			{"watch_exclude", tp.Exclude},
+			{"env_file", tp.EnvFiles},
+			{"restart", []string{tp.Restart}},
+			{"shutdown_timeout", []string{tp.ShutdownTimeout}},
+		} {
+			if len(field.values) > 0 {
+				fmt.Fprintf(tw, "  %s:\t%s\n", field.name, strings.Join(field.values, ", "))
+			}
+		}
+		tw.Flush()
+	}
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+		return exitCodeRunFailed
+	}
+	return 0
+}
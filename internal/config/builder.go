@@ -0,0 +1,320 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"prun/internal/theme"
+
+	"github.com/BurntSushi/toml"
+)
+
+// RestartPolicy is a task's restart setting. TOML may give it as a string
+// or, as a shorthand, a boolean: true is "always" and false is "never".
+type RestartPolicy string
+
+// Restart policies. An unset policy is RestartNever.
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartAlways    RestartPolicy = "always"
+)
+
+// After reports whether a task under the policy is restarted once it exits,
+// having failed or not
+func (p RestartPolicy) After(failed bool) bool {
+	return p == RestartAlways || (p == RestartOnFailure && failed)
+}
+
+// UnmarshalTOML implements toml.Unmarshaler
+func (p *RestartPolicy) UnmarshalTOML(v interface{}) error {
+	switch val := v.(type) {
+	case string:
+		*p = RestartPolicy(val)
+	case bool:
+		*p = "never"
+		if val {
+			*p = "always"
+		}
+	default:
+		return fmt.Errorf("expected a string or boolean for restart, got %T", v)
+	}
+	return nil
+}
+
+// NewConfig returns an empty config, for building one in code with AddTask
+// instead of loading it from a file
+func NewConfig() *Config {
+	return &Config{TaskDefs: make(map[string]TaskDef)}
+}
+
+// AddTask defines a task and appends it to the tasks list. The task is
+// checked on its own as it is added; checks across tasks are left to
+// Validate.
+func (c *Config) AddTask(name string, task TaskDef) error {
+	if name == "" {
+		return fmt.Errorf("task name must not be empty")
+	}
+	if _, exists := c.TaskDefs[name]; exists {
+		return fmt.Errorf("task '%s' already defined", name)
+	}
+	if err := validateTask(name, task); err != nil {
+		return err
+	}
+	if c.TaskDefs == nil {
+		c.TaskDefs = make(map[string]TaskDef)
+	}
+	c.TaskDefs[name] = task
+	c.Tasks = append(c.Tasks, name)
+	return nil
+}
+
+// Validate checks the config for everything Load rejects. Problems that
+// don't stop prun are collected in Warnings.
+func (c *Config) Validate() error {
+	for _, taskName := range c.Tasks {
+		// Included tasks with replicas are already expanded
+		if _, exists := c.TaskDefs[taskName]; !exists && c.replicas[taskName] == nil {
+			return fmt.Errorf("task '%s' listed but not defined", taskName)
+		}
+	}
+
+	for name, task := range c.TaskDefs {
+		if name == SystemTask {
+			return fmt.Errorf("task name '%s' is reserved for prun's own messages", name)
+		}
+		if err := validateTask(name, task); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateTaskRefs(); err != nil {
+		return err
+	}
+
+	if err := c.validateDepends(); err != nil {
+		return err
+	}
+
+	if err := c.validateGroups(); err != nil {
+		return err
+	}
+
+	if err := c.validateProfiles(); err != nil {
+		return err
+	}
+
+	if err := validateRender("global", c.Render); err != nil {
+		return err
+	}
+
+	if _, err := SplitArgs(c.Wrapper); err != nil {
+		return fmt.Errorf("invalid wrapper: %w", err)
+	}
+
+	if err := c.validateSignals(); err != nil {
+		return err
+	}
+
+	if err := c.validateSecretEnv(); err != nil {
+		return err
+	}
+
+	if c.PrefixMaxWidth != 0 && c.PrefixMaxWidth < MinPrefixWidth {
+		return fmt.Errorf("invalid prefix_max_width %d (must be at least %d)", c.PrefixMaxWidth, MinPrefixWidth)
+	}
+
+	if c.Watch.ConfirmAbove < 0 {
+		return fmt.Errorf("invalid [watch] confirm_above %d", c.Watch.ConfirmAbove)
+	}
+	if c.Watch.Debounce.Duration < 0 {
+		return fmt.Errorf("invalid [watch] debounce %s", c.Watch.Debounce.Duration)
+	}
+	for _, pattern := range c.Watch.Exclude {
+		if !validGlob(pattern) {
+			return fmt.Errorf("invalid [watch] exclude pattern '%s'", pattern)
+		}
+	}
+
+	if _, err := theme.New(c.UI.IconSet, c.UI.Icons); err != nil {
+		return err
+	}
+
+	c.Warnings = c.restartWarnings()
+	return c.checkConflicts()
+}
+
+// restartWarnings warns of tasks with a restart_delay but no restart policy,
+// which never restart for the delay to apply to. Replicas share one warning.
+func (c *Config) restartWarnings() []string {
+	var warnings []string
+	warned := make(map[string]bool)
+	for _, name := range slices.Sorted(maps.Keys(c.TaskDefs)) {
+		task := c.TaskDefs[name]
+		if task.RestartDelay.Duration <= 0 || task.Restart.After(true) {
+			continue
+		}
+		if task.Replica != nil {
+			name = task.Replica.Of
+		}
+		if !warned[name] {
+			warned[name] = true
+			warnings = append(warnings, fmt.Sprintf("task '%s' sets restart_delay without restart, so it never restarts", name))
+		}
+	}
+	return warnings
+}
+
+// validateTask checks the fields of one task definition
+func validateTask(name string, task TaskDef) error {
+	if err := validateSteps(name, task); err != nil {
+		return err
+	}
+	if task.Shell != nil && !*task.Shell {
+		for _, cmd := range task.Commands() {
+			if len(TaskRefs(cmd)) > 0 {
+				return fmt.Errorf("task '%s' uses {task:...} with shell = false, which needs the shell", name)
+			}
+			args, err := SplitArgs(cmd)
+			if err != nil {
+				return fmt.Errorf("task '%s' has an invalid cmd for shell = false: %w", name, err)
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("task '%s' has an empty cmd", name)
+			}
+		}
+	}
+	switch task.LogFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("task '%s' has invalid log_format '%s' (expected text or json)", name, task.LogFormat)
+	}
+	switch task.Restart {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("task '%s' has invalid restart '%s' (expected on-failure, always or never)", name, task.Restart)
+	}
+	if task.MaxRestarts < 0 {
+		return fmt.Errorf("task '%s' has invalid max_restarts %d", name, task.MaxRestarts)
+	}
+	if task.AutoStopAfter.Duration < 0 || task.AutoStopWhenIdle.Duration < 0 {
+		return fmt.Errorf("task '%s' has a negative auto_stop_after or auto_stop_when_idle", name)
+	}
+	if task.Timeout.Duration < 0 {
+		return fmt.Errorf("task '%s' has invalid timeout %s", name, task.Timeout.Duration)
+	}
+	if task.ShutdownTimeout.Duration < 0 {
+		return fmt.Errorf("task '%s' has invalid shutdown_timeout %s", name, task.ShutdownTimeout.Duration)
+	}
+	if err := validateWatch(name, task); err != nil {
+		return err
+	}
+	if err := validateReady(name, task.Ready); err != nil {
+		return err
+	}
+	if err := validateRender(fmt.Sprintf("task '%s'", name), task.Render); err != nil {
+		return err
+	}
+	if task.Replicas < 0 {
+		return fmt.Errorf("task '%s' has invalid replicas %d", name, task.Replicas)
+	}
+	if task.MaxFDs < 0 || task.MaxChildren < 0 {
+		return fmt.Errorf("task '%s' has a negative max_fds or max_children", name)
+	}
+	if task.LogKeepRuns < 0 {
+		return fmt.Errorf("task '%s' has invalid log_keep_runs %d", name, task.LogKeepRuns)
+	}
+	if task.LogMaxBackups < 0 {
+		return fmt.Errorf("task '%s' has invalid log_max_backups %d", name, task.LogMaxBackups)
+	}
+	if task.LogFilePerRun && task.LogFile == "" {
+		return fmt.Errorf("task '%s' sets log_file_per_run without a log_file directory", name)
+	}
+	if task.Wrapper != nil {
+		if _, err := SplitArgs(*task.Wrapper); err != nil {
+			return fmt.Errorf("task '%s' has invalid wrapper: %w", name, err)
+		}
+	}
+	return validateProcess(name, task)
+}
+
+// SetDefaults fills in the values prun assumes for fields left unset
+func (c *Config) SetDefaults() {
+	for name, task := range c.TaskDefs {
+		if task.LogFields.Level == "" {
+			task.LogFields.Level = "level"
+		}
+		if task.LogFields.Msg == "" {
+			task.LogFields.Msg = "msg"
+		}
+		if task.LogFields.Time == "" {
+			task.LogFields.Time = "time"
+		}
+		c.TaskDefs[name] = task
+	}
+}
+
+// WriteTOML writes the config as a prun.toml that Load reads back to the
+// same config. Tasks with replicas are written once, under their own name.
+// Included tasks are left to their remote_config, and the values Load
+// interpolates have each $ written as $$, so they read back as they are.
+func (c *Config) WriteTOML(w io.Writer) error {
+	out := *c
+	out.Tasks = nil
+	out.Env = escapeDollars(c.Env)
+	out.TaskDefs = make(map[string]TaskDef, len(c.TaskDefs))
+	for _, name := range c.Tasks {
+		if task, ok := c.TaskDefs[name]; ok && task.Replica != nil {
+			name = task.Replica.Of
+		}
+		if !slices.Contains(out.Tasks, name) {
+			out.Tasks = append(out.Tasks, name)
+		}
+	}
+	for name, task := range c.TaskDefs {
+		if c.remotes[name] != nil {
+			continue
+		}
+		if task.Replica != nil {
+			name = task.Replica.Of
+			task.Replica = nil
+		}
+		task.Cmd = strings.ReplaceAll(task.Cmd, "$", "$$")
+		task.Path = strings.ReplaceAll(task.Path, "$", "$$")
+		task.Steps = slices.Clone(task.Steps)
+		for i := range task.Steps {
+			task.Steps[i].Cmd = strings.ReplaceAll(task.Steps[i].Cmd, "$", "$$")
+		}
+		task.Env = escapeDollars(task.Env)
+		out.TaskDefs[name] = task
+	}
+
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+
+	// Separate tables by a blank line. Strings are encoded on one line, so
+	// a line starting with [ is always a table header.
+	body := strings.ReplaceAll(buf.String(), "\n[", "\n\n[")
+	_, err := fmt.Fprintf(w, "# prun configuration\n# Tasks run in the order of `tasks` and are defined under [task.<name>].\n\n%s", body)
+	return err
+}
+
+// escapeDollars returns a copy of env with each $ in its values doubled
+func escapeDollars(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	escaped := make(map[string]string, len(env))
+	for key, value := range env {
+		escaped[key] = strings.ReplaceAll(value, "$", "$$")
+	}
+	return escaped
+}
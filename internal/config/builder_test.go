@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exampleConfigs are the configs of the integration tests
+func exampleConfigs(t testing.TB) []string {
+	paths, err := filepath.Glob("../../tests/*.toml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no configs in tests/: %v", err)
+	}
+	return paths
+}
+
+// roundTrip writes cfg next to the config it was loaded from, loads it back
+// and returns both writes and the reloaded config
+func roundTrip(t testing.TB, cfg *Config, dir string) ([]byte, []byte, *Config) {
+	t.Helper()
+	var first bytes.Buffer
+	if err := cfg.WriteTOML(&first); err != nil {
+		t.Fatalf("WriteTOML: %v", err)
+	}
+	file, err := os.CreateTemp(dir, "roundtrip-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(first.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	reloaded, err := Load(file.Name())
+	if err != nil {
+		t.Fatalf("loading the written config: %v\n%s", err, first.Bytes())
+	}
+	var second bytes.Buffer
+	if err := reloaded.WriteTOML(&second); err != nil {
+		t.Fatalf("WriteTOML of the reloaded config: %v", err)
+	}
+	return first.Bytes(), second.Bytes(), reloaded
+}
+
+func TestWriteTOMLRoundTrip(t *testing.T) {
+	for _, path := range exampleConfigs(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			cfg, err := Load(path)
+			if err != nil {
+				t.Skipf("doesn't load: %v", err)
+			}
+			first, second, reloaded := roundTrip(t, cfg, filepath.Dir(path))
+			if !bytes.Equal(first, second) {
+				t.Errorf("written differently after reloading:\n%s\n---\n%s", first, second)
+			}
+			if !reflect.DeepEqual(cfg.Tasks, reloaded.Tasks) {
+				t.Errorf("tasks = %v after reloading, want %v", reloaded.Tasks, cfg.Tasks)
+			}
+			if !reflect.DeepEqual(cfg.Env, reloaded.Env) {
+				t.Errorf("env = %v after reloading, want %v", reloaded.Env, cfg.Env)
+			}
+			for name, task := range cfg.TaskDefs {
+				if got := reloaded.TaskDefs[name]; got.Cmd != task.Cmd || !reflect.DeepEqual(got.Env, task.Env) {
+					t.Errorf("task %s = %q %v after reloading, want %q %v", name, got.Cmd, got.Env, task.Cmd, task.Env)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteTOMLKeepsDollars(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prun.toml")
+	text := `tasks = ["app"]
+
+[env]
+X = "$$HOME"
+
+[task.app]
+cmd = "echo $$$${X} $HOME"
+env = { Y = "$${X}" }
+`
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, reloaded := roundTrip(t, cfg, dir)
+	if got := reloaded.Env["X"]; got != "$HOME" {
+		t.Errorf("X = %q, want $HOME", got)
+	}
+	if got := reloaded.TaskDefs["app"].Env["Y"]; got != "${X}" {
+		t.Errorf("Y = %q, want ${X}", got)
+	}
+	if got := reloaded.TaskDefs["app"].Cmd; got != "echo $${X} $HOME" {
+		t.Errorf("cmd = %q, want %q", got, "echo $${X} $HOME")
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	cfg := NewConfig()
+	if err := cfg.AddTask("api", TaskDef{Cmd: "go run ./api", Restart: RestartOnFailure}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.AddTask("web", TaskDef{Cmd: "npm run dev", DependsOn: StringList{"api"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.AddTask("api", TaskDef{Cmd: "true"}); err == nil {
+		t.Error("adding a task twice succeeded")
+	}
+	if err := cfg.AddTask("bad", TaskDef{Cmd: "true", Restart: "sometimes"}); err == nil {
+		t.Error("adding a task with an invalid restart succeeded")
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	_, _, reloaded := roundTrip(t, cfg, t.TempDir())
+	if !reflect.DeepEqual(reloaded.Tasks, []string{"api", "web"}) {
+		t.Errorf("tasks = %v, want [api web]", reloaded.Tasks)
+	}
+	if api := reloaded.TaskDefs["api"]; api.Cmd != "go run ./api" || api.Restart != RestartOnFailure {
+		t.Errorf("api = %+v", api)
+	}
+	if web := reloaded.TaskDefs["web"]; !reflect.DeepEqual(web.DependsOn, StringList{"api"}) {
+		t.Errorf("web depends on %v, want [api]", web.DependsOn)
+	}
+}
+
+func FuzzWriteTOMLRoundTrip(f *testing.F) {
+	for _, path := range exampleConfigs(f) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "prun.toml")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			return
+		}
+		first, second, _ := roundTrip(t, cfg, dir)
+		if !bytes.Equal(first, second) {
+			t.Errorf("written differently after reloading:\n%s\n---\n%s", first, second)
+		}
+	})
+}
+
+func TestRestartDelayWithoutRestartWarns(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  string
+		warns bool
+	}{
+		{"no restart", `restart_delay = "2s"`, true},
+		{"restart never", "restart = \"never\"\nrestart_delay = \"2s\"", true},
+		{"restart on-failure", "restart = \"on-failure\"\nrestart_delay = \"2s\"", false},
+		{"no delay", ``, false},
+		{"replicas", "replicas = 3\nrestart_delay = \"2s\"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "prun.toml")
+			text := "tasks = [\"web\"]\n\n[task.web]\ncmd = \"true\"\n" + tt.task + "\n"
+			if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case !tt.warns && len(cfg.Warnings) > 0:
+				t.Errorf("warnings = %q, want none", cfg.Warnings)
+			case tt.warns && (len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "task 'web' sets restart_delay without restart")):
+				t.Errorf("warnings = %q, want one about web's restart_delay", cfg.Warnings)
+			}
+		})
+	}
+}
@@ -1,32 +1,300 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prun/internal/theme"
 
 	"github.com/BurntSushi/toml"
 )
 
 // Config represents the prun.toml configuration
 type Config struct {
-	Tasks    []string           `toml:"tasks"`
-	TaskDefs map[string]TaskDef `toml:"task"`
+	Tasks    []string           `toml:"tasks,omitempty"`
+	TaskDefs map[string]TaskDef `toml:"task,omitempty"`
+	UI       UIConfig           `toml:"ui,omitempty"`
+	Web      WebConfig          `toml:"web,omitempty"`
+	Markers  StreamMarkers      `toml:"stream_markers,omitempty"`
+	Watch    WatchSettings      `toml:"watch,omitempty"`
+	Wrapper  string             `toml:"wrapper,omitempty"`  // command prefixed to every task
+	Env      map[string]string  `toml:"env,omitempty"`      // environment shared by all tasks
+	EnvFile  StringList         `toml:"env_file,omitempty"` // dotenv files shared by all tasks
+
+	ImportNpm  bool       `toml:"import_npm,omitempty"`  // expose package.json scripts as tasks
+	NpmScripts StringList `toml:"npm_scripts,omitempty"` // scripts to import; all if empty
+
+	WatchConfig bool `toml:"watch_config,omitempty"` // reload and reconcile tasks when this file changes
+
+	Simulate map[string]SimulateDef `toml:"simulate,omitempty"` // fake runs for `prun check --simulate`
+
+	// Render writes files from templates with the ports and environment of
+	// the tasks, for tools outside prun
+	Render []RenderFile `toml:"render,omitempty"`
+
+	// Groups name sets of tasks, run together as prun <group>. Members are
+	// task names or other groups.
+	Groups map[string]StringList `toml:"groups,omitempty"`
+
+	// Profiles name the sets of tasks selected with prun -p <profile>, where
+	// the tasks on the command line only narrow the profile down. Members
+	// are task names or groups.
+	Profiles map[string]StringList `toml:"profiles,omitempty"`
+
+	// Remotes include the tasks of other configs, named <namespace>:<task>
+	Remotes map[string]RemoteConfig `toml:"remote_config,omitempty"`
+
+	ShutdownSignals StringList `toml:"shutdown_signals"`          // signals that stop prun; SIGINT and SIGTERM if unset
+	ForwardSignals  StringList `toml:"forward_signals,omitempty"` // signals passed on to running tasks
+
+	Hints          *bool      `toml:"hints,omitempty"`           // suggest fixes for familiar failures; on if unset
+	PrefixMaxWidth int        `toml:"prefix_max_width,omitzero"` // widest [task] prefix; 0 means DefaultPrefixMaxWidth
+	SecretEnv      StringList `toml:"secret_env,omitempty"`      // name patterns of variables whose values are masked; DefaultSecretEnv if unset
+
+	// Warnings are problems found while loading that do not stop prun
+	Warnings []string `toml:"-"`
+
+	// Notices are things worth knowing about the config that are most
+	// likely intended, shown only with --verbose
+	Notices []string `toml:"-"`
+
+	// Overrides are environment variables set on the command line (--env).
+	// They take precedence over everything in the config.
+	Overrides map[string]string `toml:"-"`
+
+	// LogDir is the absolute directory given with --log-dir, where tasks
+	// without a log_file get one named after them
+	LogDir string `toml:"-"`
+
+	path        string                       // config file this was loaded from
+	opts        LoadOptions                  // options it was loaded with, reused by Reload
+	dir         string                       // directory containing the config file
+	replicas    map[string][]string          // task with replicas -> replica names
+	imported    map[string]bool              // tasks synthesized from package.json scripts
+	logFiles    map[string][]string          // resolved log_file path -> tasks writing to it
+	fileEnv     map[string]string            // variables loaded from the global env_file
+	taskFileEnv map[string]map[string]string // variables loaded from each task's env_file
+	raw         *uninterpolated              // values before ${VAR} interpolation, nil if there was none
+
+	included      []*remote                    // configs included by remote_config, by namespace
+	remotes       map[string]*remote           // included task -> config it came from
+	remoteFileEnv map[string]map[string]string // variables loaded from each included config's env_file
+}
+
+// UIConfig holds settings for the interactive TUI
+type UIConfig struct {
+	CompactList bool `toml:"compact_list,omitempty"` // hide detail lines in the task list
+	NoSpinner   bool `toml:"no_spinner,omitempty"`   // static placeholder for tasks with no output yet
+
+	IconSet string            `toml:"icon_set,omitempty"` // "unicode" or "ascii"; picked by the locale if unset
+	Icons   map[string]string `toml:"icons,omitempty"`    // glyph overrides by status
+}
+
+// Theme returns the status glyphs chosen by icon_set and [ui.icons], or the
+// default set if they are invalid (Validate reports why)
+func (u UIConfig) Theme() theme.Icons {
+	icons, err := theme.New(u.IconSet, u.Icons)
+	if err != nil {
+		return theme.Default()
+	}
+	return icons
+}
+
+// WatchSettings tune how watch mode restarts tasks
+type WatchSettings struct {
+	ConfirmAbove int       `toml:"confirm_above,omitempty"` // ask before one change restarts more tasks than this; 0 never asks
+	AutoConfirm  Duration  `toml:"auto_confirm,omitempty"`  // without the TUI, restart anyway after this long (default 10s)
+	Debounce     Duration  `toml:"debounce,omitempty"`      // wait for changes to settle this long; adapts to editors if unset
+	Rescan       *Duration `toml:"rescan,omitempty"`        // look for changes fsnotify missed this often; DefaultRescan if unset, 0 never
+
+	// Exclude holds watch_exclude patterns for every watched task, matched
+	// before the task's own
+	Exclude StringList `toml:"exclude,omitempty"`
+}
+
+// DefaultRescan is how often watch mode looks for changes it missed events
+// for, unless [watch] rescan says otherwise
+const DefaultRescan = 30 * time.Second
+
+// RescanInterval returns how often watch mode looks for missed changes, or
+// 0 if it doesn't
+func (s WatchSettings) RescanInterval() time.Duration {
+	if s.Rescan == nil {
+		return DefaultRescan
+	}
+	return s.Rescan.Duration
+}
+
+// StreamMarkers tag each output line with the stream it came from, so
+// stderr stands out without relying on color
+type StreamMarkers struct {
+	Enabled bool   `toml:"enabled,omitempty"`
+	Stderr  string `toml:"stderr,omitempty"` // default "!"
+	Stdout  string `toml:"stdout,omitempty"` // default " "
+}
+
+// Marker returns the marker for a line from stderr or stdout, or "" when
+// markers are disabled
+func (m StreamMarkers) Marker(isErr bool) string {
+	if !m.Enabled {
+		return ""
+	}
+	if isErr {
+		if m.Stderr == "" {
+			return "!"
+		}
+		return m.Stderr
+	}
+	if m.Stdout == "" {
+		return " "
+	}
+	return m.Stdout
+}
+
+// WebConfig holds settings for the --web supervision UI
+type WebConfig struct {
+	Token string `toml:"token,omitempty"` // bearer token required by the web UI, if set
 }
 
 // TaskDef represents a single task configuration
 type TaskDef struct {
-	Cmd     string            `toml:"cmd"`
-	Path    string            `toml:"path"`
-	Env     map[string]string `toml:"env"`
-	Restart interface{}       `toml:"restart"` // bool or string
-	Shell   *bool             `toml:"shell"`
-	Watch   bool              `toml:"watch"` // restart on file changes
+	Description string `toml:"description,omitempty"` // what the task is for, shown in the TUI's task details
+
+	Cmd     string            `toml:"cmd,omitempty"`
+	Path    string            `toml:"path,omitempty"`
+	Env     map[string]string `toml:"env,omitempty"`
+	Restart RestartPolicy     `toml:"restart,omitempty"`
+	Shell   *bool             `toml:"shell,omitempty"`
+	Watch   bool              `toml:"watch,omitempty"`    // restart on file changes
+	LogFile string            `toml:"log_file,omitempty"` // append output to this file
+	Wrapper *string           `toml:"wrapper,omitempty"`  // overrides the global wrapper; "" disables it
+	EnvFile StringList        `toml:"env_file,omitempty"` // dotenv files loaded for this task
+
+	// Steps run one after another instead of cmd, stopping at the first
+	// failure. Restarts re-run them from RestartFromStep (1-based).
+	Steps           []Step   `toml:"steps,omitempty"`
+	RestartFromStep int      `toml:"restart_from_step,omitzero"`
+	StepRetries     int      `toml:"step_retries,omitzero"`  // reruns of a failed step, unless it sets its own
+	StepTimeout     Duration `toml:"step_timeout,omitempty"` // longest a step may run, unless it sets its own
+
+	// MaxRestarts bounds the restarts of the restart policy in a row; 0 is
+	// unlimited. A watch restart starts the count over.
+	MaxRestarts int `toml:"max_restarts,omitzero"`
+
+	// RestartDelay is how long every restart of the restart policy waits,
+	// instead of a backoff doubling with each one in a row
+	RestartDelay Duration `toml:"restart_delay,omitempty"`
+
+	// AllowFailure reports the task failing without stopping the other
+	// tasks or failing the run
+	AllowFailure bool `toml:"allow_failure,omitempty"`
+
+	// With watch, WatchPaths narrows what is watched to these directories,
+	// relative to Path, and WatchInclude and WatchExclude to the files
+	// matching their patterns (see MatchGlob), relative to Path as well
+	WatchPaths   StringList `toml:"watch_paths,omitempty"`
+	WatchInclude StringList `toml:"watch_include,omitempty"`
+	WatchExclude StringList `toml:"watch_exclude,omitempty"`
+
+	// DependsOn holds the task back until these tasks exited successfully,
+	// and DependsOnStarted until these started. If one fails, the task is
+	// skipped.
+	DependsOn        StringList `toml:"depends_on,omitempty"`
+	DependsOnStarted StringList `toml:"depends_on_started,omitempty"`
+
+	// Ready tells when the task is ready to serve, and WaitFor holds the
+	// task back until these tasks are. A task that isn't ready in time
+	// fails, and those waiting for it are skipped.
+	Ready   *ReadyCheck `toml:"ready,omitempty"`
+	WaitFor StringList  `toml:"wait_for,omitempty"`
+
+	// StartTimeout fails the task if it produces no output within this time
+	StartTimeout Duration `toml:"start_timeout,omitempty"`
+
+	// Timeout kills the task once a run has lasted this long, and fails it
+	Timeout Duration `toml:"timeout,omitempty"`
+
+	// AutoStopAfter stops the task once a run has lasted this long, and
+	// AutoStopWhenIdle once it has printed nothing for this long. Either
+	// way the task counts as stopped, not failed.
+	AutoStopAfter    Duration `toml:"auto_stop_after,omitempty"`
+	AutoStopWhenIdle Duration `toml:"auto_stop_when_idle,omitempty"`
+
+	// DrainTimeout bounds how long processes left in the task's group after
+	// its main process exits may keep running before they are killed
+	DrainTimeout Duration `toml:"drain_timeout,omitempty"`
+
+	// ShutdownTimeout is how long the task's group has to exit after SIGTERM
+	// when prun stops it, before it is killed
+	ShutdownTimeout Duration `toml:"shutdown_timeout,omitempty"`
+
+	// LogFilePerRun makes log_file a directory holding one file per run,
+	// with current.log pointing at the latest
+	LogFilePerRun bool `toml:"log_file_per_run,omitempty"`
+	LogKeepRuns   int  `toml:"log_keep_runs,omitzero"` // run files kept with log_file_per_run; 0 means DefaultLogKeepRuns
+
+	// LogMaxSize rotates the log file, or each run file, once it reaches
+	// this size, keeping LogMaxBackups older files (DefaultLogMaxBackups if
+	// 0). Tasks sharing a log_file must agree on both.
+	LogMaxSize    Size `toml:"log_max_size,omitempty"`
+	LogMaxBackups int  `toml:"log_max_backups,omitzero"`
+
+	LogFormat string    `toml:"log_format,omitempty"` // "text" (default) or "json"
+	LogFields LogFields `toml:"log_fields,omitempty"` // field names used when log_format is "json"
+
+	Umask   string   `toml:"umask,omitempty"`    // octal file mode creation mask, e.g. "027"
+	PassFDs []PassFD `toml:"pass_fds,omitempty"` // listening sockets opened by prun and inherited by the task
+
+	// Sandbox confines the task's writes and network access; where the
+	// platform can't, the task fails unless SandboxOptional is set
+	Sandbox         *Sandbox `toml:"sandbox,omitempty"`
+	SandboxOptional bool     `toml:"sandbox_optional,omitempty"`
+
+	// Warn when the task's process group holds more open file descriptors
+	// or child processes than this (Linux only)
+	MaxFDs      int `toml:"max_fds,omitzero"`
+	MaxChildren int `toml:"max_children,omitzero"`
+
+	Render []RenderFile `toml:"render,omitempty"` // files written from templates, with this task as .Task
+
+	Port            int  `toml:"port,omitzero"`               // port the task listens on, checked for conflicts
+	AllowSharedPath bool `toml:"allow_shared_path,omitempty"` // don't warn when watched paths overlap
+
+	// Replicas launches this many instances of the task, named <task>-0 …
+	Replicas int      `toml:"replicas,omitzero"`
+	Replica  *Replica `toml:"-"` // set on each instance when replicas > 1
+}
+
+// LogFields names the fields of a structured log line
+type LogFields struct {
+	Level string `toml:"level,omitempty"`
+	Msg   string `toml:"msg,omitempty"`
+	Time  string `toml:"time,omitempty"`
+}
+
+// LoadOptions adjusts how a config is loaded
+type LoadOptions struct {
+	ImportNpm bool // import package.json scripts, as if import_npm = true
+	Optional  bool // treat a missing config file as an empty one
 }
 
 // Load reads and parses the prun.toml file
 func Load(configPath string) (*Config, error) {
+	return LoadWithOptions(configPath, LoadOptions{})
+}
+
+// LoadWithOptions reads and parses the prun.toml file
+func LoadWithOptions(configPath string, opts LoadOptions) (*Config, error) {
 	data, err := os.ReadFile(configPath)
-	if err != nil {
+	if err != nil && !(opts.Optional && os.IsNotExist(err)) {
 		return nil, err
 	}
 
@@ -36,36 +304,431 @@ func Load(configPath string) (*Config, error) {
 	if err := toml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse TOML: %w", err)
 	}
+	cfg.path = configPath
+	cfg.opts = opts
+	cfg.dir = filepath.Dir(configPath)
 
-	// Validate that all tasks in the list have definitions
-	for _, taskName := range cfg.Tasks {
-		if _, exists := cfg.TaskDefs[taskName]; !exists {
-			return nil, fmt.Errorf("task '%s' listed but not defined", taskName)
+	// Synthesize tasks from package.json scripts before validating
+	if cfg.ImportNpm || opts.ImportNpm {
+		if err := cfg.importNpmScripts(cfg.NpmScripts); err != nil {
+			return nil, fmt.Errorf("failed to import npm scripts: %w", err)
 		}
 	}
 
-	// Validate that all task definitions have a cmd
-	for name, task := range cfg.TaskDefs {
-		if task.Cmd == "" {
-			return nil, fmt.Errorf("task '%s' missing required 'cmd' field", name)
+	// Add the tasks of included configs under their namespaces
+	if err := cfg.includeRemotes(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.SetDefaults()
+
+	// Split tasks with replicas into one task per instance
+	if err := cfg.expandReplicas(); err != nil {
+		return nil, err
+	}
+
+	// Load env files once; paths are relative to the config directory
+	if err := cfg.readEnvFiles(); err != nil {
+		return nil, err
+	}
+
+	// Resolve ${VAR} references, which may name variables of the env files
+	if err := cfg.interpolate(); err != nil {
+		return nil, err
+	}
+
+	// Group tasks by log file so shared targets can be written through one writer
+	cfg.logFiles = make(map[string][]string)
+	for name := range cfg.TaskDefs {
+		if path := cfg.LogFilePath(name); path != "" {
+			cfg.logFiles[path] = append(cfg.logFiles[path], name)
 		}
 	}
+	if err := cfg.validateLogRotation(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// Path returns the file the config was loaded from
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Reload loads the config file again with the same options. Command line
+// overrides carry over to the new config.
+func (c *Config) Reload() (*Config, error) {
+	cfg, err := LoadWithOptions(c.path, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Overrides = c.Overrides
+	cfg.LogDir = c.LogDir
+	return cfg, nil
+}
+
+// EnvFilePaths returns the resolved env files a task loads, global ones first
+func (c *Config) EnvFilePaths(taskName string) []string {
+	var paths []string
+	for _, path := range c.EnvFile {
+		paths = append(paths, c.ResolvePath(path))
+	}
+	if r := c.remotes[taskName]; r != nil {
+		paths = append(paths, r.envFile...)
+	}
+	for _, path := range c.TaskDefs[taskName].EnvFile {
+		paths = append(paths, c.ResolvePath(path))
+	}
+	return paths
+}
 
+// ReloadEnvFiles returns a copy of the config with every env file read again,
+// and the ${VAR} references naming their variables resolved anew
+func (c *Config) ReloadEnvFiles() (*Config, error) {
+	cfg := *c
+	if err := cfg.readEnvFiles(); err != nil {
+		return nil, err
+	}
+	if cfg.raw != nil {
+		if err := cfg.interpolate(); err != nil {
+			return nil, err
+		}
+	}
 	return &cfg, nil
 }
 
-// GetTasksToRun returns the list of tasks to run based on config and args
-func (c *Config) GetTasksToRun(args []string) ([]string, error) {
+// readEnvFiles loads the global, included configs' and per-task env files
+func (c *Config) readEnvFiles() error {
+	var err error
+	c.fileEnv, err = c.loadEnvFiles(c.EnvFile)
+	if err != nil {
+		return fmt.Errorf("failed to load env_file: %w", err)
+	}
+	c.remoteFileEnv = make(map[string]map[string]string)
+	for _, r := range c.included {
+		vars, err := c.loadEnvFiles(r.envFile)
+		if err != nil {
+			return fmt.Errorf("remote_config.%s failed to load env_file: %w", r.namespace, err)
+		}
+		c.remoteFileEnv[r.namespace] = vars
+	}
+	c.taskFileEnv = make(map[string]map[string]string)
+	for name, task := range c.TaskDefs {
+		vars, err := c.loadEnvFiles(task.EnvFile)
+		if err != nil {
+			return fmt.Errorf("task '%s' failed to load env_file: %w", name, err)
+		}
+		c.taskFileEnv[name] = vars
+	}
+	return nil
+}
+
+// loadEnvFiles loads and merges dotenv files, later files overriding earlier ones
+func (c *Config) loadEnvFiles(paths []string) (map[string]string, error) {
+	merged := make(map[string]string)
+	for _, path := range paths {
+		vars, err := LoadDotenv(c.ResolvePath(path))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// Instance identifies one run of a task, for its built-in PRUN_* variables
+type Instance struct {
+	Index    int // position among the tasks being run
+	Count    int // number of tasks being run
+	Restarts int // times the task has been restarted
+}
+
+// builtinEnv returns the variables prun sets for every task. A replica's
+// index and count are those among its sibling replicas.
+func (c *Config) builtinEnv(taskName string, inst Instance) map[string]string {
+	if replica := c.TaskDefs[taskName].Replica; replica != nil {
+		inst.Index, inst.Count = replica.Index, replica.Count
+	}
+	return map[string]string{
+		"PRUN_TASK":          taskName,
+		"PRUN_TASK_INDEX":    strconv.Itoa(inst.Index),
+		"PRUN_TASK_COUNT":    strconv.Itoa(inst.Count),
+		"PRUN_RESTART_COUNT": strconv.Itoa(inst.Restarts),
+	}
+}
+
+// TaskEnv returns the environment for a task as KEY=VALUE pairs. Layers are
+// applied in increasing order of precedence:
+//
+//	os env < PRUN_* < global env_file < global env < included config's env_file
+//	       < included config's env < task env_file < task env < Overrides
+//
+// The included config's layers apply only to tasks from a remote_config.
+func (c *Config) TaskEnv(taskName string, inst Instance) []string {
+	env := os.Environ()
+	layers := append([]map[string]string{c.builtinEnv(taskName, inst)}, c.envLayers(taskName)...)
+	for _, layer := range layers {
+		// Sort keys so the resulting environment is deterministic
+		keys := make([]string, 0, len(layer))
+		for k := range layer {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, k+"="+layer[k])
+		}
+	}
+	return env
+}
+
+// envLayers returns the layers of a task's environment that come from the
+// config and the command line, in the order TaskEnv applies them
+func (c *Config) envLayers(taskName string) []map[string]string {
+	var remoteFileEnv, remoteEnv map[string]string
+	if r := c.remotes[taskName]; r != nil {
+		remoteFileEnv, remoteEnv = c.remoteFileEnv[r.namespace], r.env
+	}
+	return []map[string]string{
+		c.fileEnv,
+		c.Env,
+		remoteFileEnv,
+		remoteEnv,
+		c.taskFileEnv[taskName],
+		c.TaskDefs[taskName].Env,
+		c.Overrides,
+	}
+}
+
+// ConfigEnv returns the variables a task's environment gets from the config
+// and the command line, leaving out prun's own and the inherited ones
+func (c *Config) ConfigEnv(taskName string) map[string]string {
+	env := make(map[string]string)
+	for _, layer := range c.envLayers(taskName) {
+		maps.Copy(env, layer)
+	}
+	return env
+}
+
+// ResolvePath returns path made absolute relative to the config file's directory
+func (c *Config) ResolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	abs, err := filepath.Abs(filepath.Join(c.dir, path))
+	if err != nil {
+		return filepath.Join(c.dir, path)
+	}
+	return abs
+}
+
+// WrapperArgs returns the wrapper command prefixed to a task, or nil if none applies
+func (c *Config) WrapperArgs(taskName string) ([]string, error) {
+	wrapper := c.Wrapper
+	if override := c.TaskDefs[taskName].Wrapper; override != nil {
+		wrapper = *override
+	}
+	return SplitArgs(wrapper)
+}
+
+// HintsEnabled reports whether failed tasks get a hint at familiar causes
+func (c *Config) HintsEnabled() bool {
+	return c.Hints == nil || *c.Hints
+}
+
+// SystemTask is the task name reserved for prun's own diagnostics, such as
+// watcher errors and config reload results
+const SystemTask = "prun"
+
+// DefaultPrefixMaxWidth is the widest a task prefix gets when
+// prefix_max_width is unset; longer names are shortened in the middle
+const DefaultPrefixMaxWidth = 24
+
+// MinPrefixWidth is the narrowest prefix_max_width accepted, room for a
+// shortened name's ends around the ellipsis
+const MinPrefixWidth = 5
+
+// PrefixWidth returns the widest a task prefix may be
+func (c *Config) PrefixWidth() int {
+	if c.PrefixMaxWidth > 0 {
+		return c.PrefixMaxWidth
+	}
+	return DefaultPrefixMaxWidth
+}
+
+// DefaultLogKeepRuns is how many run files log_file_per_run keeps by default
+const DefaultLogKeepRuns = 10
+
+// LogFilePath returns the resolved log file for a task, or "" if it has none.
+// With log_file_per_run it is the directory of the run files; replicas get a
+// directory each, named after them. A task without a log_file logs to
+// <task>.log in LogDir if that is set.
+func (c *Config) LogFilePath(taskName string) string {
+	task := c.TaskDefs[taskName]
+	if task.LogFile == "" && c.LogDir != "" {
+		return filepath.Join(c.LogDir, taskName+".log")
+	}
+	path := c.ResolvePath(task.LogFile)
+	if path != "" && task.LogFilePerRun && task.Replica != nil {
+		path = filepath.Join(path, taskName)
+	}
+	return path
+}
+
+// LogPaths returns the absolute paths prun writes task output to: the log
+// file of each task, or its directory of run files with log_file_per_run,
+// and the --log-dir. Watch mode ignores changes under them, so that a task
+// logging inside the tree it watches doesn't restart itself.
+func (c *Config) LogPaths() []string {
+	var paths []string
+	if c.LogDir != "" {
+		paths = append(paths, c.LogDir)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.TaskDefs)) {
+		path := c.LogFilePath(name)
+		if path == "" || slices.Contains(paths, path) {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			paths = append(paths, abs)
+		}
+	}
+	return paths
+}
+
+// DefaultLogMaxBackups is how many rotated files log_max_size keeps by default
+const DefaultLogMaxBackups = 3
+
+// LogRotation returns the size at which a task's log file is rotated, 0 if
+// it never is, and how many rotated files are kept
+func (c *Config) LogRotation(taskName string) (maxSize int64, backups int) {
+	task := c.TaskDefs[taskName]
+	return task.LogMaxSize.Bytes, cmp.Or(task.LogMaxBackups, DefaultLogMaxBackups)
+}
+
+// validateLogRotation checks that the tasks sharing a log file rotate it
+// the same way, since they write it through one writer
+func (c *Config) validateLogRotation() error {
+	for _, path := range slices.Sorted(maps.Keys(c.logFiles)) {
+		tasks := slices.Sorted(slices.Values(c.logFiles[path]))
+		size, backups := c.LogRotation(tasks[0])
+		for _, name := range tasks[1:] {
+			if s, b := c.LogRotation(name); s != size || b != backups {
+				return fmt.Errorf("tasks '%s' and '%s' share log file %s but set different log_max_size or log_max_backups", tasks[0], name, path)
+			}
+		}
+	}
+	return nil
+}
+
+// LogKeepRuns returns how many run files a task with log_file_per_run keeps
+func (c *Config) LogKeepRuns(taskName string) int {
+	if keep := c.TaskDefs[taskName].LogKeepRuns; keep > 0 {
+		return keep
+	}
+	return DefaultLogKeepRuns
+}
+
+// SharesLogFile reports whether a task's log file is also written by other tasks
+func (c *Config) SharesLogFile(taskName string) bool {
+	path := c.LogFilePath(taskName)
+	return path != "" && len(c.logFiles[path]) > 1
+}
+
+// Task orders accepted by GetTasksToRun
+const (
+	OrderArgs   = "args"   // command-line order
+	OrderConfig = "config" // order of the tasks array
+)
+
+// GetTasksToRun returns the list of tasks to run based on config and args.
+// Without args it is the config's tasks array. Args may be globs such as
+// frontend:*, which select every matching task, or [groups], which select
+// their tasks. Otherwise each named task
+// appears once, at its first occurrence, and the names repeated in args are
+// returned as duplicates. With OrderConfig, named tasks are sorted by their
+// position in the tasks array; tasks defined but not listed there follow in
+// command-line order.
+//
+// With a profile, the profile's tasks take the place of the tasks array,
+// and args must select tasks of the profile; a glob selects those of its
+// matches that are.
+func (c *Config) GetTasksToRun(args []string, profile, order string) (tasks, duplicates []string, err error) {
+	switch order {
+	case "", OrderArgs, OrderConfig:
+	default:
+		return nil, nil, fmt.Errorf("invalid order '%s' (expected args or config)", order)
+	}
+
+	var profileTasks []string
+	if profile != "" {
+		if profileTasks, err = c.ProfileTasks(profile); err != nil {
+			return nil, nil, err
+		}
+		if len(args) == 0 {
+			args = profileTasks
+		}
+	}
 	if len(args) == 0 {
-		return c.Tasks, nil
+		return c.Tasks, nil, nil
+	}
+
+	// Validate that all requested tasks exist, dropping repeats. Naming a
+	// task with replicas selects all of them.
+	seen := make(map[string]bool, len(args))
+	implicit := make(map[string]bool) // tasks first selected by a group or glob
+	for _, arg := range args {
+		names := []string{arg}
+		group := c.IsGroup(arg)
+		if group {
+			names = c.GroupTasks(arg)
+		} else if isTaskPattern(arg) {
+			if names, err = c.matchTasks(arg); err != nil {
+				return nil, nil, err
+			}
+			if profile != "" {
+				names = slices.DeleteFunc(c.expandNames(names), func(name string) bool { return !slices.Contains(profileTasks, name) })
+				if len(names) == 0 {
+					return nil, nil, fmt.Errorf("no tasks of profile '%s' match '%s'", profile, arg)
+				}
+			}
+		}
+		for _, taskName := range c.expandNames(names) {
+			if _, exists := c.TaskDefs[taskName]; !exists {
+				return nil, nil, fmt.Errorf("task '%s' not defined in config", taskName)
+			}
+			if profile != "" && !slices.Contains(profileTasks, taskName) {
+				return nil, nil, fmt.Errorf("task '%s' is not in profile '%s' (%s)", taskName, profile, strings.Join(profileTasks, ", "))
+			}
+			if seen[taskName] {
+				// Overlapping globs and groups aren't repeats
+				if !group && !implicit[taskName] && !isTaskPattern(arg) && !slices.Contains(duplicates, taskName) {
+					duplicates = append(duplicates, taskName)
+				}
+				continue
+			}
+			seen[taskName] = true
+			implicit[taskName] = group || isTaskPattern(arg)
+			tasks = append(tasks, taskName)
+		}
 	}
 
-	// Validate that all requested tasks exist
-	for _, taskName := range args {
-		if _, exists := c.TaskDefs[taskName]; !exists {
-			return nil, fmt.Errorf("task '%s' not defined in config", taskName)
+	if order == OrderConfig {
+		position := func(name string) int {
+			if i := slices.Index(c.Tasks, name); i >= 0 {
+				return i
+			}
+			return len(c.Tasks)
 		}
+		slices.SortStableFunc(tasks, func(a, b string) int {
+			return position(a) - position(b)
+		})
 	}
 
-	return args, nil
+	return tasks, duplicates, nil
 }
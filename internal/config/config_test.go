@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTaskEnvPrecedence(t *testing.T) {
+	// Each key is set by one layer and every layer below it, so the layer
+	// it's named after must win
+	dir := t.TempDir()
+	write := func(name, text string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("FROM_OS", "os")
+	t.Setenv("PRUN_TASK", "os")
+	for _, key := range []string{"FROM_GLOBAL_FILE", "FROM_GLOBAL_ENV", "FROM_TASK_FILE", "FROM_TASK_ENV", "FROM_OVERRIDE"} {
+		t.Setenv(key, "os")
+	}
+	write("global.env", `
+FROM_GLOBAL_FILE=global_file
+FROM_GLOBAL_ENV=global_file
+FROM_TASK_FILE=global_file
+FROM_TASK_ENV=global_file
+FROM_OVERRIDE=global_file
+`)
+	write("task.env", `
+FROM_TASK_FILE=task_file
+FROM_TASK_ENV=task_file
+FROM_OVERRIDE=task_file
+`)
+	write("prun.toml", `
+tasks = ["web"]
+env_file = "global.env"
+
+[env]
+FROM_GLOBAL_ENV = "global_env"
+FROM_TASK_FILE = "global_env"
+FROM_TASK_ENV = "global_env"
+FROM_OVERRIDE = "global_env"
+
+[task.web]
+cmd = "true"
+env_file = "task.env"
+env = { FROM_TASK_ENV = "task_env", FROM_OVERRIDE = "task_env" }
+`)
+	cfg, err := Load(filepath.Join(dir, "prun.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Overrides = map[string]string{"FROM_OVERRIDE": "override"}
+
+	// As with exec, the last of a key's entries is the one that counts
+	got := map[string]string{}
+	for _, kv := range cfg.TaskEnv("web", Instance{Count: 1}) {
+		k, v, _ := strings.Cut(kv, "=")
+		got[k] = v
+	}
+	for key, want := range map[string]string{
+		"FROM_OS":          "os",
+		"PRUN_TASK":        "web",
+		"FROM_GLOBAL_FILE": "global_file",
+		"FROM_GLOBAL_ENV":  "global_env",
+		"FROM_TASK_FILE":   "task_file",
+		"FROM_TASK_ENV":    "task_env",
+		"FROM_OVERRIDE":    "override",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %q, want %q", key, got[key], want)
+		}
+	}
+}
+
+func TestGetTasksToRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prun.toml")
+	if err := os.WriteFile(path, []byte(`
+tasks = ["db", "api", "front:app", "front:docs"]
+
+[groups]
+backend = ["db", "api", "worker"]
+
+[profiles]
+test = ["db", "api"]
+
+[task.db]
+cmd = "true"
+
+[task.api]
+cmd = "true"
+
+[task.worker]
+cmd = "true"
+
+[task."front:app"]
+cmd = "true"
+
+[task."front:docs"]
+cmd = "true"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		profile    string
+		order      string
+		excludes   []string
+		want       []string
+		duplicates []string
+		err        string // in the error, if one is expected
+	}{
+		{name: "no args", want: []string{"db", "api", "front:app", "front:docs"}},
+		{name: "args order", args: []string{"api", "db"}, want: []string{"api", "db"}},
+		{name: "args order explicit", args: []string{"api", "db"}, order: OrderArgs, want: []string{"api", "db"}},
+		{name: "config order", args: []string{"api", "db"}, order: OrderConfig, want: []string{"db", "api"}},
+		{name: "config order with unlisted", args: []string{"worker", "front:docs", "db"}, order: OrderConfig, want: []string{"db", "front:docs", "worker"}},
+		{name: "repeats", args: []string{"api", "api", "db", "api", "db"}, want: []string{"api", "db"}, duplicates: []string{"api", "db"}},
+		{name: "glob", args: []string{"front:*"}, want: []string{"front:app", "front:docs"}},
+		{name: "glob then name", args: []string{"front:*", "front:app"}, want: []string{"front:app", "front:docs"}},
+		{name: "name then glob", args: []string{"front:docs", "front:*"}, want: []string{"front:docs", "front:app"}},
+		{name: "group", args: []string{"backend"}, want: []string{"db", "api", "worker"}},
+		{name: "group then member", args: []string{"backend", "api"}, want: []string{"db", "api", "worker"}},
+		{name: "member then group", args: []string{"api", "backend"}, want: []string{"api", "db", "worker"}},
+		{name: "group in config order", args: []string{"front:app", "backend"}, order: OrderConfig, want: []string{"db", "api", "front:app", "worker"}},
+		{name: "profile", profile: "test", want: []string{"db", "api"}},
+		{name: "profile glob", args: []string{"*"}, profile: "test", want: []string{"db", "api"}},
+		{name: "exclude name", excludes: []string{"api"}, want: []string{"db", "front:app", "front:docs"}},
+		{name: "exclude glob", args: []string{"backend", "front:app"}, excludes: []string{"front:*"}, want: []string{"db", "api", "worker"}},
+		{name: "exclude group", excludes: []string{"backend"}, want: []string{"front:app", "front:docs"}},
+		{name: "exclude keeps duplicates", args: []string{"api", "db", "api"}, excludes: []string{"api"}, want: []string{"db"}, duplicates: []string{"api"}},
+		{name: "unknown task", args: []string{"nope"}, err: "task 'nope' not defined"},
+		{name: "glob without matches", args: []string{"back:*"}, err: "no tasks match 'back:*'"},
+		{name: "task outside profile", args: []string{"worker"}, profile: "test", err: "not in profile 'test'"},
+		{name: "unknown order", args: []string{"api"}, order: "random", err: "invalid order 'random'"},
+		{name: "unknown exclude", excludes: []string{"nope"}, err: "cannot exclude 'nope'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, duplicates, err := cfg.GetTasksToRun(tt.args, tt.profile, tt.order)
+			if err == nil && len(tt.excludes) > 0 {
+				got, err = cfg.Exclude(got, tt.excludes)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, %v; want an error about %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) || !slices.Equal(duplicates, tt.duplicates) {
+				t.Errorf("got %v, duplicates %v; want %v, duplicates %v", got, duplicates, tt.want, tt.duplicates)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkConflicts looks for tasks that would get in each other's way. Tasks
+// declaring the same port are an error. Watched tasks with a watch root in
+// common, or one nested in the other's, produce a warning, since a change
+// under it restarts both, unless either task sets allow_shared_path. The
+// roots are the task's watch_paths if it has any, else its path.
+func (c *Config) checkConflicts() error {
+	names := make([]string, 0, len(c.TaskDefs))
+	for name := range c.TaskDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ports := make(map[int]string)
+	for _, name := range names {
+		task := c.TaskDefs[name]
+		if task.Port == 0 {
+			continue
+		}
+		if other, ok := ports[task.Port]; ok && !sameReplicaSet(c.TaskDefs[other], task) {
+			return fmt.Errorf("tasks '%s' and '%s' both declare port %d", other, name, task.Port)
+		}
+		ports[task.Port] = name
+	}
+
+	// Run files are named by their start time, so two tasks can't share a
+	// directory of them
+	runDirs := make(map[string]string)
+	for _, name := range names {
+		path := c.LogFilePath(name)
+		if path == "" {
+			continue
+		}
+		if other, ok := runDirs[path]; ok && (c.TaskDefs[name].LogFilePerRun || c.TaskDefs[other].LogFilePerRun) {
+			return fmt.Errorf("tasks '%s' and '%s' both write to %s, which log_file_per_run needs for itself", other, name, path)
+		}
+		runDirs[path] = name
+	}
+
+	roots := make(map[string][]string)
+	for _, name := range names {
+		task := c.TaskDefs[name]
+		if !task.Watch || task.AllowSharedPath {
+			continue
+		}
+		for _, dir := range task.WatchDirs() {
+			if abs, err := filepath.Abs(dir); err == nil {
+				roots[name] = append(roots[name], abs)
+			}
+		}
+	}
+
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			if len(roots[a]) == 0 || len(roots[b]) == 0 || sameReplicaSet(c.TaskDefs[a], c.TaskDefs[b]) {
+				continue
+			}
+			if warning := sharedRoot(a, b, roots[a], roots[b]); warning != "" {
+				c.Warnings = append(c.Warnings, warning)
+			}
+		}
+	}
+	return nil
+}
+
+// sharedRoot returns the warning about the first watch roots of tasks a and
+// b that are the same or nested, or "" if they have none
+func sharedRoot(a, b string, rootsA, rootsB []string) string {
+	for _, dirA := range rootsA {
+		for _, dirB := range rootsB {
+			switch {
+			case dirA == dirB:
+				return fmt.Sprintf("tasks '%s' and '%s' both watch %s; a change restarts both (set allow_shared_path = true if intended)", a, b, dirA)
+			case isWithin(dirA, dirB), isWithin(dirB, dirA):
+				return fmt.Sprintf("tasks '%s' and '%s' watch nested directories %s and %s; changes in the inner one restart both (set allow_shared_path = true if intended)", a, b, dirA, dirB)
+			}
+		}
+	}
+	return ""
+}
+
+// sameReplicaSet reports whether two tasks are replicas of one definition,
+// which share a path and port by design
+func sameReplicaSet(a, b TaskDef) bool {
+	return a.Replica != nil && b.Replica != nil && a.Replica.Of == b.Replica.Of
+}
+
+// isWithin reports whether path is strictly inside dir
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSharedPathWarnings(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app")
+	tests := []struct {
+		name  string
+		a, b  TaskDef
+		warns string // in the warning, or "" for none
+	}{
+		{
+			name:  "identical path",
+			a:     TaskDef{Cmd: "true", Path: app, Watch: true},
+			b:     TaskDef{Cmd: "true", Path: app, Watch: true},
+			warns: "both watch " + app,
+		},
+		{
+			name:  "nested path",
+			a:     TaskDef{Cmd: "true", Path: app, Watch: true},
+			b:     TaskDef{Cmd: "true", Path: filepath.Join(app, "web"), Watch: true},
+			warns: "watch nested directories",
+		},
+		{
+			name: "disjoint watch_paths",
+			a:    TaskDef{Cmd: "true", Path: app, Watch: true, WatchPaths: StringList{"api"}},
+			b:    TaskDef{Cmd: "true", Path: app, Watch: true, WatchPaths: StringList{"web"}},
+		},
+		{
+			name:  "overlapping watch_paths",
+			a:     TaskDef{Cmd: "true", Path: filepath.Join(dir, "api"), Watch: true, WatchPaths: StringList{"../shared"}},
+			b:     TaskDef{Cmd: "true", Path: filepath.Join(dir, "web"), Watch: true, WatchPaths: StringList{"src", "../shared"}},
+			warns: "both watch " + filepath.Join(dir, "shared"),
+		},
+		{
+			name: "allow_shared_path",
+			a:    TaskDef{Cmd: "true", Path: app, Watch: true, AllowSharedPath: true},
+			b:    TaskDef{Cmd: "true", Path: app, Watch: true},
+		},
+		{
+			name: "not watched",
+			a:    TaskDef{Cmd: "true", Path: app, Watch: true},
+			b:    TaskDef{Cmd: "true", Path: app},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			if err := cfg.AddTask("a", tt.a); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.AddTask("b", tt.b); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			switch {
+			case tt.warns == "" && len(cfg.Warnings) > 0:
+				t.Errorf("warnings = %q, want none", cfg.Warnings)
+			case tt.warns != "" && (len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], tt.warns)):
+				t.Errorf("warnings = %q, want one about %q", cfg.Warnings, tt.warns)
+			}
+		})
+	}
+}
+
+func TestPortConflict(t *testing.T) {
+	cfg := NewConfig()
+	for _, name := range []string{"api", "web"} {
+		if err := cfg.AddTask(name, TaskDef{Cmd: "true", Port: 8080}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "both declare port 8080") {
+		t.Errorf("Validate = %v, want a port conflict", err)
+	}
+}
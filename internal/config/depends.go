@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Dependencies returns what a task waits for before it starts: the tasks
+// that must have exited successfully (depends_on), and those that must only
+// have started (depends_on_started). A task with replicas stands for all of
+// them.
+func (c *Config) Dependencies(name string) (done, started []string) {
+	task := c.TaskDefs[name]
+	return c.expandNames(task.DependsOn), c.expandNames(task.DependsOnStarted)
+}
+
+// validateDepends checks that dependencies, wait_for included, name defined
+// tasks and do not form a cycle
+func (c *Config) validateDepends() error {
+	names := make([]string, 0, len(c.TaskDefs))
+	for name := range c.TaskDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deps := func(name string) []string {
+		done, started := c.Dependencies(name)
+		return slices.Concat(done, started, c.WaitFor(name))
+	}
+	for _, name := range names {
+		for _, dep := range deps(name) {
+			if _, ok := c.TaskDefs[dep]; !ok {
+				return fmt.Errorf("task '%s' depends on '%s', which is not defined", name, dep)
+			}
+		}
+		if err := c.validateWaitFor(name); err != nil {
+			return err
+		}
+	}
+
+	// Walk the dependencies depth first from every task
+	var walk func(name string, path []string) error
+	walk = func(name string, path []string) error {
+		if i := slices.Index(path, name); i >= 0 {
+			return fmt.Errorf("task dependencies form a cycle: %s", strings.Join(append(path[i:], name), " → "))
+		}
+		path = append(path, name)
+		for _, dep := range deps(name) {
+			if err := walk(dep, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := walk(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
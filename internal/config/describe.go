@@ -0,0 +1,116 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Detail is one resolved setting of a task, as shown in the TUI's task
+// details: a name such as "cmd" or "env" and its value. A setting with
+// several values, like env, has one Detail per value.
+type Detail struct {
+	Name  string
+	Value string
+}
+
+// maskedValue stands in for the value of a secret variable
+const maskedValue = "••••••"
+
+// Describe returns the resolved settings of a task, those left at their
+// defaults aside. Paths are absolute, env holds what the config and -e set
+// with secret_env values masked, and watch lists what the task watches.
+func (c *Config) Describe(taskName string) []Detail {
+	task, ok := c.TaskDefs[taskName]
+	if !ok {
+		return nil
+	}
+	var details []Detail
+	add := func(name, value string) {
+		if value != "" {
+			details = append(details, Detail{Name: name, Value: value})
+		}
+	}
+
+	add("description", task.Description)
+	if len(task.Steps) == 0 {
+		add("cmd", task.Cmd)
+	}
+	for i, step := range task.Steps {
+		add(fmt.Sprintf("step %d", i+1), step.Cmd)
+	}
+	if dir, err := filepath.Abs(cmp.Or(task.Path, ".")); err == nil {
+		add("path", dir)
+	}
+	if task.Shell != nil && !*task.Shell {
+		add("shell", "false")
+	}
+	if wrapper, err := c.WrapperArgs(taskName); err == nil && len(wrapper) > 0 {
+		add("wrapper", QuoteArgs(wrapper))
+	}
+
+	env := c.ConfigEnv(taskName)
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		value := env[k]
+		if c.IsSecretEnv(k) {
+			value = maskedValue
+		}
+		add("env", k+"="+value)
+	}
+	for _, path := range c.EnvFilePaths(taskName) {
+		add("env_file", path)
+	}
+
+	if task.Watch {
+		add("watch", strings.Join(task.WatchDirs(), ", "))
+		add("watch_include", strings.Join(task.WatchInclude, ", "))
+		add("watch_exclude", strings.Join(task.WatchExclude, ", "))
+	}
+	done, started := c.Dependencies(taskName)
+	add("depends_on", strings.Join(done, ", "))
+	add("depends_on_started", strings.Join(started, ", "))
+	add("wait_for", strings.Join(c.WaitFor(taskName), ", "))
+	if task.Ready != nil {
+		add("ready", task.Ready.String())
+	}
+
+	if task.Restart != "" && task.Restart != RestartNever {
+		restart := string(task.Restart)
+		if task.MaxRestarts > 0 {
+			restart += fmt.Sprintf(", at most %d in a row", task.MaxRestarts)
+		}
+		if task.RestartDelay.Duration > 0 {
+			restart += fmt.Sprintf(", after %s", task.RestartDelay.Duration)
+		}
+		add("restart", restart)
+	}
+	if task.AllowFailure {
+		add("allow_failure", "true")
+	}
+	for _, timeout := range []struct {
+		name  string
+		value Duration
+	}{
+		{"start_timeout", task.StartTimeout},
+		{"timeout", task.Timeout},
+		{"shutdown_timeout", task.ShutdownTimeout},
+		{"auto_stop_after", task.AutoStopAfter},
+		{"auto_stop_when_idle", task.AutoStopWhenIdle},
+	} {
+		if timeout.value.Duration > 0 {
+			add(timeout.name, timeout.value.Duration.String())
+		}
+	}
+	add("log_file", c.LogFilePath(taskName))
+	if size, backups := c.LogRotation(taskName); size > 0 {
+		add("log_max_size", fmt.Sprintf("%s, keeping %d rotated files", task.LogMaxSize, backups))
+	}
+	return details
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Unlisted returns the tasks defined in the config file but missing from its
+// tasks array, which only run when named on the command line. Tasks with
+// replicas are reported by their definition name, and scripts imported from
+// package.json are left out.
+func (c *Config) Unlisted() []string {
+	listed := make(map[string]bool, len(c.Tasks))
+	for _, name := range c.Tasks {
+		listed[name] = true
+	}
+
+	seen := make(map[string]bool)
+	var unlisted []string
+	for name, task := range c.TaskDefs {
+		if listed[name] || c.imported[name] {
+			continue
+		}
+		if task.Replica != nil {
+			name = task.Replica.Of
+		}
+		if !seen[name] {
+			seen[name] = true
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+	return unlisted
+}
+
+// FixOptions selects what FixTasks changes in the tasks array
+type FixOptions struct {
+	Add  []string // names appended to the array
+	Sort bool     // sort the array
+}
+
+// tasksKey finds the top-level tasks key
+var tasksKey = regexp.MustCompile(`(?m)^tasks[ \t]*=[ \t]*`)
+
+// tableHeader finds the first table, before which top-level keys must go
+var tableHeader = regexp.MustCompile(`(?m)^[ \t]*\[`)
+
+// FixTasks rewrites the tasks array in the source of a config file and
+// leaves the rest of the file, comments included, untouched. It reports
+// whether comments inside a multi-line array were dropped. Without a tasks
+// array, one is inserted before the first table.
+func FixTasks(data []byte, opts FixOptions) (fixed []byte, droppedComments bool, err error) {
+	var parsed struct {
+		Tasks []string `toml:"tasks"`
+	}
+	if err := toml.Unmarshal(data, &parsed); err != nil {
+		return nil, false, fmt.Errorf("failed to parse TOML: %w", err)
+	}
+
+	tasks := parsed.Tasks
+	for _, name := range opts.Add {
+		if !slices.Contains(tasks, name) {
+			tasks = append(tasks, name)
+		}
+	}
+	if opts.Sort {
+		tasks = slices.Clone(tasks)
+		sort.Strings(tasks)
+	}
+
+	quoted := make([]string, len(tasks))
+	for i, name := range tasks {
+		quoted[i] = strconv.Quote(name)
+	}
+	array := "[" + strings.Join(quoted, ", ") + "]"
+
+	loc := tasksKey.FindIndex(data)
+	if loc == nil {
+		line := []byte("tasks = " + array + "\n\n")
+		at := len(data)
+		if header := tableHeader.FindIndex(data); header != nil {
+			at = header[0]
+		} else if at > 0 && data[at-1] != '\n' {
+			line = append([]byte("\n"), line...)
+		}
+		return slices.Concat(data[:at], line, data[at:]), false, nil
+	}
+
+	start := loc[1]
+	end, comments, err := arrayEnd(data, start)
+	if err != nil {
+		return nil, false, err
+	}
+	var out bytes.Buffer
+	out.Write(data[:start])
+	out.WriteString(array)
+	out.Write(data[end:])
+	return out.Bytes(), comments, nil
+}
+
+// arrayEnd returns the offset just past the array starting at data[start],
+// skipping over strings, and whether the array contains comments
+func arrayEnd(data []byte, start int) (int, bool, error) {
+	if start >= len(data) || data[start] != '[' {
+		return 0, false, fmt.Errorf("tasks is not an array")
+	}
+	depth, comments := 0, false
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i + 1, comments, nil
+			}
+		case '#':
+			comments = true
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case '"', '\'':
+			quote := data[i]
+			for i++; i < len(data) && data[i] != quote; i++ {
+				if quote == '"' && data[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return 0, false, fmt.Errorf("unterminated tasks array")
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// validateGroups checks that groups don't share a name with a task, that
+// their members name tasks or other groups, and that no group includes itself
+func (c *Config) validateGroups() error {
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "" || isTaskPattern(name) {
+			return fmt.Errorf("invalid group name '%s'", name)
+		}
+		if c.isTask(name) {
+			return fmt.Errorf("'%s' is both a task and a group", name)
+		}
+		for _, member := range c.Groups[name] {
+			if _, ok := c.Groups[member]; !ok && !c.isTask(member) {
+				return fmt.Errorf("group '%s' includes '%s', which is neither a task nor a group", name, member)
+			}
+		}
+	}
+
+	// Walk the groups depth first from every group
+	var walk func(name string, path []string) error
+	walk = func(name string, path []string) error {
+		if i := slices.Index(path, name); i >= 0 {
+			return fmt.Errorf("groups form a cycle: %s", strings.Join(append(path[i:], name), " → "))
+		}
+		path = append(path, name)
+		for _, member := range c.Groups[name] {
+			if _, ok := c.Groups[member]; !ok {
+				continue
+			}
+			if err := walk(member, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := walk(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTask reports whether a task of that name is defined, counting a task
+// whose replicas are already expanded
+func (c *Config) isTask(name string) bool {
+	_, ok := c.TaskDefs[name]
+	return ok || c.replicas[name] != nil
+}
+
+// IsGroup reports whether name is one of the [groups]
+func (c *Config) IsGroup(name string) bool {
+	_, ok := c.Groups[name]
+	return ok
+}
+
+// GroupTasks returns the tasks of a group in the order they are listed, the
+// tasks of a nested group in its place, each task once
+func (c *Config) GroupTasks(group string) []string {
+	var tasks []string
+	var add func(name string)
+	add = func(name string) {
+		members, ok := c.Groups[name]
+		if !ok {
+			if !slices.Contains(tasks, name) {
+				tasks = append(tasks, name)
+			}
+			return
+		}
+		for _, member := range members {
+			add(member)
+		}
+	}
+	add(group)
+	return tasks
+}
+
+// Exclude returns tasks without those excludes names. Like the arguments of
+// GetTasksToRun, excludes may be task names, groups or globs.
+func (c *Config) Exclude(tasks, excludes []string) ([]string, error) {
+	drop := make(map[string]bool)
+	for _, exclude := range excludes {
+		names := []string{exclude}
+		switch {
+		case c.IsGroup(exclude):
+			names = c.GroupTasks(exclude)
+		case isTaskPattern(exclude):
+			var err error
+			if names, err = c.matchTasks(exclude); err != nil {
+				return nil, err
+			}
+		case !c.isTask(exclude):
+			return nil, fmt.Errorf("cannot exclude '%s': no such task or group", exclude)
+		}
+		for _, name := range c.expandNames(names) {
+			drop[name] = true
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(tasks), func(name string) bool { return drop[name] }), nil
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// uninterpolated holds the values of a config as written, before
+// interpolate resolved their ${VAR} references, so that they can be
+// resolved again once env files change
+type uninterpolated struct {
+	env   map[string]string
+	tasks map[string]TaskDef // the config's own tasks, not included ones
+}
+
+// Interpolate resolves the references in s: ${VAR} is replaced by the value
+// lookup finds for VAR, ${VAR:-default} by default if VAR is unset or empty
+// (itself interpolated, so defaults may nest), and $$ by a literal $. A $
+// followed by anything else is left as it is, so $VAR in a cmd is still
+// expanded by the shell. A variable lookup doesn't know, without a default,
+// is an error.
+func Interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	return expand(s, lookup, nil)
+}
+
+// expand is Interpolate, also replacing bare $VAR references if undefined
+// is set. A bare reference to a variable lookup doesn't know expands to
+// nothing, as in the shell, and is passed to undefined.
+func expand(s string, lookup func(string) (string, bool), undefined func(string)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := closingBrace(s[i+2:])
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			ref := s[i+2 : i+2+end]
+			name, def, hasDefault := strings.Cut(ref, ":-")
+			if !validEnvName(name) {
+				return "", fmt.Errorf("invalid variable name in ${%s}", ref)
+			}
+			value, ok := lookup(name)
+			switch {
+			case hasDefault && value == "":
+				var err error
+				if value, err = expand(def, lookup, undefined); err != nil {
+					return "", err
+				}
+			case !ok:
+				return "", fmt.Errorf("undefined variable ${%s} (give it a default with ${%s:-value}, or write $$ for a literal $)", name, name)
+			}
+			b.WriteString(value)
+			i += 2 + end
+		default:
+			n := bareName(s[i+1:])
+			if undefined == nil || n == 0 {
+				b.WriteByte('$')
+				continue
+			}
+			name := s[i+1 : i+1+n]
+			if value, ok := lookup(name); ok {
+				b.WriteString(value)
+			} else {
+				undefined(name)
+			}
+			i += n
+		}
+	}
+	return b.String(), nil
+}
+
+// bareName returns the length of the variable name s starts with, as in
+// $VAR, or 0 if it doesn't start with one
+func bareName(s string) int {
+	n := 0
+	for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || n > 0 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	return n
+}
+
+// closingBrace returns the index of the } closing a ${ reference that s
+// follows, skipping those of references nested in its default, or -1
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && (s[i+1] == '$' || s[i+1] == '{'):
+			if s[i+1] == '{' {
+				depth++
+			}
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// validEnvName reports whether name can be an environment variable: a letter
+// or underscore followed by letters, digits and underscores
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupEnv looks a variable up in prun's own environment first, then in
+// each of layers, later ones first
+func lookupEnv(layers ...map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		for _, layer := range slices.Backward(layers) {
+			if value, ok := layer[name]; ok {
+				return value, true
+			}
+		}
+		return "", false
+	}
+}
+
+// interpolate resolves the ${VAR} references in the global env and in the
+// cmd, steps, path and env of the config's own tasks, from the values they
+// had as written. Env values also expand bare $VAR references. Included
+// tasks were resolved by their own config.
+func (c *Config) interpolate() error {
+	if c.raw == nil {
+		c.raw = &uninterpolated{env: c.Env, tasks: make(map[string]TaskDef)}
+		for name, task := range c.TaskDefs {
+			if c.remotes[name] == nil {
+				c.raw.tasks[name] = task
+			}
+		}
+	}
+	c.Notices = nil
+
+	env, err := c.interpolateEnv("[env]", c.raw.env, c.fileEnv)
+	if err != nil {
+		return fmt.Errorf("env: %w", err)
+	}
+	c.Env = env
+
+	taskDefs := maps.Clone(c.TaskDefs)
+	for _, name := range slices.Sorted(maps.Keys(c.raw.tasks)) {
+		task := c.raw.tasks[name]
+		lookup := lookupEnv(c.fileEnv, c.Env, c.taskFileEnv[name])
+		if task.Cmd, err = Interpolate(task.Cmd, lookup); err != nil {
+			return fmt.Errorf("task '%s' cmd: %w", name, err)
+		}
+		task.Steps = slices.Clone(task.Steps)
+		for i := range task.Steps {
+			if task.Steps[i].Cmd, err = Interpolate(task.Steps[i].Cmd, lookup); err != nil {
+				return fmt.Errorf("task '%s' step %d: %w", name, i+1, err)
+			}
+		}
+		if task.Path, err = Interpolate(task.Path, lookup); err != nil {
+			return fmt.Errorf("task '%s' path: %w", name, err)
+		}
+		if task.Env, err = c.interpolateEnv(fmt.Sprintf("task '%s' env", name), task.Env, c.fileEnv, c.Env, c.taskFileEnv[name]); err != nil {
+			return fmt.Errorf("task '%s' env: %w", name, err)
+		}
+		taskDefs[name] = task
+	}
+	c.TaskDefs = taskDefs
+	return nil
+}
+
+// interpolateEnv returns a copy of env with the references in its values
+// resolved, bare $VAR ones included. A variable is looked up in prun's own
+// environment, then among the other variables of env, then in layers, later
+// ones first. Variables of env referring to each other in a loop are an
+// error; bare references to undefined variables are noted in c.Notices.
+func (c *Config) interpolateEnv(where string, env map[string]string, layers ...map[string]string) (map[string]string, error) {
+	if env == nil {
+		return nil, nil
+	}
+	r := &envResolver{
+		raw:      env,
+		resolved: make(map[string]string, len(env)),
+		outer:    lookupEnv(layers...),
+		undefined: func(key, name string) {
+			c.Notices = append(c.Notices, fmt.Sprintf("%s %s: $%s is undefined, expanded to an empty string", where, key, name))
+		},
+	}
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		if _, err := r.resolve(key); err != nil {
+			return nil, err
+		}
+	}
+	return r.resolved, nil
+}
+
+// envResolver resolves the values of an env table, which may refer to each
+// other
+type envResolver struct {
+	raw       map[string]string
+	resolved  map[string]string
+	resolving []string // keys being resolved, innermost last
+	outer     func(string) (string, bool)
+	undefined func(key, name string)
+}
+
+// resolve returns the value of key with its references resolved
+func (r *envResolver) resolve(key string) (string, error) {
+	if value, ok := r.resolved[key]; ok {
+		return value, nil
+	}
+	if i := slices.Index(r.resolving, key); i >= 0 {
+		loop := append(slices.Clone(r.resolving[i:]), key)
+		return "", fmt.Errorf("variables refer to each other in a loop: %s", strings.Join(loop, " → "))
+	}
+	r.resolving = append(r.resolving, key)
+	defer func() { r.resolving = r.resolving[:len(r.resolving)-1] }()
+
+	var loopErr error
+	lookup := func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		if _, ok := r.raw[name]; ok && loopErr == nil {
+			value, err := r.resolve(name)
+			loopErr = err
+			return value, true
+		}
+		return r.outer(name)
+	}
+	value, err := expand(r.raw[key], lookup, func(name string) { r.undefined(key, name) })
+	if loopErr != nil {
+		return "", loopErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	r.resolved[key] = value
+	return value, nil
+}
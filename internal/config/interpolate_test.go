@@ -0,0 +1,127 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"PORT": "8080", "HOST": "localhost", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  string // in the error, if one is expected
+	}{
+		{in: "no references", want: "no references"},
+		{in: "${PORT}", want: "8080"},
+		{in: "http://${HOST}:${PORT}/", want: "http://localhost:8080/"},
+		{in: "${PORT:-3000}", want: "8080"},
+		{in: "${MISSING:-3000}", want: "3000"},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "${MISSING:-}", want: ""},
+		{in: "${MISSING:-${HOST}:${PORT}}", want: "localhost:8080"},
+		{in: "${MISSING:-${ALSO_MISSING:-deep}}", want: "deep"},
+		{in: "${EMPTY}", want: ""},
+		{in: "${MISSING}", err: "undefined variable ${MISSING}"},
+		{in: "${MISSING:-${ALSO_MISSING}}", err: "undefined variable ${ALSO_MISSING}"},
+		{in: "costs $$5", want: "costs $5"},
+		{in: "$$$$", want: "$$"},
+		{in: "$${PORT}", want: "${PORT}"},
+		{in: "$$${PORT}", want: "$8080"},
+		{in: "${MISSING:-$$}", want: "$"},
+		{in: "echo $PORT", want: "echo $PORT"},
+		{in: "trailing $", want: "trailing $"},
+		{in: "${PORT", err: "unterminated ${"},
+		{in: "${MISSING:-${PORT}", err: "unterminated ${"},
+		{in: "${}", err: "invalid variable name"},
+		{in: "${1ABC}", err: "invalid variable name"},
+		{in: "${A-B}", err: "invalid variable name"},
+	}
+	for _, tt := range tests {
+		got, err := Interpolate(tt.in, lookup)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Interpolate(%q) = %q, %v; want an error about %q", tt.in, got, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("Interpolate(%q): %v", tt.in, err)
+		case tt.err == "" && got != tt.want:
+			t.Errorf("Interpolate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandBareReferences(t *testing.T) {
+	vars := map[string]string{"PORT": "8080"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		in        string
+		want      string
+		undefined []string
+	}{
+		{in: "$PORT", want: "8080"},
+		{in: "port=$PORT/", want: "port=8080/"},
+		{in: "$PORT_2", want: "", undefined: []string{"PORT_2"}},
+		{in: "$MISSING and $PORT", want: " and 8080", undefined: []string{"MISSING"}},
+		{in: "$$PORT", want: "$PORT"},
+		{in: "$1 $-", want: "$1 $-"},
+	}
+	for _, tt := range tests {
+		var undefined []string
+		got, err := expand(tt.in, lookup, func(name string) { undefined = append(undefined, name) })
+		if err != nil || got != tt.want || !slices.Equal(undefined, tt.undefined) {
+			t.Errorf("expand(%q) = %q, %v, undefined %v; want %q, undefined %v", tt.in, got, err, undefined, tt.want, tt.undefined)
+		}
+	}
+}
+
+func TestLoadInterpolates(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PRUN_TEST_HOST", "from-os")
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("# a comment\nPORT=\"9000\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "prun.toml")
+	text := `
+tasks = ["web"]
+env_file = ".env"
+
+[env]
+PRUN_TEST_HOST = "from-env-table"
+NAME = "web"
+
+[task.web]
+cmd = "serve --addr ${PRUN_TEST_HOST}:${PORT} --name ${NAME} --cost $$5"
+env = { URL = "http://${PRUN_TEST_HOST}:${PORT:-80}" }
+`
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := cfg.TaskDefs["web"]
+	if want := "serve --addr from-os:9000 --name web --cost $5"; task.Cmd != want {
+		t.Errorf("cmd = %q, want %q", task.Cmd, want)
+	}
+	if want := "http://from-os:9000"; task.Env["URL"] != want {
+		t.Errorf("URL = %q, want %q", task.Env["URL"], want)
+	}
+
+	if err := os.WriteFile(path, []byte("tasks = [\"web\"]\n[task.web]\ncmd = \"echo ${PRUN_TEST_UNSET}\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "PRUN_TEST_UNSET") {
+		t.Errorf("Load with an undefined variable: %v, want an error naming it", err)
+	}
+}
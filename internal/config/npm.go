@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// packageJSON is the part of a package.json that prun reads
+type packageJSON struct {
+	Scripts map[string]string `json:"scripts"`
+}
+
+// importNpmScripts adds a task running `npm run <script>` for each script in
+// the package.json next to the config file. Tasks already defined in the
+// config win over scripts of the same name. If only is non-empty, just those
+// scripts are imported and each one must exist.
+func (c *Config) importNpmScripts(only []string) error {
+	path := filepath.Join(c.dir, "package.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	names := only
+	if len(names) == 0 {
+		for name := range pkg.Scripts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var imported []string
+	for _, name := range names {
+		if _, ok := pkg.Scripts[name]; !ok {
+			return fmt.Errorf("script '%s' not found in %s", name, path)
+		}
+		if _, exists := c.TaskDefs[name]; exists {
+			continue
+		}
+		c.TaskDefs[name] = TaskDef{
+			Cmd:  "npm run " + QuoteArgs([]string{name}),
+			Path: c.dir,
+		}
+		imported = append(imported, name)
+	}
+	c.imported = make(map[string]bool, len(imported))
+	for _, name := range imported {
+		c.imported[name] = true
+	}
+
+	// Without a task list of its own, the config runs the imported scripts
+	if len(c.Tasks) == 0 {
+		c.Tasks = imported
+	}
+	return nil
+}
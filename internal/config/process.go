@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// PassFD asks prun to open a listening socket and pass it to the task
+type PassFD struct {
+	Listen string `toml:"listen"` // TCP address to listen on, e.g. ":8080"
+	Env    string `toml:"env"`    // variable set to the socket's fd number in the task
+}
+
+// Sandbox limits what a task can change outside itself
+type Sandbox struct {
+	Network    *bool      `toml:"network,omitempty"`     // allow network access; true if unset
+	WritePaths StringList `toml:"write_paths,omitempty"` // the only paths the task may write, relative to its path
+}
+
+// NetworkAllowed reports whether the sandbox lets the task use the network
+func (s *Sandbox) NetworkAllowed() bool {
+	return s.Network == nil || *s.Network
+}
+
+// SandboxWritePaths returns the absolute paths a sandboxed task may write
+func (c *Config) SandboxWritePaths(taskName string) ([]string, error) {
+	task := c.TaskDefs[taskName]
+	var paths []string
+	for _, p := range task.Sandbox.WritePaths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(task.Path, p)
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, abs)
+	}
+	return paths, nil
+}
+
+// envName matches the variable names accepted for pass_fds
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseUmask converts an octal umask such as "027" to its value, or returns
+// -1 for an empty string
+func ParseUmask(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	mask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mask > 0o777 {
+		return 0, fmt.Errorf("invalid umask '%s' (expected octal, e.g. \"027\")", s)
+	}
+	return int(mask), nil
+}
+
+// validateProcess checks a task's umask, pass_fds and sandbox
+func validateProcess(name string, task TaskDef) error {
+	if _, err := ParseUmask(task.Umask); err != nil {
+		return fmt.Errorf("task '%s' has %w", name, err)
+	}
+	seen := make(map[string]bool)
+	for _, fd := range task.PassFDs {
+		if fd.Listen == "" {
+			return fmt.Errorf("task '%s' has a pass_fds entry without 'listen'", name)
+		}
+		if !envName.MatchString(fd.Env) {
+			return fmt.Errorf("task '%s' has invalid pass_fds env '%s'", name, fd.Env)
+		}
+		if seen[fd.Env] {
+			return fmt.Errorf("task '%s' sets pass_fds env '%s' twice", name, fd.Env)
+		}
+		seen[fd.Env] = true
+	}
+	if task.Sandbox != nil && slices.Contains(task.Sandbox.WritePaths, "") {
+		return fmt.Errorf("task '%s' has an empty sandbox write_paths entry", name)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// validateProfiles checks that every profile names tasks or groups
+func (c *Config) validateProfiles() error {
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("invalid profile name '%s'", name)
+		}
+		if len(c.Profiles[name]) == 0 {
+			return fmt.Errorf("profile '%s' has no tasks", name)
+		}
+		for _, member := range c.Profiles[name] {
+			if !c.IsGroup(member) && !c.isTask(member) {
+				return fmt.Errorf("profile '%s' includes '%s', which is neither a task nor a group", name, member)
+			}
+		}
+	}
+	return nil
+}
+
+// ProfileTasks returns the tasks of a profile in the order they are listed,
+// the tasks of a group or the replicas of a task in its place, each task once
+func (c *Config) ProfileTasks(profile string) ([]string, error) {
+	members, ok := c.Profiles[profile]
+	if !ok {
+		if len(c.Profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile '%s': the config defines no [profiles]", profile)
+		}
+		return nil, fmt.Errorf("unknown profile '%s' (available: %s)", profile, strings.Join(slices.Sorted(maps.Keys(c.Profiles)), ", "))
+	}
+	var tasks []string
+	for _, member := range members {
+		names := []string{member}
+		if c.IsGroup(member) {
+			names = c.GroupTasks(member)
+		}
+		for _, name := range c.expandNames(names) {
+			if !slices.Contains(tasks, name) {
+				tasks = append(tasks, name)
+			}
+		}
+	}
+	return tasks, nil
+}
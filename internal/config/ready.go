@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A ready check polls every DefaultReadyInterval and fails the task after
+// DefaultReadyTimeout, unless it sets its own
+const (
+	DefaultReadyTimeout  = 30 * time.Second
+	DefaultReadyInterval = 500 * time.Millisecond
+)
+
+// ReadyCheck tells when a task is ready to serve, rather than only started:
+// once a TCP connection to TCP succeeds, once a GET of HTTP answers with a
+// 2xx status, or once the task prints a line containing LogLine. A task
+// that isn't ready within Timeout fails.
+type ReadyCheck struct {
+	TCP      string   `toml:"tcp,omitempty"`
+	HTTP     string   `toml:"http,omitempty"`
+	LogLine  string   `toml:"log_line,omitempty"`
+	Timeout  Duration `toml:"timeout,omitempty"`
+	Interval Duration `toml:"interval,omitempty"` // between tcp and http attempts
+}
+
+// ReadyTimeout returns how long the task has to become ready
+func (r *ReadyCheck) ReadyTimeout() time.Duration {
+	if r.Timeout.Duration > 0 {
+		return r.Timeout.Duration
+	}
+	return DefaultReadyTimeout
+}
+
+// PollInterval returns how long a tcp or http check waits between attempts
+func (r *ReadyCheck) PollInterval() time.Duration {
+	if r.Interval.Duration > 0 {
+		return r.Interval.Duration
+	}
+	return DefaultReadyInterval
+}
+
+// String describes the check, e.g. "tcp localhost:5432 every 500ms, within 30s"
+func (r *ReadyCheck) String() string {
+	switch {
+	case r.TCP != "":
+		return fmt.Sprintf("tcp %s every %s, within %s", r.TCP, r.PollInterval(), r.ReadyTimeout())
+	case r.HTTP != "":
+		return fmt.Sprintf("http %s every %s, within %s", r.HTTP, r.PollInterval(), r.ReadyTimeout())
+	}
+	return fmt.Sprintf("log line %q, within %s", r.LogLine, r.ReadyTimeout())
+}
+
+// WaitFor returns the tasks a task waits to be ready before it starts. A
+// task with replicas stands for all of them.
+func (c *Config) WaitFor(name string) []string {
+	return c.expandNames(c.TaskDefs[name].WaitFor)
+}
+
+// validateReady checks a task's ready check: exactly one of tcp, http and
+// log_line, each well formed
+func validateReady(name string, ready *ReadyCheck) error {
+	if ready == nil {
+		return nil
+	}
+	set := 0
+	for _, check := range []string{ready.TCP, ready.HTTP, ready.LogLine} {
+		if check != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("task '%s' ready check needs exactly one of tcp, http and log_line", name)
+	}
+	if ready.TCP != "" {
+		if _, port, err := net.SplitHostPort(ready.TCP); err != nil || port == "" {
+			return fmt.Errorf("task '%s' has invalid ready tcp '%s' (expected host:port)", name, ready.TCP)
+		}
+	}
+	if ready.HTTP != "" {
+		u, err := url.Parse(ready.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("task '%s' has invalid ready http '%s' (expected an http:// or https:// URL)", name, ready.HTTP)
+		}
+	}
+	if strings.ContainsAny(ready.LogLine, "\r\n") {
+		return fmt.Errorf("task '%s' has a ready log_line spanning lines", name)
+	}
+	return nil
+}
+
+// validateWaitFor checks that the tasks a task waits for have a ready check
+func (c *Config) validateWaitFor(name string) error {
+	for _, dep := range c.WaitFor(name) {
+		task := c.TaskDefs[dep]
+		if task.Ready == nil {
+			table := dep
+			if task.Replica != nil {
+				table = task.Replica.Of
+			}
+			return fmt.Errorf("task '%s' waits for '%s', which has no [task.%s.ready] check", name, dep, table)
+		}
+	}
+	return nil
+}
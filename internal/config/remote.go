@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RemoteConfig includes the tasks of another prun.toml, such as one in a
+// sibling repository
+type RemoteConfig struct {
+	Path string `toml:"path"` // config file to include, relative to this one
+}
+
+// remote is what tasks included from another config keep of it
+type remote struct {
+	namespace string
+	path      string            // absolute path of the included config file
+	env       map[string]string // its global env
+	envFile   []string          // its global env files, absolute
+}
+
+// includeRemotes loads every [remote_config.<namespace>] and adds its tasks
+// as <namespace>:<task>. Relative paths in them are resolved against the
+// included config's directory, and its env, env_file and wrapper apply to
+// its own tasks only. Unless the tasks array already names some of them,
+// the included tasks array is appended to it.
+func (c *Config) includeRemotes() error {
+	c.remotes = make(map[string]*remote)
+	c.replicas = make(map[string][]string)
+
+	namespaces := make([]string, 0, len(c.Remotes))
+	for ns := range c.Remotes {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	for _, ns := range namespaces {
+		if ns == "" || strings.ContainsAny(ns, `:*?[]\`) {
+			return fmt.Errorf("invalid remote_config namespace '%s'", ns)
+		}
+		if c.Remotes[ns].Path == "" {
+			return fmt.Errorf("remote_config.%s missing required 'path' field", ns)
+		}
+		cfgPath := c.ResolvePath(c.Remotes[ns].Path)
+		rc, err := Load(cfgPath)
+		if err != nil {
+			return fmt.Errorf("remote_config.%s: %w", ns, err)
+		}
+		if len(rc.Remotes) > 0 {
+			return fmt.Errorf("remote_config.%s: %s has a remote_config of its own, which is not supported", ns, cfgPath)
+		}
+
+		r := &remote{namespace: ns, path: cfgPath, env: rc.Env}
+		for _, file := range rc.EnvFile {
+			r.envFile = append(r.envFile, rc.ResolvePath(file))
+		}
+		c.included = append(c.included, r)
+
+		prefix := func(name string) string { return ns + ":" + name }
+		for name, task := range rc.TaskDefs {
+			full := prefix(name)
+			if _, exists := c.TaskDefs[full]; exists {
+				return fmt.Errorf("task '%s' from remote_config.%s conflicts with a task of the same name", full, ns)
+			}
+
+			if task.Path == "" {
+				task.Path = "."
+			}
+			task.Path = rc.ResolvePath(task.Path)
+			task.LogFile = rc.ResolvePath(task.LogFile)
+			task.EnvFile = nil
+			for _, file := range rc.TaskDefs[name].EnvFile {
+				task.EnvFile = append(task.EnvFile, rc.ResolvePath(file))
+			}
+			task.Render = slices.Clone(task.Render)
+			for i, file := range task.Render {
+				task.Render[i] = RenderFile{Template: rc.ResolvePath(file.Template), Output: rc.ResolvePath(file.Output)}
+			}
+			if task.Wrapper == nil {
+				wrapper := rc.Wrapper
+				task.Wrapper = &wrapper
+			}
+			namespaced := func(ref string) string {
+				return "{task:" + prefix(ref) + "}"
+			}
+			task.Cmd = ReplaceTaskRefs(task.Cmd, namespaced)
+			task.Steps = slices.Clone(task.Steps)
+			for i := range task.Steps {
+				task.Steps[i].Cmd = ReplaceTaskRefs(task.Steps[i].Cmd, namespaced)
+			}
+			task.DependsOn = prefixAll(task.DependsOn, prefix)
+			task.DependsOnStarted = prefixAll(task.DependsOnStarted, prefix)
+			task.WaitFor = prefixAll(task.WaitFor, prefix)
+			if task.Replica != nil {
+				replica := *task.Replica
+				replica.Of = prefix(replica.Of)
+				task.Replica = &replica
+				task.Replicas = 0 // already expanded
+			}
+
+			c.TaskDefs[full] = task
+			c.remotes[full] = r
+		}
+
+		for base, names := range rc.replicas {
+			for _, name := range names {
+				c.replicas[prefix(base)] = append(c.replicas[prefix(base)], prefix(name))
+			}
+		}
+
+		named := slices.ContainsFunc(c.Tasks, func(name string) bool {
+			return strings.HasPrefix(name, ns+":")
+		})
+		if !named {
+			for _, name := range rc.Tasks {
+				c.Tasks = append(c.Tasks, prefix(name))
+			}
+		}
+	}
+	return nil
+}
+
+// prefixAll returns names with prefix applied to each
+func prefixAll(names StringList, prefix func(string) string) StringList {
+	var out StringList
+	for _, name := range names {
+		out = append(out, prefix(name))
+	}
+	return out
+}
+
+// ConfigPaths returns the config file followed by every config it includes
+func (c *Config) ConfigPaths() []string {
+	paths := []string{c.path}
+	for _, r := range c.included {
+		paths = append(paths, r.path)
+	}
+	return paths
+}
+
+// isTaskPattern reports whether a task name given on the command line is a
+// glob such as frontend:*
+func isTaskPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[`)
+}
+
+// matchTasks returns the tasks whose names match a glob, those in the tasks
+// array first and in its order, then the rest sorted by name
+func (c *Config) matchTasks(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid task pattern '%s': %w", pattern, err)
+	}
+
+	var rest []string
+	for name := range c.TaskDefs {
+		if !slices.Contains(c.Tasks, name) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+
+	var matches []string
+	for _, name := range append(slices.Clone(c.Tasks), rest...) {
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no tasks match '%s'", pattern)
+	}
+	return matches, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// RenderFile is a file prun writes from a template, so tools outside prun,
+// such as an editor's launch configuration, can pick up the ports and
+// environment of this session
+type RenderFile struct {
+	Template string `toml:"template"` // text/template file, relative to the config file
+	Output   string `toml:"output"`   // file written, relative to the config file
+}
+
+// RenderData is what a render template sees
+type RenderData struct {
+	Env   map[string]string     // global env, after env_file
+	Tasks map[string]RenderTask // the tasks being run
+	Task  RenderTask            // the task of a task's own render entry; zero for a global one
+}
+
+// RenderTask describes a task to render templates
+type RenderTask struct {
+	Name string
+	Path string            // working directory, absolute
+	Port int               // port the task declares, 0 if none
+	Env  map[string]string // environment the task runs with
+}
+
+// validateRender checks render entries, global or of a task
+func validateRender(where string, files []RenderFile) error {
+	for i, file := range files {
+		if file.Template == "" || file.Output == "" {
+			return fmt.Errorf("%s render entry %d needs both 'template' and 'output'", where, i+1)
+		}
+	}
+	return nil
+}
+
+// RenderFiles writes the global render entries and those of the given tasks, and
+// returns the outputs whose content changed, as the config names them. A
+// file is written under a temporary name and renamed into place, so readers
+// never see it half-written.
+func (c *Config) RenderFiles(tasks []string) ([]string, error) {
+	data := RenderData{
+		Env:   make(map[string]string),
+		Tasks: make(map[string]RenderTask, len(tasks)),
+	}
+	for _, layer := range []map[string]string{c.fileEnv, c.Env, c.Overrides} {
+		for k, v := range layer {
+			data.Env[k] = v
+		}
+	}
+	for i, name := range tasks {
+		data.Tasks[name] = c.renderTask(name, Instance{Index: i, Count: len(tasks)})
+	}
+
+	var touched []string
+	render := func(file RenderFile, task RenderTask) error {
+		data := data
+		data.Task = task
+		changed, err := c.renderFile(file, data)
+		if err != nil {
+			return err
+		}
+		if changed && !slices.Contains(touched, file.Output) {
+			touched = append(touched, file.Output)
+		}
+		return nil
+	}
+
+	for _, file := range c.Render {
+		if err := render(file, RenderTask{}); err != nil {
+			return touched, err
+		}
+	}
+	for _, name := range tasks {
+		for _, file := range c.TaskDefs[name].Render {
+			if err := render(file, data.Tasks[name]); err != nil {
+				return touched, fmt.Errorf("task '%s': %w", name, err)
+			}
+		}
+	}
+	return touched, nil
+}
+
+// renderTask describes a task to templates
+func (c *Config) renderTask(name string, inst Instance) RenderTask {
+	task := c.TaskDefs[name]
+	dir := c.ResolvePath(task.Path)
+	if dir == "" {
+		dir = c.dir
+	}
+	env := make(map[string]string)
+	for _, kv := range c.TaskEnv(name, inst) {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return RenderTask{Name: name, Path: dir, Port: task.Port, Env: env}
+}
+
+// renderFile executes one template and replaces its output if the result
+// differs from what is there, reporting whether it did
+func (c *Config) renderFile(file RenderFile, data RenderData) (bool, error) {
+	text, err := os.ReadFile(c.ResolvePath(file.Template))
+	if err != nil {
+		return false, fmt.Errorf("failed to read render template: %w", err)
+	}
+	tmpl, err := template.New(file.Template).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return false, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return false, err
+	}
+
+	output := c.ResolvePath(file.Output)
+	if old, err := os.ReadFile(output); err == nil && bytes.Equal(old, buf.Bytes()) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", file.Output, err)
+	}
+	tmp := filepath.Join(filepath.Dir(output), "."+filepath.Base(output)+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", file.Output, err)
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to write %s: %w", file.Output, err)
+	}
+	return true, nil
+}
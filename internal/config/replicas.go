@@ -0,0 +1,66 @@
+package config
+
+import "fmt"
+
+// Replica identifies one instance of a task with replicas
+type Replica struct {
+	Of    string // name of the task definition
+	Index int    // position among the replicas, from 0
+	Count int    // total number of replicas
+}
+
+// expandReplicas replaces every task with replicas > 1 by that many tasks
+// named <task>-0 … <task>-N, both in the definitions and in the tasks list.
+// Each replica is an independent task sharing the base definition.
+func (c *Config) expandReplicas() error {
+	// Replicas of included tasks are already expanded
+	if c.replicas == nil {
+		c.replicas = make(map[string][]string)
+	}
+
+	expanded := make(map[string][]string)
+	for name, task := range c.TaskDefs {
+		if task.Replicas < 0 {
+			return fmt.Errorf("task '%s' has invalid replicas %d", name, task.Replicas)
+		}
+		if task.Replicas <= 1 {
+			continue
+		}
+
+		names := make([]string, task.Replicas)
+		for i := range names {
+			names[i] = fmt.Sprintf("%s-%d", name, i)
+			if _, exists := c.TaskDefs[names[i]]; exists {
+				return fmt.Errorf("replica '%s' of task '%s' conflicts with a task of the same name", names[i], name)
+			}
+		}
+		expanded[name] = names
+	}
+
+	for name, names := range expanded {
+		base := c.TaskDefs[name]
+		delete(c.TaskDefs, name)
+		for i, replica := range names {
+			def := base
+			def.Replica = &Replica{Of: name, Index: i, Count: len(names)}
+			c.TaskDefs[replica] = def
+		}
+		c.replicas[name] = names
+	}
+
+	c.Tasks = c.expandNames(c.Tasks)
+	return nil
+}
+
+// expandNames replaces names of tasks with replicas by their replicas
+func (c *Config) expandNames(names []string) []string {
+	var out []string
+	for _, name := range names {
+		if replicas, ok := c.replicas[name]; ok {
+			out = append(out, replicas...)
+		} else {
+			out = append(out, name)
+		}
+	}
+	return out
+}
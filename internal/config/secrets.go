@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultSecretEnv are the patterns of variable names whose values are
+// masked when secret_env is not set
+var DefaultSecretEnv = []string{"*SECRET*", "*TOKEN*", "*PASSWORD*", "*PASSWD*", "*CREDENTIAL*", "*_KEY", "*_KEY_*"}
+
+// IsSecretEnv reports whether the value of an environment variable must not
+// be shown, by matching its name against secret_env (or DefaultSecretEnv)
+// case-insensitively
+func (c *Config) IsSecretEnv(name string) bool {
+	patterns := c.SecretEnv
+	if len(patterns) == 0 {
+		patterns = DefaultSecretEnv
+	}
+	name = strings.ToUpper(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSecretEnv checks that the secret_env patterns are valid globs
+func (c *Config) validateSecretEnv() error {
+	for _, pattern := range c.SecretEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid secret_env pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// defaultShutdownSignals stop prun when shutdown_signals is not set
+var defaultShutdownSignals = []string{"SIGINT", "SIGTERM"}
+
+// ParseSignal converts a signal name such as "SIGTERM" or "term" to a signal
+func ParseSignal(name string) (syscall.Signal, error) {
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	sig, ok := signalNames[upper]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal '%s'", name)
+	}
+	return sig, nil
+}
+
+// validateSignals checks the signal names of shutdown_signals and
+// forward_signals, which must not overlap
+func (c *Config) validateSignals() error {
+	shutdown := make(map[syscall.Signal]bool)
+	for _, name := range c.shutdownSignalNames() {
+		sig, err := ParseSignal(name)
+		if err != nil {
+			return fmt.Errorf("invalid shutdown_signals: %w", err)
+		}
+		shutdown[sig] = true
+	}
+	for _, name := range c.ForwardSignals {
+		sig, err := ParseSignal(name)
+		if err != nil {
+			return fmt.Errorf("invalid forward_signals: %w", err)
+		}
+		if shutdown[sig] {
+			return fmt.Errorf("signal '%s' is in both shutdown_signals and forward_signals", name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) shutdownSignalNames() []string {
+	if c.ShutdownSignals == nil {
+		return defaultShutdownSignals
+	}
+	return c.ShutdownSignals
+}
+
+// SignalRoutes returns the signals that stop prun and the signals forwarded
+// to running tasks. Names are validated by Load.
+func (c *Config) SignalRoutes() (shutdown, forward []os.Signal) {
+	for _, name := range c.shutdownSignalNames() {
+		if sig, err := ParseSignal(name); err == nil {
+			shutdown = append(shutdown, sig)
+		}
+	}
+	for _, name := range c.ForwardSignals {
+		if sig, err := ParseSignal(name); err == nil {
+			forward = append(forward, sig)
+		}
+	}
+	return shutdown, forward
+}
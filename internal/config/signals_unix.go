@@ -0,0 +1,18 @@
+//go:build unix
+
+package config
+
+import "syscall"
+
+// signalNames are the signals that can be routed by name
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGWINCH": syscall.SIGWINCH,
+	"SIGCONT":  syscall.SIGCONT,
+	"SIGALRM":  syscall.SIGALRM,
+}
@@ -0,0 +1,11 @@
+package config
+
+import "syscall"
+
+// signalNames are the signals that can be routed by name. Windows only
+// delivers interrupt and termination requests to console programs.
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SimulateDef scripts the fake process that replaces a task under
+// `prun check --simulate`
+type SimulateDef struct {
+	Exits []int    `toml:"exits,omitempty"` // exit code of each run, the last one repeating
+	Delay Duration `toml:"delay,omitempty"` // how long each run takes
+}
+
+// defaultSimulateDelay is used when a task's delay is not set
+const defaultSimulateDelay = time.Second
+
+// Simulated returns a copy of the config in which every task runs a fake
+// command that sleeps and exits as scripted in the [simulate] table. The
+// fake picks its exit code by PRUN_RESTART_COUNT, so restarted runs follow
+// the script. Paths, wrappers, log files and passed sockets are dropped so
+// the simulation needs nothing from the real environment.
+func (c *Config) Simulated() *Config {
+	cfg := *c
+	cfg.TaskDefs = make(map[string]TaskDef, len(c.TaskDefs))
+	cfg.Wrapper = ""
+	cfg.logFiles = nil
+	cfg.raw = nil // the fake commands are shell, not to be interpolated
+
+	noWrapper := ""
+	for name, task := range c.TaskDefs {
+		sim := c.Simulate[name]
+		if task.Replica != nil {
+			if s, ok := c.Simulate[task.Replica.Of]; ok {
+				sim = s
+			}
+		}
+
+		delay := sim.Delay.Duration
+		if delay == 0 {
+			delay = defaultSimulateDelay
+		}
+		exits := sim.Exits
+		if len(exits) == 0 {
+			exits = []int{0}
+		}
+		codes := make([]string, len(exits))
+		for i, code := range exits {
+			codes[i] = strconv.Itoa(code)
+		}
+
+		task.Cmd = fmt.Sprintf(
+			`codes=(%s); n=${PRUN_RESTART_COUNT:-0}; (( n < ${#codes[@]} )) || n=$((${#codes[@]} - 1)); `+
+				`echo "simulated run $n"; sleep %g; exit ${codes[$n]}`,
+			strings.Join(codes, " "), delay.Seconds())
+		task.Steps = nil
+		task.Path = ""
+		task.LogFile = ""
+		task.PassFDs = nil
+		task.Wrapper = &noWrapper
+		cfg.TaskDefs[name] = task
+	}
+	return &cfg
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes written in TOML as a string like "10MB" or
+// "512KB". Units are powers of 1024; a bare number is in bytes.
+type Size struct {
+	Bytes int64
+}
+
+// sizeUnits are the suffixes Size accepts, longest first so that "MB" is
+// not read as "B"
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (s *Size) UnmarshalText(text []byte) error {
+	value := strings.ToUpper(strings.TrimSpace(string(text)))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(value, u.suffix); ok {
+			value, unit = strings.TrimSpace(n), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return fmt.Errorf("invalid size %q (expected a number of bytes, or one with KB, MB or GB)", string(text))
+	}
+	s.Bytes = n * unit
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// String writes the size in the largest unit that divides it
+func (s Size) String() string {
+	for _, u := range sizeUnits[:3] {
+		if s.Bytes >= u.bytes && s.Bytes%u.bytes == 0 {
+			return strconv.FormatInt(s.Bytes/u.bytes, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(s.Bytes, 10) + "B"
+}
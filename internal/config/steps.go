@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Step is one command of a task with steps. TOML may give it as a string,
+// the command, or as a table with cmd and the step's own retries and
+// timeout.
+type Step struct {
+	Cmd     string   `toml:"cmd"`
+	Retries *int     `toml:"retries,omitempty"` // reruns after a failure; the task's step_retries if unset
+	Timeout Duration `toml:"timeout,omitempty"` // fail the step if it runs longer; the task's step_timeout if unset
+}
+
+// UnmarshalTOML implements toml.Unmarshaler
+func (s *Step) UnmarshalTOML(v interface{}) error {
+	switch val := v.(type) {
+	case string:
+		*s = Step{Cmd: val}
+	case map[string]interface{}:
+		*s = Step{}
+		for key, field := range val {
+			var ok bool
+			switch key {
+			case "cmd":
+				s.Cmd, ok = field.(string)
+			case "retries":
+				var n int64
+				if n, ok = field.(int64); ok {
+					retries := int(n)
+					s.Retries = &retries
+				}
+			case "timeout":
+				var text string
+				if text, ok = field.(string); ok {
+					if err := s.Timeout.UnmarshalText([]byte(text)); err != nil {
+						return fmt.Errorf("step timeout: %w", err)
+					}
+				}
+			default:
+				return fmt.Errorf("unknown step field '%s' (expected cmd, retries or timeout)", key)
+			}
+			if !ok {
+				return fmt.Errorf("step field '%s' has the wrong type %T", key, field)
+			}
+		}
+	default:
+		return fmt.Errorf("expected a string or table for a step, got %T", v)
+	}
+	return nil
+}
+
+// Commands returns what a task runs: its cmd, or the cmd of each step
+func (t TaskDef) Commands() []string {
+	if len(t.Steps) == 0 {
+		return []string{t.Cmd}
+	}
+	cmds := make([]string, len(t.Steps))
+	for i, step := range t.Steps {
+		cmds[i] = step.Cmd
+	}
+	return cmds
+}
+
+// RetriesFor returns how many times step i of a task is rerun after failing
+func (t TaskDef) RetriesFor(i int) int {
+	if retries := t.Steps[i].Retries; retries != nil {
+		return *retries
+	}
+	return t.StepRetries
+}
+
+// TimeoutFor returns how long step i of a task may run, or 0 if unlimited
+func (t TaskDef) TimeoutFor(i int) time.Duration {
+	if timeout := t.Steps[i].Timeout.Duration; timeout > 0 {
+		return timeout
+	}
+	return t.StepTimeout.Duration
+}
+
+// validateSteps checks the steps of a task, which replace its cmd
+func validateSteps(name string, task TaskDef) error {
+	if task.Cmd != "" && len(task.Steps) > 0 {
+		return fmt.Errorf("task '%s' sets both 'cmd' and 'steps'; use one", name)
+	}
+	if task.Cmd == "" && len(task.Steps) == 0 {
+		return fmt.Errorf("task '%s' missing required 'cmd' field", name)
+	}
+	for i, step := range task.Steps {
+		if step.Cmd == "" {
+			return fmt.Errorf("task '%s' step %d has no cmd", name, i+1)
+		}
+		if step.Retries != nil && *step.Retries < 0 {
+			return fmt.Errorf("task '%s' step %d has invalid retries %d", name, i+1, *step.Retries)
+		}
+	}
+	if task.StepRetries < 0 {
+		return fmt.Errorf("task '%s' has invalid step_retries %d", name, task.StepRetries)
+	}
+	if task.RestartFromStep != 0 && (len(task.Steps) == 0 || task.RestartFromStep < 1 || task.RestartFromStep > len(task.Steps)) {
+		return fmt.Errorf("task '%s' has invalid restart_from_step %d (the task has %d steps)", name, task.RestartFromStep, len(task.Steps))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MaxTaskRefDepth bounds how deeply {task:name} references may nest
+const MaxTaskRefDepth = 8
+
+// taskRefPattern matches a {task:name} reference in a cmd
+var taskRefPattern = regexp.MustCompile(`\{task:([^{}\s]+)\}`)
+
+// TaskRefs returns the names of the tasks a cmd references as {task:name}
+func TaskRefs(cmd string) []string {
+	var names []string
+	for _, m := range taskRefPattern.FindAllStringSubmatch(cmd, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// ReplaceTaskRefs replaces every {task:name} reference in cmd with the
+// result of replace
+func ReplaceTaskRefs(cmd string, replace func(name string) string) string {
+	return taskRefPattern.ReplaceAllStringFunc(cmd, func(ref string) string {
+		return replace(taskRefPattern.FindStringSubmatch(ref)[1])
+	})
+}
+
+// taskRefsOf returns the tasks referenced by a task's cmd or steps
+func (c *Config) taskRefsOf(name string) []string {
+	var refs []string
+	for _, cmd := range c.TaskDefs[name].Commands() {
+		refs = append(refs, TaskRefs(cmd)...)
+	}
+	return refs
+}
+
+// validateTaskRefs checks that every {task:name} reference names a task,
+// and that references neither form a cycle nor nest deeper than
+// MaxTaskRefDepth
+func (c *Config) validateTaskRefs() error {
+	names := make([]string, 0, len(c.TaskDefs))
+	for name := range c.TaskDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, ref := range c.taskRefsOf(name) {
+			task, ok := c.TaskDefs[ref]
+			switch {
+			case !ok:
+				return fmt.Errorf("task '%s' references {task:%s}, which is not defined", name, ref)
+			case task.Replicas > 1:
+				return fmt.Errorf("task '%s' references {task:%s}, which has replicas", name, ref)
+			case task.Watch:
+				return fmt.Errorf("task '%s' references {task:%s}, which has watch enabled and would never finish", name, ref)
+			}
+		}
+	}
+
+	// Walk the references depth first from every task
+	var walk func(name string, path []string) error
+	walk = func(name string, path []string) error {
+		if i := slices.Index(path, name); i >= 0 {
+			return fmt.Errorf("task references form a cycle: %s", strings.Join(append(path[i:], name), " → "))
+		}
+		path = append(path, name)
+		if len(path) > MaxTaskRefDepth+1 {
+			return fmt.Errorf("task references nest deeper than %d: %s", MaxTaskRefDepth, strings.Join(path, " → "))
+		}
+		for _, ref := range c.taskRefsOf(name) {
+			if err := walk(ref, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := walk(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
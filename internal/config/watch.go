@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WatchDirs returns the directories a watched task watches: each of its
+// watch_paths, relative to its path, or else its path
+func (t TaskDef) WatchDirs() []string {
+	base := t.Path
+	if base == "" {
+		base = "."
+	}
+	if len(t.WatchPaths) == 0 {
+		return []string{base}
+	}
+	dirs := make([]string, len(t.WatchPaths))
+	for i, p := range t.WatchPaths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(base, p)
+		}
+		dirs[i] = p
+	}
+	return dirs
+}
+
+// WatchMatches reports whether a change to a file restarts a watched task,
+// going by its watch_include and watch_exclude patterns after the shared
+// [watch] exclude ones. rel is the file's path relative to the task's path.
+// Without watch_include every file counts.
+func (t TaskDef) WatchMatches(rel string, shared []string) bool {
+	ok, _ := t.WatchMatch(rel, shared)
+	return ok
+}
+
+// WatchMatch is WatchMatches along with the rule that decided, e.g.
+// "watch_exclude pattern '*.log'": the watch_include pattern a file
+// matched, or the exclude pattern that ruled it out. It is "" when there is
+// no watch_include to match, or when none of its patterns matched.
+//
+// In each list, a pattern starting with ! takes back what an earlier one
+// matched, and the last pattern to match decides. The task's watch_exclude
+// comes after [watch] exclude, so the task's rules have the last word.
+func (t TaskDef) WatchMatch(rel string, shared []string) (bool, string) {
+	rel = filepath.ToSlash(rel)
+	included, rule := len(t.WatchInclude) == 0, ""
+	if pattern, ok := lastMatch(t.WatchInclude, rel); ok {
+		included = !strings.HasPrefix(pattern, "!")
+		rule = fmt.Sprintf("watch_include pattern '%s'", pattern)
+	}
+	if !included {
+		return false, rule
+	}
+
+	excluded, excludedBy := false, ""
+	if pattern, ok := lastMatch(shared, rel); ok {
+		excluded = !strings.HasPrefix(pattern, "!")
+		excludedBy = fmt.Sprintf("[watch] exclude pattern '%s'", pattern)
+	}
+	if pattern, ok := lastMatch(t.WatchExclude, rel); ok {
+		excluded = !strings.HasPrefix(pattern, "!")
+		excludedBy = fmt.Sprintf("watch_exclude pattern '%s'", pattern)
+	}
+	if excluded {
+		return false, excludedBy
+	}
+	return true, rule
+}
+
+// lastMatch returns the last of patterns that matches rel, ! included
+func lastMatch(patterns []string, rel string) (string, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if MatchGlob(strings.TrimPrefix(patterns[i], "!"), rel) {
+			return patterns[i], true
+		}
+	}
+	return "", false
+}
+
+// MatchGlob matches a slash-separated path against a pattern in which each
+// segment is a path.Match pattern and a "**" segment stands for any number
+// of directories, including none
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// validateWatch checks a task's watch_paths, watch_include and watch_exclude
+func validateWatch(name string, task TaskDef) error {
+	for _, p := range task.WatchPaths {
+		if p == "" {
+			return fmt.Errorf("task '%s' has an empty watch_paths entry", name)
+		}
+	}
+	if err := validateGlobs(name, "watch_include", task.WatchInclude); err != nil {
+		return err
+	}
+	return validateGlobs(name, "watch_exclude", task.WatchExclude)
+}
+
+// validateGlobs checks the MatchGlob patterns of a task's field, each
+// perhaps negated with a leading !
+func validateGlobs(name, field string, patterns StringList) error {
+	for _, pattern := range patterns {
+		if !validGlob(pattern) {
+			return fmt.Errorf("task '%s' has an invalid %s pattern '%s'", name, field, pattern)
+		}
+	}
+	return nil
+}
+
+// validGlob reports whether pattern, less a leading !, is a MatchGlob pattern
+func validGlob(pattern string) bool {
+	pattern = strings.TrimPrefix(pattern, "!")
+	if pattern == "" {
+		return false
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// autoStop is the cancellation cause of a run stopped by auto_stop_after or
+// auto_stop_when_idle
+type autoStop struct {
+	reason string // e.g. "no output for 30m0s"
+}
+
+func (a *autoStop) Error() string {
+	return "auto-stopped: " + a.reason
+}
+
+// autoStopReason returns why ctx was cancelled by armAutoStop, or ""
+func autoStopReason(ctx context.Context) string {
+	if stop, ok := context.Cause(ctx).(*autoStop); ok {
+		return stop.reason
+	}
+	return ""
+}
+
+// idleClock holds when a run last printed a line
+type idleClock struct {
+	last atomic.Int64 // UnixNano
+}
+
+func (c *idleClock) tick() {
+	c.last.Store(time.Now().UnixNano())
+}
+
+func (c *idleClock) since() time.Duration {
+	return time.Since(time.Unix(0, c.last.Load()))
+}
+
+// armAutoStop cancels a run with an autoStop cause once it has lasted the
+// task's auto_stop_after, or printed nothing for its auto_stop_when_idle.
+// Both count from the start of the run, so a restart resets them. It
+// returns a function disarming them.
+func (r *Runner) armAutoStop(cancel context.CancelCauseFunc, taskName string, out *taskOutput) func() {
+	taskDef := r.cfg.TaskDefs[taskName]
+	disarmed := make(chan struct{})
+
+	var deadline *time.Timer
+	if after := taskDef.AutoStopAfter.Duration; after > 0 {
+		deadline = time.AfterFunc(after, func() {
+			cancel(&autoStop{reason: fmt.Sprintf("ran for %s", FormatElapsed(after))})
+		})
+	}
+
+	if idle := taskDef.AutoStopWhenIdle.Duration; idle > 0 {
+		out.idle.tick()
+		go func() {
+			defer RecoverPanic()
+			timer := time.NewTimer(idle)
+			defer timer.Stop()
+			for {
+				select {
+				case <-disarmed:
+					return
+				case <-timer.C:
+				}
+				if wait := idle - out.idle.since(); wait > 0 {
+					timer.Reset(wait)
+					continue
+				}
+				cancel(&autoStop{reason: fmt.Sprintf("no output for %s", FormatElapsed(idle))})
+				return
+			}
+		}()
+	}
+
+	return func() {
+		close(disarmed)
+		if deadline != nil {
+			deadline.Stop()
+		}
+	}
+}
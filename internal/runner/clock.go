@@ -0,0 +1,26 @@
+package runner
+
+import "time"
+
+// clock tells the time and runs the timers of debouncing and restart
+// backoff. Tests replace realClock with one they move forward themselves,
+// so that they neither wait nor depend on timing.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) timer
+	After(d time.Duration) <-chan time.Time
+}
+
+// timer is a timer started by a clock's AfterFunc
+type timer interface {
+	Stop() bool
+}
+
+// realClock is the clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer { return time.AfterFunc(d, f) }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
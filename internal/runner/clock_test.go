@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock that only moves forward when a test advances it
+type fakeClock struct {
+	mu      sync.Mutex
+	changed *sync.Cond // signalled when a timer is started
+	now     time.Time
+	timers  []*fakeTimer // pending
+	started int          // timers started so far
+}
+
+type fakeTimer struct {
+	c  *fakeClock
+	at time.Time
+	f  func()
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	c.started++
+	c.changed.Broadcast()
+	return t
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	i := slices.Index(t.c.timers, t)
+	if i < 0 {
+		return false
+	}
+	t.c.timers = slices.Delete(t.c.timers, i, i+1)
+	return true
+}
+
+// Advance moves the clock forward by d and runs the timers that are due, in
+// the order they were due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	c.timers = slices.DeleteFunc(c.timers, func(t *fakeTimer) bool {
+		if t.at.After(c.now) {
+			return false
+		}
+		due = append(due, t)
+		return true
+	})
+	c.mu.Unlock()
+
+	slices.SortStableFunc(due, func(a, b *fakeTimer) int { return a.at.Compare(b.at) })
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// WaitStarted waits until n timers have been started since the clock was
+// made, so that advancing the clock fires the one the code under test just
+// started to wait on
+func (c *fakeClock) WaitStarted(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.started < n {
+		c.changed.Wait()
+	}
+}
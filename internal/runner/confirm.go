@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restartWindow is how long the watcher collects the restarts set off by a
+// change before deciding whether to ask first. The debounce timers of tasks
+// watching the same file fire together, so a short window catches them all.
+const restartWindow = 50 * time.Millisecond
+
+// defaultAutoConfirm is how long a pending restart waits without the TUI
+// before it goes ahead
+const defaultAutoConfirm = 10 * time.Second
+
+// PendingRestart is a restart of more tasks than [watch] confirm_above
+// allows, waiting to be confirmed. It travels in LogEvent.Pending.
+type PendingRestart struct {
+	ID    int
+	Tasks []string  // sorted
+	Path  string    // the latest change that set it off
+	Until time.Time // when it goes ahead by itself; zero if it waits for the TUI
+	Done  bool      // confirmed, cancelled or superseded; the prompt should go
+}
+
+// restartBatch collects the restarts of one change window, and holds the
+// restart waiting for confirmation, if any
+type restartBatch struct {
+	mu      sync.Mutex
+	tasks   map[string]bool
+	path    string
+	window  *time.Timer
+	pending *PendingRestart
+	confirm *time.Timer // confirms pending when it times out
+	lastID  int
+}
+
+// SetInteractive makes restarts that need confirmation wait for
+// ResolveRestart, as the TUI asks the user, instead of going ahead after
+// [watch] auto_confirm
+func (w *Watcher) SetInteractive(interactive bool) {
+	w.interactive = interactive
+}
+
+// SetAutoConfirm restarts tasks right away even when a change affects more
+// than [watch] confirm_above of them
+func (w *Watcher) SetAutoConfirm(auto bool) {
+	w.autoConfirm = auto
+}
+
+// queueRestart restarts a task because of a change to path. When the config
+// asks for confirmation, restarts are collected for restartWindow first.
+func (w *Watcher) queueRestart(taskName, path string) {
+	if w.config().Watch.ConfirmAbove <= 0 || w.autoConfirm {
+		w.triggerRestart(taskName, path)
+		return
+	}
+
+	b := &w.batch
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tasks == nil {
+		b.tasks = make(map[string]bool)
+	}
+	b.tasks[taskName] = true
+	b.path = path
+	if b.window == nil {
+		b.window = time.AfterFunc(restartWindow, w.flushRestarts)
+	}
+}
+
+// flushRestarts restarts the tasks of a change window, or holds them for
+// confirmation if there are too many. A window that comes in while a restart
+// is pending supersedes it with the tasks of both.
+func (w *Watcher) flushRestarts() {
+	defer RecoverPanic()
+	cfg := w.config()
+	b := &w.batch
+	b.mu.Lock()
+	tasks := make([]string, 0, len(b.tasks))
+	for name := range b.tasks {
+		tasks = append(tasks, name)
+	}
+	path := b.path
+	b.tasks, b.window = nil, nil
+
+	if b.pending == nil && len(tasks) <= cfg.Watch.ConfirmAbove {
+		b.mu.Unlock()
+		for _, name := range tasks {
+			w.triggerRestart(name, path)
+		}
+		return
+	}
+
+	if b.pending != nil {
+		if b.confirm != nil {
+			b.confirm.Stop()
+		}
+		for _, name := range b.pending.Tasks {
+			if !slices.Contains(tasks, name) {
+				tasks = append(tasks, name)
+			}
+		}
+	}
+	slices.Sort(tasks)
+	b.lastID++
+	p := &PendingRestart{ID: b.lastID, Tasks: tasks, Path: path}
+	b.confirm = nil
+	if !w.interactive {
+		wait := cfg.Watch.AutoConfirm.Duration
+		if wait <= 0 {
+			wait = defaultAutoConfirm
+		}
+		p.Until = time.Now().Add(wait)
+		b.confirm = time.AfterFunc(wait, func() {
+			w.ResolveRestart(p.ID, p.Tasks)
+		})
+	}
+	b.pending = p
+	b.mu.Unlock()
+
+	msg := fmt.Sprintf("%d tasks affected by %s change: %s", len(tasks), filepath.Base(path), strings.Join(tasks, ", "))
+	if w.interactive {
+		msg += "; restart them? [y/n/s]"
+	} else {
+		msg += fmt.Sprintf("; restarting in %s (--watch-auto-confirm skips asking)", time.Until(p.Until).Round(time.Second))
+	}
+	w.announce(msg, p)
+}
+
+// ResolveRestart settles the pending restart with the given ID by restarting
+// tasks, which may be a subset of its tasks or none to cancel it. It returns
+// false if that restart is no longer pending, having been superseded.
+func (w *Watcher) ResolveRestart(id int, tasks []string) bool {
+	b := &w.batch
+	b.mu.Lock()
+	p := b.pending
+	if p == nil || p.ID != id {
+		b.mu.Unlock()
+		return false
+	}
+	if b.confirm != nil {
+		b.confirm.Stop()
+	}
+	b.pending = nil
+	b.mu.Unlock()
+
+	var restart []string
+	for _, name := range tasks {
+		if slices.Contains(p.Tasks, name) {
+			restart = append(restart, name)
+		}
+	}
+	msg := "restart cancelled"
+	if len(restart) > 0 {
+		msg = "restarting " + strings.Join(restart, ", ")
+	}
+	w.announce(msg, &PendingRestart{ID: id, Done: true})
+
+	for _, name := range restart {
+		w.triggerRestart(name, p.Path)
+	}
+	return true
+}
+
+// announce reports a change to the pending restart under SystemTask
+func (w *Watcher) announce(msg string, p *PendingRestart) {
+	if w.eventChan == nil {
+		w.system(LevelInfo, msg)
+		return
+	}
+	w.eventChan <- LogEvent{Task: SystemTask, Line: msg, Time: time.Now(), Level: LevelInfo, Pending: p}
+}
+
+// stop stops the timers of the change window and pending restart
+func (b *restartBatch) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.window != nil {
+		b.window.Stop()
+	}
+	if b.confirm != nil {
+		b.confirm.Stop()
+	}
+}
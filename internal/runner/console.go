@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"prun/internal/config"
+	"prun/internal/theme"
+)
+
+// Console is the terminal that tasks' output and prun's own messages share.
+// Everything written to it goes through one lock, so lines from different
+// goroutines never tear, even where stdout and stderr end up in one stream.
+// main creates it once and hands it to the runner or watcher.
+type Console struct {
+	out *outputWriter
+}
+
+// NewConsole creates a console writing task output to stdout and prun's own
+// messages to stderr
+func NewConsole(stdout, stderr io.Writer) *Console {
+	return &Console{out: newOutputWriter(stdout, stderr, config.StreamMarkers{}, theme.Icons{})}
+}
+
+// Configure sets the stream markers and lifecycle glyphs once the config is
+// loaded
+func (c *Console) Configure(markers config.StreamMarkers, icons theme.Icons) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.markers = markers
+	c.out.icons = icons
+}
+
+// SetPrefixes shortens and aligns the [task] prefixes of lines
+func (c *Console) SetPrefixes(p *Prefixes) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.prefixes = p
+}
+
+// SetJSON makes the console write NDJSON records instead of prefixed lines
+func (c *Console) SetJSON(json bool) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.json = json
+}
+
+// SetColor chooses when output is colored: "always", "never", or "auto",
+// on a terminal unless NO_COLOR is set (--color)
+func (c *Console) SetColor(mode string) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	switch mode {
+	case "always":
+		c.out.color, c.out.errColor = true, true
+	case "never":
+		c.out.color, c.out.errColor = false, false
+	default:
+		c.out.color, c.out.errColor = colorEnabled(c.out.writer), colorEnabled(c.out.errWriter)
+	}
+}
+
+// SetTimestamps puts the time, as HH:MM:SS.mmm, in front of each line of
+// task output and each lifecycle line (--timestamps)
+func (c *Console) SetTimestamps(timestamps bool) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.timestamps = timestamps
+}
+
+// SetNoPrefix leaves the [task] prefix out of task output and lifecycle
+// lines, for a single task (--no-prefix)
+func (c *Console) SetNoPrefix(noPrefix bool) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.noPrefix = noPrefix
+}
+
+// Infof writes a message from prun itself
+func (c *Console) Infof(format string, args ...any) {
+	c.out.writeSystem(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Warnf writes a warning from prun itself
+func (c *Console) Warnf(format string, args ...any) {
+	c.out.writeSystem(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// Errorf writes an error from prun itself
+func (c *Console) Errorf(format string, args ...any) {
+	c.out.writeSystem(LevelError, fmt.Sprintf(format, args...))
+}
+
+// WriteLine writes a line of output, as for a task
+func (c *Console) WriteLine(ev LogEvent) {
+	c.out.WriteLine(ev)
+}
+
+// Flush waits until everything written to the console has reached the
+// terminal. Lines of task output are written in the background, so prun
+// flushes before it exits or hands the terminal to the TUI. A terminal that
+// takes nothing for spoolFlushWait loses the task output still queued.
+func (c *Console) Flush() {
+	c.out.spool.flush()
+}
+
+// levelColors color prun's own messages by severity
+var levelColors = map[string]string{
+	LevelDebug: ansiGray,
+	LevelWarn:  ansiYellow,
+	LevelError: ansiRed,
+}
+
+// writeSystem writes a message from prun itself under the SystemTask prefix.
+// Text goes to stderr, colored by level on a terminal; NDJSON records join
+// the rest on stdout so a log collector gets them in order.
+func (ow *outputWriter) writeSystem(level, message string) {
+	ow.spool.add(func() { ow.writeSystemNow(level, message) })
+}
+
+// writeSystemNow is writeSystem, bypassing the spool
+func (ow *outputWriter) writeSystemNow(level, message string) {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+
+	if ow.json {
+		ow.writeJSON(LogEvent{
+			Task:  SystemTask,
+			Line:  message,
+			IsErr: level == LevelWarn || level == LevelError,
+			Time:  time.Now(),
+			Level: level,
+		})
+		return
+	}
+	prefix := ow.prefixes.Padded(SystemTask)
+	if color := levelColors[level]; color != "" && ow.errColor {
+		fmt.Fprintf(ow.errWriter, "%s %s%s%s\n", prefix, color, message, ansiReset)
+		return
+	}
+	fmt.Fprintf(ow.errWriter, "%s %s\n", prefix, message)
+}
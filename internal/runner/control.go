@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// restartByUser is the restart reason of a task restarted with RestartTask,
+// which restarts it even if it isn't watched
+const restartByUser = "restarted by user"
+
+// errNoControl is returned by the task controls of an Orchestrator running
+// its tasks once, without a watcher
+var errNoControl = errors.New("tasks can only be restarted, stopped and started while prun watches them (-w, watch or watch_config)")
+
+// exited reports whether a task's restart loop has ended, as it does after
+// a run of a task that isn't watched
+func (t *watchedTask) exited() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// RestartTask restarts a task as a file change would, whether or not it is
+// watched, or starts it again if it has exited or was stopped. It is safe to
+// call while the tasks run, as are StopTask and StartTask.
+func (w *Watcher) RestartTask(taskName string) error {
+	if err := w.awaitHeld(taskName); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.controllable(taskName); err != nil {
+		return err
+	}
+	t, ok := w.running[taskName]
+	if !ok || t.exited() {
+		delete(w.held, taskName)
+		w.startTask(w.ctx, taskName)
+		w.logEvent(taskName, "Started by user")
+		return nil
+	}
+	select {
+	case t.restart <- restartByUser:
+	default:
+		// A restart is already pending
+	}
+	return nil
+}
+
+// StopTask stops a task, sending SIGTERM to its process group. It stays
+// stopped, through file changes and config reloads, until it is started
+// again with StartTask or RestartTask.
+func (w *Watcher) StopTask(taskName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.controllable(taskName); err != nil {
+		return err
+	}
+	t, ok := w.running[taskName]
+	if !ok || t.exited() {
+		return fmt.Errorf("task '%s' is not running", taskName)
+	}
+	t.stop()
+	delete(w.running, taskName)
+	w.held[taskName] = t
+	w.logEvent(taskName, "Stopped by user")
+	return nil
+}
+
+// StartTask starts a task that has no run going: one that was stopped or
+// has exited, or a watched task waiting for a change, which runs again now
+func (w *Watcher) StartTask(taskName string) error {
+	if err := w.awaitHeld(taskName); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.controllable(taskName); err != nil {
+		return err
+	}
+	if t, ok := w.running[taskName]; ok && !t.exited() {
+		if !t.idle.Load() {
+			return fmt.Errorf("task '%s' is already running", taskName)
+		}
+		select {
+		case t.restart <- restartByUser:
+		default:
+		}
+		return nil
+	}
+	delete(w.held, taskName)
+	w.startTask(w.ctx, taskName)
+	w.logEvent(taskName, "Started by user")
+	return nil
+}
+
+// awaitHeld waits for a task stopped with StopTask to exit, so that starting
+// it again can reuse its ports and files
+func (w *Watcher) awaitHeld(taskName string) error {
+	w.mu.Lock()
+	t := w.held[taskName]
+	w.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	select {
+	case <-t.done:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// controllable checks that a task can be controlled: it is one of the tasks
+// run and prun isn't shutting down. w.mu must be held.
+func (w *Watcher) controllable(taskName string) error {
+	if w.ctx == nil || w.ctx.Err() != nil {
+		return errors.New("tasks are not running")
+	}
+	if !slices.Contains(w.tasks, taskName) {
+		return fmt.Errorf("task '%s' is not one of the tasks being run", taskName)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultDebounce is how long a watched task waits for changes to settle
+// before restarting, unless [watch] debounce says otherwise
+const defaultDebounce = 500 * time.Millisecond
+
+// Editors save in bursts of events: a write and a rewrite by a formatter, or
+// a temporary file renamed over the original. While learning, the watcher
+// times the gaps between events on the same file. A pattern of files whose
+// saves keep outlasting the debounce, restarting tasks twice, gets a longer
+// debounce of its own.
+const (
+	learnFor      = 5 * time.Minute         // how long after starting the watcher learns
+	maxBurstGap   = 1500 * time.Millisecond // longer gaps separate two saves
+	maxDebounce   = 2 * time.Second         // the most a debounce is widened to
+	splitsToAdapt = 3                       // split saves seen before adapting
+)
+
+// gapBuckets are the upper bounds of the gap histogram
+var gapBuckets = [...]time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	400 * time.Millisecond,
+	800 * time.Millisecond,
+	maxBurstGap,
+}
+
+// debouncer picks the debounce of each change. It is only used from the
+// watch loop.
+type debouncer struct {
+	base     time.Duration
+	adaptive bool      // a configured debounce turns learning off
+	until    time.Time // end of learning
+	last     map[string]time.Time
+	patterns map[string]*savePattern
+}
+
+// savePattern is what was learned about the saves of one kind of file
+type savePattern struct {
+	gaps     [len(gapBuckets)]int // histogram of gaps within a save
+	splits   []time.Duration      // gaps that outlasted the debounce
+	debounce time.Duration        // widened debounce; 0 until adapted
+}
+
+func newDebouncer(configured time.Duration, now time.Time) *debouncer {
+	d := &debouncer{
+		base:     defaultDebounce,
+		adaptive: configured == 0,
+		until:    now.Add(learnFor),
+		last:     make(map[string]time.Time),
+		patterns: make(map[string]*savePattern),
+	}
+	if configured > 0 {
+		d.base = configured
+	}
+	return d
+}
+
+// savePatternOf groups files by extension ("*.go"), or by name if they
+// have none
+func savePatternOf(path string) string {
+	if ext := filepath.Ext(path); ext != "" {
+		return "*" + ext
+	}
+	return filepath.Base(path)
+}
+
+// observe records a change to path and returns the debounce to wait for it.
+// When the debounce of path's pattern was just widened, it also returns the
+// pattern, to be announced once.
+func (d *debouncer) observe(path string, now time.Time) (time.Duration, string) {
+	if !d.adaptive {
+		return d.base, ""
+	}
+	key := savePatternOf(path)
+	p := d.patterns[key]
+	if p == nil {
+		p = &savePattern{}
+		d.patterns[key] = p
+	}
+	debounce := max(d.base, p.debounce)
+
+	last, seen := d.last[path]
+	d.last[path] = now
+	if !seen || now.After(d.until) || p.debounce > 0 {
+		return debounce, ""
+	}
+	gap := now.Sub(last)
+	if gap > maxBurstGap {
+		return debounce, ""
+	}
+	for i, bound := range gapBuckets {
+		if gap <= bound {
+			p.gaps[i]++
+			break
+		}
+	}
+	if gap <= debounce {
+		return debounce, ""
+	}
+
+	// The save outlasted the debounce. Widen it once such saves are
+	// consistent: the longest gap at most twice the shortest.
+	p.splits = append(p.splits, gap)
+	if len(p.splits) < splitsToAdapt {
+		return debounce, ""
+	}
+	recent := p.splits[len(p.splits)-splitsToAdapt:]
+	shortest, longest := recent[0], recent[0]
+	for _, g := range recent {
+		shortest, longest = min(shortest, g), max(longest, g)
+	}
+	if longest > 2*shortest {
+		return debounce, ""
+	}
+	p.debounce = min((longest * 5 / 4).Round(50*time.Millisecond), maxDebounce)
+	return p.debounce, key
+}
+
+// stats describes what was learned about a pattern's saves
+func (d *debouncer) stats(key string) string {
+	p := d.patterns[key]
+	if p == nil {
+		return key + ": no saves seen"
+	}
+	var counts []string
+	for i, n := range p.gaps {
+		if n > 0 {
+			counts = append(counts, fmt.Sprintf("≤%s %d", gapBuckets[i], n))
+		}
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "none")
+	}
+	return fmt.Sprintf("%s: gaps within a save %s; %d outlasted the debounce; debounce %s",
+		key, strings.Join(counts, ", "), len(p.splits), max(d.base, p.debounce))
+}
@@ -0,0 +1,151 @@
+package runner
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// depGate tracks which tasks have started, which are ready and how they
+// ended, for tasks waiting on them with depends_on, depends_on_started and
+// wait_for. The runners of one prun share it.
+type depGate struct {
+	mu      sync.Mutex
+	changed chan struct{}   // closed and replaced whenever a task starts, becomes ready or ends
+	started map[string]bool // tasks that have started at least once
+	ready   map[string]bool // tasks whose current run passed its ready check
+	ended   map[string]bool // tasks that ended, and whether they succeeded
+}
+
+func newDepGate() *depGate {
+	return &depGate{
+		changed: make(chan struct{}),
+		started: make(map[string]bool),
+		ready:   make(map[string]bool),
+		ended:   make(map[string]bool),
+	}
+}
+
+// start records that a task started
+func (g *depGate) start(taskName string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.started[taskName] {
+		g.started[taskName] = true
+		g.notify()
+	}
+}
+
+// becameReady records that a task passed its ready check
+func (g *depGate) becameReady(taskName string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.ready[taskName] {
+		g.ready[taskName] = true
+		g.notify()
+	}
+}
+
+// end records how a task ended. A task that is run again, after a restart,
+// counts as not having ended until it does again.
+func (g *depGate) end(taskName string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ended[taskName] = ok
+	g.notify()
+}
+
+// rerun forgets how a task ended, and that it was ready, as it runs again
+func (g *depGate) rerun(taskName string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.ended, taskName)
+	delete(g.ready, taskName)
+}
+
+// notify wakes the tasks waiting for a change; g.mu must be held
+func (g *depGate) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// check returns the dependencies still pending and the first that failed,
+// along with a channel closed on the next change. A task waited for to be
+// ready fails if it ended without being so.
+func (g *depGate) check(done, started, ready []string) (pending []string, failed string, changed <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, dep := range done {
+		ok, ended := g.ended[dep]
+		switch {
+		case ended && !ok:
+			return nil, dep, g.changed
+		case !ended:
+			pending = append(pending, dep)
+		}
+	}
+	for _, dep := range started {
+		ok, ended := g.ended[dep]
+		switch {
+		case ended && !ok && !g.started[dep]:
+			return nil, dep, g.changed
+		case !g.started[dep]:
+			pending = append(pending, dep)
+		}
+	}
+	for _, dep := range ready {
+		_, ended := g.ended[dep]
+		switch {
+		case ended && !g.ready[dep]:
+			return nil, dep, g.changed
+		case !g.ready[dep]:
+			pending = append(pending, dep)
+		}
+	}
+	return pending, "", g.changed
+}
+
+// waitForDependencies holds a task back until the tasks it depends on that
+// are being run allow it to start, showing it as queued meanwhile. It
+// returns the dependency that failed, if one did, and "" once the task may
+// start or ctx is done.
+func (r *Runner) waitForDependencies(ctx context.Context, taskName string) string {
+	order := r.order
+	if order == nil {
+		order = r.tasks
+	}
+	running := func(deps []string) []string {
+		return slices.DeleteFunc(deps, func(dep string) bool {
+			return !slices.Contains(order, dep)
+		})
+	}
+	doneDeps, startedDeps := r.cfg.Dependencies(taskName)
+	doneDeps, startedDeps = running(doneDeps), running(startedDeps)
+	readyDeps := running(r.cfg.WaitFor(taskName))
+
+	reported := 0
+	for {
+		pending, failed, changed := r.deps.check(doneDeps, startedDeps, readyDeps)
+		if failed != "" || len(pending) == 0 {
+			return failed
+		}
+		if len(pending) != reported {
+			reported = len(pending)
+			r.emitStatus(LogEvent{
+				Task:   taskName,
+				Status: StatusQueued,
+				Ahead:  len(pending),
+				Reason: "waiting for " + strings.Join(pending, ", "),
+			})
+		}
+		select {
+		case <-ctx.Done():
+			// A dependency that failed cancels the others without -w;
+			// report it rather than the cancellation
+			_, failed, _ = r.deps.check(doneDeps, startedDeps, readyDeps)
+			return failed
+		case <-changed:
+		}
+	}
+}
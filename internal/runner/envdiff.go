@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"prun/internal/config"
+)
+
+// maxEnvValue is the longest value shown in an environment diff
+const maxEnvValue = 40
+
+// envHistory remembers the environment of each task's last run, so a
+// restart can report what changed. It keeps one environment per task.
+type envHistory struct {
+	mu   sync.Mutex
+	last map[string]map[string]string
+}
+
+func newEnvHistory() *envHistory {
+	return &envHistory{last: make(map[string]map[string]string)}
+}
+
+// swap records env as the task's latest environment and returns the one it
+// replaces, or nil on the task's first run
+func (h *envHistory) swap(taskName string, env []string) map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.last[taskName]
+	h.last[taskName] = envMap(env)
+	return prev
+}
+
+// envMap turns a KEY=VALUE environment into a map, later entries overriding
+// earlier ones
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// envDiff describes how cur differs from prev as "+ADDED=value, -REMOVED,
+// CHANGED old → new", or "" if they are the same. Values of secret
+// variables are left out. PRUN_RESTART_COUNT changes on every restart and is
+// skipped.
+func envDiff(cfg *config.Config, prev, cur map[string]string) string {
+	var added, removed, changed []string
+	for k, v := range cur {
+		if k == "PRUN_RESTART_COUNT" {
+			continue
+		}
+		old, ok := prev[k]
+		switch {
+		case !ok && cfg.IsSecretEnv(k):
+			added = append(added, "+"+k)
+		case !ok:
+			added = append(added, "+"+k+"="+shortValue(v))
+		case old != v && cfg.IsSecretEnv(k):
+			changed = append(changed, k+" (changed)")
+		case old != v:
+			changed = append(changed, fmt.Sprintf("%s %s → %s", k, shortValue(old), shortValue(v)))
+		}
+	}
+	for k := range prev {
+		if _, ok := cur[k]; !ok {
+			removed = append(removed, "-"+k)
+		}
+	}
+	if len(added)+len(removed)+len(changed) == 0 {
+		return ""
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return strings.Join(append(append(added, removed...), changed...), ", ")
+}
+
+// shortValue quotes a value for a diff, cutting long ones short
+func shortValue(v string) string {
+	if len(v) > maxEnvValue {
+		v = v[:maxEnvValue] + "…"
+	}
+	if v == "" || strings.ContainsAny(v, " ,\t") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
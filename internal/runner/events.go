@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// subscriberBuffer is the channel capacity given to each live subscriber
+const subscriberBuffer = 256
+
+// storedEvent is an event tagged with its publish order
+type storedEvent struct {
+	seq uint64
+	ev  LogEvent
+}
+
+// EventStore sits between the runner and its consumers. It retains the most
+// recent log events per task plus each task's latest status, so a consumer
+// that subscribes late (like the TUI, which starts after the runner) first
+// receives a snapshot of what it missed and then the live stream, with no
+// gaps or duplicates in between.
+//
+// Events reach subscribers outside the lock on what the store retains, so a
+// subscriber that stops reading holds up the publisher, and with it the
+// runner, but never a consumer subscribing or taking a snapshot meanwhile.
+type EventStore struct {
+	sendMu   sync.Mutex // held while an event is sent, and to close channels
+	mu       sync.Mutex
+	perTask  int
+	seq      uint64
+	logs     map[string][]storedEvent
+	statuses map[string]storedEvent
+	subs     []subscriber
+	closed   bool
+}
+
+// subscriber is a live event channel. A detachable subscriber is dropped,
+// and its channel closed, instead of blocking publishers when it falls behind.
+type subscriber struct {
+	ch         chan LogEvent
+	detachable bool
+}
+
+// NewEventStore creates a store that retains up to perTask log events per task
+func NewEventStore(perTask int) *EventStore {
+	return &EventStore{
+		perTask:  perTask,
+		logs:     make(map[string][]storedEvent),
+		statuses: make(map[string]storedEvent),
+	}
+}
+
+// Consume publishes every event from in until it is closed, then closes all
+// subscriber channels
+func (s *EventStore) Consume(in <-chan LogEvent) {
+	defer RecoverPanic()
+	for ev := range in {
+		s.Publish(ev)
+	}
+	s.Close()
+}
+
+// Publish records an event and forwards it to live subscribers, waiting
+// for a subscriber whose channel is full unless it is detachable
+func (s *EventStore) Publish(ev LogEvent) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	subs, ok := s.record(ev)
+	if !ok {
+		return
+	}
+
+	var behind []chan LogEvent
+	for _, sub := range subs {
+		if !sub.detachable {
+			sub.ch <- ev
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			behind = append(behind, sub.ch)
+		}
+	}
+	if len(behind) > 0 {
+		s.detach(behind)
+	}
+}
+
+// record retains an event and returns the subscribers to send it to, or
+// false once the store is closed
+func (s *EventStore) record(ev LogEvent) ([]subscriber, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, false
+	}
+
+	s.seq++
+	entry := storedEvent{seq: s.seq, ev: ev}
+	if ev.IsStatus() {
+		s.statuses[ev.Task] = entry
+	} else {
+		logs := append(s.logs[ev.Task], entry)
+		if len(logs) > s.perTask {
+			logs = logs[len(logs)-s.perTask:]
+		}
+		s.logs[ev.Task] = logs
+	}
+	return slices.Clone(s.subs), true
+}
+
+// detach drops the detachable subscribers that fell behind and closes their
+// channels; s.sendMu must be held
+func (s *EventStore) detach(behind []chan LogEvent) {
+	s.mu.Lock()
+	s.subs = slices.DeleteFunc(s.subs, func(sub subscriber) bool {
+		return slices.Contains(behind, sub.ch)
+	})
+	s.mu.Unlock()
+	for _, ch := range behind {
+		close(ch)
+	}
+}
+
+// Subscribe returns the retained events in publish order, and a channel that
+// receives every event published afterwards. The channel is closed when the
+// store is closed.
+func (s *EventStore) Subscribe() ([]LogEvent, <-chan LogEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.snapshot()
+	live := make(chan LogEvent, subscriberBuffer)
+	if s.closed {
+		close(live)
+	} else {
+		s.subs = append(s.subs, subscriber{ch: live})
+	}
+	return snapshot, live
+}
+
+// Snapshot returns the retained events in publish order
+func (s *EventStore) Snapshot() []LogEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// SubscribeDetachable is like Subscribe for consumers that must never slow
+// the runner down, such as remote clients. If the subscriber falls behind by
+// more than the channel buffer, its channel is closed and it should subscribe
+// again to get a fresh snapshot. Calling the returned function unsubscribes,
+// without waiting for an event being sent to a slow subscriber; the channel
+// is left open.
+func (s *EventStore) SubscribeDetachable() ([]LogEvent, <-chan LogEvent, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.snapshot()
+	live := make(chan LogEvent, subscriberBuffer)
+	if s.closed {
+		close(live)
+		return snapshot, live, func() {}
+	}
+	s.subs = append(s.subs, subscriber{ch: live, detachable: true})
+
+	unsubscribe := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.subs = slices.DeleteFunc(s.subs, func(sub subscriber) bool { return sub.ch == live })
+	}
+	return snapshot, live, unsubscribe
+}
+
+// snapshot returns the retained events in publish order; s.mu must be held
+func (s *EventStore) snapshot() []LogEvent {
+	var entries []storedEvent
+	for _, logs := range s.logs {
+		entries = append(entries, logs...)
+	}
+	for _, status := range s.statuses {
+		entries = append(entries, status)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	snapshot := make([]LogEvent, len(entries))
+	for i, entry := range entries {
+		snapshot[i] = entry.ev
+	}
+	return snapshot
+}
+
+// Close closes all subscriber channels; later events are dropped
+func (s *EventStore) Close() {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, sub := range s.subs {
+		close(sub.ch)
+	}
+	s.subs = nil
+}
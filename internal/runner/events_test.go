@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// logEvent returns the nth log line of task
+func logEvent(task string, n int) LogEvent {
+	return LogEvent{Task: task, Line: fmt.Sprintf("line %d", n)}
+}
+
+// returnsInTime fails the test unless f returns in time
+func returnsInTime(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(eventWait):
+		t.Fatalf("%s didn't return within %s", what, eventWait)
+	}
+}
+
+func TestSlowSubscriberHoldsUpOnlyThePublisher(t *testing.T) {
+	s := NewEventStore(1000)
+	_, slow := s.Subscribe()
+
+	// One event more than the buffer holds leaves Publish waiting on the
+	// slow subscriber
+	const total = subscriberBuffer + 1
+	published := make(chan struct{})
+	go func() {
+		for i := range total {
+			s.Publish(logEvent("web", i))
+		}
+		close(published)
+	}()
+	deadline := time.After(eventWait)
+	for len(slow) < subscriberBuffer {
+		select {
+		case <-deadline:
+			t.Fatalf("buffer not filled within %s", eventWait)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	select {
+	case <-published:
+		t.Fatal("Publish didn't wait for a full subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Meanwhile others can still subscribe, take snapshots and leave
+	returnsInTime(t, "Snapshot", func() { s.Snapshot() })
+	returnsInTime(t, "Subscribe", func() { s.Subscribe() })
+	returnsInTime(t, "SubscribeDetachable", func() {
+		_, _, unsubscribe := s.SubscribeDetachable()
+		unsubscribe()
+	})
+
+	// Once the subscriber reads again, it gets every event in order
+	for i := range total {
+		select {
+		case ev := <-slow:
+			if want := logEvent("web", i); ev.Line != want.Line {
+				t.Fatalf("event %d = %q, want %q", i, ev.Line, want.Line)
+			}
+		case <-time.After(eventWait):
+			t.Fatalf("event %d not received within %s", i, eventWait)
+		}
+	}
+	returnsInTime(t, "Publish", func() { <-published })
+}
+
+func TestDetachableSubscriberDroppedWhenBehind(t *testing.T) {
+	s := NewEventStore(1000)
+	_, events, unsubscribe := s.SubscribeDetachable()
+	defer unsubscribe()
+
+	returnsInTime(t, "Publish", func() {
+		for i := range subscriberBuffer + 1 {
+			s.Publish(logEvent("web", i))
+		}
+	})
+	for i := range subscriberBuffer {
+		if ev := <-events; ev.Line != logEvent("web", i).Line {
+			t.Fatalf("event %d = %q", i, ev.Line)
+		}
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("channel of a subscriber that fell behind still open")
+	}
+}
+
+func TestSubscribeMidStreamMissesNothing(t *testing.T) {
+	s := NewEventStore(100000)
+	const total = 20000
+	go func() {
+		for i := range total {
+			s.Publish(logEvent("web", i))
+		}
+		s.Close()
+	}()
+	time.Sleep(time.Millisecond)
+
+	// The snapshot and the live events together are every event, once
+	snapshot, live := s.Subscribe()
+	got := snapshot
+	returnsInTime(t, "the stream", func() {
+		for ev := range live {
+			got = append(got, ev)
+		}
+	})
+	if len(got) != total {
+		t.Fatalf("got %d events, want %d", len(got), total)
+	}
+	for i, ev := range got {
+		if want := logEvent("web", i); ev.Line != want.Line {
+			t.Fatalf("event %d = %q, want %q", i, ev.Line, want.Line)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"regexp"
+	"sync"
+)
+
+// hintTailLines is how many of a run's last lines are searched for a hint
+const hintTailLines = 20
+
+// failureHint suggests a fix for a failure whose output matches pattern.
+// The hint may refer to the pattern's groups as $1, $2, ….
+type failureHint struct {
+	pattern *regexp.Regexp
+	hint    string
+}
+
+// failureHints are tried in order against a failed run's last lines, from
+// the most recent line back; the first match gives the hint
+var failureHints = []failureHint{
+	{
+		regexp.MustCompile(`(?i)(?:EADDRINUSE|address already in use).*?:(\d+)\b`),
+		"port $1 is already in use; another process may still be running (declare port = $1 to have prun catch clashes between tasks)",
+	},
+	{
+		regexp.MustCompile(`(?i)EADDRINUSE|address already in use`),
+		"the address is already in use; another process may still be running",
+	},
+	{
+		regexp.MustCompile(`([^\s:]+): (?:command )?not found$`),
+		"$1 isn't installed or isn't on the PATH of the task",
+	},
+	{
+		regexp.MustCompile(`([^\s:]+): Permission denied$`),
+		"$1: permission denied — is it executable? (chmod +x $1)",
+	},
+	{
+		regexp.MustCompile(`([^\s:]+): No such file or directory$`),
+		"$1 doesn't exist; relative paths start from the task's path",
+	},
+	{
+		regexp.MustCompile(`\b([A-Z][A-Z0-9_]{2,})\b.*\b(?:is not set|not set|is required|must be set|is missing|is undefined)`),
+		"$1 isn't set; add it to env or env_file",
+	},
+	{
+		regexp.MustCompile(`Cannot find module '([^']+)'`),
+		"module $1 is missing; have the dependencies been installed (npm install)?",
+	},
+}
+
+// findHint returns the hint for the first line, from the last one back, that
+// a known pattern matches, or "" if none does
+func findHint(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, h := range failureHints {
+			if m := h.pattern.FindStringSubmatchIndex(lines[i]); m != nil {
+				return string(h.pattern.ExpandString(nil, h.hint, lines[i], m))
+			}
+		}
+	}
+	return ""
+}
+
+// outputTail keeps the last lines of a run, from both streams
+type outputTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *outputTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) == hintTailLines {
+		t.lines = t.lines[1:]
+	}
+	t.lines = append(t.lines, line)
+}
+
+// hint returns the hint for the lines kept so far
+func (t *outputTail) hint() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return findHint(t.lines)
+}
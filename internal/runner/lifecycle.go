@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"prun/internal/theme"
+
+	"github.com/mattn/go-isatty"
+)
+
+// ANSI colors of lifecycle lines and task prefixes
+const (
+	ansiReset   = "\x1b[0m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+	ansiGray    = "\x1b[90m"
+
+	ansiBrightBlue    = "\x1b[94m"
+	ansiBrightMagenta = "\x1b[95m"
+	ansiBrightCyan    = "\x1b[96m"
+)
+
+// colorEnabled reports whether w is a terminal that should get colors
+func colorEnabled(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd())
+}
+
+// lifecycleLine describes a status event as a glyph-prefixed line such as
+// "▶ started (pid 4242)" and its color. Statuses without a line return "".
+// The wording is meant to be grepped, so keep it stable.
+func lifecycleLine(ev LogEvent, icons theme.Icons) (string, string) {
+	switch ev.Status {
+	case StatusRunning:
+		if ev.Restarts > 0 && !ev.NextStep {
+			detail := "#" + strconv.Itoa(ev.Restarts)
+			if ev.Reason != "" {
+				detail += ", " + ev.Reason
+			}
+			return fmt.Sprintf("%s restarted (%s)", icons.Icon("restarted"), detail), ansiYellow
+		}
+		return fmt.Sprintf("%s started (pid %d)", icons.Icon("started"), ev.Pid), ansiCyan
+	case StatusReady:
+		return fmt.Sprintf("%s ready after %s", icons.Icon(StatusReady), FormatElapsed(ev.Elapsed)), ansiGreen
+	case StatusRetrying:
+		wait := time.Until(ev.Until).Round(100 * time.Millisecond)
+		line := fmt.Sprintf("%s retrying in %s", icons.Icon(StatusRetrying), FormatElapsed(wait))
+		if ev.MaxAttempts > 0 {
+			line += fmt.Sprintf(" (attempt %d/%d)", ev.Attempt, ev.MaxAttempts)
+		}
+		return line, ansiYellow
+	case StatusDone:
+		return fmt.Sprintf("%s finished in %s", icons.Icon(StatusDone), FormatElapsed(ev.Elapsed)), ansiGreen
+	case StatusFailed:
+		line := fmt.Sprintf("%s exited %d after %s", icons.Icon(StatusFailed), ev.ExitCode, FormatElapsed(ev.Elapsed))
+		if ev.ExitCode == -1 && ev.Elapsed == 0 {
+			line = icons.Icon(StatusFailed) + " failed to start"
+		}
+		if ev.Allowed {
+			return line + " (allowed)", ansiYellow
+		}
+		return line, ansiRed
+	case StatusQueued:
+		if ev.Reason != "" {
+			return fmt.Sprintf("%s %s", icons.Icon(StatusQueued), ev.Reason), ansiGray
+		}
+	case StatusStopped:
+		if ev.Auto {
+			return fmt.Sprintf("%s auto-stopped (%s)", icons.Icon(StatusStopped), ev.Reason), ansiGray
+		}
+		if ev.Reason != "" {
+			return fmt.Sprintf("%s skipped (%s)", icons.Icon(StatusStopped), ev.Reason), ansiGray
+		}
+		return fmt.Sprintf("%s stopped after %s", icons.Icon(StatusStopped), FormatElapsed(ev.Elapsed)), ansiGray
+	case StatusDraining:
+		return fmt.Sprintf("%s draining (%d left in group)", icons.Icon(StatusDraining), ev.Survivors), ansiGray
+	}
+	return "", ""
+}
+
+// FormatElapsed prints a run time to a tenth of a second, dropping a zero
+// fraction ("12.3s", "41s"), or rounded to the second from a minute up
+func FormatElapsed(d time.Duration) string {
+	if d >= time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return strconv.FormatFloat(d.Round(100*time.Millisecond).Seconds(), 'f', -1, 64) + "s"
+}
+
+// WriteStatus writes the lifecycle line of a status event under the task's
+// prefix, in color on a terminal. NDJSON output gets a record for every
+// status change.
+func (ow *outputWriter) WriteStatus(ev LogEvent) {
+	ow.spool.add(func() { ow.writeStatusNow(ev) })
+}
+
+// writeStatusNow is WriteStatus, bypassing the spool
+func (ow *outputWriter) writeStatusNow(ev LogEvent) {
+	if ow.json {
+		ow.mu.Lock()
+		defer ow.mu.Unlock()
+		ow.writeJSON(ev)
+		return
+	}
+
+	line, color := lifecycleLine(ev, ow.icons)
+	if line == "" {
+		return
+	}
+
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	if ow.color {
+		fmt.Fprintf(ow.writer, "%s%s%s%s\n", ow.lead(eventLabel(ev), ev.Time), color, line, ansiReset)
+	} else {
+		fmt.Fprintf(ow.writer, "%s%s\n", ow.lead(eventLabel(ev), ev.Time), line)
+	}
+}
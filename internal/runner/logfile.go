@@ -0,0 +1,361 @@
+package runner
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFlushInterval is how often buffered log file output is flushed to disk
+const logFlushInterval = 200 * time.Millisecond
+
+// logFileSet hands out one writer per log file path, so tasks sharing a
+// log_file all append through the same serializing goroutine
+type logFileSet struct {
+	mu      sync.Mutex
+	files   map[string]*logFile
+	verbose bool
+	output  *outputWriter // where failures are reported
+}
+
+func newLogFileSet(verbose bool, output *outputWriter) *logFileSet {
+	return &logFileSet{
+		files:   make(map[string]*logFile),
+		verbose: verbose,
+		output:  output,
+	}
+}
+
+// rotation is when a log file is rotated: once it reaches maxSize, if set,
+// keeping backups older files as <path>.1 (the newest) to <path>.<backups>
+type rotation struct {
+	maxSize int64
+	backups int
+}
+
+// Get returns the writer for path, opening the file on first use. Tasks
+// sharing the file rotate it the same way, which the config checks, so the
+// rotation of the first one applies.
+func (s *logFileSet) Get(path string, rot rotation) (*logFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.files[path]; ok {
+		return f, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return s.add(path, file, rot), nil
+}
+
+// add starts the writer of an opened log file
+func (s *logFileSet) add(path string, file *os.File, rot rotation) *logFile {
+	f := &logFile{
+		path:    path,
+		file:    file,
+		rot:     rot,
+		lines:   make(chan string, 256),
+		done:    make(chan struct{}),
+		verbose: s.verbose,
+		output:  s.output,
+	}
+	if info, err := file.Stat(); err == nil {
+		f.size = info.Size()
+	}
+	go f.loop()
+	s.files[path] = f
+	return f
+}
+
+// OpenRun creates the log file of a new run in dir, named by its start time,
+// repoints dir/current.log at it and removes the oldest run files beyond
+// keep. A run file is rotated like a log file, its rotated files going with
+// it when it is removed. The file is closed by Release when the run ends.
+func (s *logFileSet) OpenRun(dir string, keep int, rot rotation) (*logFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	// Restarts within a second get a numbered suffix
+	stamp := time.Now().Format(runLogLayout)
+	var path string
+	var file *os.File
+	var err error
+	for n := 1; ; n++ {
+		name := stamp + ".log"
+		if n > 1 {
+			name = fmt.Sprintf("%s-%d.log", stamp, n)
+		}
+		path = filepath.Join(dir, name)
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	s.report(pointCurrentRun(dir, filepath.Base(path)))
+	s.report(pruneRuns(dir, keep))
+	return s.add(path, file, rot), nil
+}
+
+// Release flushes and closes a log file opened by OpenRun
+func (s *logFileSet) Release(f *logFile) {
+	s.mu.Lock()
+	if s.files[f.path] == f {
+		delete(s.files, f.path)
+	}
+	s.mu.Unlock()
+	f.close()
+}
+
+// report prints a failure to maintain a run log directory with --verbose.
+// The run itself goes on.
+func (s *logFileSet) report(err error) {
+	if err != nil && s.verbose {
+		s.output.writeSystem(LevelWarn, err.Error())
+	}
+}
+
+// Close flushes and closes every open log file
+func (s *logFileSet) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for path, f := range s.files {
+		f.close()
+		delete(s.files, path)
+	}
+}
+
+// runLogLayout names the file of each run, e.g. 2024-06-03T14-02-13.log.
+// The time has no colons, which Windows doesn't allow in file names.
+const runLogLayout = "2006-01-02T15-04-05"
+
+// Files in a run log directory besides the runs. current.path holds the path
+// of the latest run where current.log can't be a symlink.
+const (
+	currentRunLink    = "current.log"
+	currentRunPointer = "current.path"
+)
+
+// pointCurrentRun repoints current.log at the run file name. The link is
+// made under a temporary name and renamed over the old one, so readers
+// never find it missing.
+func pointCurrentRun(dir, name string) error {
+	tmp := filepath.Join(dir, "."+currentRunLink+".tmp")
+	_ = os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err == nil {
+		if err := os.Rename(tmp, filepath.Join(dir, currentRunLink)); err != nil {
+			return fmt.Errorf("failed to update %s: %w", filepath.Join(dir, currentRunLink), err)
+		}
+		return nil
+	}
+
+	// No symlinks here, as on Windows without developer mode
+	tmp = filepath.Join(dir, "."+currentRunPointer+".tmp")
+	if err := os.WriteFile(tmp, []byte(filepath.Join(dir, name)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to update %s: %w", filepath.Join(dir, currentRunPointer), err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, currentRunPointer)); err != nil {
+		return fmt.Errorf("failed to update %s: %w", filepath.Join(dir, currentRunPointer), err)
+	}
+	return nil
+}
+
+// pruneRuns removes the oldest run files in dir beyond keep
+func pruneRuns(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to prune %s: %w", dir, err)
+	}
+
+	var runs []string
+	for _, e := range entries {
+		if _, ok := runOrder(e.Name()); ok && e.Type().IsRegular() {
+			runs = append(runs, e.Name())
+		}
+	}
+	slices.SortFunc(runs, func(a, b string) int {
+		ka, _ := runOrder(a)
+		kb, _ := runOrder(b)
+		return cmp.Or(strings.Compare(ka.stamp, kb.stamp), cmp.Compare(ka.n, kb.n))
+	})
+
+	for len(runs) > keep {
+		if err := os.Remove(filepath.Join(dir, runs[0])); err != nil {
+			return fmt.Errorf("failed to prune %s: %w", dir, err)
+		}
+		for _, e := range entries {
+			if rotatedFrom(e.Name()) == runs[0] {
+				_ = os.Remove(filepath.Join(dir, e.Name()))
+			}
+		}
+		runs = runs[1:]
+	}
+	return nil
+}
+
+// rotatedFrom returns the name of the file that a rotated file name, such
+// as web.log.2, was rotated from, or "" if it isn't one
+func rotatedFrom(name string) string {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return ""
+	}
+	if n, err := strconv.Atoi(name[i+1:]); err != nil || n < 1 {
+		return ""
+	}
+	return name[:i]
+}
+
+// runKey orders run files: by start time, then by the suffix of restarts
+// within the same second
+type runKey struct {
+	stamp string
+	n     int
+}
+
+// runOrder parses a run file name, reporting false for other files
+func runOrder(name string) (runKey, bool) {
+	base, ok := strings.CutSuffix(name, ".log")
+	if !ok || len(base) < len(runLogLayout) {
+		return runKey{}, false
+	}
+	key := runKey{stamp: base[:len(runLogLayout)], n: 1}
+	if _, err := time.Parse(runLogLayout, key.stamp); err != nil {
+		return runKey{}, false
+	}
+	if rest := base[len(runLogLayout):]; rest != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(rest, "-"))
+		if err != nil || rest[0] != '-' {
+			return runKey{}, false
+		}
+		key.n = n
+	}
+	return key, true
+}
+
+// logFile serializes appends to a single file. Each line is queued whole and
+// written by one goroutine, so lines from different tasks never interleave,
+// and rotating the file between two lines needs no coordination between the
+// tasks sharing it.
+type logFile struct {
+	path    string
+	file    *os.File
+	rot     rotation
+	size    int64 // bytes in the file, for rotation
+	lines   chan string
+	done    chan struct{}
+	verbose bool
+	output  *outputWriter
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// WriteLine queues a complete line, prefixed with the task name when the file is shared
+func (f *logFile) WriteLine(taskName, line string, prefixed bool) {
+	if prefixed {
+		line = "[" + taskName + "] " + line
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return
+	}
+	f.lines <- line + "\n"
+}
+
+func (f *logFile) loop() {
+	defer RecoverPanic()
+	defer close(f.done)
+
+	w := bufio.NewWriter(f.file)
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	reported := false
+	report := func(err error) {
+		if err != nil && f.verbose && !reported {
+			f.output.writeSystem(LevelWarn, fmt.Sprintf("failed to write log file %s: %v", f.path, err))
+			reported = true
+		}
+	}
+
+	for {
+		select {
+		case line, ok := <-f.lines:
+			if !ok {
+				report(w.Flush())
+				report(f.file.Close())
+				return
+			}
+			if f.rot.maxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.rot.maxSize {
+				report(w.Flush())
+				report(f.rotate())
+				w.Reset(f.file)
+			}
+			n, err := w.WriteString(line)
+			f.size += int64(n)
+			report(err)
+		case <-ticker.C:
+			report(w.Flush())
+		}
+	}
+}
+
+// rotate closes the file, shifts path.1 … to path.2 …, dropping the oldest
+// beyond the backups kept, renames the file to path.1 and starts a new one
+func (f *logFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", f.path, f.rot.backups))
+	for n := f.rot.backups - 1; n >= 1; n-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", f.path, n), fmt.Sprintf("%s.%d", f.path, n+1))
+	}
+	if f.rot.backups > 0 {
+		_ = os.Rename(f.path, f.path+".1")
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		// Keep writing to nowhere rather than stop every task sharing it
+		file, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		f.file, f.size = file, 0
+		return fmt.Errorf("failed to rotate: %w", err)
+	}
+	f.file, f.size = file, 0
+	return nil
+}
+
+func (f *logFile) close() {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.lines)
+	}
+	f.mu.Unlock()
+	<-f.done
+}
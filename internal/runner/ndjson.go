@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jsonSchemaVersion is the "v" field of every NDJSON record. Bump it when a
+// field changes meaning or goes away; adding fields doesn't need a bump.
+const jsonSchemaVersion = 1
+
+// jsonRecord is one line of NDJSON console output, either a line of task
+// output (stream and line set) or a status change (status set)
+type jsonRecord struct {
+	V         int     `json:"v"`
+	Time      string  `json:"time"`
+	Task      string  `json:"task"`
+	Stream    string  `json:"stream,omitempty"`
+	Line      *string `json:"line,omitempty"`
+	Level     string  `json:"level,omitempty"` // of a structured log line, or of prun's own diagnostics
+	Status    string  `json:"status,omitempty"`
+	Pid       int     `json:"pid,omitempty"`
+	Run       int     `json:"run,omitempty"` // 1 for the first run, counting up with watch-mode restarts
+	ExitCode  *int    `json:"exit_code,omitempty"`
+	Allowed   bool    `json:"allowed,omitempty"`      // a failure of a task with allow_failure
+	Auto      bool    `json:"auto_stopped,omitempty"` // stopped by auto_stop_after or auto_stop_when_idle
+	Reason    string  `json:"reason,omitempty"`
+	Survivors int     `json:"survivors,omitempty"`
+	ElapsedMs int64   `json:"elapsed_ms,omitempty"`
+	LogFile   string  `json:"log_file,omitempty"` // the run's own file with log_file_per_run
+	Step      int     `json:"step,omitempty"`     // 1-based step of a task with steps
+	Steps     int     `json:"steps,omitempty"`
+	Via       string  `json:"via,omitempty"` // the task whose {task:name} reference ran this one
+}
+
+// newJSONRecord converts an event to its NDJSON record
+func newJSONRecord(ev LogEvent) jsonRecord {
+	t := ev.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	rec := jsonRecord{
+		V:     jsonSchemaVersion,
+		Time:  t.Format(time.RFC3339Nano),
+		Task:  ev.Task,
+		Pid:   ev.Pid,
+		Step:  ev.Step,
+		Steps: ev.Steps,
+	}
+	if ev.Pid != 0 {
+		rec.Run = ev.Restarts + 1
+	}
+	if ev.Ref != "" {
+		rec.Task, rec.Via = ev.Ref, ev.Task
+	}
+
+	if !ev.IsStatus() {
+		rec.Stream = "stdout"
+		if ev.IsErr {
+			rec.Stream = "stderr"
+		}
+		line := ev.Line
+		rec.Line = &line
+		rec.Level = ev.Level
+		return rec
+	}
+
+	rec.Status = ev.Status
+	rec.Reason = ev.Reason
+	rec.Survivors = ev.Survivors
+	rec.ElapsedMs = ev.Elapsed.Milliseconds()
+	rec.LogFile = ev.LogFile
+	rec.Allowed = ev.Allowed
+	rec.Auto = ev.Auto
+	if ev.Status == StatusDone || ev.Status == StatusFailed {
+		code := ev.ExitCode
+		rec.ExitCode = &code
+	}
+	return rec
+}
+
+// writeJSON writes an event as one NDJSON line; the caller holds ow.mu
+func (ow *outputWriter) writeJSON(ev LogEvent) {
+	data, err := json.Marshal(newJSONRecord(ev))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(ow.writer, "%s\n", data)
+}
+
+// WriteLine writes a line of task output, as a prefixed line or an NDJSON
+// record carrying the pid and run of the process that printed it. It only
+// queues the line, so a terminal that stops taking output never holds up
+// the task.
+func (ow *outputWriter) WriteLine(ev LogEvent) {
+	ow.spool.addLine(ev)
+}
+
+// writeLine is WriteLine, bypassing the spool
+func (ow *outputWriter) writeLine(ev LogEvent) {
+	if !ow.json {
+		ow.writeTextNow(eventLabel(ev), ev.Line+"\n", ev.IsErr, ev.Time)
+		return
+	}
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.writeJSON(ev)
+}
+
+// jsonText turns text given to WritePrefix into an event for writeJSON
+func jsonText(task, text string, isErr bool) LogEvent {
+	return LogEvent{Task: task, Line: strings.TrimSuffix(text, "\n"), IsErr: isErr, Time: time.Now()}
+}
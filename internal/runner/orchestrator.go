@@ -0,0 +1,242 @@
+package runner
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"prun/internal/config"
+)
+
+// OrchestratorOptions are how an Orchestrator runs its tasks
+type OrchestratorOptions struct {
+	Watch       bool // restart every task on changes, not only watch = true ones (-w)
+	NoWatch     bool // never watch, as in the prun of a {task:name} step
+	TraceEvents bool // report every file system event (--watch-events)
+	WatchBlock  bool // register watched directories before starting tasks (--watch-block)
+	AutoConfirm bool // restart without asking however many tasks a change affects
+	Interactive bool // restarts over [watch] confirm_above wait for ResolveRestart
+	Stdin       bool // give tasks a stdin pipe for SendInput instead of /dev/null
+
+	// ContinueOnError lets tasks run once carry on when one fails, instead
+	// of stopping them all; watched tasks never stop the others
+	ContinueOnError bool
+
+	Verbose     bool
+	Quiet       bool     // leave lifecycle lines out of console output
+	GroupOutput bool     // print each task's console output as one block when it exits
+	Console     *Console // console output; a default one if nil
+
+	// KillTimeout, if set, replaces every task's shutdown_timeout as how
+	// long a stopped task has after SIGTERM before it is killed
+	KillTimeout time.Duration
+
+	// Events sends everything to an EventStore, for the TUI or the web UI,
+	// instead of the console
+	Events bool
+}
+
+// eventBuffer is the size of the channel feeding the event store, and
+// storePerTask how many events of each task the store keeps
+const (
+	eventBuffer  = 100
+	storePerTask = 500
+)
+
+// Orchestrator runs a set of tasks, under a Watcher if any of them is
+// watched and under a Runner otherwise, so callers don't have to care which
+type Orchestrator struct {
+	runner  *Runner
+	watcher *Watcher
+	events  chan LogEvent
+	store   *EventStore
+	done    chan struct{}
+	err     error
+}
+
+// NewOrchestrator prepares tasks of cfg to be run with Start
+func NewOrchestrator(cfg *config.Config, tasks []string, opts OrchestratorOptions) (*Orchestrator, error) {
+	o := &Orchestrator{done: make(chan struct{})}
+	if opts.Events {
+		o.events = make(chan LogEvent, eventBuffer)
+		o.store = NewEventStore(storePerTask)
+		go o.store.Consume(o.events)
+	}
+
+	if NeedsWatcher(cfg, tasks, opts.Watch) && !opts.NoWatch {
+		w, err := NewWatcher(cfg, tasks, opts.Verbose, opts.Watch)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Console != nil {
+			w.SetConsole(opts.Console)
+		}
+		if o.events != nil {
+			w.SetEventChannel(o.events)
+		}
+		w.SetGroupOutput(opts.GroupOutput)
+		w.SetQuiet(opts.Quiet)
+		w.SetKillTimeout(opts.KillTimeout)
+		w.SetTraceEvents(opts.TraceEvents)
+		w.SetWatchBlock(opts.WatchBlock)
+		w.SetInteractive(opts.Interactive)
+		w.SetAutoConfirm(opts.AutoConfirm)
+		w.SetStdin(opts.Stdin)
+		o.watcher = w
+		return o, nil
+	}
+
+	r := New(cfg, tasks, opts.Verbose)
+	if opts.Console != nil {
+		r.SetConsole(opts.Console)
+	}
+	if o.events != nil {
+		r.SetEventChannel(o.events)
+	}
+	r.SetGroupOutput(opts.GroupOutput)
+	r.SetQuiet(opts.Quiet)
+	r.SetKillTimeout(opts.KillTimeout)
+	r.SetStdin(opts.Stdin)
+	r.SetContinueOnError(opts.ContinueOnError)
+	o.runner = r
+	return o, nil
+}
+
+// NeedsWatcher reports whether running tasks needs a watcher: one of them
+// is watched, every task is (-w), or the config file itself is
+func NeedsWatcher(cfg *config.Config, tasks []string, watchAll bool) bool {
+	if watchAll || cfg.WatchConfig {
+		return true
+	}
+	for _, taskName := range tasks {
+		if cfg.TaskDefs[taskName].Watch {
+			return true
+		}
+	}
+	return false
+}
+
+// Watching reports whether the tasks run under a watcher
+func (o *Orchestrator) Watching() bool {
+	return o.watcher != nil
+}
+
+// Describe returns the resolved settings of a task under the config in
+// effect, which a reload in watch mode may have replaced
+func (o *Orchestrator) Describe(taskName string) []config.Detail {
+	if o.watcher != nil {
+		return o.watcher.config().Describe(taskName)
+	}
+	return o.runner.cfg.Describe(taskName)
+}
+
+// EventSource returns the store events go to with Options.Events, or nil
+func (o *Orchestrator) EventSource() *EventStore {
+	return o.store
+}
+
+// Start runs the tasks in the background until they finish or ctx is
+// cancelled. The event source sees its channel closed once they're done.
+func (o *Orchestrator) Start(ctx context.Context) {
+	go func() {
+		defer RecoverPanic()
+		if o.watcher != nil {
+			o.err = o.watcher.Start(ctx)
+		} else {
+			o.err = o.runner.Run(ctx)
+		}
+		if o.events != nil {
+			close(o.events)
+		}
+		close(o.done)
+	}()
+}
+
+// Done is closed when the tasks have finished
+func (o *Orchestrator) Done() <-chan struct{} {
+	return o.done
+}
+
+// Wait waits for the tasks to finish and returns the first error
+func (o *Orchestrator) Wait() error {
+	<-o.done
+	return o.err
+}
+
+// Results returns how each task ended once they are done, or nil under a
+// watcher, whose tasks have no single ending
+func (o *Orchestrator) Results() []TaskResult {
+	if o.runner == nil {
+		return nil
+	}
+	return o.runner.Results()
+}
+
+// Signal forwards sig to the process groups of all running tasks
+func (o *Orchestrator) Signal(sig syscall.Signal) {
+	if o.watcher != nil {
+		o.watcher.Signal(sig)
+	} else {
+		o.runner.Signal(sig)
+	}
+}
+
+// ResolveRestart settles a restart waiting for confirmation; see
+// Watcher.ResolveRestart. Without a watcher there is nothing to settle.
+func (o *Orchestrator) ResolveRestart(id int, tasks []string) bool {
+	if o.watcher == nil {
+		return false
+	}
+	return o.watcher.ResolveRestart(id, tasks)
+}
+
+// RestartTask restarts a task, or starts it if it isn't running; see
+// Watcher.RestartTask. Without a watcher, tasks run once and can't be
+// controlled.
+func (o *Orchestrator) RestartTask(taskName string) error {
+	if o.watcher == nil {
+		return errNoControl
+	}
+	return o.watcher.RestartTask(taskName)
+}
+
+// StopTask stops a task until it is started again; see Watcher.StopTask
+func (o *Orchestrator) StopTask(taskName string) error {
+	if o.watcher == nil {
+		return errNoControl
+	}
+	return o.watcher.StopTask(taskName)
+}
+
+// StartTask starts a task that was stopped or has exited
+func (o *Orchestrator) StartTask(taskName string) error {
+	if o.watcher == nil {
+		return errNoControl
+	}
+	return o.watcher.StartTask(taskName)
+}
+
+// SendInput writes input to the stdin of a task's running process, which
+// it has with OrchestratorOptions.Stdin
+func (o *Orchestrator) SendInput(taskName string, input []byte) error {
+	if o.watcher != nil {
+		return o.watcher.SendInput(taskName, input)
+	}
+	return o.runner.SendInput(taskName, input)
+}
+
+// CloseInput closes the stdin of a task's running process
+func (o *Orchestrator) CloseInput(taskName string) error {
+	if o.watcher != nil {
+		return o.watcher.CloseInput(taskName)
+	}
+	return o.runner.CloseInput(taskName)
+}
+
+// Shutdown releases what the tasks leave behind, flushing their log files.
+// The tasks themselves stop when the context given to Start is cancelled.
+func (o *Orchestrator) Shutdown() {
+	if o.watcher != nil {
+		o.watcher.Close()
+	}
+}
@@ -0,0 +1,21 @@
+package runner
+
+import "runtime/debug"
+
+// PanicHandler, if set, is given a panic of any goroutine prun starts,
+// with its stack, and is expected to exit. main uses it to give the
+// terminal back before reporting the panic.
+var PanicHandler func(v any, stack []byte)
+
+// RecoverPanic passes a panic of the calling goroutine to PanicHandler, or
+// panics again without one. It must be deferred by the goroutine itself.
+func RecoverPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if PanicHandler != nil {
+		PanicHandler(v, debug.Stack())
+	}
+	panic(v)
+}
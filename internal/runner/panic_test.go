@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanicPassesPanicToHandler(t *testing.T) {
+	type report struct {
+		v     any
+		stack string
+	}
+	reports := make(chan report, 1)
+	old := PanicHandler
+	PanicHandler = func(v any, stack []byte) {
+		reports <- report{v, string(stack)}
+		runtime.Goexit() // stands in for exiting
+	}
+	t.Cleanup(func() { PanicHandler = old })
+
+	go func() {
+		defer RecoverPanic()
+		panickingTask()
+	}()
+	r := <-reports
+	if r.v != "boom" {
+		t.Errorf("handler got %v, want boom", r.v)
+	}
+	if !strings.Contains(r.stack, "panickingTask") {
+		t.Errorf("stack doesn't show where the panic happened:\n%s", r.stack)
+	}
+}
+
+func TestRecoverPanicWithoutHandlerPanicsAgain(t *testing.T) {
+	old := PanicHandler
+	PanicHandler = nil
+	t.Cleanup(func() { PanicHandler = old })
+
+	recovered := make(chan any, 1)
+	go func() {
+		defer func() { recovered <- recover() }()
+		defer RecoverPanic()
+		panickingTask()
+	}()
+	if v := <-recovered; v != "boom" {
+		t.Errorf("panicked again with %v, want boom", v)
+	}
+}
+
+func TestRecoverPanicWithoutPanic(t *testing.T) {
+	old := PanicHandler
+	PanicHandler = func(v any, stack []byte) { t.Errorf("handler called with %v", v) }
+	t.Cleanup(func() { PanicHandler = old })
+
+	func() {
+		defer RecoverPanic()
+	}()
+}
+
+// panickingTask panics, under a name to find in the stack
+func panickingTask() {
+	panic("boom")
+}
@@ -0,0 +1,16 @@
+//go:build pruntest
+
+package runner
+
+import "os"
+
+// panicTaskEnv names a task whose runs panic instead of starting, in builds
+// for the integration tests of how prun handles a panic of its own
+const panicTaskEnv = "PRUN_TEST_PANIC"
+
+// panicHook panics if taskName is the task panicTaskEnv names
+func panicHook(taskName string) {
+	if os.Getenv(panicTaskEnv) == taskName {
+		panic("panic requested by " + panicTaskEnv)
+	}
+}
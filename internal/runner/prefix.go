@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Prefixes are the labels tasks are shown under: the [task] prefix of
+// console output, the TUI task list and its correlation view. Names wider
+// than the width are shortened in the middle ("backend-…-server"), with a
+// numeric suffix where two of them would come out the same. The width is
+// that of the longest label, so short names aren't padded further than
+// needed. Each task also gets a color, by its position, shared with its
+// steps.
+type Prefixes struct {
+	width    int
+	maxWidth int
+	labels   map[string]string
+	colors   map[string]int // index into prefixPalette
+}
+
+// prefixPalette colors [task] prefixes on a terminal, cycling through the
+// tasks. Red is left out, as it marks failures.
+var prefixPalette = []string{
+	ansiCyan, ansiMagenta, ansiBlue, ansiGreen, ansiYellow,
+	ansiBrightCyan, ansiBrightMagenta, ansiBrightBlue,
+}
+
+// NewPrefixes computes the labels of tasks, at most maxWidth columns wide
+func NewPrefixes(tasks []string, maxWidth int) *Prefixes {
+	p := &Prefixes{
+		maxWidth: maxWidth,
+		labels:   make(map[string]string, len(tasks)),
+		colors:   make(map[string]int, len(tasks)),
+	}
+	used := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if _, ok := p.labels[task]; ok {
+			continue
+		}
+		if base, ok := p.stepOf(task); ok {
+			p.colors[task] = p.colors[base]
+		} else {
+			p.colors[task] = len(p.colors) % len(prefixPalette)
+		}
+		label := shorten(task, maxWidth)
+		for n := 2; used[label]; n++ {
+			suffix := "-" + strconv.Itoa(n)
+			label = shorten(task, maxWidth-len(suffix)) + suffix
+		}
+		used[label] = true
+		p.labels[task] = label
+		p.width = max(p.width, utf8.RuneCountInString(label))
+	}
+	return p
+}
+
+// Label returns the label of a task. Tasks that appeared after the labels
+// were computed, such as ones added by a config reload, are shortened
+// without a uniqueness check.
+func (p *Prefixes) Label(task string) string {
+	if p == nil {
+		return task
+	}
+	if label, ok := p.labels[task]; ok {
+		return label
+	}
+	return shorten(task, p.maxWidth)
+}
+
+// Padded returns the [label] prefix of a task, padded so the text after it
+// lines up
+func (p *Prefixes) Padded(task string) string {
+	label := p.Label(task)
+	if p == nil {
+		return "[" + label + "]"
+	}
+	pad := max(p.width-utf8.RuneCountInString(label), 0)
+	return "[" + label + "]" + strings.Repeat(" ", pad)
+}
+
+// stepOf returns the task a step label such as "web:2/3" belongs to, if
+// that task has a label
+func (p *Prefixes) stepOf(label string) (string, bool) {
+	i := strings.LastIndexByte(label, ':')
+	if i < 0 {
+		return "", false
+	}
+	base := label[:i]
+	if _, ok := p.labels[base]; !ok {
+		return "", false
+	}
+	step, steps, ok := strings.Cut(label[i+1:], "/")
+	if _, err := strconv.Atoi(step); err != nil || !ok {
+		return "", false
+	}
+	if _, err := strconv.Atoi(steps); err != nil {
+		return "", false
+	}
+	return base, true
+}
+
+// Colored returns the prefix of Padded with the task's color around the
+// brackets, or Padded as it is for a task without a color
+func (p *Prefixes) Colored(task string) string {
+	padded := p.Padded(task)
+	if p == nil {
+		return padded
+	}
+	i, ok := p.colors[task]
+	if !ok {
+		return padded
+	}
+	end := strings.LastIndexByte(padded, ']') + 1
+	return prefixPalette[i] + padded[:end] + ansiReset + padded[end:]
+}
+
+// shorten cuts the middle of name out if it is wider than width, keeping
+// one more rune of the start than of the end
+func shorten(name string, width int) string {
+	runes := []rune(name)
+	if len(runes) <= width {
+		return name
+	}
+	keep := max(width-1, 2)
+	head := (keep + 1) / 2
+	return string(runes[:head]) + "…" + string(runes[len(runes)-(keep-head):])
+}
@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// processGroups abstracts the platform-specific handling of task process
+// groups. Each task runs as the leader of its own group so that signals reach
+// every process it spawns, not just the shell.
+type processGroups interface {
+	// Prepare configures cmd to start in a new process group
+	Prepare(cmd *exec.Cmd)
+	// Signal sends sig to every process in the group led by pgid
+	Signal(pgid int, sig syscall.Signal) error
+	// Count returns how many processes remain in the group. Platforms that
+	// cannot enumerate group members report 1 while the one they can see,
+	// any member or only the leader, is alive.
+	Count(pgid int) (int, error)
+}
+
+// procs is the process group implementation for the current platform
+var procs processGroups = osProcessGroups{}
+
+// startMu serializes process starts. The umask is process-wide, so while one
+// task's umask is applied no other task may start and inherit it.
+var startMu sync.Mutex
+
+// startProcess starts cmd with the given umask, or prun's own if umask is -1
+func startProcess(cmd *exec.Cmd, umask int) error {
+	startMu.Lock()
+	defer startMu.Unlock()
+
+	if umask < 0 {
+		return cmd.Start()
+	}
+	restore, err := setUmask(umask)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return cmd.Start()
+}
+
+// activeGroups tracks the process groups of running tasks, so that signals
+// received by prun can be forwarded to them
+type activeGroups struct {
+	mu    sync.Mutex
+	pgids map[int]bool
+}
+
+func newActiveGroups() *activeGroups {
+	return &activeGroups{pgids: make(map[int]bool)}
+}
+
+func (a *activeGroups) add(pgid int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pgids[pgid] = true
+}
+
+func (a *activeGroups) remove(pgid int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pgids, pgid)
+}
+
+// signal sends sig to every tracked group
+func (a *activeGroups) signal(sig syscall.Signal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for pgid := range a.pgids {
+		_ = procs.Signal(pgid, sig)
+	}
+}
+
+// defaultShutdownTimeout is how long a stopped task's group has to exit
+// after SIGTERM before it is killed, unless shutdown_timeout or
+// --kill-timeout say otherwise
+const defaultShutdownTimeout = 5 * time.Second
+
+// groupStop stops a task's process group: SIGTERM first, then SIGKILL for
+// whatever is still running once the grace period is over
+type groupStop struct {
+	grace time.Duration
+
+	mu     sync.Mutex
+	pgid   int
+	killAt *time.Timer
+	forced bool // SIGKILL was needed
+}
+
+// stop sends SIGTERM to the group and arms the SIGKILL; calls after the
+// first do nothing
+func (s *groupStop) stop(pgid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.killAt != nil {
+		return
+	}
+	s.pgid = pgid
+	_ = procs.Signal(pgid, syscall.SIGTERM)
+	s.killAt = time.AfterFunc(s.grace, func() {
+		if n, err := procs.Count(pgid); err == nil && n > 0 {
+			s.mu.Lock()
+			s.forced = true
+			s.mu.Unlock()
+			_ = procs.Signal(pgid, syscall.SIGKILL)
+		}
+	})
+}
+
+// wait waits for the group of a stopped task to be gone, which the SIGKILL
+// ensures after the grace period
+func (s *groupStop) wait() {
+	deadline := time.Now().Add(s.grace + time.Second)
+	for time.Now().Before(deadline) {
+		if n, err := procs.Count(s.pgid); err != nil || n == 0 {
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.killAt != nil {
+		s.killAt.Stop()
+	}
+}
+
+// wasForced reports whether the group had to be killed
+func (s *groupStop) wasForced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.forced
+}
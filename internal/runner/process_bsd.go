@@ -0,0 +1,15 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package runner
+
+// countGroup reports whether the group is alive. The BSDs expose process
+// tables through sysctl/kvm rather than /proc (which is absent or optional),
+// so a null-signal probe is the portable choice here.
+func countGroup(pgid int) (int, error) {
+	return probeGroup(pgid)
+}
+
+// groupUsage is not sampled on the BSDs, for the same reason
+func groupUsage(pgid int) (GroupUsage, bool) {
+	return GroupUsage{}, false
+}
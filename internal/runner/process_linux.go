@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// countGroup counts processes in the group by scanning /proc, falling back to
+// a liveness probe when /proc is unavailable (e.g. restricted containers)
+func countGroup(pgid int) (int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return probeGroup(pgid)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue // process exited while scanning
+		}
+		if statPgrp(string(data)) == pgid {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// groupUsage counts the processes in the group and their open file
+// descriptors. Descriptors of processes owned by other users can't be listed
+// and count as none.
+func groupUsage(pgid int) (GroupUsage, bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return GroupUsage{}, false
+	}
+
+	var u GroupUsage
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil || statPgrp(string(data)) != pgid {
+			continue
+		}
+		u.Processes++
+		if fds, err := os.ReadDir("/proc/" + entry.Name() + "/fd"); err == nil {
+			u.FDs += len(fds)
+		}
+	}
+	return u, u.Processes > 0
+}
+
+// statPgrp extracts the process group from a /proc/<pid>/stat line. The
+// command name is parenthesized and may contain spaces, so fields are counted
+// from the last closing parenthesis: state, ppid, pgrp. Zombies have exited
+// and only await reaping, so they report -1 rather than their group.
+func statPgrp(stat string) int {
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return -1
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 3 || fields[0] == "Z" {
+		return -1
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return -1
+	}
+	return pgrp
+}
@@ -0,0 +1,21 @@
+package runner
+
+import "testing"
+
+func TestStatPgrp(t *testing.T) {
+	for _, tt := range []struct {
+		stat string
+		want int
+	}{
+		{"1234 (node) S 1 1234 1234 0 -1", 1234},
+		{"1235 (my server) R 1234 1234 1234 0 -1", 1234},
+		{"1236 (a) b) (c) S 1234 5678 5678 0 -1", 5678},
+		{"1237 (node) Z 1234 1234 1234 0 -1", -1},
+		{"1238 (node) S 1", -1},
+		{"garbage", -1},
+	} {
+		if got := statPgrp(tt.stat); got != tt.want {
+			t.Errorf("statPgrp(%q) = %d, want %d", tt.stat, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package runner
+
+// countGroup reports whether the group is alive. illumos has /proc, but its
+// psinfo files are binary structures; a null-signal probe avoids depending on
+// their layout.
+func countGroup(pgid int) (int, error) {
+	return probeGroup(pgid)
+}
+
+// groupUsage is not sampled on illumos, for the same reason
+func groupUsage(pgid int) (GroupUsage, bool) {
+	return GroupUsage{}, false
+}
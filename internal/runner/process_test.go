@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeGroups is a processGroups over made-up process groups. Count answers
+// from counts while it has more than one left, then repeats the last; a
+// SIGKILL, or a SIGTERM if termExits, empties the group.
+type fakeGroups struct {
+	mu        sync.Mutex
+	counts    []int
+	termExits bool
+	signals   []syscall.Signal
+}
+
+// useFakeGroups makes procs the given fake for the rest of the test
+func useFakeGroups(t *testing.T, f *fakeGroups) *fakeGroups {
+	t.Helper()
+	old := procs
+	procs = f
+	t.Cleanup(func() { procs = old })
+	return f
+}
+
+func (f *fakeGroups) Prepare(cmd *exec.Cmd) {}
+
+func (f *fakeGroups) Signal(pgid int, sig syscall.Signal) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signals = append(f.signals, sig)
+	if sig == syscall.SIGKILL || (sig == syscall.SIGTERM && f.termExits) {
+		f.counts = []int{0}
+	}
+	return nil
+}
+
+func (f *fakeGroups) Count(pgid int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.counts[0]
+	if len(f.counts) > 1 {
+		f.counts = f.counts[1:]
+	}
+	return n, nil
+}
+
+// sent returns the signals sent so far
+func (f *fakeGroups) sent() []syscall.Signal {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.signals)
+}
+
+func TestGroupStopExitsOnTerm(t *testing.T) {
+	f := useFakeGroups(t, &fakeGroups{counts: []int{3}, termExits: true})
+	s := &groupStop{grace: 50 * time.Millisecond}
+	s.stop(7)
+	s.stop(7) // a second stop sends nothing
+	s.wait()
+	time.Sleep(100 * time.Millisecond) // past the grace period
+
+	if got := f.sent(); !slices.Equal(got, []syscall.Signal{syscall.SIGTERM}) {
+		t.Errorf("signals = %v, want only SIGTERM", got)
+	}
+	if s.wasForced() {
+		t.Error("group that exited on SIGTERM reported as forced")
+	}
+}
+
+func TestGroupStopKillsSurvivors(t *testing.T) {
+	f := useFakeGroups(t, &fakeGroups{counts: []int{2}})
+	s := &groupStop{grace: 50 * time.Millisecond}
+	s.stop(7)
+	s.wait()
+
+	if got := f.sent(); !slices.Equal(got, []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}) {
+		t.Errorf("signals = %v, want SIGTERM then SIGKILL", got)
+	}
+	if !s.wasForced() {
+		t.Error("group that ignored SIGTERM not reported as forced")
+	}
+}
+
+// drainRunner returns a runner for drain tests and its event channel
+func drainRunner(t *testing.T) (*Runner, chan LogEvent) {
+	t.Helper()
+	cfg := loadConfig(t, `
+tasks = ["web"]
+
+[task.web]
+cmd = "true"
+`)
+	r := New(cfg, cfg.Tasks, false)
+	events := make(chan LogEvent, 100)
+	r.SetEventChannel(events)
+	return r, events
+}
+
+// drained returns the events sent during a drain
+func drained(events chan LogEvent) []LogEvent {
+	close(events)
+	var got []LogEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	return got
+}
+
+func TestDrainReportsSurvivors(t *testing.T) {
+	f := useFakeGroups(t, &fakeGroups{counts: []int{3, 3, 2, 0}})
+	r, events := drainRunner(t)
+	r.drain(context.Background(), "web", 7, 0, time.Minute, &groupStop{grace: time.Second})
+
+	var survivors []int
+	for _, ev := range drained(events) {
+		if ev.Status != StatusDraining {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+		survivors = append(survivors, ev.Survivors)
+	}
+	if !slices.Equal(survivors, []int{3, 2}) {
+		t.Errorf("draining with %v survivors, want [3 2]", survivors)
+	}
+	if got := f.sent(); len(got) != 0 {
+		t.Errorf("signals = %v, want none for a group that exits by itself", got)
+	}
+}
+
+func TestDrainKillsAfterTimeout(t *testing.T) {
+	f := useFakeGroups(t, &fakeGroups{counts: []int{2}})
+	r, events := drainRunner(t)
+	r.drain(context.Background(), "web", 7, 0, 150*time.Millisecond, &groupStop{grace: time.Second})
+
+	if got := f.sent(); !slices.Equal(got, []syscall.Signal{syscall.SIGKILL}) {
+		t.Errorf("signals = %v, want SIGKILL", got)
+	}
+	got := drained(events)
+	if last := got[len(got)-1]; !strings.Contains(last.Line, "killing 2 process(es)") {
+		t.Errorf("last event = %+v, want a notice of the kill", last)
+	}
+}
+
+func TestDrainStopsGroupOnCancel(t *testing.T) {
+	f := useFakeGroups(t, &fakeGroups{counts: []int{2}, termExits: true})
+	r, events := drainRunner(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.drain(ctx, "web", 7, 0, time.Minute, &groupStop{grace: time.Second})
+
+	if got := f.sent(); !slices.Equal(got, []syscall.Signal{syscall.SIGTERM}) {
+		t.Errorf("signals = %v, want SIGTERM", got)
+	}
+	if got := drained(events); len(got) != 0 {
+		t.Errorf("events = %+v, want none when cancelled", got)
+	}
+}
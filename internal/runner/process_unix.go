@@ -0,0 +1,50 @@
+//go:build unix
+
+package runner
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// osProcessGroups implements processGroups with POSIX process groups
+type osProcessGroups struct{}
+
+func (osProcessGroups) Prepare(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func (osProcessGroups) Signal(pgid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pgid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil // group already gone
+	}
+	return err
+}
+
+func (osProcessGroups) Count(pgid int) (int, error) {
+	return countGroup(pgid)
+}
+
+// setUmask applies mask to prun and returns a function restoring the old one
+func setUmask(mask int) (func(), error) {
+	old := syscall.Umask(mask)
+	return func() { syscall.Umask(old) }, nil
+}
+
+// probeGroup reports whether any process in the group is alive, using the
+// null signal. It works on every POSIX system but cannot count members.
+func probeGroup(pgid int) (int, error) {
+	err := syscall.Kill(-pgid, 0)
+	switch {
+	case err == nil, errors.Is(err, syscall.EPERM):
+		return 1, nil
+	case errors.Is(err, syscall.ESRCH):
+		return 0, nil
+	}
+	return 0, err
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// osProcessGroups implements processGroups on Windows, which has no POSIX
+// process groups or signals. Tasks start in a new console process group and
+// any signal terminates the group leader.
+type osProcessGroups struct{}
+
+func (osProcessGroups) Prepare(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+func (osProcessGroups) Signal(pgid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pgid)
+	if err != nil {
+		return nil // already gone
+	}
+	return p.Kill()
+}
+
+// stillActive is the exit code Windows reports for a running process
+const stillActive = 259
+
+// Count is best-effort on Windows, which can't enumerate the members of a
+// console process group: it reports 1 while the group leader is running and
+// 0 once it exited, whatever its children still do.
+func (osProcessGroups) Count(pgid int) (int, error) {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pgid))
+	if err != nil {
+		return 0, nil // already gone
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return 0, err
+	}
+	if code == stillActive {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// groupUsage is not sampled on Windows, which has no process groups to
+// enumerate
+func groupUsage(pgid int) (GroupUsage, bool) {
+	return GroupUsage{}, false
+}
+
+// setUmask fails on Windows, which has no umask
+func setUmask(mask int) (func(), error) {
+	return nil, errors.New("umask is not supported on Windows")
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"prun/internal/config"
+)
+
+// errNotReady is the cancellation cause when a task doesn't pass its ready
+// check within its timeout
+var errNotReady = errors.New("not ready")
+
+// readyProbeTimeout bounds one tcp or http attempt of a ready check
+const readyProbeTimeout = 2 * time.Second
+
+// readyClient makes the requests of http ready checks
+var readyClient = &http.Client{Timeout: readyProbeTimeout}
+
+// readiness is the ready check of one run of a task's command
+type readiness struct {
+	check  *config.ReadyCheck
+	once   sync.Once
+	passed chan struct{} // closed once the check passes
+}
+
+// newReadiness returns the ready check of the command about to run, or nil
+// if it has none: only a task's cmd, or the last of its steps, has one. A
+// log_line check watches the command's output from its first line.
+func (r *Runner) newReadiness(taskName string, out *taskOutput, st stepRun) *readiness {
+	check := r.cfg.TaskDefs[taskName].Ready
+	if check == nil || st.index != st.count {
+		return nil
+	}
+	rd := &readiness{check: check, passed: make(chan struct{})}
+	if check.LogLine != "" {
+		out.onReady = func(line string) {
+			if strings.Contains(line, check.LogLine) {
+				rd.pass()
+			}
+		}
+	}
+	return rd
+}
+
+// pass records that the check passed
+func (rd *readiness) pass() {
+	rd.once.Do(func() { close(rd.passed) })
+}
+
+// watchReadiness waits for a started command to pass its ready check, then
+// reports the task ready, which lets the tasks waiting for it start. If it
+// doesn't pass in time, the command is cancelled with errNotReady. The
+// returned func stops watching; once it returns, no ready event follows.
+func (r *Runner) watchReadiness(ctx context.Context, cancel context.CancelCauseFunc, taskName string, pid, restarts int, rd *readiness) func() {
+	ctx, stop := context.WithCancel(ctx)
+	if rd.check.LogLine == "" {
+		go rd.poll(ctx)
+	}
+
+	started := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer RecoverPanic()
+		defer close(done)
+		timeout := time.NewTimer(rd.check.ReadyTimeout())
+		defer timeout.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timeout.C:
+			cancel(errNotReady)
+		case <-rd.passed:
+			r.emitStatus(LogEvent{Task: taskName, Status: StatusReady, Pid: pid, Restarts: restarts, Elapsed: time.Since(started)})
+		}
+	}()
+	return func() {
+		stop()
+		<-done
+	}
+}
+
+// poll tries a tcp or http check every interval until it passes or ctx is done
+func (rd *readiness) poll(ctx context.Context) {
+	defer RecoverPanic()
+	ticker := time.NewTicker(rd.check.PollInterval())
+	defer ticker.Stop()
+	for {
+		if probe(ctx, rd.check) {
+			rd.pass()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe makes one attempt of a tcp or http check: a connection that
+// succeeds, or a response with a 2xx status
+func probe(ctx context.Context, check *config.ReadyCheck) bool {
+	if check.TCP != "" {
+		dialer := net.Dialer{Timeout: readyProbeTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", check.TCP)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.HTTP, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := readyClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
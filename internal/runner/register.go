@@ -0,0 +1,197 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watched directories are registered in the background in batches of
+// registerBatch, yielding between batches so the event loop keeps up.
+// Progress is reported every registerReportEvery directories on trees
+// larger than that.
+const (
+	registerBatch       = 500
+	registerReportEvery = 10000
+)
+
+// SetWatchBlock makes watched directories get registered before tasks start,
+// as with --watch-block, instead of in the background while they run
+func (w *Watcher) SetWatchBlock(block bool) {
+	w.watchBlock = block
+}
+
+// registration is a walk of one watched root, shared by the tasks that
+// watch it
+type registration struct {
+	dir   string   // root as the tasks give it
+	tasks []string // tasks waiting on it
+}
+
+// registerDirs walks a task's watch root and adds its directories to the
+// file watcher. Tasks watching the same root share one walk, and walks run
+// one at a time. w.mu must be held.
+func (w *Watcher) registerDirs(ctx context.Context, taskName, dir, root string) {
+	if reg := w.registering[root]; reg != nil {
+		reg.tasks = append(reg.tasks, taskName)
+		return
+	}
+	reg := &registration{dir: dir, tasks: []string{taskName}}
+	w.registering[root] = reg
+
+	go func() {
+		defer RecoverPanic()
+		w.registerMu.Lock()
+		defer w.registerMu.Unlock()
+		started := time.Now()
+
+		dirs, added, err := w.addDirs(ctx, dir)
+
+		w.mu.Lock()
+		delete(w.registering, root)
+		tasks := reg.tasks
+		w.mu.Unlock()
+		sort.Strings(tasks)
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.system(LevelError, fmt.Sprintf("Failed to watch %s for %s: %v", dir, strings.Join(tasks, ", "), err))
+			return
+		}
+		if w.verbose {
+			for _, taskName := range tasks {
+				w.logEvent(taskName, fmt.Sprintf("Watching directory: %s (%d directories)", dir, len(dirs)))
+			}
+		}
+		w.rescan(started, added)
+	}()
+}
+
+// registerNewDir adds a directory created under a watched one, and any
+// subdirectories it already has, to the file watcher. Files that appeared
+// in it before it was watched restart the tasks watching them, as after
+// the first registration.
+func (w *Watcher) registerNewDir(ctx context.Context, dir string) {
+	go func() {
+		defer RecoverPanic()
+		w.registerMu.Lock()
+		defer w.registerMu.Unlock()
+
+		_, added, err := w.addDirs(ctx, dir)
+		if err != nil {
+			// Most likely removed again already
+			w.trace("  → new directory %s not watched: %v", dir, err)
+			return
+		}
+		w.trace("  → watching new directory %s", dir)
+		w.rescan(time.Time{}, added)
+	}()
+}
+
+// forgetDirs stops watching dir and the directories under it, once it was
+// removed or renamed away, so that a long session doesn't collect watches
+// of directories that are gone. A directory renamed within a watched one is
+// watched again under its new name.
+func (w *Watcher) forgetDirs(dir string) {
+	w.mu.Lock()
+	var gone []string
+	for d := range w.sourceDirs {
+		if within(dir, d) {
+			delete(w.sourceDirs, d)
+			gone = append(gone, d)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, d := range gone {
+		// The kernel drops the watch of a removed directory by itself
+		_ = w.fsWatcher.Remove(d)
+		w.sweep.forget(d)
+	}
+	w.trace("  → stopped watching removed directory %s", dir)
+}
+
+// addDirs adds the directories under dir to the file watcher in batches,
+// and returns them along with when each was added
+func (w *Watcher) addDirs(ctx context.Context, dir string) ([]string, map[string]time.Time, error) {
+	dirs, err := WatchedDirs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := len(dirs) > registerReportEvery
+
+	added := make(map[string]time.Time, len(dirs))
+	for start := 0; start < len(dirs); start += registerBatch {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		batch := dirs[start:min(start+registerBatch, len(dirs))]
+		for _, d := range batch {
+			if err := w.fsWatcher.Add(d); err != nil {
+				return nil, nil, err
+			}
+		}
+		now := time.Now()
+		w.mu.Lock()
+		for _, d := range batch {
+			w.sourceDirs[d] = true
+			added[d] = now
+		}
+		w.mu.Unlock()
+
+		done := start + len(batch)
+		if report && (done%registerReportEvery == 0 || done == len(dirs)) {
+			w.system(LevelInfo, fmt.Sprintf("registered %s/%s dirs for watching", formatCount(done), formatCount(len(dirs))))
+		}
+		runtime.Gosched()
+	}
+	return dirs, added, nil
+}
+
+// rescan looks for files changed after registration began in directories
+// that were not yet being watched at the time, and restarts the tasks
+// watching them once
+func (w *Watcher) rescan(started time.Time, added map[string]time.Time) {
+	changed := make(map[string]string) // task -> a file it missed
+	for dir, watchedAt := range added {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().After(started) || info.ModTime().After(watchedAt) {
+				continue
+			}
+			path := dir + string(os.PathSeparator) + entry.Name()
+			for _, taskName := range w.tasksWatching(path) {
+				if _, ok := changed[taskName]; !ok {
+					changed[taskName] = path
+				}
+			}
+		}
+	}
+
+	for taskName, path := range changed {
+		w.trace("  → %s changed before its directory was watched", path)
+		w.queueRestart(taskName, path)
+	}
+}
+
+// formatCount formats n with thousands separators, e.g. 41,000
+func formatCount(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
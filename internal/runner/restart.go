@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Restarts under a task's restart policy wait restartBackoff, doubling from
+// minRestartBackoff with each one in a row up to maxRestartBackoff
+const (
+	minRestartBackoff = 500 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+)
+
+// restartBackoff is how long the nth restart in a row waits
+func restartBackoff(n int) time.Duration {
+	backoff := minRestartBackoff
+	for i := 1; i < n && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRestartBackoff)
+}
+
+// runCount is where a run stands among the task's runs: the restarts
+// before it, for PRUN_RESTART_COUNT and its events, and why the last one
+// happened
+type runCount struct {
+	restarts int
+	reason   string
+}
+
+// runTask runs a task once its dependencies allow, and runs it again after
+// it exits as its restart policy says, up to max_restarts times in a row,
+// waiting restart_delay or the backoff in between. A run lasting longer
+// than maxRestartBackoff was healthy, so it ends the row. A watch restart
+// cancels ctx and runs the task anew, which starts the count and backoff
+// over. A run killed by the task's timeout is not restarted, as it would
+// most likely hang again. run starts from the restarts so far and counts
+// the task's own; it belongs to this task alone, as tasks run side by side.
+func (r *Runner) runTask(ctx context.Context, taskName string, run *runCount) (err error) {
+	r.deps.rerun(taskName)
+	if failed := r.waitForDependencies(ctx, taskName); failed != "" {
+		r.emitStatus(LogEvent{Task: taskName, Status: StatusStopped, Reason: "dependency " + failed + " failed"})
+		r.deps.end(taskName, false)
+		return errDependencyFailed
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	// Only a run that ended on its own tells dependents anything
+	defer func() {
+		if ctx.Err() == nil {
+			r.deps.end(taskName, err == nil)
+		}
+	}()
+
+	taskDef := r.cfg.TaskDefs[taskName]
+	for attempt := 1; ; attempt++ {
+		started := r.clock.Now()
+		res := r.runOnce(ctx, taskName, *run)
+		if r.clock.Now().Sub(started) > maxRestartBackoff {
+			attempt = 1
+		}
+		failed := res.status == StatusFailed
+		if ctx.Err() != nil || res.status == StatusStopped || !taskDef.Restart.After(failed) {
+			return res.err
+		}
+		if res.timedOut {
+			r.notice(taskName, "not restarting, timed out", true)
+			return res.err
+		}
+		if taskDef.MaxRestarts > 0 && attempt > taskDef.MaxRestarts {
+			r.notice(taskName, fmt.Sprintf("giving up after %d restarts", taskDef.MaxRestarts), true)
+			return res.err
+		}
+
+		backoff := restartBackoff(attempt)
+		if delay := taskDef.RestartDelay.Duration; delay > 0 {
+			backoff = delay
+		}
+		exited := fmt.Sprintf("exited with code %d", res.code)
+		if res.pid == 0 {
+			exited = "failed to start"
+		}
+		limit := ""
+		if taskDef.MaxRestarts > 0 {
+			limit = fmt.Sprintf("/%d", taskDef.MaxRestarts)
+		}
+		r.notice(taskName, fmt.Sprintf("%s, restarting in %s (attempt %d%s)", exited, FormatElapsed(backoff), attempt, limit), failed)
+		// The TUI counts down to the restart
+		if r.eventChan != nil {
+			r.emitStatus(LogEvent{
+				Task:        taskName,
+				Status:      StatusRetrying,
+				Attempt:     attempt,
+				MaxAttempts: taskDef.MaxRestarts,
+				Until:       r.clock.Now().Add(backoff),
+				Restarts:    run.restarts,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.clock.After(backoff):
+		}
+		run.restarts++
+		run.reason = "restart = " + string(taskDef.Restart)
+	}
+}
@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// How a task of Run ended, in its TaskResult
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled" // stopped before it ended on its own, or skipped after a dependency failed
+)
+
+// errDependencyFailed is returned by runTask for a task that never started
+// because a task it depends on failed
+var errDependencyFailed = errors.New("a dependency failed")
+
+// TaskResult is how one task of Run ended
+type TaskResult struct {
+	Task     string
+	Outcome  string
+	ExitCode int   // of a failed task; -1 if it failed to start, timed out or was killed by a signal
+	Allowed  bool  // a failed task has allow_failure set, so its failure doesn't count
+	Err      error // why a failed task failed
+}
+
+// RunError is returned by Run when a task failed. It holds the results of
+// every task, in the order they were given.
+type RunError struct {
+	Results []TaskResult
+}
+
+// failed returns the results of the tasks whose failure counts
+func (e *RunError) failed() []TaskResult {
+	var failed []TaskResult
+	for _, res := range e.Results {
+		if res.Outcome == OutcomeFailed && !res.Allowed {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+func (e *RunError) Error() string {
+	failed := e.failed()
+	if len(failed) == 1 {
+		return fmt.Sprintf("task '%s': %v", failed[0].Task, failed[0].Err)
+	}
+	names := make([]string, len(failed))
+	for i, res := range failed {
+		names[i] = res.Task
+	}
+	return fmt.Sprintf("%d tasks failed: %s", len(failed), strings.Join(names, ", "))
+}
+
+// ExitCode is the code prun exits with: that of the failed task if only one
+// failed and it exited with one, 1 otherwise
+func (e *RunError) ExitCode() int {
+	if failed := e.failed(); len(failed) == 1 && failed[0].ExitCode > 0 {
+		return failed[0].ExitCode
+	}
+	return 1
+}
+
+// PrintResults writes a table of how each task of Run ended, with its exit
+// code, for --continue-on-error
+func PrintResults(c *Console, results []TaskResult) {
+	width := len("task")
+	for _, res := range results {
+		width = max(width, len(res.Task))
+	}
+	c.Infof("%-*s  %-18s  %s", width, "task", "result", "exit code")
+	for _, res := range results {
+		outcome, code := res.Outcome, "-"
+		switch {
+		case res.Outcome == OutcomeSucceeded:
+			code = "0"
+		case res.Outcome == OutcomeFailed && res.ExitCode >= 0:
+			code = strconv.Itoa(res.ExitCode)
+		}
+		if res.Allowed {
+			outcome += " (allowed)"
+		}
+		c.Infof("%-*s  %-18s  %s", width, res.Task, outcome, code)
+	}
+}
+
+// SetContinueOnError lets the other tasks run to completion when one fails,
+// instead of stopping them all (the default, fail-fast)
+func (r *Runner) SetContinueOnError(continueOnError bool) {
+	r.continueOnError = continueOnError
+}
+
+// Results returns how each task ended, once Run has returned
+func (r *Runner) Results() []TaskResult {
+	return r.results
+}
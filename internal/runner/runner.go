@@ -3,23 +3,94 @@ package runner
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"prun/internal/config"
+	"prun/internal/theme"
 )
 
-// LogEvent represents a log line from a task
+// Task statuses carried by status events
+const (
+	StatusIdle     = "idle"
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusReady    = "ready"
+	StatusRetrying = "retrying"
+	StatusDraining = "draining"
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusStopped  = "stopped"
+)
+
+// IsRunning reports whether a task in this status has its process running,
+// whether it passed its ready check yet or not
+func IsRunning(status string) bool {
+	return status == StatusRunning || status == StatusReady
+}
+
+// maxLineSize is the longest output line streamed intact; longer lines are split
+const maxLineSize = 1024 * 1024
+
+// LogEvent represents a log line from a task, or a status change when Status is set
 type LogEvent struct {
 	Task  string
 	Line  string
 	IsErr bool
 	Time  time.Time
+
+	// Status change fields, zero for plain log lines
+	Status      string
+	Attempt     int           // retry attempt, for "retrying"
+	MaxAttempts int           // retry limit, 0 if unbounded
+	Until       time.Time     // when a "retrying" task will be relaunched
+	Ahead       int           // number of tasks ahead of a "queued" task
+	ExitCode    int           // exit code, for "done" and "failed"
+	Survivors   int           // processes left in the group, for "draining"
+	Pid         int           // process id, for log lines and "running", "ready", "draining", "done" and "failed"
+	Restarts    int           // restarts so far in watch mode, alongside Pid
+	Reason      string        // what caused the restart, for "running"; what the task waits for, for "queued"; why it was skipped, for "stopped"
+	Elapsed     time.Duration // run time, for "done", "failed" and "stopped"; time to pass the ready check, for "ready"
+	Allowed     bool          // a "failed" task has allow_failure set
+	Auto        bool          // a "stopped" task was stopped by auto_stop_after or auto_stop_when_idle, Reason saying why
+	LogFile     string        // the file of this run with log_file_per_run, for "running"
+
+	// The step of a task with steps that a line or status is about, 1-based,
+	// and how many steps the task has; zero for a task with a cmd
+	Step     int
+	Steps    int
+	NextStep bool // "running" of a later step, not the first of a (re)started run
+
+	// A restart waiting for confirmation, or its end, reported by the watcher
+	// under SystemTask with Line describing it
+	Pending *PendingRestart
+
+	// The tasks a config reload added and removed, reported by the watcher
+	// under SystemTask with Line naming them
+	Added   []string
+	Removed []string
+
+	// Structured log fields, set when the task uses log_format = "json" and
+	// the line parsed. Line always keeps the original text.
+	Level   string
+	Message string
+
+	// The task that printed the line, when Task ran it through a
+	// {task:name} reference; its label in place of Task's
+	Ref string
+}
+
+// IsStatus reports whether the event is a status change rather than a log line
+func (e LogEvent) IsStatus() bool {
+	return e.Status != ""
 }
 
 // Runner manages multiple task processes
@@ -29,202 +100,956 @@ type Runner struct {
 	verbose   bool
 	output    *outputWriter
 	eventChan chan LogEvent
+	logs      *logFileSet
+	sockets   *socketSet
+	active    *activeGroups
+	envs      *envHistory // environments of previous runs, kept by the watcher
+	deps      *depGate    // what tasks waiting on others know of them, shared with the watcher
+	stdin     *stdinPipes // stdin of running tasks, with SetStdin; nil otherwise
+	clock     clock       // times restart backoff
+
+	// For the PRUN_* variables: the full list of tasks being run, which the
+	// watcher sets since its runners each run one task
+	order []string
+
+	groupOutput bool          // print each task's output as one block when it exits
+	quiet       bool          // leave out lifecycle lines in console output
+	killTimeout time.Duration // overrides shutdown_timeout if set
+
+	continueOnError bool         // let the other tasks finish when one fails
+	results         []TaskResult // how each task ended, once Run returns
 }
 
 // New creates a new Runner
 func New(cfg *config.Config, tasks []string, verbose bool) *Runner {
+	output := newOutputWriter(os.Stdout, os.Stderr, cfg.Markers, cfg.UI.Theme())
 	return &Runner{
 		cfg:       cfg,
 		tasks:     tasks,
 		verbose:   verbose,
-		output:    newOutputWriter(os.Stdout),
+		output:    output,
 		eventChan: nil, // will be set if interactive mode
+		logs:      newLogFileSet(verbose, output),
+		sockets:   newSocketSet(),
+		active:    newActiveGroups(),
+		deps:      newDepGate(),
+		clock:     realClock{},
 	}
 }
 
+// SetConsole makes console output go through c, shared with prun's own messages
+func (r *Runner) SetConsole(c *Console) {
+	r.output = c.out
+	r.logs.output = c.out
+}
+
 // SetEventChannel sets a channel for publishing log events (for interactive UI)
 func (r *Runner) SetEventChannel(ch chan LogEvent) {
 	r.eventChan = ch
 }
 
-// Run starts all tasks and waits for them to complete
+// SetQuiet leaves lifecycle lines (started, exited, …) out of console output
+func (r *Runner) SetQuiet(quiet bool) {
+	r.quiet = quiet
+}
+
+// SetGroupOutput makes console output of each task be buffered and printed
+// in one block under a header once the task exits, instead of interleaved
+func (r *Runner) SetGroupOutput(group bool) {
+	r.groupOutput = group
+}
+
+// Run starts all tasks and waits for them to complete. Unless
+// SetContinueOnError is on, the first task to fail stops the others. If a
+// task failed, the error is a *RunError with the results of every task.
 func (r *Runner) Run(ctx context.Context) error {
 	// Create a cancellable context for all tasks
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	defer r.logs.Close()
+	defer r.sockets.Close()
 
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(r.tasks))
+	results := make([]TaskResult, len(r.tasks))
 
 	// Start all tasks
-	for _, taskName := range r.tasks {
+	for i, taskName := range r.tasks {
 		wg.Add(1)
-		go func(name string) {
+		go func(i int, name string) {
+			defer RecoverPanic()
 			defer wg.Done()
-			if err := r.runTask(ctx, name); err != nil {
-				errChan <- fmt.Errorf("task '%s': %w", name, err)
+			err := r.runTask(ctx, name, &runCount{})
+			res := TaskResult{Task: name, Outcome: OutcomeSucceeded}
+			switch {
+			case errors.Is(err, errDependencyFailed) || (err == nil && ctx.Err() != nil):
+				res.Outcome = OutcomeCancelled
+			case err != nil:
+				// An allowed failure was reported as such already; the rest carry on
+				res.Outcome, res.ExitCode, res.Err = OutcomeFailed, exitCode(err), err
+				res.Allowed = r.cfg.TaskDefs[name].AllowFailure
+			}
+			results[i] = res
+			if res.Outcome == OutcomeFailed && !res.Allowed && !r.continueOnError {
 				cancel() // Cancel all other tasks on error
 			}
-		}(taskName)
+		}(i, taskName)
 	}
 
 	// Wait for all tasks to complete
 	wg.Wait()
-	close(errChan)
+	r.results = results
 
-	// Check for errors
-	var firstErr error
-	for err := range errChan {
-		if firstErr == nil {
-			firstErr = err
-		}
-		if r.verbose {
-			fmt.Fprintf(os.Stderr, "prun: %v\n", err)
+	runErr := &RunError{Results: results}
+	failed := runErr.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	if r.verbose {
+		for _, res := range failed {
+			r.output.writeSystem(LevelError, fmt.Sprintf("task '%s': %v", res.Task, res.Err))
 		}
 	}
+	return runErr
+}
+
+// A task whose main process exited keeps draining until the rest of its
+// process group is gone, checked every drainPollInterval, for at most
+// drain_timeout (defaultDrainTimeout if unset)
+const (
+	drainPollInterval   = 100 * time.Millisecond
+	defaultDrainTimeout = 5 * time.Second
+)
+
+// errStartTimeout is the cancellation cause when a task is silent past its start_timeout
+var errStartTimeout = errors.New("start timeout")
+
+// errRunTimeout is the cancellation cause when a run lasts past the task's timeout
+var errRunTimeout = errors.New("timeout")
+
+// errStepTimeout is the cancellation cause when a step runs past its timeout
+var errStepTimeout = errors.New("step timeout")
+
+// errOutputPipe is returned when a task's stdout or stderr pipe can't be
+// created, usually because prun ran out of file descriptors. The task never
+// started, so the watcher retries it after a backoff.
+var errOutputPipe = errors.New("failed to allocate output pipe")
+
+// outOfDescriptors reports whether err comes from running out of file
+// descriptors, which frees up again as other processes exit
+func outOfDescriptors(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
 
-	return firstErr
+// retryable reports whether a run failed before its task could start, for a
+// reason that may go away on its own
+func retryable(err error) bool {
+	return errors.Is(err, errOutputPipe) || outOfDescriptors(err)
 }
 
-// runTask runs a single task
-func (r *Runner) runTask(ctx context.Context, taskName string) error {
+// taskOutput describes where a task's output lines go besides the console/event stream
+type taskOutput struct {
+	logFile  *logFile
+	prefixed bool // prefix lines in the log file with the task name
+	onLine   func()
+	onReady  func(line string) // a ready check watching for log_line, if any
+	idle     idleClock         // last line of the run, for auto_stop_when_idle
+	fields   *config.LogFields // parse lines as JSON logs with these fields, if set
+	runLog   string            // the file of this run with log_file_per_run
+	run      runCount          // the restarts before this run, tagged on its events
+	refs     bool              // the command has {task:name} references, whose lines come as NDJSON
+
+	// Of the command running now, the task's cmd or one of its steps
+	started chan struct{} // closed once the process started, or failed to
+	pid     int           // set before started is closed
+	step    stepRun
+	tail    outputTail // the last lines, searched for a hint if the run fails
+
+	// With grouped output, console lines are held here until the task exits
+	mu    sync.Mutex
+	group []string
+}
+
+// start resets the per-command state of out for the next command to run
+func (out *taskOutput) start(st stepRun) {
+	out.started = make(chan struct{})
+	out.pid = 0
+	out.step = st
+	out.tail = outputTail{}
+	out.onLine = nil
+	out.onReady = nil
+}
+
+// runOnce runs a single task once: its cmd, or each of its steps in turn
+func (r *Runner) runOnce(parent context.Context, taskName string, run runCount) runResult {
+	panicHook(taskName)
 	taskDef := r.cfg.TaskDefs[taskName]
 
-	if r.verbose {
-		r.output.WritePrefix(taskName, fmt.Sprintf("Starting: %s\n", taskDef.Cmd))
+	// Set environment variables
+	order := r.order
+	if order == nil {
+		order = r.tasks
 	}
+	env := r.cfg.TaskEnv(taskName, config.Instance{
+		Index:    slices.Index(order, taskName),
+		Count:    len(order),
+		Restarts: run.restarts,
+	})
 
-	// Determine if we should use shell
-	useShell := true
-	if taskDef.Shell != nil {
-		useShell = *taskDef.Shell
+	// Tell what changed in the environment since the previous run
+	if r.envs != nil {
+		prev := r.envs.swap(taskName, env)
+		if diff := envDiff(r.cfg, prev, envMap(env)); prev != nil && diff != "" && (r.eventChan != nil || r.verbose) {
+			r.notice(taskName, "env since last run: "+diff, false)
+		}
 	}
 
-	var cmd *exec.Cmd
-	if useShell {
-		cmd = exec.CommandContext(ctx, "/bin/bash", "-c", taskDef.Cmd)
-	} else {
-		// For non-shell, we'd need to parse the command - simplified for now
-		cmd = exec.CommandContext(ctx, "/bin/bash", "-c", taskDef.Cmd)
+	// Open the task's log file, shared with any other task writing to it
+	out := &taskOutput{prefixed: r.cfg.SharesLogFile(taskName), run: run}
+	if r.groupOutput && r.eventChan == nil {
+		defer func() {
+			r.output.WriteGroup(taskName, out.group)
+		}()
+	}
+	if taskDef.LogFormat == "json" {
+		out.fields = &taskDef.LogFields
+	}
+	if path := r.cfg.LogFilePath(taskName); path != "" && taskDef.LogFilePerRun {
+		logFile, err := r.logs.OpenRun(path, r.cfg.LogKeepRuns(taskName), logRotation(r.cfg, taskName))
+		if err != nil {
+			return runResult{status: StatusFailed, code: -1, err: err}
+		}
+		defer r.logs.Release(logFile)
+		out.logFile, out.runLog = logFile, logFile.path
+	} else if path != "" {
+		logFile, err := r.logs.Get(path, logRotation(r.cfg, taskName))
+		if err != nil {
+			return runResult{status: StatusFailed, code: -1, err: err}
+		}
+		out.logFile = logFile
+	}
+
+	// auto_stop_after and auto_stop_when_idle cancel the run with a cause
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	defer r.armAutoStop(cancel, taskName, out)()
+
+	// A run may not last longer than the task's timeout, steps and all
+	if timeout := taskDef.Timeout.Duration; timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			cancel(errRunTimeout)
+		})
+		defer timer.Stop()
 	}
 
+	if len(taskDef.Steps) > 0 {
+		return r.runSteps(ctx, taskName, env, out)
+	}
+	res := r.runCommand(ctx, taskName, taskDef.Cmd, env, out, stepRun{})
+	r.finish(taskName, out, res, stepRun{})
+	return res
+}
+
+// logRotation returns how a task's log file is rotated
+func logRotation(cfg *config.Config, taskName string) rotation {
+	maxSize, backups := cfg.LogRotation(taskName)
+	return rotation{maxSize: maxSize, backups: backups}
+}
+
+// stepRun is the step runCommand runs, or zero for a task's cmd
+type stepRun struct {
+	index   int           // 1-based
+	count   int           // steps of the task
+	timeout time.Duration // fail the step if it runs longer, if set
+	next    bool          // a later step of the run, not the one a restart began with
+}
+
+// runResult is how a command run by runCommand ended
+type runResult struct {
+	status  string // StatusDone, StatusFailed or StatusStopped
+	code    int
+	pid     int // 0 if the process never started
+	elapsed time.Duration
+	err     error
+
+	autoStop string // why auto_stop_after or auto_stop_when_idle stopped it
+	timedOut bool   // killed for lasting past the task's timeout
+}
+
+// runCommand runs one command of a task, its cmd or one of its steps, and
+// reports its start. How it ended is left to the caller to report.
+func (r *Runner) runCommand(parent context.Context, taskName, command string, env []string, out *taskOutput, st stepRun) runResult {
+	taskDef := r.cfg.TaskDefs[taskName]
+	failedToStart := func(err error) runResult {
+		return runResult{status: StatusFailed, code: -1, err: err}
+	}
+
+	// The command's own context can be cancelled with a cause, such as a
+	// start timeout
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	out.refs = len(config.TaskRefs(command)) > 0
+
+	if r.verbose {
+		r.output.WritePrefix(stepLabel(taskName, st.index, st.count), fmt.Sprintf("Starting: %s\n", command), false)
+	}
+
+	args, err := commandArgs(r.cfg, taskName, command)
+	if err != nil {
+		return failedToStart(err)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
 	// Set working directory if specified
 	if taskDef.Path != "" {
 		cmd.Dir = taskDef.Path
 	}
+	cmd.Env = slices.Clip(env)
 
-	// Set environment variables
-	cmd.Env = os.Environ()
-	for k, v := range taskDef.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	// Pass the task's sockets as fds 3, 4, … and name them in its environment
+	for _, fd := range taskDef.PassFDs {
+		file, err := r.sockets.Get(fd.Listen)
+		if err != nil {
+			return failedToStart(err)
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", fd.Env, 2+len(cmd.ExtraFiles)))
 	}
 
-	// Set process group for signal forwarding
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
+	if err := r.applySandbox(cmd, taskName); err != nil {
+		return failedToStart(err)
 	}
 
-	// Configure cancellation to kill the process group
+	// Set process group for signal forwarding
+	procs.Prepare(cmd)
+
+	// Cancellation stops the whole process group, gracefully if it can
+	stop := &groupStop{grace: r.shutdownTimeout(taskName)}
 	cmd.Cancel = func() error {
-		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		stop.stop(cmd.Process.Pid)
+		return nil
 	}
 
 	// Capture stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+		return failedToStart(r.pipeFailed(taskName, err))
 	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+		stdout.Close()
+		return failedToStart(r.pipeFailed(taskName, err))
 	}
+	forgetStdin, err := r.attachStdin(cmd, taskName)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return failedToStart(err)
+	}
+	defer forgetStdin()
+	out.start(st)
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start: %w", err)
+	// Arm the start timeout; the first line of output disarms it
+	if timeout := taskDef.StartTimeout.Duration; timeout > 0 {
+		startTimer := time.AfterFunc(timeout, func() {
+			cancel(errStartTimeout)
+		})
+		defer startTimer.Stop()
+		var once sync.Once
+		out.onLine = func() {
+			once.Do(func() { startTimer.Stop() })
+		}
+	}
+
+	// The task's ready check is for its cmd, or the last of its steps
+	ready := r.newReadiness(taskName, out, st)
+
+	// A step may not run longer than its timeout
+	if st.timeout > 0 {
+		stepTimer := time.AfterFunc(st.timeout, func() {
+			cancel(errStepTimeout)
+		})
+		defer stepTimer.Stop()
 	}
 
-	// Stream output
+	// Stream output. Readers start before the process so that nothing a
+	// fast-exiting command writes is missed; if Start fails exec closes the
+	// pipes and the readers return immediately.
 	var streamWg sync.WaitGroup
 	streamWg.Add(2)
 
 	go func() {
+		defer RecoverPanic()
 		defer streamWg.Done()
-		r.streamOutput(taskName, stdout)
+		r.streamOutput(taskName, stdout, false, out)
 	}()
 
 	go func() {
+		defer RecoverPanic()
 		defer streamWg.Done()
-		r.streamOutput(taskName, stderr)
+		r.streamOutput(taskName, stderr, true, out)
 	}()
 
-	// Wait for output streaming to complete
+	// Start the command
+	umask, _ := config.ParseUmask(taskDef.Umask)
+	err = startProcess(cmd, umask)
+	if err == nil {
+		out.pid = cmd.Process.Pid
+	}
+	close(out.started)
+	if err != nil {
+		streamWg.Wait()
+		if outOfDescriptors(err) {
+			r.notice(taskName, "failed to start, too many open files (check ulimit -n)", true)
+		}
+		return failedToStart(fmt.Errorf("failed to start: %w", err))
+	}
+	started := time.Now()
+	pid := cmd.Process.Pid
+	running := LogEvent{
+		Task:     taskName,
+		Status:   StatusRunning,
+		Pid:      pid,
+		Restarts: out.run.restarts,
+		Reason:   out.run.reason,
+		LogFile:  out.runLog,
+		Step:     st.index,
+		Steps:    st.count,
+		NextStep: st.next,
+	}
+	if st.next {
+		running.Reason = ""
+	}
+	r.emitStatus(running)
+	r.active.add(pid)
+	if ready != nil {
+		defer r.watchReadiness(ctx, cancel, taskName, pid, out.run.restarts, ready)()
+	}
+
+	// Sample the group's processes and descriptors while the task runs
+	sampleCtx, stopSampling := context.WithCancel(ctx)
+	defer stopSampling()
+	go r.sampleUsage(sampleCtx, taskName, pid, taskDef)
+
+	ended := func(status string, err error) runResult {
+		return runResult{status: status, code: exitCode(err), pid: pid, elapsed: time.Since(started), err: err}
+	}
+
+	// Wait for output streaming to complete. Both readers must reach EOF
+	// before the exit status is reported, so a task's last lines always
+	// precede its exit event.
 	streamWg.Wait()
 
 	// Wait for command to exit
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+
+	// Children that detached from the shell's pipes may outlive it; the task
+	// is not over until its process group is empty
+	r.drain(ctx, taskName, pid, out.run.restarts, taskDef.DrainTimeout.Duration, stop)
+	r.active.remove(pid)
+	if stop.wasForced() {
+		r.notice(taskName, fmt.Sprintf("killed, still running %s after SIGTERM", FormatElapsed(stop.grace)), true)
+	}
+
+	// The run's timeout cancels parent, so it is told apart by its cause
+	if errors.Is(context.Cause(ctx), errRunTimeout) {
+		msg := fmt.Sprintf("timed out after %s", FormatElapsed(taskDef.Timeout.Duration))
+		r.notice(taskName, msg, true)
+		res := ended(StatusFailed, err)
+		res.err = errors.New(msg)
+		res.timedOut = true
+		return res
+	}
+
+	if parent.Err() == nil && errors.Is(context.Cause(ctx), errNotReady) {
+		msg := fmt.Sprintf("not ready within %s", FormatElapsed(ready.check.ReadyTimeout()))
+		r.notice(taskName, msg, true)
+		res := ended(StatusFailed, err)
+		res.err = errors.New(msg)
+		return res
+	}
+
+	if cause := context.Cause(ctx); parent.Err() == nil && (errors.Is(cause, errStartTimeout) || errors.Is(cause, errStepTimeout)) {
+		msg := fmt.Sprintf("failed to start within %s", taskDef.StartTimeout.Duration)
+		notice := msg
+		if errors.Is(cause, errStepTimeout) {
+			msg = fmt.Sprintf("timed out after %s", st.timeout)
+			notice = fmt.Sprintf("step %d/%d %s", st.index, st.count, msg)
+		}
+		r.notice(taskName, notice, true)
+		res := ended(StatusFailed, err)
+		res.err = errors.New(msg)
+		return res
+	}
+
+	// However the task took being stopped, it didn't fail
+	if reason := autoStopReason(ctx); reason != "" {
+		res := ended(StatusStopped, nil)
+		res.autoStop = reason
+		return res
+	}
+
+	if err != nil {
 		if ctx.Err() != nil {
 			// Context was cancelled, this is expected
-			return nil
+			return ended(StatusStopped, nil)
+		}
+		return ended(StatusFailed, err)
+	}
+	return ended(StatusDone, nil)
+}
+
+// finish reports how a task's run ended, followed by a hint at the cause of
+// a failure that looks familiar
+func (r *Runner) finish(taskName string, out *taskOutput, res runResult, st stepRun) {
+	r.emitStatus(LogEvent{
+		Task:     taskName,
+		Status:   res.status,
+		ExitCode: res.code,
+		Pid:      res.pid,
+		Restarts: out.run.restarts,
+		Elapsed:  res.elapsed,
+		Step:     st.index,
+		Steps:    st.count,
+		Reason:   res.autoStop,
+		Auto:     res.autoStop != "",
+	})
+	if res.status == StatusFailed && r.cfg.HintsEnabled() {
+		if hint := out.tail.hint(); hint != "" {
+			r.notice(taskName, "hint: "+hint, false)
+		}
+	}
+}
+
+// pipeFailed reports that a task's output pipe could not be created, with a
+// hint when the cause is running out of file descriptors
+func (r *Runner) pipeFailed(taskName string, err error) error {
+	msg := fmt.Sprintf("%v: %v", errOutputPipe, err)
+	if outOfDescriptors(err) {
+		msg = fmt.Sprintf("%v, possibly too many open files (check ulimit -n): %v", errOutputPipe, err)
+	}
+	r.notice(taskName, msg, true)
+	return fmt.Errorf("%w: %w", errOutputPipe, err)
+}
+
+// drain waits for the processes left in a task's group after its main
+// process exited, reporting the task as draining meanwhile. Whatever is
+// still alive after timeout is killed; when ctx is cancelled, the group is
+// stopped as the task would have been.
+func (r *Runner) drain(ctx context.Context, taskName string, pgid, restarts int, timeout time.Duration, stop *groupStop) {
+	n, err := procs.Count(pgid)
+	if err != nil || n == 0 {
+		return
+	}
+	if ctx.Err() != nil {
+		stop.stop(pgid)
+		stop.wait()
+		return
+	}
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	r.emitStatus(LogEvent{Task: taskName, Status: StatusDraining, Survivors: n, Pid: pgid, Restarts: restarts})
+	if r.verbose {
+		r.output.WritePrefix(taskName, fmt.Sprintf("%d process(es) still running in group\n", n), false)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(drainPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stop.stop(pgid)
+			stop.wait()
+			return
+		case <-deadline.C:
+			r.notice(taskName, fmt.Sprintf("killing %d process(es) still running in group after %s", n, timeout), true)
+			_ = procs.Signal(pgid, syscall.SIGKILL)
+			return
+		case <-poll.C:
+			left, err := procs.Count(pgid)
+			if err != nil || left == 0 {
+				return
+			}
+			if left != n {
+				n = left
+				r.emitStatus(LogEvent{Task: taskName, Status: StatusDraining, Survivors: n, Pid: pgid, Restarts: restarts})
+			}
+		}
+	}
+}
+
+// Signal forwards sig to the process groups of all running tasks
+func (r *Runner) Signal(sig syscall.Signal) {
+	r.active.signal(sig)
+}
+
+// notice reports a message from prun about a task, through the event channel
+// in interactive mode or as a prefixed console line otherwise
+func (r *Runner) notice(taskName, message string, isErr bool) {
+	if r.eventChan != nil {
+		r.eventChan <- LogEvent{
+			Task:  taskName,
+			Line:  message,
+			IsErr: isErr,
+			Time:  time.Now(),
+		}
+		return
+	}
+	r.output.WritePrefix(taskName, message+"\n", isErr)
+}
+
+// warn reports a warning from prun about a task, such as a resource above
+// its threshold
+func (r *Runner) warn(taskName, message string) {
+	if r.eventChan != nil {
+		r.eventChan <- LogEvent{
+			Task:  taskName,
+			Line:  message,
+			IsErr: true,
+			Time:  time.Now(),
+			Level: LevelWarn,
+		}
+		return
+	}
+	r.output.WritePrefix(taskName, message+"\n", true)
+}
+
+// emitStatus publishes a status change event, or prints it as a lifecycle
+// line when there is no event channel
+func (r *Runner) emitStatus(ev LogEvent) {
+	ev.Time = time.Now()
+	switch ev.Status {
+	case StatusRunning:
+		r.deps.start(ev.Task)
+	case StatusReady:
+		r.deps.becameReady(ev.Task)
+	case StatusFailed:
+		ev.Allowed = r.cfg.TaskDefs[ev.Task].AllowFailure
+	}
+	if r.eventChan == nil {
+		if !r.quiet {
+			r.output.WriteStatus(ev)
+		}
+		return
+	}
+	r.eventChan <- ev
+}
+
+// exitCode extracts a process exit code from a Wait error, or -1 if the
+// process did not exit normally
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// CommandArgs returns the full argv used to launch a task, including any
+// wrapper. For a task with steps it is that of the first step.
+func CommandArgs(cfg *config.Config, taskName string) ([]string, error) {
+	return commandArgs(cfg, taskName, cfg.TaskDefs[taskName].Commands()[0])
+}
+
+// StepArgs returns the full argv of each command a task runs: its cmd, or
+// each of its steps
+func StepArgs(cfg *config.Config, taskName string) ([][]string, error) {
+	var all [][]string
+	for _, command := range cfg.TaskDefs[taskName].Commands() {
+		args, err := commandArgs(cfg, taskName, command)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, args)
+	}
+	return all, nil
+}
+
+// commandArgs returns the argv that runs command, one of a task's commands
+func commandArgs(cfg *config.Config, taskName, command string) ([]string, error) {
+	taskDef := cfg.TaskDefs[taskName]
+
+	// Determine if we should use shell
+	useShell := true
+	if taskDef.Shell != nil {
+		useShell = *taskDef.Shell
+	}
+
+	cmd, err := expandTaskRefs(cfg, command)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without the shell the command is split into argv as a shell would,
+	// quotes and backslashes included, and run directly
+	args := []string{"/bin/bash", "-c", cmd}
+	if !useShell {
+		if args, err = config.SplitArgs(cmd); err != nil {
+			return nil, fmt.Errorf("invalid cmd: %w", err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty cmd")
 		}
-		return err
 	}
 
-	return nil
+	// The wrapper goes in front of the shell so it applies to the whole command
+	wrapper, err := cfg.WrapperArgs(taskName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapper: %w", err)
+	}
+	return append(wrapper, args...), nil
 }
 
 // streamOutput reads from a reader and writes prefixed lines
-func (r *Runner) streamOutput(taskName string, reader io.Reader) {
+func (r *Runner) streamOutput(taskName string, reader io.Reader, isErr bool, out *taskOutput) {
 	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	scanner.Split(scanLines)
+	// If reading fails, keep draining so the task never blocks writing to a
+	// pipe nobody reads
+	defer io.Copy(io.Discard, reader)
+
+	// Lines carry the pid, known once the process has started
+	<-out.started
+
 	for scanner.Scan() {
 		line := scanner.Text()
+		isErr, ref := isErr, ""
+		if out.refs {
+			if text, task, refErr, ok := refLine(r.cfg, line); ok {
+				line, ref, isErr = text, task, refErr
+			}
+		}
+
+		if out.onLine != nil {
+			out.onLine()
+		}
+		if out.onReady != nil {
+			out.onReady(line)
+		}
+		out.idle.tick()
+		if out.logFile != nil {
+			out.logFile.WriteLine(taskName, line, out.prefixed)
+		}
+		out.tail.add(line)
+
+		ev := LogEvent{
+			Task:     taskName,
+			Line:     line,
+			IsErr:    isErr,
+			Time:     time.Now(),
+			Pid:      out.pid,
+			Restarts: out.run.restarts,
+			Step:     out.step.index,
+			Steps:    out.step.count,
+			Ref:      ref,
+		}
 
 		// Send to event channel if interactive mode
 		if r.eventChan != nil {
-			r.eventChan <- LogEvent{
-				Task:  taskName,
-				Line:  line,
-				IsErr: false,
-				Time:  time.Now(),
+			if out.fields != nil {
+				if s, ok := parseJSONLine(line, *out.fields); ok {
+					ev.Level = s.Level
+					ev.Message = formatStructured(s)
+					if !s.Time.IsZero() {
+						ev.Time = s.Time
+					}
+				}
 			}
+			r.eventChan <- ev
+		} else if r.groupOutput {
+			out.mu.Lock()
+			if ref != "" {
+				line = "[" + ref + "] " + line
+			}
+			out.group = append(out.group, r.output.mark(line, isErr))
+			out.mu.Unlock()
 		} else {
 			// Normal output mode
-			r.output.WritePrefix(taskName, line+"\n")
+			r.output.WriteLine(ev)
 		}
 	}
 }
 
+// scanLines is bufio.ScanLines, except that a line filling the whole buffer is
+// emitted in pieces instead of failing with bufio.ErrTooLong
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= maxLineSize {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
+
 // outputWriter handles synchronized, prefixed output
 type outputWriter struct {
-	mu     sync.Mutex
-	writer io.Writer
+	mu         sync.Mutex
+	writer     io.Writer
+	errWriter  io.Writer // prun's own messages
+	markers    config.StreamMarkers
+	prefixes   *Prefixes   // labels of tasks; their full names if nil
+	icons      theme.Icons // glyphs of lifecycle lines
+	color      bool        // color prefixes, lifecycle lines and stderr lines
+	errColor   bool        // color prun's own messages
+	json       bool        // write NDJSON records instead of prefixed lines
+	timestamps bool        // put the time in front of each line (--timestamps)
+	noPrefix   bool        // leave the [task] prefix out (--no-prefix)
+	spool      *spool      // everything written, on its way to the terminal
+}
+
+func newOutputWriter(w, errW io.Writer, markers config.StreamMarkers, icons theme.Icons) *outputWriter {
+	ow := &outputWriter{
+		writer:    w,
+		errWriter: errW,
+		markers:   markers,
+		icons:     icons,
+		color:     colorEnabled(w),
+		errColor:  colorEnabled(errW),
+	}
+	ow.spool = newSpool(ow.writeLine)
+	return ow
+}
+
+// mark puts the stream marker, if enabled, in front of a line
+func (ow *outputWriter) mark(line string, isErr bool) string {
+	if marker := ow.markers.Marker(isErr); marker != "" {
+		return marker + " " + line
+	}
+	return line
 }
 
-func newOutputWriter(w io.Writer) *outputWriter {
-	return &outputWriter{writer: w}
+func (ow *outputWriter) WritePrefix(prefix, text string, isErr bool) {
+	ow.writeText(prefix, text, isErr, time.Now())
 }
 
-func (ow *outputWriter) WritePrefix(prefix, text string) {
+// writeText writes text of a task printed at t under its prefix. On a
+// terminal, stderr text is dimmed.
+func (ow *outputWriter) writeText(prefix, text string, isErr bool, t time.Time) {
+	ow.spool.add(func() { ow.writeTextNow(prefix, text, isErr, t) })
+}
+
+// writeTextNow is writeText, bypassing the spool
+func (ow *outputWriter) writeTextNow(prefix, text string, isErr bool, t time.Time) {
 	ow.mu.Lock()
 	defer ow.mu.Unlock()
 
-	// Calculate max prefix width for alignment
-	maxWidth := 15
-	paddedPrefix := prefix
-	if len(prefix) < maxWidth {
-		paddedPrefix = prefix + string(make([]byte, maxWidth-len(prefix)))
-		for i := len(prefix); i < maxWidth; i++ {
-			paddedPrefix = paddedPrefix[:len(prefix)] + " " + paddedPrefix[len(prefix):]
+	if ow.json {
+		ow.writeJSON(jsonText(prefix, text, isErr))
+		return
+	}
+
+	text = ow.mark(text, isErr)
+	if isErr && ow.color {
+		line, newline := strings.CutSuffix(text, "\n")
+		text = ansiDim + line + ansiReset
+		if newline {
+			text += "\n"
+		}
+	}
+	fmt.Fprintf(ow.writer, "%s%s", ow.lead(prefix, t), text)
+}
+
+// lead returns what goes in front of a task's line: the time with
+// --timestamps, then the task's prefix, colored on a terminal, unless
+// --no-prefix leaves it out. It ends in a space unless it is empty.
+func (ow *outputWriter) lead(task string, t time.Time) string {
+	var lead string
+	if ow.timestamps {
+		stamp := t.Format("15:04:05.000")
+		if ow.color {
+			stamp = ansiGray + stamp + ansiReset
 		}
+		lead = stamp + " "
+	}
+	switch {
+	case ow.noPrefix:
+	case ow.color:
+		lead += ow.prefixes.Colored(task) + " "
+	default:
+		lead += ow.prefixes.Padded(task) + " "
 	}
+	return lead
+}
 
-	fmt.Fprintf(ow.writer, "[%s] %s", prefix, text)
+// WriteGroup writes a task's buffered output as one block. Under GitHub
+// Actions the block is a collapsible log group.
+func (ow *outputWriter) WriteGroup(task string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	ow.spool.add(func() {
+		ow.mu.Lock()
+		defer ow.mu.Unlock()
+		ow.writeGroupNow(task, lines)
+	})
 }
 
-// Shutdown gracefully shuts down all running processes
-func (r *Runner) Shutdown(timeout time.Duration) {
-	if r.verbose {
-		fmt.Fprintln(os.Stderr, "prun: shutting down tasks...")
+// writeGroupNow is WriteGroup, bypassing the spool; ow.mu must be held
+func (ow *outputWriter) writeGroupNow(task string, lines []string) {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		fmt.Fprintf(ow.writer, "::group::%s\n", task)
+		defer fmt.Fprintln(ow.writer, "::endgroup::")
+	} else {
+		fmt.Fprintf(ow.writer, "==> %s <==\n", task)
+	}
+	for _, line := range lines {
+		fmt.Fprintln(ow.writer, line)
+	}
+}
+
+// PrintOptions control how PrintEvents writes events
+type PrintOptions struct {
+	Group bool // print each task's lines as one block when it exits
+	Quiet bool // leave status changes out
+}
+
+// PrintEvents writes the log lines among events to c, prefixed with their task
+// name as in non-interactive mode, until events is closed. Lines carry the
+// stream markers, if enabled, status changes are printed as lifecycle lines,
+// and prun's own messages go where Console.Errorf and the like put them.
+func PrintEvents(c *Console, events <-chan LogEvent, opts PrintOptions) {
+	out := c.out
+	groups := make(map[string][]string)
+	for ev := range events {
+		switch {
+		case ev.Task == SystemTask && ev.Level != "":
+			out.writeSystem(ev.Level, ev.Line)
+		case !ev.IsStatus() && opts.Group:
+			groups[ev.Task] = append(groups[ev.Task], out.mark(ev.Line, ev.IsErr))
+		case !ev.IsStatus():
+			out.WriteLine(ev)
+		default:
+			if opts.Group && (ev.Status == StatusDone || ev.Status == StatusFailed || ev.Status == StatusStopped) {
+				out.WriteGroup(ev.Task, groups[ev.Task])
+				delete(groups, ev.Task)
+			}
+			if !opts.Quiet {
+				out.WriteStatus(ev)
+			}
+		}
+	}
+}
+
+// SetKillTimeout gives every task this long to exit after SIGTERM before
+// it is killed, in place of its shutdown_timeout (--kill-timeout)
+func (r *Runner) SetKillTimeout(timeout time.Duration) {
+	r.killTimeout = timeout
+}
+
+// shutdownTimeout is how long a task's group has to exit after SIGTERM
+func (r *Runner) shutdownTimeout(taskName string) time.Duration {
+	if r.killTimeout > 0 {
+		return r.killTimeout
+	}
+	if timeout := r.cfg.TaskDefs[taskName].ShutdownTimeout.Duration; timeout > 0 {
+		return timeout
 	}
-	// Tasks are managed via context cancellation in Run()
+	return defaultShutdownTimeout
 }
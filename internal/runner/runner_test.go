@@ -0,0 +1,385 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"prun/internal/config"
+)
+
+// fixture is the tests/fixture binary, built once by TestMain
+var fixture string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "prun-runner-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fixture = filepath.Join(dir, "fixture")
+	build := exec.Command("go", "build", "-o", fixture, "prun/tests/fixture")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "building the fixture:", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// eventWait bounds how long a test waits for an event
+const eventWait = 10 * time.Second
+
+// loadConfig writes a prun.toml into a temporary directory and loads it.
+// {fixture} in the text stands for the fixture binary.
+func loadConfig(t *testing.T, text string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prun.toml")
+	text = strings.ReplaceAll(text, "{fixture}", fixture)
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	return cfg
+}
+
+// nextStatus reads events until a status change of task, failing the test
+// if none comes in time. Log lines of task read on the way are appended to
+// lines, if not nil.
+func nextStatus(t *testing.T, events <-chan LogEvent, task string, lines *[]LogEvent) LogEvent {
+	t.Helper()
+	deadline := time.After(eventWait)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Task != task {
+				continue
+			}
+			if ev.IsStatus() {
+				return ev
+			}
+			if lines != nil {
+				*lines = append(*lines, ev)
+			}
+		case <-deadline:
+			t.Fatalf("no status of %s within %s", task, eventWait)
+		}
+	}
+}
+
+// expectStatus is nextStatus, failing the test unless the status is want
+func expectStatus(t *testing.T, events <-chan LogEvent, task, want string) LogEvent {
+	t.Helper()
+	ev := nextStatus(t, events, task, nil)
+	if ev.Status != want {
+		t.Fatalf("%s status = %q (%+v), want %q", task, ev.Status, ev, want)
+	}
+	return ev
+}
+
+// waitStarted waits for n timers to have started on clock, failing the
+// test if they don't in time
+func waitStarted(t *testing.T, clock *fakeClock, n int) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		clock.WaitStarted(n)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(eventWait):
+		t.Fatalf("%d timers not started within %s", n, eventWait)
+	}
+}
+
+// startRunner runs r in the background with an event channel; the returned
+// channel gets Run's error
+func startRunner(ctx context.Context, r *Runner) (<-chan LogEvent, <-chan error) {
+	events := make(chan LogEvent, 1000)
+	r.SetEventChannel(events)
+	result := make(chan error, 1)
+	go func() { result <- r.Run(ctx) }()
+	return events, result
+}
+
+// waitRun returns the error of Run, failing the test if it doesn't return in time
+func waitRun(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(eventWait):
+		t.Fatalf("Run did not return within %s", eventWait)
+		return nil
+	}
+}
+
+func TestRunCompletes(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["ok"]
+
+[task.ok]
+cmd = "{fixture} -lines 3"
+`)
+	r := New(cfg, cfg.Tasks, false)
+	events, result := startRunner(context.Background(), r)
+
+	running := expectStatus(t, events, "ok", StatusRunning)
+	if running.Pid == 0 {
+		t.Errorf("running event has no pid")
+	}
+	var lines []LogEvent
+	done := nextStatus(t, events, "ok", &lines)
+	if done.Status != StatusDone || done.ExitCode != 0 {
+		t.Errorf("final status = %q with code %d, want done with code 0", done.Status, done.ExitCode)
+	}
+
+	// Every line comes between running and done, in order, from that process
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	for i, ev := range lines {
+		if want := fmt.Sprintf("line %d", i+1); ev.Line != want {
+			t.Errorf("line %d = %q, want %q", i, ev.Line, want)
+		}
+		if ev.Pid != running.Pid {
+			t.Errorf("line %d has pid %d, want %d", i, ev.Pid, running.Pid)
+		}
+	}
+
+	if err := waitRun(t, result); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res := r.Results(); len(res) != 1 || res[0].Outcome != OutcomeSucceeded {
+		t.Errorf("results = %+v, want ok succeeded", res)
+	}
+}
+
+func TestRunFailureCancelsOthers(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["bad", "slow"]
+
+[task.bad]
+cmd = "{fixture} -delay 200ms -exit 4"
+
+[task.slow]
+cmd = "{fixture} -sleep 1h"
+`)
+	r := New(cfg, cfg.Tasks, false)
+	_, result := startRunner(context.Background(), r)
+
+	err := waitRun(t, result)
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("Run error = %v, want a *RunError", err)
+	}
+	outcomes := map[string]TaskResult{}
+	for _, res := range runErr.Results {
+		outcomes[res.Task] = res
+	}
+	if bad := outcomes["bad"]; bad.Outcome != OutcomeFailed || bad.ExitCode != 4 {
+		t.Errorf("bad = %+v, want failed with code 4", bad)
+	}
+	if slow := outcomes["slow"]; slow.Outcome != OutcomeCancelled {
+		t.Errorf("slow = %+v, want cancelled", slow)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["slow"]
+
+[task.slow]
+cmd = "{fixture} -sleep 1h"
+`)
+	r := New(cfg, cfg.Tasks, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, result := startRunner(ctx, r)
+
+	expectStatus(t, events, "slow", StatusRunning)
+	cancel()
+	if err := waitRun(t, result); err != nil {
+		t.Fatalf("Run after cancel: %v", err)
+	}
+	if res := r.Results(); len(res) != 1 || res[0].Outcome != OutcomeCancelled {
+		t.Errorf("results = %+v, want slow cancelled", res)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["crash"]
+
+[task.crash]
+cmd = "{fixture} -exit 3"
+restart = "on-failure"
+max_restarts = 2
+`)
+	clock := newFakeClock()
+	r := New(cfg, cfg.Tasks, false)
+	r.clock = clock
+	events, result := startRunner(context.Background(), r)
+
+	for attempt, backoff := range []time.Duration{minRestartBackoff, 2 * minRestartBackoff} {
+		running := expectStatus(t, events, "crash", StatusRunning)
+		if running.Restarts != attempt {
+			t.Errorf("run %d has restarts %d", attempt+1, running.Restarts)
+		}
+		expectStatus(t, events, "crash", StatusFailed)
+		retrying := expectStatus(t, events, "crash", StatusRetrying)
+		if retrying.Attempt != attempt+1 || retrying.MaxAttempts != 2 {
+			t.Errorf("retrying attempt %d/%d, want %d/2", retrying.Attempt, retrying.MaxAttempts, attempt+1)
+		}
+		if wait := retrying.Until.Sub(clock.Now()); wait != backoff {
+			t.Errorf("restart %d waits %s, want %s", attempt+1, wait, backoff)
+		}
+
+		// Nothing restarts until the backoff is over
+		waitStarted(t, clock, attempt+1)
+		clock.Advance(backoff - time.Millisecond)
+		select {
+		case ev := <-events:
+			if ev.Status == StatusRunning {
+				t.Fatalf("restarted %s early", time.Millisecond)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+		clock.Advance(time.Millisecond)
+	}
+
+	expectStatus(t, events, "crash", StatusRunning)
+	expectStatus(t, events, "crash", StatusFailed)
+	var runErr *RunError
+	if err := waitRun(t, result); !errors.As(err, &runErr) {
+		t.Fatalf("Run error = %v, want a *RunError after giving up", err)
+	}
+}
+
+func TestRestartBackoffResetsAfterHealthyRun(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["crash"]
+
+[task.crash]
+cmd = "{fixture} -delay 300ms -exit 3"
+restart = "on-failure"
+max_restarts = 1
+`)
+	clock := newFakeClock()
+	r := New(cfg, cfg.Tasks, false)
+	r.clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, result := startRunner(ctx, r)
+
+	// A quick crash is the first restart in a row
+	expectStatus(t, events, "crash", StatusRunning)
+	expectStatus(t, events, "crash", StatusFailed)
+	expectStatus(t, events, "crash", StatusRetrying)
+	waitStarted(t, clock, 1)
+	clock.Advance(minRestartBackoff)
+
+	// A run outlasting the longest backoff ends the row, so the next crash
+	// neither uses up max_restarts nor backs off further
+	expectStatus(t, events, "crash", StatusRunning)
+	clock.Advance(maxRestartBackoff + time.Second)
+	expectStatus(t, events, "crash", StatusFailed)
+	retrying := expectStatus(t, events, "crash", StatusRetrying)
+	if retrying.Attempt != 1 {
+		t.Errorf("restart after a healthy run is attempt %d, want 1", retrying.Attempt)
+	}
+	if wait := retrying.Until.Sub(clock.Now()); wait != minRestartBackoff {
+		t.Errorf("restart after a healthy run waits %s, want %s", wait, minRestartBackoff)
+	}
+	cancel()
+	if err := waitRun(t, result); err != nil {
+		t.Errorf("Run cancelled during backoff: %v", err)
+	}
+}
+
+func TestRestartDelayOverridesBackoff(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["crash"]
+
+[task.crash]
+cmd = "{fixture} -exit 3"
+restart = "on-failure"
+restart_delay = "7s"
+max_restarts = 1
+`)
+	clock := newFakeClock()
+	r := New(cfg, cfg.Tasks, false)
+	r.clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, result := startRunner(ctx, r)
+
+	expectStatus(t, events, "crash", StatusRunning)
+	expectStatus(t, events, "crash", StatusFailed)
+	retrying := expectStatus(t, events, "crash", StatusRetrying)
+	if wait := retrying.Until.Sub(clock.Now()); wait != 7*time.Second {
+		t.Errorf("restart waits %s, want 7s", wait)
+	}
+	cancel()
+	if err := waitRun(t, result); err != nil {
+		t.Errorf("Run cancelled during backoff: %v", err)
+	}
+}
+
+func TestRestartCountsArePerTask(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["a", "b"]
+
+[task.a]
+cmd = "{fixture} -text \"restart $PRUN_RESTART_COUNT\" -exit 3"
+restart = "on-failure"
+max_restarts = 3
+restart_delay = "10ms"
+
+[task.b]
+cmd = "{fixture} -delay 5ms -text \"restart $PRUN_RESTART_COUNT\" -exit 3"
+restart = "on-failure"
+max_restarts = 3
+restart_delay = "10ms"
+`)
+	r := New(cfg, cfg.Tasks, false)
+	r.SetContinueOnError(true) // the first to give up doesn't cut the other short
+	events, result := startRunner(context.Background(), r)
+	if err := waitRun(t, result); err == nil {
+		t.Fatal("Run succeeded, want the tasks to fail after their restarts")
+	}
+
+	// Each task counts its own restarts, whatever the other does. Run has
+	// returned, so every event is in the channel.
+	lines := map[string][]string{}
+	runs := map[string][]int{}
+	for len(events) > 0 {
+		switch ev := <-events; {
+		case ev.Status == StatusRunning:
+			runs[ev.Task] = append(runs[ev.Task], ev.Restarts)
+		case !ev.IsStatus() && strings.HasPrefix(ev.Line, "restart "):
+			lines[ev.Task] = append(lines[ev.Task], ev.Line)
+		}
+	}
+	for _, task := range []string{"a", "b"} {
+		if got, want := fmt.Sprint(lines[task]), "[restart 0 restart 1 restart 2 restart 3]"; got != want {
+			t.Errorf("%s printed %s, want %s", task, got, want)
+		}
+		if got, want := fmt.Sprint(runs[task]), "[0 1 2 3]"; got != want {
+			t.Errorf("%s ran with restarts %s, want %s", task, got, want)
+		}
+	}
+}
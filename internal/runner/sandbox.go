@@ -0,0 +1,37 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// SandboxHelper is the first argument of prun re-executed to set up a task's
+// sandbox from the inside before running the task's command in it
+const SandboxHelper = "__sandbox"
+
+// errSandboxUnsupported is returned where prun has no way to sandbox a task
+var errSandboxUnsupported = errors.New("sandboxing isn't supported on " + runtime.GOOS)
+
+// applySandbox confines cmd as the task's sandbox setting asks. A sandbox
+// that can't be set up fails the task, unless sandbox_optional lets it run
+// without one after a warning.
+func (r *Runner) applySandbox(cmd *exec.Cmd, taskName string) error {
+	taskDef := r.cfg.TaskDefs[taskName]
+	if taskDef.Sandbox == nil {
+		return nil
+	}
+	paths, err := r.cfg.SandboxWritePaths(taskName)
+	if err == nil {
+		err = sandboxCommand(cmd, paths, taskDef.Sandbox.NetworkAllowed())
+	}
+	if err == nil {
+		return nil
+	}
+	if taskDef.SandboxOptional {
+		r.warn(taskName, fmt.Sprintf("running without a sandbox: %v", err))
+		return nil
+	}
+	return fmt.Errorf("sandbox: %w (set sandbox_optional = true to run without one)", err)
+}
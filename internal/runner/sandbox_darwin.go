@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sandboxCommand runs cmd under sandbox-exec with a profile denying writes
+// outside writePaths and, unless network is allowed, all network access
+func sandboxCommand(cmd *exec.Cmd, writePaths []string, network bool) error {
+	sandboxExec, err := exec.LookPath("sandbox-exec")
+	if err != nil {
+		return err
+	}
+	if cmd.Err != nil {
+		return nil // the command can't be found; Start reports it
+	}
+	cmd.Path = sandboxExec
+	cmd.Args = append([]string{sandboxExec, "-p", sandboxProfile(writePaths, network)}, cmd.Args...)
+	return nil
+}
+
+// sandboxProfile writes the Seatbelt profile of a sandbox. Paths are
+// resolved, as the profile matches real paths (/private/tmp, not /tmp).
+func sandboxProfile(writePaths []string, network bool) string {
+	var b strings.Builder
+	b.WriteString("(version 1)\n(allow default)\n(deny file-write*)\n")
+	b.WriteString(`(allow file-write* (subpath "/dev")`)
+	for _, p := range writePaths {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			p = real
+		}
+		fmt.Fprintf(&b, ` (subpath %s)`, profileString(p))
+	}
+	b.WriteString(")\n")
+	if !network {
+		b.WriteString("(deny network*)\n")
+	}
+	return b.String()
+}
+
+// profileString quotes s as a string of the profile language
+func profileString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// RunSandboxHelper only exists on Linux, where the sandbox is set up from
+// the inside
+func RunSandboxHelper(args []string) int {
+	fmt.Fprintln(os.Stderr, "sandbox: the helper only runs on Linux")
+	return 126
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// The sandbox runs the task in new user and mount namespaces, and a network
+// namespace without interfaces when network is off. Being root in its own
+// user namespace, prun's helper remounts the whole tree read-only except
+// for the write paths, then executes the task's command.
+
+// sandboxProbe records whether this system lets prun create the namespaces,
+// found out once by starting the helper with nothing to run
+var sandboxProbe struct {
+	once sync.Once
+	err  error
+}
+
+// sandboxCommand makes cmd start through the helper in fresh namespaces
+func sandboxCommand(cmd *exec.Cmd, writePaths []string, network bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	sandboxProbe.once.Do(func() {
+		probe := exec.Command(exe, SandboxHelper, "-probe")
+		probe.SysProcAttr = &syscall.SysProcAttr{}
+		namespaces(probe.SysProcAttr, false)
+		if out, err := probe.CombinedOutput(); err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				sandboxProbe.err = errors.New(msg)
+			} else {
+				sandboxProbe.err = fmt.Errorf("can't create user and mount namespaces (%v); unprivileged user namespaces may be disabled, e.g. inside a container", err)
+			}
+		}
+	})
+	if sandboxProbe.err != nil {
+		return sandboxProbe.err
+	}
+	if cmd.Err != nil {
+		return nil // the command can't be found; Start reports it
+	}
+
+	args := []string{exe, SandboxHelper}
+	for _, p := range writePaths {
+		args = append(args, "-write", p)
+	}
+	cmd.Path = exe
+	cmd.Args = append(append(args, "--"), cmd.Args...)
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	namespaces(cmd.SysProcAttr, network)
+	return nil
+}
+
+// namespaces sets attr up to start a process as root in a new user
+// namespace, mapped to prun's own user, with its own mounts and, unless
+// network is allowed, no network
+func namespaces(attr *syscall.SysProcAttr, network bool) {
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS
+	if !network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+}
+
+// RunSandboxHelper is prun started by sandboxCommand inside the task's new
+// namespaces. It makes everything but the write paths read-only and replaces
+// itself with the task's command, returning an exit code only on failure.
+func RunSandboxHelper(args []string) int {
+	fs := flag.NewFlagSet(SandboxHelper, flag.ContinueOnError)
+	var writePaths []string
+	fs.Func("write", "a path the task may write", func(p string) error {
+		writePaths = append(writePaths, p)
+		return nil
+	})
+	probe := fs.Bool("probe", false, "set up an empty sandbox and exit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := confine(writePaths); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 126
+	}
+	if *probe || fs.NArg() == 0 {
+		return 0
+	}
+
+	path, err := exec.LookPath(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 127
+	}
+	err = syscall.Exec(path, fs.Args(), os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	return 126
+}
+
+// confine remounts the filesystem read-only, except for writePaths
+func confine(writePaths []string) error {
+	// The working directory is looked up again afterwards, so that one
+	// inside a write path resolves to its writable mount
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// Keep the changes below to this mount namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("can't make mounts private: %w", err)
+	}
+	for _, p := range writePaths {
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				return fmt.Errorf("write path %s doesn't exist", p)
+			}
+			return fmt.Errorf("can't mount write path %s: %w", p, err)
+		}
+	}
+
+	readOnly := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := unix.MountSetattr(unix.AT_FDCWD, "/", unix.AT_RECURSIVE, readOnly); err != nil {
+		return fmt.Errorf("can't make the filesystem read-only (needs Linux 5.12 or later): %w", err)
+	}
+	writable := &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY}
+	for _, p := range writePaths {
+		if err := unix.MountSetattr(unix.AT_FDCWD, p, unix.AT_RECURSIVE, writable); err != nil {
+			return fmt.Errorf("can't make write path %s writable: %w", p, err)
+		}
+	}
+	return os.Chdir(cwd)
+}
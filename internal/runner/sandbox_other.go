@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func sandboxCommand(cmd *exec.Cmd, writePaths []string, network bool) error {
+	return errSandboxUnsupported
+}
+
+// RunSandboxHelper only exists on Linux, where the sandbox is set up from
+// the inside
+func RunSandboxHelper(args []string) int {
+	fmt.Fprintln(os.Stderr, "sandbox: the helper only runs on Linux")
+	return 126
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// socketSet owns the listening sockets handed to tasks through pass_fds.
+// Each address is bound once and stays open across task restarts, so a
+// restarted server inherits the same socket and no connection is refused
+// while it comes back up.
+type socketSet struct {
+	mu      sync.Mutex
+	sockets map[string]*socket
+}
+
+type socket struct {
+	listener net.Listener
+	file     *os.File // duplicate of the listener's fd, passed to tasks
+}
+
+func newSocketSet() *socketSet {
+	return &socketSet{sockets: make(map[string]*socket)}
+}
+
+// Get returns the file of the socket listening on addr, binding it on first use
+func (s *socketSet) Get(addr string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sock, ok := s.sockets[addr]; ok {
+		return sock.file, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to pass socket %s: %w", addr, err)
+	}
+	s.sockets[addr] = &socket{listener: ln, file: file}
+	return file, nil
+}
+
+// Close closes every socket
+func (s *socketSet) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr, sock := range s.sockets {
+		sock.file.Close()
+		sock.listener.Close()
+		delete(s.sockets, addr)
+	}
+}
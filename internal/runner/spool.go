@@ -0,0 +1,219 @@
+package runner
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// A terminal can stop taking output, under Ctrl-S or over a stalled SSH
+// connection. Task output then waits in a spool of up to spoolLines lines
+// per task, written to the terminal by a goroutine of its own, so the
+// goroutines reading the tasks' pipes don't block on it. A task that
+// outgrows its spool waits for room while the terminal keeps up, however
+// slowly, but once a write has been stuck for spoolStall it loses lines
+// from the middle of its spool instead, replaced by truncatedMarker. Log
+// files and events still get every line.
+const (
+	spoolLines      = 2000
+	spoolStall      = 500 * time.Millisecond
+	truncatedMarker = "[… output truncated while terminal was blocked …]"
+
+	// How long prun's own messages and lifecycle lines wait to be written
+	// before their writer moves on, leaving them queued
+	spoolWait = 250 * time.Millisecond
+
+	// How long a flush waits for the writer to make progress before the
+	// task output left in the queue is dropped for a marker
+	spoolFlushWait = 2 * time.Second
+)
+
+// spool queues what an outputWriter writes, in order, for its writer
+// goroutine. Lines of task output are queued per task, and may be dropped;
+// everything else is queued under the system task and never is.
+type spool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  map[string][]spooled // by task
+	queued  int
+	next    uint64    // sequence number of the next write queued
+	written uint64    // every write numbered below this is done or dropped
+	moved   time.Time // when the writer last finished a write, or found one after idling
+	started bool
+	line    func(ev LogEvent) // writes a line of task output
+}
+
+// spooled is one queued write: a line of task output, a marker standing in
+// for the lines dropped after it, or some other write
+type spooled struct {
+	seq     uint64
+	ev      LogEvent
+	dropped int    // a marker for this many dropped lines
+	write   func() // anything but a line
+}
+
+func newSpool(line func(ev LogEvent)) *spool {
+	s := &spool{queues: make(map[string][]spooled), moved: time.Now(), line: line}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// addLine queues a line of task output without waiting for it to be
+// written. If the task's queue is full, it waits for room while the writer
+// makes progress, or drops a line from the middle of the queue once it is
+// stuck.
+func (s *spool) addLine(ev LogEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queues[ev.Task]) >= spoolLines {
+		stuck := time.Since(s.moved)
+		if stuck >= spoolStall {
+			s.drop(s.queues[ev.Task])
+			break
+		}
+		s.wait(spoolStall - stuck)
+	}
+	s.queues[ev.Task] = append(s.queues[ev.Task], spooled{seq: s.next, ev: ev})
+	s.push()
+}
+
+// add queues a write and waits for it to be done, for at most spoolWait
+func (s *spool) add(write func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := s.next
+	s.queues[SystemTask] = append(s.queues[SystemTask], spooled{seq: seq, write: write})
+	s.push()
+
+	deadline := time.Now().Add(spoolWait)
+	for s.written <= seq && time.Now().Before(deadline) {
+		s.wait(time.Until(deadline))
+	}
+}
+
+// wait waits for the writer to make progress, or for d to pass; s.mu must
+// be held
+func (s *spool) wait(d time.Duration) {
+	timeout := time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer timeout.Stop()
+	s.cond.Wait()
+}
+
+// push counts the write just queued and wakes the writer, starting it the
+// first time; s.mu must be held
+func (s *spool) push() {
+	s.next++
+	s.queued++
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	s.cond.Broadcast()
+}
+
+// drop makes room in a full queue of task output: the line in the middle
+// becomes a marker, or, once there is one, the line after the marker is
+// dropped and counted in it. s.mu must be held.
+func (s *spool) drop(q []spooled) {
+	marker := slices.IndexFunc(q, func(e spooled) bool { return e.dropped > 0 })
+	if marker < 0 || marker == len(q)-1 {
+		q[len(q)/2].dropped = 1
+		return
+	}
+	q[marker].dropped++
+	s.queues[q[0].ev.Task] = slices.Delete(q, marker+1, marker+2)
+	s.queued--
+}
+
+// run writes what is queued, oldest first, as fast as the terminal takes it
+func (s *spool) run() {
+	defer RecoverPanic()
+	for {
+		s.mu.Lock()
+		idle := false
+		for s.queued == 0 {
+			s.written = s.next
+			s.cond.Broadcast()
+			s.cond.Wait()
+			idle = true
+		}
+		if idle {
+			s.moved = time.Now()
+		}
+		task := ""
+		for name, q := range s.queues {
+			if len(q) > 0 && (task == "" || q[0].seq < s.queues[task][0].seq) {
+				task = name
+			}
+		}
+		e := s.queues[task][0]
+		s.queues[task] = s.queues[task][1:]
+		s.queued--
+		s.mu.Unlock()
+
+		switch {
+		case e.write != nil:
+			e.write()
+		case e.dropped > 0:
+			ev := e.ev
+			ev.Line, ev.IsErr = truncatedMarker, false
+			s.line(ev)
+		default:
+			s.line(e.ev)
+		}
+
+		s.mu.Lock()
+		s.written = e.seq + 1
+		s.moved = time.Now()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// flush waits until everything queued so far is written, as long as the
+// writer keeps making progress. Once it has been stuck for spoolFlushWait,
+// the lines of task output still queued give way to a marker per task, so
+// a blocked terminal only has those left to take.
+func (s *spool) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := s.next
+	start := time.Now()
+	for s.written < target {
+		stuck := time.Since(latest(s.moved, start))
+		if stuck >= spoolFlushWait {
+			s.truncate()
+			return
+		}
+		s.wait(spoolFlushWait - stuck)
+	}
+}
+
+// latest returns the later of two times
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// truncate replaces the queued lines of each task with one marker for them
+// all; s.mu must be held
+func (s *spool) truncate() {
+	for task, q := range s.queues {
+		if task == SystemTask || len(q) == 0 {
+			continue
+		}
+		marker := q[0]
+		marker.dropped = 0
+		for _, e := range q {
+			marker.dropped += max(e.dropped, 1)
+		}
+		s.queues[task] = []spooled{marker}
+		s.queued -= len(q) - 1
+	}
+}
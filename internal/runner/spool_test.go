@@ -0,0 +1,174 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledTerminal stands for a terminal that takes nothing until released
+type stalledTerminal struct {
+	release chan struct{}
+	lines   chan string
+}
+
+func newStalledTerminal() *stalledTerminal {
+	return &stalledTerminal{release: make(chan struct{}), lines: make(chan string, 1000)}
+}
+
+func (st *stalledTerminal) line(ev LogEvent) {
+	<-st.release
+	st.lines <- ev.Line
+}
+
+// written returns what the terminal got, once it has been idle for a while
+func (st *stalledTerminal) written() []string {
+	var got []string
+	for {
+		select {
+		case line := <-st.lines:
+			got = append(got, line)
+		case <-time.After(200 * time.Millisecond):
+			return got
+		}
+	}
+}
+
+func TestSpoolFlushWaitsForSlowTerminal(t *testing.T) {
+	st := newStalledTerminal()
+	close(st.release)
+	s := newSpool(func(ev LogEvent) {
+		time.Sleep(time.Millisecond)
+		st.line(ev)
+	})
+	for i := range 100 {
+		s.addLine(LogEvent{Task: "web", Line: fmt.Sprintf("line %d", i)})
+	}
+	s.flush()
+	if got := len(st.lines); got != 100 {
+		t.Errorf("flush returned with %d of 100 lines written", got)
+	}
+}
+
+func TestSpoolFlushGivesUpOnBlockedTerminal(t *testing.T) {
+	st := newStalledTerminal()
+	s := newSpool(st.line)
+	for i := range 10 {
+		s.addLine(LogEvent{Task: "web", Line: fmt.Sprintf("line %d", i)})
+	}
+	s.addLine(LogEvent{Task: "api", Line: "api line"})
+
+	start := time.Now()
+	s.flush()
+	if took := time.Since(start); took < spoolFlushWait || took > spoolFlushWait+time.Second {
+		t.Errorf("flush returned after %s, want %s", took, spoolFlushWait)
+	}
+
+	// The line being written when the terminal blocked still goes out, then
+	// a marker for each task's lines
+	close(st.release)
+	got := st.written()
+	want := []string{"line 0", truncatedMarker, truncatedMarker}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("terminal got %q, want %q", got, want)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued != 0 {
+		t.Errorf("%d writes still queued", s.queued)
+	}
+}
+
+func TestSpoolDropsMiddleForBlockedTerminal(t *testing.T) {
+	st := newStalledTerminal()
+	s := newSpool(st.line)
+
+	// The writer takes the first line and blocks on it
+	s.addLine(LogEvent{Task: "web", Line: "line 0"})
+	for {
+		s.mu.Lock()
+		queued := s.queued
+		s.mu.Unlock()
+		if queued == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Filling the queue doesn't wait; the line after it waits for the
+	// writer to be stuck for spoolStall, and those after that not at all
+	const total = spoolLines + 500
+	start := time.Now()
+	var slowest time.Duration
+	for i := 1; i < total; i++ {
+		lineStart := time.Now()
+		s.addLine(LogEvent{Task: "web", Line: fmt.Sprintf("line %d", i)})
+		slowest = max(slowest, time.Since(lineStart))
+	}
+	if took := time.Since(start); slowest > spoolStall+250*time.Millisecond || took > spoolStall+time.Second {
+		t.Errorf("queueing took %s, the slowest line %s; want about %s", took, slowest, spoolStall)
+	}
+
+	// Half the queue is kept at the head, half at the tail, and the marker
+	// between them counts the lines it stands for, itself included
+	dropped := total - spoolLines - 1
+	s.mu.Lock()
+	if q := s.queues["web"]; len(q) != spoolLines+1 || q[spoolLines/2].dropped != dropped {
+		t.Errorf("queue of %d with a marker for %d lines, want %d with one for %d", len(q), q[spoolLines/2].dropped, spoolLines+1, dropped)
+	}
+	s.mu.Unlock()
+
+	close(st.release)
+	got := st.written()
+	var want []string
+	for i := 0; i <= spoolLines/2; i++ {
+		want = append(want, fmt.Sprintf("line %d", i))
+	}
+	want = append(want, truncatedMarker)
+	for i := spoolLines/2 + 1 + dropped; i < total; i++ {
+		want = append(want, fmt.Sprintf("line %d", i))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("terminal got %d lines, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSpoolKeepsEveryLineForSlowTerminal(t *testing.T) {
+	// A writer slower than the task, pausing now and then for less than
+	// spoolStall, holds the task back but loses nothing
+	var mu sync.Mutex
+	var got []string
+	s := newSpool(func(ev LogEvent) {
+		mu.Lock()
+		n := len(got)
+		got = append(got, ev.Line)
+		mu.Unlock()
+		if n%1000 == 999 {
+			time.Sleep(spoolStall / 2)
+		} else {
+			time.Sleep(100 * time.Microsecond)
+		}
+	})
+	const total = spoolLines + 500
+	for i := range total {
+		s.addLine(LogEvent{Task: "web", Line: fmt.Sprintf("line %d", i)})
+	}
+	s.flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != total {
+		t.Fatalf("terminal got %d of %d lines", len(got), total)
+	}
+	for i, line := range got {
+		if want := fmt.Sprintf("line %d", i); line != want {
+			t.Fatalf("line %d = %q, want %q", i, line, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// errNoInput is returned by SendInput and CloseInput when tasks were started
+// without a stdin pipe, as they are outside the TUI
+var errNoInput = errors.New("input can only be sent to tasks run under the TUI")
+
+// stdinPipes holds the write end of the stdin of each task's running
+// process, for the TUI to type into. The runners of a watcher share one.
+type stdinPipes struct {
+	mu    sync.Mutex
+	pipes map[string]io.WriteCloser
+}
+
+func newStdinPipes() *stdinPipes {
+	return &stdinPipes{pipes: make(map[string]io.WriteCloser)}
+}
+
+// set makes w the stdin input of a task goes to
+func (s *stdinPipes) set(taskName string, w io.WriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pipes[taskName] = w
+}
+
+// remove forgets a task's stdin once its process has exited, unless a later
+// run replaced it
+func (s *stdinPipes) remove(taskName string, w io.WriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pipes[taskName] == w {
+		delete(s.pipes, taskName)
+	}
+}
+
+func (s *stdinPipes) get(taskName string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.pipes[taskName]
+	if !ok {
+		return nil, fmt.Errorf("task '%s' is not running", taskName)
+	}
+	return w, nil
+}
+
+// write sends input to a task's stdin. It blocks while the task doesn't
+// read and the pipe is full, so it happens outside the lock.
+func (s *stdinPipes) write(taskName string, input []byte) error {
+	w, err := s.get(taskName)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(input); err != nil {
+		return fmt.Errorf("task '%s' doesn't take input: %w", taskName, err)
+	}
+	return nil
+}
+
+// close ends a task's input, which it reads as end of file
+func (s *stdinPipes) close(taskName string) error {
+	w, err := s.get(taskName)
+	if err != nil {
+		return err
+	}
+	s.remove(taskName, w)
+	return w.Close()
+}
+
+// SetStdin gives each task a stdin pipe that SendInput writes to, in place
+// of /dev/null. A task reading stdin then waits for input, or CloseInput,
+// instead of seeing end of file.
+func (r *Runner) SetStdin(on bool) {
+	r.stdin = nil
+	if on {
+		r.stdin = newStdinPipes()
+	}
+}
+
+// SendInput writes input to the stdin of a task's running process
+func (r *Runner) SendInput(taskName string, input []byte) error {
+	if r.stdin == nil {
+		return errNoInput
+	}
+	return r.stdin.write(taskName, input)
+}
+
+// CloseInput closes the stdin of a task's running process. A process
+// started later, after a restart, gets a new one.
+func (r *Runner) CloseInput(taskName string) error {
+	if r.stdin == nil {
+		return errNoInput
+	}
+	return r.stdin.close(taskName)
+}
+
+// attachStdin connects a command's stdin to a pipe registered for the task,
+// if SetStdin asked for one. The returned func forgets it once the command
+// has exited.
+func (r *Runner) attachStdin(cmd *exec.Cmd, taskName string) (func(), error) {
+	if r.stdin == nil {
+		return func() {}, nil
+	}
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	r.stdin.set(taskName, w)
+	return func() { r.stdin.remove(taskName, w) }, nil
+}
+
+// SetStdin gives each task a stdin pipe; see Runner.SetStdin
+func (w *Watcher) SetStdin(on bool) {
+	w.stdin = nil
+	if on {
+		w.stdin = newStdinPipes()
+	}
+}
+
+// SendInput writes input to the stdin of a task's current run
+func (w *Watcher) SendInput(taskName string, input []byte) error {
+	if w.stdin == nil {
+		return errNoInput
+	}
+	return w.stdin.write(taskName, input)
+}
+
+// CloseInput closes the stdin of a task's current run; the next run, after
+// a restart, gets a new one
+func (w *Watcher) CloseInput(taskName string) error {
+	if w.stdin == nil {
+		return errNoInput
+	}
+	return w.stdin.close(taskName)
+}
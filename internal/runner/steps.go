@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// stepRetryDelay is how long a failed step waits before it is rerun
+const stepRetryDelay = time.Second
+
+// stepLabel names a step of a task in console prefixes, e.g. "web:2/3", or
+// the task itself when step is 0
+func stepLabel(taskName string, step, steps int) string {
+	if step == 0 {
+		return taskName
+	}
+	return taskName + ":" + strconv.Itoa(step) + "/" + strconv.Itoa(steps)
+}
+
+// eventLabel is the prefix an event is printed under
+func eventLabel(ev LogEvent) string {
+	if ev.Ref != "" {
+		return ev.Ref
+	}
+	return stepLabel(ev.Task, ev.Step, ev.Steps)
+}
+
+// StepLabels returns the prefixes a task's output may appear under: its
+// name, and one per step if it has steps
+func StepLabels(taskName string, steps int) []string {
+	labels := []string{taskName}
+	for i := 1; i <= steps; i++ {
+		labels = append(labels, stepLabel(taskName, i, steps))
+	}
+	return labels
+}
+
+// runSteps runs a task's steps one after another, stopping at the first that
+// fails for good, and returns how the last one it ran ended. A restart
+// resumes from restart_from_step.
+func (r *Runner) runSteps(ctx context.Context, taskName string, env []string, out *taskOutput) runResult {
+	taskDef := r.cfg.TaskDefs[taskName]
+	first := 0
+	if out.run.restarts > 0 && taskDef.RestartFromStep > 0 {
+		first = taskDef.RestartFromStep - 1
+	}
+
+	var res runResult
+	for i := first; i < len(taskDef.Steps); i++ {
+		st := stepRun{
+			index:   i + 1,
+			count:   len(taskDef.Steps),
+			timeout: taskDef.TimeoutFor(i),
+			next:    i > first,
+		}
+		res = r.runStep(ctx, taskName, taskDef.Steps[i].Cmd, taskDef.RetriesFor(i), env, out, st)
+		if res.status == StatusDone && st.index < st.count {
+			r.stepDone(taskName, out, res, st)
+			continue
+		}
+		r.finish(taskName, out, res, st)
+		if res.err != nil {
+			res.err = fmt.Errorf("step %d/%d: %w", st.index, st.count, res.err)
+		}
+		break
+	}
+	return res
+}
+
+// runStep runs one step, rerunning it up to retries times while it fails
+func (r *Runner) runStep(ctx context.Context, taskName, command string, retries int, env []string, out *taskOutput, st stepRun) runResult {
+	for attempt := 1; ; attempt++ {
+		res := r.runCommand(ctx, taskName, command, env, out, st)
+		if res.status != StatusFailed || attempt > retries || ctx.Err() != nil {
+			return res
+		}
+
+		r.emitStatus(LogEvent{
+			Task:        taskName,
+			Status:      StatusRetrying,
+			Attempt:     attempt,
+			MaxAttempts: retries,
+			Until:       time.Now().Add(stepRetryDelay),
+			Restarts:    out.run.restarts,
+			Step:        st.index,
+			Steps:       st.count,
+		})
+		select {
+		case <-ctx.Done():
+			return runResult{status: StatusStopped}
+		case <-time.After(stepRetryDelay):
+		}
+		st.next = true
+	}
+}
+
+// stepDone reports that a step other than the last finished, in the words of
+// a lifecycle line. Quiet console output leaves it out, and NDJSON, where the
+// next step's "running" record shows the same.
+func (r *Runner) stepDone(taskName string, out *taskOutput, res runResult, st stepRun) {
+	ev := LogEvent{
+		Task:     taskName,
+		Line:     fmt.Sprintf("%s step finished in %s", r.output.icons.Icon(StatusDone), FormatElapsed(res.elapsed)),
+		Time:     time.Now(),
+		Pid:      res.pid,
+		Restarts: out.run.restarts,
+		Step:     st.index,
+		Steps:    st.count,
+	}
+	switch {
+	case r.eventChan != nil:
+		r.eventChan <- ev
+	case r.quiet, r.output.json:
+	case r.groupOutput:
+		out.mu.Lock()
+		out.group = append(out.group, ev.Line)
+		out.mu.Unlock()
+	default:
+		r.output.WriteLine(ev)
+	}
+}
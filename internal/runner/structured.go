@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"prun/internal/config"
+)
+
+// structuredLine holds the fields extracted from a structured log line
+type structuredLine struct {
+	Level   string
+	Message string
+	Time    time.Time
+}
+
+// logRecord is a JSON log line split into the fields prun knows, by their
+// configured names, and the rest in the order they came
+type logRecord struct {
+	level, msg, time json.RawMessage
+	extra            []logField
+}
+
+// logField is one field of a JSON log line other than level, msg and time
+type logField struct {
+	key string
+	raw json.RawMessage
+}
+
+// decodeLogRecord splits a JSON object into its known fields and the rest,
+// which it finds by walking the top-level keys once the object is known to
+// be valid, so values are sliced from data rather than decoded. As with a
+// map, the last of repeated keys wins.
+func decodeLogRecord(data []byte, fields config.LogFields) (logRecord, bool) {
+	if !json.Valid(data) {
+		return logRecord{}, false
+	}
+	var rec logRecord
+	i := skipSpace(data, 1)
+	for data[i] != '}' {
+		end := stringEnd(data, i)
+		key := data[i:end]
+		i = skipSpace(data, end)
+		i = skipSpace(data, i+1) // past the colon
+		start := i
+		i = valueEnd(data, i)
+		raw := json.RawMessage(bytes.TrimSpace(data[start:i]))
+		if data[i] == ',' {
+			i = skipSpace(data, i+1)
+		}
+
+		var name string
+		if unquoted := key[1 : len(key)-1]; bytes.IndexByte(unquoted, '\\') < 0 && utf8.Valid(unquoted) {
+			name = string(unquoted)
+		} else {
+			_ = json.Unmarshal(key, &name) // escapes, or invalid UTF-8 to replace
+		}
+		switch name {
+		case fields.Level:
+			rec.level = raw
+		case fields.Msg:
+			rec.msg = raw
+		case fields.Time:
+			rec.time = raw
+		default:
+			rec.extra = append(rec.extra, logField{name, raw})
+		}
+	}
+	return rec, true
+}
+
+// skipSpace returns the index of the first non-space byte of data from i
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// stringEnd returns the index just past the JSON string starting at i
+func stringEnd(data []byte, i int) int {
+	for i++; data[i] != '"'; i++ {
+		if data[i] == '\\' {
+			i++
+		}
+	}
+	return i + 1
+}
+
+// valueEnd returns the index of the comma or closing brace ending the JSON
+// value starting at i
+func valueEnd(data []byte, i int) int {
+	depth := 0
+	for ; ; i++ {
+		switch data[i] {
+		case '"':
+			i = stringEnd(data, i) - 1
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+}
+
+// parseJSONLine extracts level, message and time from a JSON log line using
+// the configured field names. Fields other than those three are appended to
+// the message as key=value pairs, so nested payloads stay readable on one
+// line. ok is false when the line is not a JSON object, in which case callers
+// should treat it as plain text.
+func parseJSONLine(line string, fields config.LogFields) (structuredLine, bool) {
+	// Cheap rejection before allocating anything for non-JSON lines
+	trimmed := strings.TrimSpace(line)
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' {
+		return structuredLine{}, false
+	}
+
+	rec, ok := decodeLogRecord([]byte(trimmed), fields)
+	if !ok {
+		return structuredLine{}, false
+	}
+
+	var out structuredLine
+	if rec.level != nil {
+		out.Level = strings.ToLower(rawString(rec.level))
+	}
+	if rec.msg != nil {
+		out.Message = rawString(rec.msg)
+	}
+	if rec.time != nil {
+		if t, ok := parseLogTime(rec.time); ok {
+			out.Time = t
+		} else {
+			rec.extra = append(rec.extra, logField{fields.Time, rec.time})
+		}
+	}
+
+	if len(rec.extra) > 0 {
+		// Sorted, keeping only the last of repeated keys
+		slices.SortStableFunc(rec.extra, func(a, b logField) int { return strings.Compare(a.key, b.key) })
+		var b strings.Builder
+		b.WriteString(out.Message)
+		for i, f := range rec.extra {
+			if i+1 < len(rec.extra) && rec.extra[i+1].key == f.key {
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(f.key)
+			b.WriteByte('=')
+			b.WriteString(rawString(f.raw))
+		}
+		out.Message = b.String()
+	}
+	return out, true
+}
+
+// rawString returns a JSON string value unquoted, or any other value compacted
+func rawString(raw json.RawMessage) string {
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// parseLogTime accepts RFC 3339 strings and Unix timestamps in seconds or
+// milliseconds (as emitted by zap, zerolog and pino respectively)
+func parseLogTime(raw json.RawMessage) (time.Time, bool) {
+	if len(raw) > 0 && raw[0] == '"' {
+		t, err := time.Parse(time.RFC3339Nano, rawString(raw))
+		return t, err == nil
+	}
+
+	f, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if f > 1e12 {
+		f /= 1000 // milliseconds
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// formatStructured renders a parsed line for display, e.g. "WARN disk almost full"
+func formatStructured(s structuredLine) string {
+	if s.Level == "" {
+		return s.Message
+	}
+	return fmt.Sprintf("%s %s", strings.ToUpper(s.Level), s.Message)
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"prun/internal/config"
+)
+
+var testLogFields = config.LogFields{Level: "level", Msg: "msg", Time: "time"}
+
+func TestParseJSONLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want structuredLine
+		ok   bool
+	}{
+		{line: `listening on :8080`},
+		{line: `{not json}`},
+		{line: `{"msg": "a"} {"msg": "b"}`},
+		{
+			line: `{"level":"WARN","msg":"disk almost full","time":"2026-10-17T09:30:00Z"}`,
+			want: structuredLine{Level: "warn", Message: "disk almost full", Time: time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)},
+			ok:   true,
+		},
+		{
+			line: `  {"msg":"request","status":200,"path":"/api","user":{"id": 7}}  `,
+			want: structuredLine{Message: "request path=/api status=200 user={\"id\":7}"},
+			ok:   true,
+		},
+		{
+			line: `{"level":"info","msg":"tick","time":1792229400000}`,
+			want: structuredLine{Level: "info", Message: "tick", Time: time.Unix(1792229400, 0)},
+			ok:   true,
+		},
+		{
+			line: `{"msg":"odd clock","time":"yesterday"}`,
+			want: structuredLine{Message: "odd clock time=yesterday"},
+			ok:   true,
+		},
+		{
+			line: `{"msg":"first","msg":"second","n":1,"n":2}`,
+			want: structuredLine{Message: "second n=2"},
+			ok:   true,
+		},
+		{
+			line: `{ "msg" : "a, b}" , "tags" : ["x", {"y": "]"}] , "say\"hi" : 1 }`,
+			want: structuredLine{Message: `a, b} say"hi=1 tags=["x",{"y":"]"}]`},
+			ok:   true,
+		},
+		{
+			line: `{"m\u0073g":"escaped key"}`,
+			want: structuredLine{Message: "escaped key"},
+			ok:   true,
+		},
+		{line: `{}`, ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := parseJSONLine(tt.line, testLogFields)
+		if ok != tt.ok || got.Level != tt.want.Level || got.Message != tt.want.Message || !got.Time.Equal(tt.want.Time) {
+			t.Errorf("parseJSONLine(%s) = %+v, %t; want %+v, %t", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func FuzzDecodeLogRecord(f *testing.F) {
+	f.Add(`{"level":"info","msg":"hi","time":1,"n":[1,{"a":"}"}]}`)
+	f.Add(`{ "a\\u0062" : "x\\"y" , "c" : null }`)
+	f.Fuzz(func(t *testing.T, line string) {
+		// Every key and value must come out as encoding/json sees them
+		var want map[string]json.RawMessage
+		if json.Unmarshal([]byte(line), &want) != nil || !strings.HasPrefix(line, "{") {
+			return
+		}
+		rec, ok := decodeLogRecord([]byte(line), testLogFields)
+		if !ok {
+			t.Fatalf("not decoded: %s", line)
+		}
+		got := map[string]json.RawMessage{}
+		for _, f := range rec.extra {
+			got[f.key] = f.raw
+		}
+		for key, raw := range map[string]json.RawMessage{"level": rec.level, "msg": rec.msg, "time": rec.time} {
+			if raw != nil {
+				got[key] = raw
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("keys %v, want %v", got, want)
+		}
+		for key, raw := range want {
+			if rawString(got[key]) != rawString(raw) {
+				t.Errorf("%q = %s, want %s", key, got[key], raw)
+			}
+		}
+	})
+}
+
+func BenchmarkParseJSONLine(b *testing.B) {
+	line := `{"level":"info","ts":1792229400.123,"caller":"server/main.go:42","msg":"request served","method":"GET","path":"/api/users","status":200,"duration":0.0042}`
+	fields := config.LogFields{Level: "level", Msg: "msg", Time: "ts"}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, ok := parseJSONLine(line, fields); !ok {
+			b.Fatal("not parsed")
+		}
+	}
+}
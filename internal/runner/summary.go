@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+)
+
+// summaryTail is how many of a failed task's last lines the summary repeats
+const summaryTail = 10
+
+// PrintSummary writes what became of each task once the TUI has closed and
+// taken its screen with it: the lifecycle line of its last status, "stopped
+// by user" for one still running, and the last lines of one that failed.
+// events is a snapshot of the event store. It reports whether a task failed,
+// leaving out those with allow_failure.
+func PrintSummary(c *Console, tasks []string, events []LogEvent) bool {
+	last := make(map[string]LogEvent)
+	lines := make(map[string][]LogEvent)
+	for _, ev := range events {
+		if ev.IsStatus() {
+			last[ev.Task] = ev
+		} else if ev.Task != SystemTask {
+			lines[ev.Task] = append(lines[ev.Task], ev)
+		}
+	}
+
+	failed := false
+	for _, taskName := range tasks {
+		ev, ok := last[taskName]
+		switch {
+		case ok && (ev.Status == StatusDone || ev.Status == StatusStopped):
+			c.out.WriteStatus(ev)
+		case ok && ev.Status == StatusFailed:
+			failed = failed || !ev.Allowed
+			c.out.WriteStatus(ev)
+			// Only the run that failed, not those before a restart
+			var tail []LogEvent
+			for _, line := range lines[taskName] {
+				if line.Restarts == ev.Restarts {
+					tail = append(tail, line)
+				}
+			}
+			for _, line := range tail[max(0, len(tail)-summaryTail):] {
+				c.out.WriteLine(line)
+			}
+		default:
+			c.out.writeStoppedByUser(taskName)
+		}
+	}
+	return failed
+}
+
+// writeStoppedByUser writes the lifecycle line of a task that was still
+// running, or yet to start, when the user quit
+func (ow *outputWriter) writeStoppedByUser(taskName string) {
+	if ow.json {
+		return
+	}
+	ow.spool.add(func() {
+		ow.mu.Lock()
+		defer ow.mu.Unlock()
+		ow.writeStoppedNow(taskName)
+	})
+}
+
+// writeStoppedNow is writeStoppedByUser, bypassing the spool; ow.mu must be
+// held
+func (ow *outputWriter) writeStoppedNow(taskName string) {
+	line := ow.icons.Icon(StatusStopped) + " stopped by user"
+	if ow.color {
+		fmt.Fprintf(ow.writer, "%s%s%s%s\n", ow.lead(taskName, time.Now()), ansiGray, line, ansiReset)
+	} else {
+		fmt.Fprintf(ow.writer, "%s%s\n", ow.lead(taskName, time.Now()), line)
+	}
+}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fsnotify drops events when its queue overflows, so watch mode checks
+// every [watch] rescan that nothing changed without it noticing. A sweep
+// reads the watched directories sweepBatch at a time, pausing sweepPause
+// between batches, so a large tree is never read all at once.
+const (
+	sweepBatch = 200
+	sweepPause = 50 * time.Millisecond
+
+	// Changes this recent may still have their events on the way, and are
+	// left to the next sweep
+	sweepGrace = 2 * time.Second
+)
+
+// sweeper remembers a fingerprint of each watched directory's files, their
+// names, sizes and modification times, as of the last sweep
+type sweeper struct {
+	mu      sync.Mutex
+	dirs    map[string]dirPrint
+	noticed map[string]bool // directories with events since their last sweep
+}
+
+// dirPrint is a directory's fingerprint and when it was taken
+type dirPrint struct {
+	sum uint64
+	at  time.Time
+}
+
+func newSweeper() *sweeper {
+	return &sweeper{
+		dirs:    make(map[string]dirPrint),
+		noticed: make(map[string]bool),
+	}
+}
+
+// notice records that fsnotify reported a change in dir, which the next
+// sweep then doesn't count as missed
+func (s *sweeper) notice(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noticed[dir] = true
+}
+
+// forget drops what is known of a directory no longer watched
+func (s *sweeper) forget(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dirs, dir)
+	delete(s.noticed, dir)
+}
+
+// check fingerprints dir and returns the files in it that changed since
+// the last sweep without an event, judging by their modification times, or
+// all of them if none of those is recent enough to tell. The first sweep of
+// a directory only takes its fingerprint.
+func (s *sweeper) check(dir string, now time.Time) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// Removed since it was watched
+		s.forget(dir)
+		return nil
+	}
+
+	s.mu.Lock()
+	last, known := s.dirs[dir]
+	s.mu.Unlock()
+
+	h := fnv.New64a()
+	var files []string
+	var mods []time.Time
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if known && now.Sub(info.ModTime()) < sweepGrace {
+			// Look at the directory again next time, when its events are in
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", entry.Name(), info.Size(), info.ModTime().UnixNano())
+		files = append(files, filepath.Join(dir, entry.Name()))
+		mods = append(mods, info.ModTime())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	noticed := s.noticed[dir]
+	delete(s.noticed, dir)
+	s.dirs[dir] = dirPrint{sum: h.Sum64(), at: now}
+	if !known || noticed || last.sum == h.Sum64() {
+		return nil
+	}
+
+	var changed []string
+	for i, path := range files {
+		if mods[i].After(last.at.Add(-sweepGrace)) {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return files
+	}
+	return changed
+}
+
+// sweepLoop looks for missed changes interval after the last sweep ended,
+// so sweeps never overlap or come closer together than interval
+func (w *Watcher) sweepLoop(ctx context.Context, interval time.Duration) {
+	defer RecoverPanic()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.clock.After(interval):
+			w.sweepOnce(ctx)
+		}
+	}
+}
+
+// sweepOnce checks every watched directory for changes fsnotify missed,
+// and hands the files that changed in them to the watch loop, which
+// restarts the tasks watching them as for any change
+func (w *Watcher) sweepOnce(ctx context.Context) {
+	w.mu.Lock()
+	dirs := make([]string, 0, len(w.sourceDirs))
+	for dir := range w.sourceDirs {
+		dirs = append(dirs, dir)
+	}
+	w.mu.Unlock()
+	sort.Strings(dirs)
+
+	for i, dir := range dirs {
+		if i > 0 && i%sweepBatch == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.clock.After(sweepPause):
+			}
+		}
+		changed := w.sweep.check(dir, w.clock.Now())
+		changed = slices.DeleteFunc(changed, func(path string) bool { return len(w.tasksWatching(path)) == 0 })
+		if len(changed) == 0 {
+			continue
+		}
+		w.system(LevelWarn, fmt.Sprintf("detected missed changes under %s (rescan)", displayDir(dir)))
+		for _, path := range changed {
+			select {
+			case w.missed <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// displayDir shows dir relative to the working directory if it is under
+// it, with a trailing slash
+func displayDir(dir string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, dir); err == nil && within(wd, dir) {
+			dir = rel
+		}
+	}
+	return strings.TrimSuffix(filepath.ToSlash(dir), "/") + "/"
+}
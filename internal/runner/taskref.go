@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"prun/internal/config"
+)
+
+// TaskRefDepthEnv tells a prun started for a {task:name} reference how deeply
+// it is nested. Such a prun runs its task once, without watching, and
+// refuses to nest beyond config.MaxTaskRefDepth.
+const TaskRefDepthEnv = "PRUN_TASK_REF_DEPTH"
+
+// TaskRefDepth returns how deeply this prun is nested in {task:name}
+// references, 0 for a prun started by hand
+func TaskRefDepth() int {
+	depth, _ := strconv.Atoi(os.Getenv(TaskRefDepthEnv))
+	return depth
+}
+
+// expandTaskRefs replaces every {task:name} in cmd with a command running
+// that task through prun itself, with the same config, --env variables and
+// --log-dir. The task thus runs with its own env, path and restart policy,
+// as a step of the shell command: `{task:build} && ./bin/server` starts the
+// server only if the build succeeded. Its lines come as NDJSON records,
+// which refLine turns back into lines of the referenced task.
+func expandTaskRefs(cfg *config.Config, cmd string) (string, error) {
+	if len(config.TaskRefs(cmd)) == 0 {
+		return cmd, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("can't run {task:...} references: %w", err)
+	}
+
+	depth := TaskRefDepth() + 1
+	return config.ReplaceTaskRefs(cmd, func(name string) string {
+		args := []string{exe, "--quiet", "--log-format", "json"}
+		// The task's own directory may not be prun's
+		if path, err := filepath.Abs(cfg.Path()); err == nil && cfg.Path() != "" {
+			args = append(args, "-c", path)
+		}
+		if cfg.LogDir != "" {
+			args = append(args, "--log-dir", cfg.LogDir)
+		}
+		for _, key := range slices.Sorted(maps.Keys(cfg.Overrides)) {
+			args = append(args, "-e", key+"="+cfg.Overrides[key])
+		}
+		args = append(args, name)
+		return fmt.Sprintf("%s=%d %s", TaskRefDepthEnv, depth, config.QuoteArgs(args))
+	}), nil
+}
+
+// refLine decodes a line a command with {task:name} references printed, if
+// it is an NDJSON record of a line of a referenced task, into the line's
+// text, task and stream. Any other line, the command's own included, is
+// not one.
+func refLine(cfg *config.Config, line string) (text, task string, isErr, ok bool) {
+	if !strings.HasPrefix(line, `{"v":`) {
+		return "", "", false, false
+	}
+	var rec jsonRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.V != jsonSchemaVersion || rec.Line == nil {
+		return "", "", false, false
+	}
+	if _, defined := cfg.TaskDefs[rec.Task]; !defined {
+		return "", "", false, false
+	}
+	return *rec.Line, rec.Task, rec.Stream == "stderr", true
+}
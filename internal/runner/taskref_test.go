@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"prun/internal/config"
+)
+
+func TestExpandTaskRefsPassesOptions(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["app"]
+
+[task.build]
+cmd = "true"
+
+[task.app]
+cmd = "{task:build} && echo up"
+`)
+	cfg.Overrides = map[string]string{"MODE": "release", "A": "1"}
+	cfg.LogDir = "/tmp/logs"
+
+	cmd, err := expandTaskRefs(cfg, cfg.TaskDefs["app"].Cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := config.SplitArgs(strings.TrimSuffix(cmd, " && echo up"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(args[2:], " ")
+	want := "--quiet --log-format json -c " + cfg.Path() + " --log-dir /tmp/logs -e A=1 -e MODE=release build"
+	if args[0] != TaskRefDepthEnv+"=1" || got != want {
+		t.Errorf("expanded to %q, want the child prun to get %q", cmd, want)
+	}
+}
+
+func TestRefLine(t *testing.T) {
+	cfg := loadConfig(t, `
+tasks = ["app"]
+
+[task.build]
+cmd = "true"
+
+[task.app]
+cmd = "{task:build}"
+`)
+	tests := []struct {
+		line  string
+		text  string
+		task  string
+		isErr bool
+		ok    bool
+	}{
+		{`{"v":1,"time":"2024-01-01T00:00:00Z","task":"build","stream":"stdout","line":"compiling","pid":7,"run":1}`, "compiling", "build", false, true},
+		{`{"v":1,"time":"2024-01-01T00:00:00Z","task":"build","stream":"stderr","line":"","pid":7,"run":1}`, "", "build", true, true},
+		{`{"v":1,"time":"2024-01-01T00:00:00Z","task":"build","status":"running","pid":7,"run":1}`, "", "", false, false},
+		{`{"v":1,"time":"2024-01-01T00:00:00Z","task":"other","stream":"stdout","line":"x"}`, "", "", false, false},
+		{`{"v":2,"task":"build","stream":"stdout","line":"x"}`, "", "", false, false},
+		{`{"level":"info","msg":"started"}`, "", "", false, false},
+		{`app up`, "", "", false, false},
+	}
+	for _, tt := range tests {
+		text, task, isErr, ok := refLine(cfg, tt.line)
+		if text != tt.text || task != tt.task || isErr != tt.isErr || ok != tt.ok {
+			t.Errorf("refLine(%s) = %q, %q, %v, %v; want %q, %q, %v, %v", tt.line, text, task, isErr, ok, tt.text, tt.task, tt.isErr, tt.ok)
+		}
+	}
+}
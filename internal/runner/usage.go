@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"prun/internal/config"
+)
+
+// usageInterval is how often the process group of a running task is sampled
+const usageInterval = 2 * time.Second
+
+// GroupUsage is what a task's process group holds at the last sample
+type GroupUsage struct {
+	Processes int // the main process and its children
+	FDs       int // open file descriptors, summed over the group
+}
+
+// usage holds the latest sample of every running task. It is shared by all
+// runners of the process, so the web UI and the TUI can read it by task name.
+var usage = struct {
+	mu    sync.Mutex
+	tasks map[string]GroupUsage
+}{tasks: make(map[string]GroupUsage)}
+
+// TaskUsage returns the last sample of a running task's process group. It
+// reports false when the task isn't running or the platform can't tell,
+// which is anywhere but Linux.
+func TaskUsage(taskName string) (GroupUsage, bool) {
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	u, ok := usage.tasks[taskName]
+	return u, ok
+}
+
+// sampleUsage records the usage of a task's process group until ctx is
+// done, warning when it goes above max_fds or max_children. Each warning is
+// given once, and again only after the count came back down.
+func (r *Runner) sampleUsage(ctx context.Context, taskName string, pgid int, taskDef config.TaskDef) {
+	defer RecoverPanic()
+	defer func() {
+		usage.mu.Lock()
+		delete(usage.tasks, taskName)
+		usage.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(usageInterval)
+	defer ticker.Stop()
+
+	var overFDs, overChildren bool
+	for {
+		u, ok := groupUsage(pgid)
+		if !ok {
+			return
+		}
+		usage.mu.Lock()
+		usage.tasks[taskName] = u
+		usage.mu.Unlock()
+
+		if limit := taskDef.MaxFDs; limit > 0 && (u.FDs > limit) != overFDs {
+			overFDs = !overFDs
+			if overFDs {
+				r.warn(taskName, fmt.Sprintf("%d open file descriptors, above max_fds = %d", u.FDs, limit))
+			}
+		}
+		if limit := taskDef.MaxChildren; limit > 0 && (u.Processes-1 > limit) != overChildren {
+			overChildren = !overChildren
+			if overChildren {
+				r.warn(taskName, fmt.Sprintf("%d child processes, above max_children = %d", u.Processes-1, limit))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
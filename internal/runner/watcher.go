@@ -1,11 +1,18 @@
 package runner
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"prun/internal/config"
@@ -13,16 +20,82 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+// SystemTask is the task name under which prun reports its own diagnostics,
+// such as watcher errors, config reloads and the events traced with
+// SetTraceEvents. No real task may have it.
+const SystemTask = config.SystemTask
+
+// Levels of prun's own diagnostics, carried in LogEvent.Level
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
 // Watcher manages file watching and task restarts
 type Watcher struct {
-	cfg          *config.Config
-	tasks        []string
-	verbose      bool
-	globalWatch  bool
-	eventChan    chan LogEvent
-	fsWatcher    *fsnotify.Watcher
-	restartChans map[string]chan struct{}
-	mu           sync.Mutex
+	cfg         *config.Config
+	tasks       []string
+	pinned      bool // tasks were chosen on the command line, not by the config's list
+	verbose     bool
+	globalWatch bool
+	groupOutput bool
+	quiet       bool
+	killTimeout time.Duration // --kill-timeout, passed on to each run
+	traceEvents bool          // report every fsnotify event and what was done about it
+	interactive bool          // pending restarts wait for ResolveRestart
+	autoConfirm bool          // never hold restarts for confirmation
+	watchBlock  bool          // register watched directories before starting tasks
+	batch       restartBatch
+	output      *outputWriter // console output of the watcher's own messages
+	eventChan   chan LogEvent
+	fsWatcher   eventSource
+	sourceDirs  map[string]bool                  // directories watched for task source changes
+	roots       map[string][]string              // watched task -> absolute directories it watches
+	logPaths    []string                         // log files and directories prun writes, never counted as changes
+	files       map[string]func(context.Context) // files watched individually, by absolute path
+	registering map[string]*registration         // watch roots being registered in the background
+	registerMu  sync.Mutex                       // held by the registration being walked
+	sweep       *sweeper                         // fingerprints of watched directories, for [watch] rescan
+	missed      chan string                      // files sweeps found changed without an event
+	running     map[string]*watchedTask
+	held        map[string]*watchedTask // tasks stopped with StopTask, until started again
+	ctx         context.Context         // the context of Start, for tasks started later
+	logs        *logFileSet
+	sockets     *socketSet
+	active      *activeGroups
+	envs        *envHistory // each task's environment in its last run
+	deps        *depGate    // task starts and ends, for depends_on
+	clock       clock       // times debouncing, restart backoff and rescans
+	stdin       *stdinPipes // stdin of running tasks, with SetStdin; nil otherwise
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+}
+
+// eventSource reports changes in the directories added to it. It is
+// fsnotify, except in tests that need events lost or made up.
+type eventSource interface {
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+	events() <-chan fsnotify.Event
+	errors() <-chan error
+}
+
+// fsnotifySource is the eventSource of watch mode
+type fsnotifySource struct{ *fsnotify.Watcher }
+
+func (s fsnotifySource) events() <-chan fsnotify.Event { return s.Events }
+
+func (s fsnotifySource) errors() <-chan error { return s.Errors }
+
+// watchedTask is the restart loop of one task
+type watchedTask struct {
+	restart chan string // the reason for a pending restart
+	stop    context.CancelFunc
+	done    chan struct{} // closed when the loop and its process have exited
+	idle    atomic.Bool   // between runs, waiting for a change or a retry
 }
 
 // NewWatcher creates a new file watcher
@@ -32,16 +105,40 @@ func NewWatcher(cfg *config.Config, tasks []string, verbose bool, globalWatch bo
 		return nil, fmt.Errorf("failed to create file watcher: %w", err)
 	}
 
+	output := newOutputWriter(os.Stdout, os.Stderr, cfg.Markers, cfg.UI.Theme())
 	return &Watcher{
-		cfg:          cfg,
-		tasks:        tasks,
-		verbose:      verbose,
-		globalWatch:  globalWatch,
-		fsWatcher:    fsWatcher,
-		restartChans: make(map[string]chan struct{}),
+		cfg:         cfg,
+		tasks:       tasks,
+		pinned:      !slices.Equal(tasks, cfg.Tasks),
+		verbose:     verbose,
+		globalWatch: globalWatch,
+		fsWatcher:   fsnotifySource{fsWatcher},
+		sourceDirs:  make(map[string]bool),
+		logPaths:    cfg.LogPaths(),
+		roots:       make(map[string][]string),
+		files:       make(map[string]func(context.Context)),
+		registering: make(map[string]*registration),
+		sweep:       newSweeper(),
+		missed:      make(chan string),
+		running:     make(map[string]*watchedTask),
+		held:        make(map[string]*watchedTask),
+		logs:        newLogFileSet(verbose, output),
+		sockets:     newSocketSet(),
+		active:      newActiveGroups(),
+		envs:        newEnvHistory(),
+		deps:        newDepGate(),
+		clock:       realClock{},
+		output:      output,
 	}, nil
 }
 
+// SetConsole makes console output, of the tasks and the watcher's own
+// messages, go through c
+func (w *Watcher) SetConsole(c *Console) {
+	w.output = c.out
+	w.logs.output = c.out
+}
+
 // SetEventChannel sets a channel for publishing log events
 func (w *Watcher) SetEventChannel(ch chan LogEvent) {
 	w.eventChan = ch
@@ -50,163 +147,511 @@ func (w *Watcher) SetEventChannel(ch chan LogEvent) {
 // Start begins watching files and running tasks
 func (w *Watcher) Start(ctx context.Context) error {
 	// Setup watchers for each task
+	w.mu.Lock()
+	w.ctx = ctx
 	for _, taskName := range w.tasks {
-		taskDef := w.cfg.TaskDefs[taskName]
-		shouldWatch := w.globalWatch || taskDef.Watch
-
-		if shouldWatch {
-			watchDir := taskDef.Path
-			if watchDir == "" {
-				watchDir = "."
-			}
-
-			// Add the directory to watch
-			if err := w.addWatchRecursive(watchDir); err != nil {
-				return fmt.Errorf("failed to watch directory for task '%s': %w", taskName, err)
-			}
+		if err := w.watchTask(ctx, taskName); err != nil {
+			w.mu.Unlock()
+			return err
+		}
+	}
+	w.mu.Unlock()
 
-			if w.verbose {
-				w.logEvent(taskName, fmt.Sprintf("Watching directory: %s", watchDir))
+	// Watch the config file itself and those it includes
+	if w.cfg.WatchConfig {
+		for _, path := range w.cfg.ConfigPaths() {
+			if err := w.watchFile(path, w.reloadConfig); err != nil {
+				return fmt.Errorf("failed to watch config file: %w", err)
 			}
 		}
+
+		// Keep running while waiting for config edits, even if every task exits
+		w.wg.Add(1)
+		go func() {
+			<-ctx.Done()
+			w.wg.Done()
+		}()
 	}
 
 	// Start file watcher event loop
 	go w.watchLoop(ctx)
+	if interval := w.cfg.Watch.RescanInterval(); interval > 0 {
+		go w.sweepLoop(ctx, interval)
+	}
 
 	// Start all tasks
-	var wg sync.WaitGroup
+	w.mu.Lock()
 	for _, taskName := range w.tasks {
-		w.restartChans[taskName] = make(chan struct{}, 1)
-		wg.Add(1)
-		go func(name string) {
-			defer wg.Done()
-			w.runTaskWithRestart(ctx, name)
-		}(taskName)
+		w.startTask(ctx, taskName)
 	}
+	w.mu.Unlock()
 
-	wg.Wait()
+	w.wg.Wait()
 	return nil
 }
 
-// addWatchRecursive adds a directory and all its subdirectories to the watcher
-func (w *Watcher) addWatchRecursive(root string) error {
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// watchTask watches a task's directories and env files if the task restarts
+// on file changes. Unless SetWatchBlock is on, the directory is registered in
+// the background and the task may start before it is watched. w.mu must be
+// held.
+func (w *Watcher) watchTask(ctx context.Context, taskName string) error {
+	taskDef := w.cfg.TaskDefs[taskName]
+	if !w.globalWatch && !taskDef.Watch {
+		return nil
+	}
+
+	var roots []string
+	for _, watchDir := range taskDef.WatchDirs() {
+		root, err := w.watchDir(ctx, taskName, watchDir)
+		if err != nil {
+			return fmt.Errorf("failed to watch directory for task '%s': %w", taskName, err)
+		}
+		roots = append(roots, root)
+	}
+	w.roots[taskName] = roots
+
+	// Env files are often hidden or gitignored, so they are registered
+	// individually rather than found by the directory walk
+	for _, path := range w.cfg.EnvFilePaths(taskName) {
+		err := w.watchFile(path, func(context.Context) {
+			w.reloadEnvFile(path)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to watch env_file for task '%s': %w", taskName, err)
+		}
+	}
+	return nil
+}
+
+// watchDir watches one of a task's directories and returns it as an
+// absolute path; w.mu must be held
+func (w *Watcher) watchDir(ctx context.Context, taskName, watchDir string) (string, error) {
+	root, err := filepath.Abs(watchDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Add the directory to watch
+	if w.watchBlock {
+		dirs, err := WatchedDirs(watchDir)
+		if err != nil {
+			return "", err
+		}
+		for _, dir := range dirs {
+			if err := w.fsWatcher.Add(dir); err != nil {
+				return "", err
+			}
+			w.sourceDirs[dir] = true
+		}
+		if w.verbose {
+			w.logEvent(taskName, fmt.Sprintf("Watching directory: %s (%d directories)", watchDir, len(dirs)))
+		}
+	} else {
+		if err := checkWatchRoot(watchDir); err != nil {
+			return "", err
+		}
+		w.registerDirs(ctx, taskName, watchDir, root)
+	}
+	return root, nil
+}
+
+// watchFile registers a single file, regardless of the skip rules applied to
+// directories, and calls onChange (debounced) when it is written or replaced.
+// Editors often replace a file instead of writing to it, so its directory is
+// watched rather than the file itself. w.mu must be held.
+func (w *Watcher) watchFile(path string, onChange func(context.Context)) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := w.files[abs]; ok {
+		return nil
+	}
+	if err := w.fsWatcher.Add(filepath.Dir(abs)); err != nil {
+		return err
+	}
+	w.files[abs] = onChange
+	return nil
+}
+
+// fileHandler returns the change handler of an individually watched file
+func (w *Watcher) fileHandler(path string) (string, func(context.Context)) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return abs, w.files[abs]
+}
+
+// startTask launches a task's restart loop; w.mu must be held
+func (w *Watcher) startTask(ctx context.Context, taskName string) {
+	taskCtx, stop := context.WithCancel(ctx)
+	t := &watchedTask{
+		restart: make(chan string, 1),
+		stop:    stop,
+		done:    make(chan struct{}),
+	}
+	w.running[taskName] = t
+
+	w.wg.Add(1)
+	go func() {
+		defer RecoverPanic()
+		defer w.wg.Done()
+		defer close(t.done)
+		w.runTaskWithRestart(taskCtx, taskName, t)
+	}()
+}
+
+// config returns the current config, which a reload may replace
+func (w *Watcher) config() *config.Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cfg
+}
+
+// WatchedDirs returns root and the subdirectories of it that watch mode
+// watches for a task, leaving out hidden directories, node_modules, vendor,
+// dist and build. Excluded trees are skipped before descending into them.
+// Having root itself left out, so that nothing would be watched, is an
+// error.
+func WatchedDirs(root string) ([]string, error) {
+	if err := checkWatchRoot(root); err != nil {
+		return nil, err
+	}
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
 		// Skip hidden directories and node_modules, .git, etc.
-		if info.IsDir() {
-			base := filepath.Base(path)
-			if base[0] == '.' || base == "node_modules" || base == "vendor" || base == "dist" || base == "build" {
+		if d.IsDir() {
+			if excludedDir(d.Name()) {
 				return filepath.SkipDir
 			}
-			return w.fsWatcher.Add(path)
+			dirs = append(dirs, filepath.Clean(path))
 		}
 		return nil
 	})
+	return dirs, err
+}
+
+// checkWatchRoot returns an error if root can't be watched: it is missing,
+// not a directory, or excluded itself
+func checkWatchRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+	if excludedDir(filepath.Base(root)) {
+		return fmt.Errorf("%s is excluded from watching (hidden, node_modules, vendor, dist or build), so nothing would be watched", root)
+	}
+	return nil
+}
+
+// excludedDir reports whether watch mode skips a directory by its name. A
+// root of "." or ".." is not hidden.
+func excludedDir(base string) bool {
+	if base == "." || base == ".." {
+		return false
+	}
+	return base[0] == '.' || base == "node_modules" || base == "vendor" || base == "dist" || base == "build"
 }
 
 // watchLoop monitors file system events
 func (w *Watcher) watchLoop(ctx context.Context) {
-	// Debounce timer to avoid too many restarts
-	var debounceTimer *time.Timer
-	debounceDuration := 500 * time.Millisecond
+	defer RecoverPanic()
+	// Debounce timers to avoid too many restarts. Each task has its own, so
+	// constant churn in one task's directory doesn't hold back the others.
+	taskTimers := make(map[string]timer)
+	fileTimers := make(map[string]timer)
+	debounce := newDebouncer(w.config().Watch.Debounce.Duration, w.clock.Now())
+	debounceDuration := debounce.base
+	defer func() {
+		for _, timer := range taskTimers {
+			timer.Stop()
+		}
+		for _, timer := range fileTimers {
+			timer.Stop()
+		}
+	}()
+
+	// changed resets the debounce timer of each task watching path
+	changed := func(path string) {
+		if w.verbose {
+			w.system(LevelInfo, fmt.Sprintf("File changed: %s", path))
+		}
+
+		tasks := w.tasksWatching(path)
+		wait := debounce.base
+		adapted := ""
+		if len(tasks) > 0 {
+			wait, adapted = debounce.observe(path, w.clock.Now())
+		}
+		if adapted != "" {
+			w.system(LevelInfo, fmt.Sprintf("detected multi-event saves for %s; using %s debounce", adapted, wait))
+			if w.verbose || w.traceEvents {
+				w.system(LevelDebug, "watch timing "+debounce.stats(adapted))
+			}
+		}
+		for _, taskName := range tasks {
+			if timer := taskTimers[taskName]; timer != nil {
+				timer.Stop()
+			}
+			taskTimers[taskName] = w.clock.AfterFunc(wait, func() {
+				defer RecoverPanic()
+				w.queueRestart(taskName, path)
+			})
+		}
+		if len(tasks) == 0 {
+			w.trace("  → ignored: no running task watches it")
+		} else {
+			sort.Strings(tasks)
+			w.trace("  → debounced: %s restarts in %s unless more changes follow", strings.Join(tasks, ", "), wait)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case event, ok := <-w.fsWatcher.Events:
+		case event, ok := <-w.fsWatcher.events():
 			if !ok {
 				return
 			}
+			w.trace("%s %s", event.Op, event.Name)
 
-			// Only watch Write and Create events
-			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-				if w.verbose {
-					w.logEvent("watcher", fmt.Sprintf("File changed: %s", event.Name))
+			// Individually watched files have their own handlers instead of
+			// restarting every watched task
+			if path, onChange := w.fileHandler(event.Name); onChange != nil {
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					if timer := fileTimers[path]; timer != nil {
+						timer.Stop()
+					}
+					fileTimers[path] = w.clock.AfterFunc(debounceDuration, func() {
+						defer RecoverPanic()
+						w.trace("  → handling change to %s", path)
+						onChange(ctx)
+					})
+					w.trace("  → debounced: watched file, handled in %s", debounceDuration)
+				} else {
+					w.trace("  → ignored: %s of a watched file", event.Op)
 				}
+				continue
+			}
 
-				// Reset debounce timer
-				if debounceTimer != nil {
-					debounceTimer.Stop()
+			// A directory may be watched only for individual files in it
+			if !w.isSourceDir(filepath.Dir(filepath.Clean(event.Name))) {
+				w.trace("  → ignored: not in a watched directory")
+				continue
+			}
+			w.sweep.notice(filepath.Dir(filepath.Clean(event.Name)))
+
+			// Directories created under a watched one are watched as well
+			if event.Op&fsnotify.Create == fsnotify.Create && !excludedDir(filepath.Base(event.Name)) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					w.registerNewDir(ctx, filepath.Clean(event.Name))
 				}
-				debounceTimer = time.AfterFunc(debounceDuration, func() {
-					w.triggerRestarts()
-				})
 			}
-		case err, ok := <-w.fsWatcher.Errors:
+
+			// Directories removed or renamed away are no longer watched
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && w.isSourceDir(filepath.Clean(event.Name)) {
+				w.forgetDirs(filepath.Clean(event.Name))
+			}
+
+			// Editors that save by renaming a new file over the old one
+			// remove or rename files as often as they write them
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+				changed(event.Name)
+			} else {
+				w.trace("  → ignored: only WRITE, CREATE, REMOVE and RENAME restart tasks")
+			}
+		case path := <-w.missed:
+			w.trace("%s missed, found by rescan", path)
+			changed(path)
+		case err, ok := <-w.fsWatcher.errors():
 			if !ok {
 				return
 			}
-			if w.verbose {
-				w.logEvent("watcher", fmt.Sprintf("Error: %v", err))
-			}
+			w.system(LevelError, fmt.Sprintf("Watcher error: %v", err))
 		}
 	}
 }
 
-// triggerRestarts signals all watched tasks to restart
-func (w *Watcher) triggerRestarts() {
+// tasksWatching returns the running watched tasks that a change to path
+// restarts, as matchChange decides for each task whose directories contain it
+func (w *Watcher) tasksWatching(path string) []string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	for taskName, restartChan := range w.restartChans {
+	var tasks []string
+	for taskName, roots := range w.roots {
+		if _, ok := w.running[taskName]; !ok {
+			continue
+		}
+		if !slices.ContainsFunc(roots, func(root string) bool { return within(root, abs) }) {
+			continue
+		}
 		taskDef := w.cfg.TaskDefs[taskName]
-		if w.globalWatch || taskDef.Watch {
-			select {
-			case restartChan <- struct{}{}:
-				if w.verbose {
-					w.logEvent(taskName, "Restarting due to file change...")
-				}
-			default:
-				// Channel already has a pending restart
-			}
+		base, err := filepath.Abs(cmp.Or(taskDef.Path, "."))
+		if err != nil {
+			continue
+		}
+		if ok, reason := matchChange(taskDef, w.cfg.Watch.Exclude, base, roots, w.logPaths, abs); ok {
+			tasks = append(tasks, taskName)
+		} else {
+			w.trace("  → %s not restarted: %s", taskName, reason)
 		}
 	}
+	return tasks
 }
 
-// runTaskWithRestart runs a task and restarts it when signaled
-func (w *Watcher) runTaskWithRestart(ctx context.Context, taskName string) {
+// within reports whether path is dir or under it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// triggerRestart signals a task to restart if it is still running and
+// watched, because of a change to path
+func (w *Watcher) triggerRestart(taskName, path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	t, ok := w.running[taskName]
+	if !ok {
+		w.trace("  → %s not restarted: no longer running", taskName)
+		return
+	}
 	taskDef := w.cfg.TaskDefs[taskName]
-	shouldWatch := w.globalWatch || taskDef.Watch
-	restartChan := w.restartChans[taskName]
+	if w.globalWatch || taskDef.Watch {
+		select {
+		case t.restart <- "file change: " + path:
+			w.trace("  → triggered: restarting %s for %s", taskName, path)
+			if w.verbose {
+				w.logEvent(taskName, "Restarting due to file change...")
+			}
+		default:
+			// Channel already has a pending restart
+			w.trace("  → %s not restarted: a restart is already pending", taskName)
+		}
+	} else {
+		w.trace("  → %s not restarted: its watch is off", taskName)
+	}
+}
+
+// A run that could not start for lack of pipes or descriptors is retried
+// after pipeRetryBackoff, at most maxPipeFailures times in a row
+const maxPipeFailures = 5
+
+// pipeRetryBackoff doubles from half a second with each consecutive failure
+func pipeRetryBackoff(failures int) time.Duration {
+	return min(500*time.Millisecond<<(failures-1), 10*time.Second)
+}
+
+// runTaskWithRestart runs a task and restarts it when signaled. A run that
+// could not start for lack of output pipes or file descriptors is retried
+// after a backoff, up to maxPipeFailures times in a row. The restarts of the
+// task's restart policy happen within a run, so a signaled restart cancels a
+// pending one and starts its count over.
+func (w *Watcher) runTaskWithRestart(ctx context.Context, taskName string, t *watchedTask) {
+	restartChan := t.restart
+	reason := ""
+	pipeFailures := 0
+	for restarts := 0; ; restarts++ {
+		// Each run uses the latest config and task list
+		w.mu.Lock()
+		cfg, order := w.cfg, w.tasks
+		w.mu.Unlock()
+		shouldWatch := w.globalWatch || cfg.TaskDefs[taskName].Watch
 
-	for {
 		// Create a cancellable context for this task instance
 		taskCtx, cancel := context.WithCancel(ctx)
 
 		// Run the task in a goroutine
+		t.idle.Store(false)
 		done := make(chan error, 1)
+		r := New(cfg, []string{taskName}, w.verbose)
+		r.logs = w.logs
+		r.sockets = w.sockets
+		r.active = w.active
+		r.envs = w.envs
+		r.deps = w.deps
+		r.stdin = w.stdin
+		r.clock = w.clock
+		r.order = order
+		run := &runCount{restarts: restarts, reason: reason}
+		r.groupOutput = w.groupOutput
+		r.quiet = w.quiet
+		r.killTimeout = w.killTimeout
+		r.output = w.output
+		if w.eventChan != nil {
+			r.SetEventChannel(w.eventChan)
+		}
 		go func() {
-			r := New(w.cfg, []string{taskName}, w.verbose)
-			if w.eventChan != nil {
-				r.SetEventChannel(w.eventChan)
-			}
-			done <- r.runTask(taskCtx, taskName)
+			defer RecoverPanic()
+			done <- r.runTask(taskCtx, taskName, run)
 		}()
 
 		// Wait for completion, restart signal, or context cancellation
 		select {
 		case <-ctx.Done():
 			cancel()
+			<-done
 			return
-		case <-restartChan:
-			if shouldWatch {
+		case reason = <-restartChan:
+			if shouldWatch || reason == restartByUser {
 				// Cancel current task and restart
 				cancel()
 				<-done // Wait for task to finish
-				w.logEvent(taskName, "Restarted")
+				restarts = run.restarts
+				w.logRestart(taskName)
 				continue
 			}
 		case err := <-done:
 			cancel()
+			t.idle.Store(true)
+			// The run counts the restarts of the task's restart policy
+			restarts = run.restarts
 			if err != nil && w.verbose {
 				w.logEvent(taskName, fmt.Sprintf("Exited with error: %v", err))
 			}
 
+			// The task never started; try again once descriptors may have freed up
+			if retryable(err) {
+				pipeFailures++
+				if pipeFailures < maxPipeFailures {
+					backoff := pipeRetryBackoff(pipeFailures)
+					w.emitStatus(LogEvent{
+						Task:        taskName,
+						Status:      StatusRetrying,
+						Attempt:     pipeFailures + 1,
+						MaxAttempts: maxPipeFailures,
+						Until:       w.clock.Now().Add(backoff),
+					})
+					select {
+					case <-ctx.Done():
+						return
+					case reason = <-restartChan:
+					case <-w.clock.After(backoff):
+						reason = "retry after failed start"
+					}
+					continue
+				}
+				w.logEvent(taskName, fmt.Sprintf("giving up after %d failed starts in a row", pipeFailures))
+			} else {
+				pipeFailures = 0
+			}
+
 			// If not watching, exit after first run
 			if !shouldWatch {
 				return
@@ -216,14 +661,270 @@ func (w *Watcher) runTaskWithRestart(ctx context.Context, taskName string) {
 			select {
 			case <-ctx.Done():
 				return
-			case <-restartChan:
-				w.logEvent(taskName, "Restarted")
+			case reason = <-restartChan:
+				w.logRestart(taskName)
 				continue
 			}
 		}
 	}
 }
 
+// SetGroupOutput makes each run's console output print as one block when it exits
+func (w *Watcher) SetGroupOutput(group bool) {
+	w.groupOutput = group
+}
+
+// SetQuiet leaves lifecycle lines out of console output
+func (w *Watcher) SetQuiet(quiet bool) {
+	w.quiet = quiet
+}
+
+// SetKillTimeout overrides every task's shutdown_timeout (--kill-timeout)
+func (w *Watcher) SetKillTimeout(timeout time.Duration) {
+	w.killTimeout = timeout
+}
+
+// SetTraceEvents makes the watcher report every raw file system event and
+// the decision taken on it, under the SystemTask name
+func (w *Watcher) SetTraceEvents(trace bool) {
+	w.traceEvents = trace
+}
+
+// Signal forwards sig to the process groups of all running tasks
+func (w *Watcher) Signal(sig syscall.Signal) {
+	w.active.signal(sig)
+}
+
+// isSourceDir reports whether dir is watched for task source changes
+func (w *Watcher) isSourceDir(dir string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sourceDirs[dir]
+}
+
+// reloadConfig re-parses the config file and reconciles running tasks with
+// it: new tasks start, removed tasks stop, and tasks whose definition or
+// environment changed restart. An invalid config is reported and ignored.
+func (w *Watcher) reloadConfig(ctx context.Context) {
+	newCfg, err := w.config().Reload()
+	if err != nil {
+		w.system(LevelError, fmt.Sprintf("Config reload failed, keeping the previous config: %v", err))
+		return
+	}
+
+	w.mu.Lock()
+	old := w.cfg
+	tasks := newCfg.Tasks
+	if w.pinned {
+		// Keep the tasks chosen on the command line that still exist
+		tasks = nil
+		for _, name := range w.tasks {
+			if _, ok := newCfg.TaskDefs[name]; ok {
+				tasks = append(tasks, name)
+			}
+		}
+	}
+	w.cfg = newCfg
+	w.logPaths = newCfg.LogPaths()
+	w.tasks = tasks
+	w.render()
+
+	var removed, restarted []*watchedTask
+	var added, changed, gone []string
+	for name := range w.held {
+		if !slices.Contains(tasks, name) {
+			delete(w.held, name)
+		}
+	}
+	for name, t := range w.running {
+		if !slices.Contains(tasks, name) {
+			t.stop()
+			removed = append(removed, t)
+			delete(w.running, name)
+			gone = append(gone, name)
+			w.logEvent(name, "Stopped (removed from config)")
+		}
+	}
+	for _, name := range tasks {
+		t, ok := w.running[name]
+		switch {
+		case !ok && w.held[name] != nil:
+			// Stopped by the user, and started again only by them
+		case !ok:
+			added = append(added, name)
+		case taskChanged(old, newCfg, name):
+			t.stop()
+			restarted = append(restarted, t)
+			changed = append(changed, name)
+		}
+	}
+	w.announceTasks(added, gone)
+	w.mu.Unlock()
+
+	// Let changed tasks exit before relaunching them, so they can reuse
+	// ports and files
+	for _, t := range restarted {
+		<-t.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	for _, path := range newCfg.ConfigPaths() {
+		if err := w.watchFile(path, w.reloadConfig); err != nil {
+			w.system(LevelWarn, fmt.Sprintf("Failed to watch %s: %v", path, err))
+		}
+	}
+	for _, name := range added {
+		if err := w.watchTask(ctx, name); err != nil {
+			w.logEvent(name, err.Error())
+		}
+		w.startTask(ctx, name)
+		w.logEvent(name, "Started (added to config)")
+	}
+	for _, name := range changed {
+		if err := w.watchTask(ctx, name); err != nil {
+			w.logEvent(name, err.Error())
+		}
+		w.startTask(ctx, name)
+		w.logEvent(name, "Restarted (config changed)")
+	}
+	if w.verbose && len(added)+len(changed)+len(removed) == 0 {
+		w.system(LevelInfo, "Config reloaded, no task changes")
+	}
+}
+
+// announceTasks tells the TUI which tasks a config reload added and removed,
+// so that its task list follows. Console output has the tasks' own lines.
+func (w *Watcher) announceTasks(added, removed []string) {
+	if w.eventChan == nil || len(added)+len(removed) == 0 {
+		return
+	}
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		slices.Sort(removed)
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	w.eventChan <- LogEvent{
+		Task:    SystemTask,
+		Line:    "Config reloaded: " + strings.Join(parts, "; "),
+		Time:    time.Now(),
+		Level:   LevelInfo,
+		Added:   added,
+		Removed: removed,
+	}
+}
+
+// render rewrites the render files after the config or environment changed,
+// naming those whose content did; w.mu must be held
+func (w *Watcher) render() {
+	touched, err := w.cfg.RenderFiles(w.tasks)
+	if len(touched) > 0 {
+		w.system(LevelInfo, "rendered "+strings.Join(touched, ", "))
+	}
+	if err != nil {
+		w.system(LevelError, fmt.Sprintf("Failed to render: %v", err))
+	}
+}
+
+// reloadEnvFile re-reads env files after path changed, and restarts the
+// watched tasks that load it and whose environment changed as a result. The
+// restart message names the changed variables but never their values.
+func (w *Watcher) reloadEnvFile(path string) {
+	w.mu.Lock()
+	newCfg, err := w.cfg.ReloadEnvFiles()
+	if err != nil {
+		w.mu.Unlock()
+		w.system(LevelError, fmt.Sprintf("Failed to reload %s, keeping the previous environment: %v", path, err))
+		return
+	}
+	defer w.mu.Unlock()
+
+	old := w.cfg
+	w.cfg = newCfg
+	w.render()
+	for _, name := range w.tasks {
+		t := w.running[name]
+		if t == nil || !(w.globalWatch || newCfg.TaskDefs[name].Watch) {
+			continue
+		}
+		if !slices.Contains(newCfg.EnvFilePaths(name), path) {
+			continue
+		}
+		changed := changedEnvVars(old.TaskEnv(name, config.Instance{}), newCfg.TaskEnv(name, config.Instance{}))
+		if len(changed) == 0 {
+			continue
+		}
+		select {
+		case t.restart <- "env change: " + filepath.Base(path):
+			w.logEvent(name, fmt.Sprintf("Restarting: %s changed %s", filepath.Base(path), strings.Join(changed, ", ")))
+		default:
+			// Channel already has a pending restart
+		}
+	}
+}
+
+// changedEnvVars returns the sorted names of variables that differ between
+// two KEY=VALUE environments, where later entries override earlier ones
+func changedEnvVars(old, cur []string) []string {
+	toMap := func(env []string) map[string]string {
+		m := make(map[string]string, len(env))
+		for _, kv := range env {
+			k, v, _ := strings.Cut(kv, "=")
+			m[k] = v
+		}
+		return m
+	}
+	oldVars, curVars := toMap(old), toMap(cur)
+
+	var names []string
+	for k, v := range curVars {
+		if ov, ok := oldVars[k]; !ok || ov != v {
+			names = append(names, k)
+		}
+	}
+	for k := range oldVars {
+		if _, ok := curVars[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// taskChanged reports whether anything that shapes how a task runs differs
+// between two configs
+func taskChanged(old, cur *config.Config, taskName string) bool {
+	oldArgs, _ := CommandArgs(old, taskName)
+	curArgs, _ := CommandArgs(cur, taskName)
+	return !reflect.DeepEqual(old.TaskDefs[taskName], cur.TaskDefs[taskName]) ||
+		!slices.Equal(oldArgs, curArgs) ||
+		!slices.Equal(old.TaskEnv(taskName, config.Instance{}), cur.TaskEnv(taskName, config.Instance{})) ||
+		old.SharesLogFile(taskName) != cur.SharesLogFile(taskName)
+}
+
+// system reports one of prun's own diagnostics under SystemTask, which the
+// TUI shows in a pane of its own
+func (w *Watcher) system(level, message string) {
+	isErr := level == LevelWarn || level == LevelError
+	if w.eventChan != nil {
+		w.eventChan <- LogEvent{
+			Task:  SystemTask,
+			Line:  message,
+			IsErr: isErr,
+			Time:  time.Now(),
+			Level: level,
+		}
+	} else {
+		w.output.writeSystem(level, message)
+	}
+}
+
 // logEvent sends a log event
 func (w *Watcher) logEvent(taskName, message string) {
 	if w.eventChan != nil {
@@ -234,11 +935,41 @@ func (w *Watcher) logEvent(taskName, message string) {
 			Time:  time.Now(),
 		}
 	} else {
-		fmt.Printf("[%s] %s\n", taskName, message)
+		w.output.WritePrefix(taskName, message+"\n", false)
+	}
+}
+
+// emitStatus publishes a status change of a task, or prints it as a
+// lifecycle line when there is no event channel
+func (w *Watcher) emitStatus(ev LogEvent) {
+	ev.Time = time.Now()
+	if w.eventChan != nil {
+		w.eventChan <- ev
+	} else if !w.quiet {
+		w.output.WriteStatus(ev)
+	}
+}
+
+// trace reports a file system event or a decision about one, with
+// --watch-events
+func (w *Watcher) trace(format string, args ...any) {
+	if w.traceEvents {
+		w.system(LevelDebug, fmt.Sprintf(format, args...))
+	}
+}
+
+// logRestart notes a restart in the task's log. Console output gets the
+// runner's lifecycle line instead.
+func (w *Watcher) logRestart(taskName string) {
+	if w.eventChan != nil {
+		w.logEvent(taskName, "Restarted")
 	}
 }
 
-// Close closes the watcher
+// Close closes the watcher and flushes any open log files
 func (w *Watcher) Close() error {
+	w.batch.stop()
+	w.logs.Close()
+	w.sockets.Close()
 	return w.fsWatcher.Close()
 }
@@ -0,0 +1,284 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// stubSource is an eventSource that delivers only the events a test sends
+type stubSource struct {
+	evs  chan fsnotify.Event
+	errs chan error
+}
+
+func (*stubSource) Add(string) error    { return nil }
+func (*stubSource) Remove(string) error { return nil }
+func (*stubSource) Close() error        { return nil }
+
+func (s *stubSource) events() <-chan fsnotify.Event { return s.evs }
+
+func (s *stubSource) errors() <-chan error { return s.errs }
+
+// notify delivers a file system event to a watcher of startWatcher
+func notify(w *Watcher, name string, op fsnotify.Op) {
+	w.fsWatcher.(*stubSource).evs <- fsnotify.Event{Name: name, Op: op}
+}
+
+// startWatcher runs a watcher of cfg's tasks on clock in the background
+// until the test ends. It hears of file changes only from notify.
+func startWatcher(t *testing.T, text string, clock *fakeClock) (*Watcher, <-chan LogEvent) {
+	t.Helper()
+	cfg := loadConfig(t, text)
+	w, err := NewWatcher(cfg, cfg.Tasks, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.fsWatcher.Close()
+	w.fsWatcher = &stubSource{evs: make(chan fsnotify.Event), errs: make(chan error)}
+	w.clock = clock
+	w.SetWatchBlock(true)
+	events := make(chan LogEvent, 1000)
+	w.SetEventChannel(events)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(eventWait):
+			t.Errorf("watcher did not stop within %s", eventWait)
+		}
+		w.Close()
+	})
+	return w, events
+}
+
+// watchedSource returns a source directory with a main.go in it
+func watchedSource(t *testing.T) (string, string) {
+	t.Helper()
+	src := t.TempDir()
+	file := filepath.Join(src, "main.go")
+	if err := os.WriteFile(file, []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return src, file
+}
+
+func TestWatcherRestartsOnChange(t *testing.T) {
+	src, file := watchedSource(t)
+	clock := newFakeClock()
+	w, events := startWatcher(t, `
+tasks = ["app"]
+
+[watch]
+debounce = "200ms"
+rescan = "0"
+
+[task.app]
+cmd = "{fixture} -sleep 1h"
+path = "`+src+`"
+watch = true
+`, clock)
+
+	first := expectStatus(t, events, "app", StatusRunning)
+	notify(w, file, fsnotify.Write)
+
+	// The restart waits for the debounce to pass
+	waitStarted(t, clock, 1)
+	clock.Advance(199 * time.Millisecond)
+	select {
+	case ev := <-events:
+		if ev.Task == "app" && ev.IsStatus() {
+			t.Fatalf("status %q before the debounce passed", ev.Status)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+
+	expectStatus(t, events, "app", StatusStopped)
+	second := expectStatus(t, events, "app", StatusRunning)
+	if second.Restarts != 1 || second.Pid == first.Pid {
+		t.Errorf("after the change: restarts %d, pid %d (was %d), want restart 1 in a new process", second.Restarts, second.Pid, first.Pid)
+	}
+	if !strings.HasPrefix(second.Reason, "file change: ") || !strings.HasSuffix(second.Reason, "main.go") {
+		t.Errorf("restart reason = %q, want the file change", second.Reason)
+	}
+}
+
+func TestWatcherDebouncesBursts(t *testing.T) {
+	src, file := watchedSource(t)
+	clock := newFakeClock()
+	w, events := startWatcher(t, `
+tasks = ["app"]
+
+[watch]
+debounce = "200ms"
+rescan = "0"
+
+[task.app]
+cmd = "{fixture} -sleep 1h"
+path = "`+src+`"
+watch = true
+`, clock)
+
+	expectStatus(t, events, "app", StatusRunning)
+
+	// Each change within the debounce starts it over, and the burst
+	// restarts the task once
+	for i := range 3 {
+		notify(w, file, fsnotify.Write)
+		waitStarted(t, clock, i+1)
+		clock.Advance(150 * time.Millisecond)
+	}
+	clock.Advance(50 * time.Millisecond)
+
+	expectStatus(t, events, "app", StatusStopped)
+	if ev := expectStatus(t, events, "app", StatusRunning); ev.Restarts != 1 {
+		t.Errorf("restarts = %d after one burst, want 1", ev.Restarts)
+	}
+	select {
+	case ev := <-events:
+		if ev.Task == "app" && ev.IsStatus() {
+			t.Errorf("unexpected %q after the restart", ev.Status)
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatcherIgnoresOwnLogFile(t *testing.T) {
+	src, _ := watchedSource(t)
+	w, events := startWatcher(t, `
+tasks = ["app"]
+
+[task.app]
+cmd = "{fixture} -sleep 1h"
+path = "`+src+`"
+watch = true
+log_file = "`+filepath.Join(src, "app.log")+`"
+`, newFakeClock())
+
+	expectStatus(t, events, "app", StatusRunning)
+	if tasks := w.tasksWatching(filepath.Join(src, "main.go")); len(tasks) != 1 {
+		t.Errorf("tasks watching a source file = %v, want app", tasks)
+	}
+	if tasks := w.tasksWatching(filepath.Join(src, "app.log")); len(tasks) != 0 {
+		t.Errorf("tasks watching the log file = %v, want none", tasks)
+	}
+}
+
+func TestWatcherRescanFindsMissedChanges(t *testing.T) {
+	src, file := watchedSource(t)
+	ignored := filepath.Join(src, "cache.tmp")
+	clock := newFakeClock()
+	touch := func(path string, at time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(at.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(file, clock.Now().Add(-time.Hour))
+	touch(ignored, clock.Now().Add(-time.Hour))
+
+	// No events arrive; only sweeps see the changes
+	_, events := startWatcher(t, `
+tasks = ["app"]
+
+[watch]
+debounce = "200ms"
+rescan = "10s"
+
+[task.app]
+cmd = "{fixture} -sleep 1h"
+path = "`+src+`"
+watch = true
+watch_exclude = ["*.tmp"]
+`, clock)
+
+	// missedWarnings counts the rescan warnings among the events sent so
+	// far, failing the test if the task was restarted
+	missedWarnings := func() int {
+		t.Helper()
+		n := 0
+		for len(events) > 0 {
+			ev := <-events
+			if ev.Task == "app" && ev.IsStatus() {
+				t.Fatalf("unexpected %q of app", ev.Status)
+			}
+			if ev.Task == SystemTask && strings.Contains(ev.Line, "detected missed changes under") {
+				n++
+			}
+		}
+		return n
+	}
+	started := func() int {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return clock.started
+	}
+
+	expectStatus(t, events, "app", StatusRunning)
+
+	// The first sweep only fingerprints the directory, and each sweep
+	// waits for the one before to end
+	waitStarted(t, clock, 1)
+	clock.Advance(10 * time.Second)
+	waitStarted(t, clock, 2)
+	swept := clock.Now()
+
+	// A change to an excluded file restarts nothing
+	touch(ignored, swept.Add(time.Second))
+	clock.Advance(10 * time.Second)
+	waitStarted(t, clock, 3)
+	if n := missedWarnings(); n != 0 {
+		t.Fatalf("%d rescan warnings for an excluded file, want none", n)
+	}
+	swept = clock.Now()
+
+	// A change to a source file restarts the task after the debounce, as
+	// if its event had arrived
+	touch(file, swept.Add(time.Second))
+	clock.Advance(10 * time.Second)
+	waitStarted(t, clock, 5) // the debounce and the next sweep
+	if n := missedWarnings(); n != 1 {
+		t.Fatalf("%d rescan warnings for a missed change, want 1", n)
+	}
+	swept = clock.Now()
+	clock.Advance(200 * time.Millisecond)
+	expectStatus(t, events, "app", StatusStopped)
+	ev := expectStatus(t, events, "app", StatusRunning)
+	if ev.Restarts != 1 || !strings.HasSuffix(ev.Reason, "main.go") {
+		t.Errorf("after the rescan: restarts %d, reason %q; want restart 1 for main.go", ev.Restarts, ev.Reason)
+	}
+
+	// The next sweep comes no sooner than the interval after the last
+	touch(file, swept.Add(time.Second))
+	clock.Advance(10*time.Second - 200*time.Millisecond - time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if n := missedWarnings(); n != 0 {
+		t.Fatalf("%d rescan warnings within the interval, want none", n)
+	}
+	if n := started(); n != 5 {
+		t.Fatalf("%d timers started within the interval, want no new sweep's", n)
+	}
+	clock.Advance(time.Millisecond)
+	waitStarted(t, clock, 7)
+	if n := missedWarnings(); n != 1 {
+		t.Fatalf("%d rescan warnings after the interval, want 1", n)
+	}
+	clock.Advance(200 * time.Millisecond)
+	expectStatus(t, events, "app", StatusStopped)
+	if ev := expectStatus(t, events, "app", StatusRunning); ev.Restarts != 2 {
+		t.Errorf("restarts = %d after the second rescan, want 2", ev.Restarts)
+	}
+}
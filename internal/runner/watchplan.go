@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"prun/internal/config"
+)
+
+// skippedDirs describes the directories excludedDir leaves out of watching
+var skippedDirs = []string{".*", "node_modules", "vendor", "dist", "build"}
+
+// WatchPlan is what watch mode would watch and how it would react, as
+// `prun watch-plan` reports it
+type WatchPlan struct {
+	Debounce         string          `json:"debounce"`                // wait for changes to settle this long
+	AdaptiveDebounce bool            `json:"adaptive_debounce"`       // widened for files saved in bursts, up to maxDebounce
+	ConfirmAbove     int             `json:"confirm_above,omitempty"` // ask before a change restarts more tasks than this
+	SkippedDirs      []string        `json:"skipped_dirs"`            // directory names never watched below a root
+	Exclude          []string        `json:"exclude,omitempty"`       // [watch] exclude, matched before each task's watch_exclude
+	ConfigFiles      []string        `json:"config_files,omitempty"`  // reloaded on change, with watch_config
+	Tasks            []TaskWatchPlan `json:"tasks"`
+}
+
+// TaskWatchPlan is how watch mode treats one task
+type TaskWatchPlan struct {
+	Task            string   `json:"task"`
+	Watched         bool     `json:"watched"`
+	Roots           []string `json:"roots,omitempty"` // absolute directories watched, subdirectories included
+	Problems        []string `json:"problems,omitempty"`
+	Include         []string `json:"watch_include,omitempty"`
+	Exclude         []string `json:"watch_exclude,omitempty"`
+	EnvFiles        []string `json:"env_files,omitempty"` // reloaded on change, restarting the task if its environment changed
+	Restart         string   `json:"restart"`             // restart policy when it exits on its own
+	ShutdownTimeout string   `json:"shutdown_timeout"`    // how long a restart waits for it to exit after SIGTERM
+}
+
+// ChangeVerdict is whether a change to a file restarts a task, and why
+type ChangeVerdict struct {
+	Task     string `json:"task"`
+	Restarts bool   `json:"restarts"`
+	Reason   string `json:"reason"`
+}
+
+// PlanWatch returns what watch mode would do for tasks, as the watcher
+// started with watchAll (-w) sets it up
+func PlanWatch(cfg *config.Config, tasks []string, watchAll bool) WatchPlan {
+	debounce := newDebouncer(cfg.Watch.Debounce.Duration, time.Time{})
+	plan := WatchPlan{
+		Debounce:         debounce.base.String(),
+		AdaptiveDebounce: debounce.adaptive,
+		ConfirmAbove:     cfg.Watch.ConfirmAbove,
+		SkippedDirs:      skippedDirs,
+		Exclude:          cfg.Watch.Exclude,
+		Tasks:            []TaskWatchPlan{},
+	}
+	if cfg.WatchConfig {
+		plan.ConfigFiles = absPaths(cfg.ConfigPaths())
+	}
+
+	for _, taskName := range tasks {
+		taskDef := cfg.TaskDefs[taskName]
+		tp := TaskWatchPlan{
+			Task:            taskName,
+			Watched:         watchAll || taskDef.Watch,
+			Restart:         string(cmp.Or(taskDef.Restart, config.RestartNever)),
+			ShutdownTimeout: cmp.Or(taskDef.ShutdownTimeout.Duration, defaultShutdownTimeout).String(),
+		}
+		if tp.Watched {
+			tp.Roots = absPaths(taskDef.WatchDirs())
+			for _, root := range tp.Roots {
+				if err := checkWatchRoot(root); err != nil {
+					tp.Problems = append(tp.Problems, err.Error())
+				}
+			}
+			tp.Include, tp.Exclude = taskDef.WatchInclude, taskDef.WatchExclude
+			tp.EnvFiles = absPaths(cfg.EnvFilePaths(taskName))
+		}
+		plan.Tasks = append(plan.Tasks, tp)
+	}
+	return plan
+}
+
+// ExplainChange says, for each of tasks, whether a change to path would
+// restart it, by the rules the watcher applies at runtime. A change to an
+// env_file or, with watch_config, the config gets a verdict of its own.
+func ExplainChange(cfg *config.Config, tasks []string, watchAll bool, path string) ([]ChangeVerdict, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var verdicts []ChangeVerdict
+	if cfg.WatchConfig && slices.Contains(absPaths(cfg.ConfigPaths()), abs) {
+		verdicts = append(verdicts, ChangeVerdict{
+			Task:   SystemTask,
+			Reason: "reloads the config (watch_config); tasks whose definition or environment changed restart",
+		})
+	}
+	for _, taskName := range tasks {
+		taskDef := cfg.TaskDefs[taskName]
+		verdict := ChangeVerdict{Task: taskName}
+		switch {
+		case !watchAll && !taskDef.Watch:
+			verdict.Reason = "not watched (no watch = true, and prun runs without -w)"
+		case slices.Contains(absPaths(cfg.EnvFilePaths(taskName)), abs):
+			verdict.Restarts = true
+			verdict.Reason = "it is an env_file of the task: reloaded, restarting the task if a variable it gets changed"
+		default:
+			base, err := filepath.Abs(cmp.Or(taskDef.Path, "."))
+			if err != nil {
+				return nil, err
+			}
+			verdict.Restarts, verdict.Reason = matchChange(taskDef, cfg.Watch.Exclude, base, absPaths(taskDef.WatchDirs()), cfg.LogPaths(), abs)
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	return verdicts, nil
+}
+
+// matchChange decides whether a change to the file at abs restarts a watched
+// task whose path is base and whose watch roots are roots, all absolute: the
+// file must be under a root, in no directory that watching skips, neither
+// one of logPaths that prun writes itself nor a directory holding one, and
+// pass the task's watch_include and watch_exclude patterns after the shared
+// [watch] exclude ones. The reason says which rule decided.
+func matchChange(taskDef config.TaskDef, shared []string, base string, roots, logPaths []string, abs string) (bool, string) {
+	if i := slices.IndexFunc(logPaths, func(p string) bool { return within(p, abs) }); i >= 0 {
+		return false, fmt.Sprintf("prun writes it, as task output under %s", logPaths[i])
+	}
+	if i := slices.IndexFunc(logPaths, func(p string) bool { return within(abs, p) && abs != p }); i >= 0 {
+		return false, fmt.Sprintf("a directory prun creates for task output (%s)", logPaths[i])
+	}
+	i := slices.IndexFunc(roots, func(root string) bool { return within(root, abs) })
+	if i < 0 {
+		return false, fmt.Sprintf("outside the directories it watches (%s)", strings.Join(roots, ", "))
+	}
+	root := roots[i]
+
+	// Directories below the root that the walk skips are never registered
+	rel, _ := filepath.Rel(root, filepath.Dir(abs))
+	if rel != "." {
+		for _, dir := range strings.Split(rel, string(filepath.Separator)) {
+			if excludedDir(dir) {
+				return false, fmt.Sprintf("in %s, a directory watching skips (%s)", dir, strings.Join(skippedDirs, ", "))
+			}
+		}
+	}
+
+	rel, err := filepath.Rel(base, abs)
+	if err != nil {
+		return false, err.Error()
+	}
+	ok, rule := taskDef.WatchMatch(rel, shared)
+	switch {
+	case !ok && rule != "":
+		return false, fmt.Sprintf("%s matches %s", filepath.ToSlash(rel), rule)
+	case !ok:
+		return false, fmt.Sprintf("%s matches no watch_include pattern (%s)", filepath.ToSlash(rel), strings.Join(taskDef.WatchInclude, ", "))
+	case rule != "":
+		return true, fmt.Sprintf("under %s, and %s matches %s", root, filepath.ToSlash(rel), rule)
+	}
+	return true, "under " + root
+}
+
+// absPaths makes paths absolute, leaving out any that can't be
+func absPaths(paths []string) []string {
+	var abs []string
+	for _, p := range paths {
+		if a, err := filepath.Abs(p); err == nil {
+			abs = append(abs, a)
+		}
+	}
+	return abs
+}
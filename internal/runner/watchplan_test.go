@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"prun/internal/config"
+)
+
+func TestMatchChange(t *testing.T) {
+	base := filepath.FromSlash("/repo")
+	src := filepath.Join(base, "src")
+	tests := []struct {
+		name     string
+		task     config.TaskDef
+		shared   []string // [watch] exclude
+		roots    []string // default: base
+		path     string   // relative to base
+		restarts bool
+		reason   string // in the reason
+	}{
+		{
+			name:     "no patterns",
+			path:     "main.go",
+			restarts: true,
+			reason:   "under " + base,
+		},
+		{
+			name:     "** matches nested directories",
+			task:     config.TaskDef{WatchInclude: config.StringList{"src/**/*.go"}},
+			path:     "src/a/b/c.go",
+			restarts: true,
+			reason:   "matches watch_include pattern 'src/**/*.go'",
+		},
+		{
+			name:     "** matches no directory at all",
+			task:     config.TaskDef{WatchInclude: config.StringList{"src/**/*.go"}},
+			path:     "src/main.go",
+			restarts: true,
+		},
+		{
+			name:   "** stays within its prefix",
+			task:   config.TaskDef{WatchInclude: config.StringList{"src/**/*.go"}},
+			path:   "docs/main.go",
+			reason: "matches no watch_include pattern (src/**/*.go)",
+		},
+		{
+			name:   "! takes back an include",
+			task:   config.TaskDef{WatchInclude: config.StringList{"src/**", "!src/gen/**"}},
+			path:   "src/gen/api.go",
+			reason: "matches watch_include pattern '!src/gen/**'",
+		},
+		{
+			name:     "! only takes back what it matches",
+			task:     config.TaskDef{WatchInclude: config.StringList{"src/**", "!src/gen/**"}},
+			path:     "src/app.go",
+			restarts: true,
+			reason:   "matches watch_include pattern 'src/**'",
+		},
+		{
+			name:     "a later include wins over an earlier !",
+			task:     config.TaskDef{WatchInclude: config.StringList{"!src/gen/**", "src/**"}},
+			path:     "src/gen/api.go",
+			restarts: true,
+		},
+		{
+			name:   "exclude wins over include",
+			task:   config.TaskDef{WatchInclude: config.StringList{"**/*.go"}, WatchExclude: config.StringList{"**/*_test.go"}},
+			path:   "src/app_test.go",
+			reason: "matches watch_exclude pattern '**/*_test.go'",
+		},
+		{
+			name:   "an exclude covering the whole watch root",
+			task:   config.TaskDef{WatchExclude: config.StringList{"src/**"}},
+			roots:  []string{src},
+			path:   "src/app.go",
+			reason: "matches watch_exclude pattern 'src/**'",
+		},
+		{
+			name:   "a skipped directory below the watch root",
+			roots:  []string{src},
+			path:   "src/node_modules/lib/index.js",
+			reason: "in node_modules, a directory watching skips",
+		},
+		{
+			name:   "outside every root",
+			roots:  []string{src, filepath.Join(base, "lib")},
+			path:   "docs/readme.md",
+			reason: "outside the directories it watches",
+		},
+		{
+			name:   "outside the task's path",
+			path:   "../elsewhere/main.go",
+			reason: "outside the directories it watches",
+		},
+		{
+			name:   "prun's own log file",
+			path:   "logs/app.log",
+			reason: "prun writes it",
+		},
+		{
+			name:   "[watch] exclude applies to every task",
+			shared: []string{"**/*.tmp"},
+			path:   "src/x.tmp",
+			reason: "matches [watch] exclude pattern '**/*.tmp'",
+		},
+		{
+			name:     "a task's ! takes back a [watch] exclude",
+			task:     config.TaskDef{WatchExclude: config.StringList{"!keep.tmp"}},
+			shared:   []string{"**/*.tmp"},
+			path:     "keep.tmp",
+			restarts: true,
+		},
+		{
+			name:   "a [watch] ! doesn't take back a task's exclude",
+			task:   config.TaskDef{WatchExclude: config.StringList{"*.tmp"}},
+			shared: []string{"**/*.tmp", "!keep.tmp"},
+			path:   "keep.tmp",
+			reason: "matches watch_exclude pattern '*.tmp'",
+		},
+		{
+			name:   "[watch] exclude doesn't bring back what watch_include leaves out",
+			task:   config.TaskDef{WatchInclude: config.StringList{"*.go"}},
+			shared: []string{"!*.md"},
+			path:   "readme.md",
+			reason: "matches no watch_include pattern",
+		},
+	}
+	logPaths := []string{filepath.Join(base, "logs", "app.log")}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots := tt.roots
+			if roots == nil {
+				roots = []string{base}
+			}
+			abs := filepath.Join(base, filepath.FromSlash(tt.path))
+			restarts, reason := matchChange(tt.task, tt.shared, base, roots, logPaths, abs)
+			if restarts != tt.restarts {
+				t.Errorf("restarts = %t (%s), want %t", restarts, reason, tt.restarts)
+			}
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("reason = %q, want it to contain %q", reason, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package theme holds the glyphs prun shows for task statuses, shared by
+// the TUI and the console lifecycle lines.
+package theme
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Icons maps a status, or one of the lifecycle events "started" and
+// "restarted", to its glyph
+type Icons map[string]string
+
+// maxWidth is the most terminal columns a glyph may take, so the task list
+// stays aligned
+const maxWidth = 2
+
+// unicodeIcons is the default set
+var unicodeIcons = Icons{
+	"idle":      " ",
+	"queued":    "·",
+	"running":   "▲",
+	"ready":     "●",
+	"retrying":  "↻",
+	"draining":  "◌",
+	"done":      "✓",
+	"failed":    "✗",
+	"stopped":   "■",
+	"started":   "▶",
+	"restarted": "↻",
+}
+
+// asciiIcons is for terminals and fonts without the glyphs above
+var asciiIcons = Icons{
+	"idle":      " ",
+	"queued":    ".",
+	"running":   ">",
+	"ready":     "+",
+	"retrying":  "*",
+	"draining":  "o",
+	"done":      "ok",
+	"failed":    "x!",
+	"stopped":   "-",
+	"started":   ">",
+	"restarted": "*",
+}
+
+// New returns the icon set named by set ("unicode", "ascii", or "" to pick
+// by whether the locale is UTF-8) with overrides applied on top. Overrides
+// must name a known status and be one or two columns wide.
+func New(set string, overrides map[string]string) (Icons, error) {
+	var base Icons
+	switch set {
+	case "":
+		base = unicodeIcons
+		if !utf8Locale() {
+			base = asciiIcons
+		}
+	case "unicode":
+		base = unicodeIcons
+	case "ascii":
+		base = asciiIcons
+	default:
+		return nil, fmt.Errorf("invalid icon_set '%s' (expected unicode or ascii)", set)
+	}
+
+	icons := make(Icons, len(base))
+	for status, icon := range base {
+		icons[status] = icon
+	}
+	for status, icon := range overrides {
+		if _, ok := base[status]; !ok {
+			return nil, fmt.Errorf("unknown status '%s' in [ui.icons] (expected one of %s)", status, strings.Join(base.statuses(), ", "))
+		}
+		if w := lipgloss.Width(icon); w < 1 || w > maxWidth {
+			return nil, fmt.Errorf("icon '%s' for %s must be 1 or 2 columns wide", icon, status)
+		}
+		icons[status] = icon
+	}
+	return icons, nil
+}
+
+// Default returns the icon set picked by the locale, without overrides
+func Default() Icons {
+	icons, _ := New("", nil)
+	return icons
+}
+
+// Icon returns the glyph of a status, blank for statuses without one
+func (i Icons) Icon(status string) string {
+	if icon, ok := i[status]; ok {
+		return icon
+	}
+	return i["idle"]
+}
+
+// Padded returns the glyph of a status padded to Width, for columns
+func (i Icons) Padded(status string) string {
+	icon := i.Icon(status)
+	return icon + strings.Repeat(" ", i.Width()-lipgloss.Width(icon))
+}
+
+// Width is the width of the widest glyph
+func (i Icons) Width() int {
+	width := 1
+	for _, icon := range i {
+		width = max(width, lipgloss.Width(icon))
+	}
+	return width
+}
+
+func (i Icons) statuses() []string {
+	names := make([]string, 0, len(i))
+	for name := range i {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// utf8Locale reports whether the terminal is expected to render UTF-8, going
+// by the locale variables in order of precedence. Windows terminals are.
+func utf8Locale() bool {
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			value = strings.ToLower(value)
+			return strings.Contains(value, "utf-8") || strings.Contains(value, "utf8")
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package ui
+
+import (
+	"time"
+
+	"prun/internal/runner"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// control applies a task control to the selected task in the background,
+// as it may wait for a stopped run to exit. An error, such as starting a
+// task that is already running, is shown as a warning under prun.
+func (m *Model) control(do func(task string) error) tea.Cmd {
+	task := m.tasks[m.selected]
+	if do == nil || task == runner.SystemTask {
+		return nil
+	}
+	return func() tea.Msg {
+		if err := do(task); err != nil {
+			return logMsg(runner.LogEvent{
+				Task:  runner.SystemTask,
+				Line:  err.Error(),
+				IsErr: true,
+				Time:  time.Now(),
+				Level: runner.LevelWarn,
+			})
+		}
+		return nil
+	}
+}
+
+// active reports whether a task in this status has a run going or about to
+// go, which x stops rather than starts
+func active(status string) bool {
+	switch status {
+	case runner.StatusRunning, runner.StatusReady, runner.StatusRetrying, runner.StatusQueued, runner.StatusDraining:
+		return true
+	}
+	return false
+}
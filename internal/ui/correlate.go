@@ -0,0 +1,102 @@
+package ui
+
+import (
+	"regexp"
+	"slices"
+
+	"prun/internal/runner"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxCorrelated is how many matching lines the correlation view keeps
+const maxCorrelated = 1000
+
+// taskColors tell tasks apart in the correlation view
+var taskColors = []lipgloss.Color{"39", "170", "214", "113", "204", "141", "80", "222"}
+
+// correlation is a view of the lines of every task that match a filter, such
+// as a request or trace ID, in the order they were printed
+type correlation struct {
+	filter string
+	re     *regexp.Regexp
+	lines  []runner.LogEvent // sorted by time
+}
+
+// newCorrelation creates a view for filter, a regular expression or, if it
+// doesn't compile as one, a literal string, seeded with the matching lines
+// already in the log buffers
+func newCorrelation(filter string, logs map[string][]runner.LogEvent) *correlation {
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		re = regexp.MustCompile(regexp.QuoteMeta(filter))
+	}
+	c := &correlation{filter: filter, re: re}
+	for _, lines := range logs {
+		for _, ev := range lines {
+			if c.re.MatchString(ev.Line) {
+				c.lines = append(c.lines, ev)
+			}
+		}
+	}
+	slices.SortStableFunc(c.lines, func(a, b runner.LogEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+	if len(c.lines) > maxCorrelated {
+		c.lines = c.lines[len(c.lines)-maxCorrelated:]
+	}
+	return c
+}
+
+// add records a new line if it matches. Lines arrive nearly in order, but
+// batches from different tasks interleave, so each is inserted at its time.
+func (c *correlation) add(ev runner.LogEvent) {
+	if !c.re.MatchString(ev.Line) {
+		return
+	}
+	i := len(c.lines)
+	for i > 0 && c.lines[i-1].Time.After(ev.Time) {
+		i--
+	}
+	c.lines = slices.Insert(c.lines, i, ev)
+	if len(c.lines) > maxCorrelated {
+		c.lines = c.lines[1:]
+	}
+}
+
+// taskStyle returns the color of a task in the correlation view
+func (m *Model) taskStyle(task string) lipgloss.Style {
+	i := slices.Index(m.tasks, task)
+	if i < 0 {
+		i = len(m.tasks)
+	}
+	return lipgloss.NewStyle().Foreground(taskColors[i%len(taskColors)])
+}
+
+// correlationKey edits the filter being typed after T; enter applies it and
+// an empty filter clears the view
+func (m *Model) correlationKey(key tea.KeyMsg) tea.Cmd {
+	switch key.Type {
+	case tea.KeyRunes:
+		m.input += string(key.Runes)
+	case tea.KeySpace:
+		m.input += " "
+	case tea.KeyBackspace:
+		if r := []rune(m.input); len(r) > 0 {
+			m.input = string(r[:len(r)-1])
+		}
+	case tea.KeyEnter:
+		m.typing = false
+		m.correlation = nil
+		if m.input != "" {
+			m.correlation = newCorrelation(m.input, m.logs)
+		}
+		m.autoScroll = true
+	case tea.KeyEsc:
+		m.typing = false
+	case tea.KeyCtrlC:
+		return tea.Quit
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"prun/internal/config"
+	"prun/internal/runner"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxRuns is how many finished runs of each task the details panel lists
+const maxRuns = 5
+
+// recordRun keeps a status event that ends a run for the details panel
+func (m *Model) recordRun(ev runner.LogEvent) {
+	switch ev.Status {
+	case runner.StatusDone, runner.StatusFailed, runner.StatusStopped:
+	default:
+		return
+	}
+	// A later step's end is part of the same run
+	if ev.Steps > 0 && ev.Status == runner.StatusDone && ev.Step < ev.Steps {
+		return
+	}
+	runs := append(m.runs[ev.Task], ev)
+	if len(runs) > maxRuns {
+		runs = runs[len(runs)-maxRuns:]
+	}
+	m.runs[ev.Task] = runs
+}
+
+// detailsKey handles a key while the details panel is open: d or esc closes
+// it, and the arrows and PgUp/PgDn scroll it. Keys it doesn't handle, such
+// as q, fall through to the usual ones.
+func (m *Model) detailsKey(key string) bool {
+	switch key {
+	case "d", "esc":
+		m.describing = false
+	case "up", "k":
+		m.detailOffset = max(m.detailOffset-1, 0)
+	case "down", "j":
+		m.detailOffset++
+	case "pgup":
+		m.detailOffset = max(m.detailOffset-10, 0)
+	case "pgdown", " ":
+		m.detailOffset += 10
+	case "home":
+		m.detailOffset = 0
+	default:
+		return false
+	}
+	return true
+}
+
+// detailLines renders the details panel of a task: its resolved settings,
+// one per line with values wrapped to width, then its last runs
+func (m *Model) detailLines(task string, width int) []string {
+	nameStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("14"))
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+
+	var details []config.Detail
+	if m.describe != nil {
+		details = m.describe(task)
+	}
+	var lines []string
+	if len(details) == 0 {
+		lines = append(lines, dim.Render("(no settings to show)"))
+	}
+	nameWidth := 0
+	for _, d := range details {
+		nameWidth = max(nameWidth, len(d.Name))
+	}
+	valueWidth := max(width-nameWidth-2, 10)
+	for _, d := range details {
+		name := nameStyle.Render(fmt.Sprintf("%-*s", nameWidth, d.Name))
+		indent := strings.Repeat(" ", nameWidth)
+		for i, part := range wrapLine(d.Value, valueWidth) {
+			if i == 0 {
+				lines = append(lines, name+"  "+part)
+			} else {
+				lines = append(lines, indent+"  "+part)
+			}
+		}
+	}
+
+	lines = append(lines, "", nameStyle.Render("recent runs"))
+	runs := m.runs[task]
+	if len(runs) == 0 {
+		lines = append(lines, dim.Render("(none finished yet)"))
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("%s %s  %s", m.icons.Padded(runs[i].Status), runs[i].Time.Format("15:04:05"), runOutcome(runs[i])))
+	}
+	return lines
+}
+
+// runOutcome describes how a run ended, e.g. "exit 1 after 2.3s"
+func runOutcome(ev runner.LogEvent) string {
+	switch {
+	case ev.Status == runner.StatusDone:
+		return "finished in " + runner.FormatElapsed(ev.Elapsed)
+	case ev.Status == runner.StatusFailed && ev.Allowed:
+		return exitDetail(ev) + " (allowed)"
+	case ev.Status == runner.StatusFailed:
+		return exitDetail(ev)
+	case ev.Auto:
+		return "auto-stopped (" + ev.Reason + ")"
+	case ev.Reason != "":
+		return "skipped (" + ev.Reason + ")"
+	}
+	return "stopped after " + runner.FormatElapsed(ev.Elapsed)
+}
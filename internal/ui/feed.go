@@ -0,0 +1,142 @@
+package ui
+
+import (
+	"slices"
+	"sync"
+
+	"prun/internal/runner"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxBatch is the most events applied to the model in one update, and so
+// between two renders
+const maxBatch = 200
+
+// maxQueued is the most log lines held per task while the TUI catches up.
+// Older lines are dropped first; the model only keeps maxLogLines per task
+// anyway. Status events are never dropped.
+const maxQueued = 5000
+
+// batchMsg carries events taken from the feed in round-robin order, and how
+// many events are still queued per task afterwards
+type batchMsg struct {
+	events  []runner.LogEvent
+	pending map[string]int
+}
+
+// feed sits between the event stream and the TUI. It drains the stream into
+// per-task queues as fast as events arrive and hands them to the model in
+// batches taken round-robin across tasks, so a task flooding the stream
+// can't hold up the lines of the others.
+type feed struct {
+	mu     sync.Mutex
+	queues map[string][]runner.LogEvent
+	order  []string // tasks in the order they first sent an event
+	next   int      // index in order of the task to take from next
+	closed bool
+	wake   chan struct{}
+}
+
+func newFeed() *feed {
+	return &feed{
+		queues: make(map[string][]runner.LogEvent),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// push queues an event, dropping the task's oldest queued line if it is over
+// maxQueued
+func (f *feed) push(ev runner.LogEvent) {
+	f.mu.Lock()
+	q, ok := f.queues[ev.Task]
+	if !ok {
+		f.order = append(f.order, ev.Task)
+	}
+	q = append(q, ev)
+	if len(q) > maxQueued {
+		if !q[0].IsStatus() {
+			q = q[1:]
+		} else if i := slices.IndexFunc(q, func(ev runner.LogEvent) bool { return !ev.IsStatus() }); i >= 0 {
+			q = slices.Delete(q, i, i+1)
+		}
+	}
+	f.queues[ev.Task] = q
+	f.mu.Unlock()
+	f.notify()
+}
+
+// close marks the end of the stream; take reports done once it's drained
+func (f *feed) close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.notify()
+}
+
+func (f *feed) notify() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes up to max events, one per task in turn, and returns them with
+// the number left per task. done is set once the stream ended and every
+// queue is empty.
+func (f *feed) take(max int) (batch []runner.LogEvent, pending map[string]int, done bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(batch) < max {
+		took := false
+		for i := 0; i < len(f.order) && len(batch) < max; i++ {
+			task := f.order[f.next]
+			f.next = (f.next + 1) % len(f.order)
+			if q := f.queues[task]; len(q) > 0 {
+				batch = append(batch, q[0])
+				f.queues[task] = q[1:]
+				took = true
+			}
+		}
+		if !took {
+			break
+		}
+	}
+
+	pending = make(map[string]int)
+	for task, q := range f.queues {
+		if len(q) > 0 {
+			pending[task] = len(q)
+		}
+	}
+	return batch, pending, f.closed && len(pending) == 0
+}
+
+// run reads events into the feed and sends them on as batches until events
+// is closed and everything queued was sent. send blocks until the model has
+// applied a batch, which paces the batches to the TUI's speed.
+func (f *feed) run(events <-chan runner.LogEvent, send func(tea.Msg)) {
+	go func() {
+		defer runner.RecoverPanic()
+		for ev := range events {
+			f.push(ev)
+		}
+		f.close()
+	}()
+
+	for range f.wake {
+		for {
+			batch, pending, done := f.take(maxBatch)
+			if len(batch) > 0 {
+				send(batchMsg{events: batch, pending: pending})
+			}
+			if done {
+				return
+			}
+			if len(batch) == 0 {
+				break
+			}
+		}
+	}
+}
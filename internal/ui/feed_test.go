@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"prun/internal/runner"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestFeedSlowTaskNotHeldUpByFlood(t *testing.T) {
+	const (
+		floodLines = 50000
+		chunk      = 1000 // flood lines arriving while the model applies a batch
+		every      = 2500 // flood lines between two lines of the slow task
+	)
+
+	f := newFeed()
+	events := make(chan runner.LogEvent)
+	flooded, batches, backlog := 0, 0, 0
+	pushedAt := make(map[string]int)    // slow line -> batches sent before it was pushed
+	deliveredAt := make(map[string]int) // slow line -> the batch that carried it
+	var want, got []string
+
+	// produce pushes the next chunk, as if it came in while the model was
+	// busy, and ends the stream after the last
+	produce := func() {
+		for range chunk {
+			flooded++
+			f.push(runner.LogEvent{Task: "flood", Line: fmt.Sprintf("flood %d", flooded)})
+			if flooded%every == 0 {
+				line := fmt.Sprintf("slow %d", flooded/every)
+				pushedAt[line] = batches
+				want = append(want, line)
+				f.push(runner.LogEvent{Task: "slow", Line: line})
+			}
+		}
+		if flooded == floodLines {
+			close(events)
+		}
+	}
+	send := func(msg tea.Msg) {
+		b := msg.(batchMsg)
+		batches++
+		if len(b.events) > maxBatch {
+			t.Errorf("batch %d has %d events, over %d", batches, len(b.events), maxBatch)
+		}
+		backlog = max(backlog, b.pending["flood"])
+		for _, ev := range b.events {
+			if ev.Task == "slow" {
+				deliveredAt[ev.Line] = batches
+				got = append(got, ev.Line)
+			}
+		}
+		if flooded < floodLines {
+			produce()
+		}
+	}
+
+	produce()
+	done := make(chan struct{})
+	go func() {
+		f.run(events, send)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("feed didn't drain within 10s")
+	}
+
+	if backlog < maxQueued-chunk {
+		t.Fatalf("the flood queued at most %d lines, so it held nothing up", backlog)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("slow lines arrived as %v, want %v", got, want)
+	}
+	// However long the flood's queue, the next batch carries a slow line
+	for _, line := range want {
+		if wait := deliveredAt[line] - pushedAt[line]; wait != 1 {
+			t.Errorf("%q came %d batches after it was queued, want the next one", line, wait)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// interactKey handles a key in interact mode, where typing goes to the
+// selected task's stdin a line at a time, echoed in the footer since the
+// task has no terminal to echo it. ctrl+z or esc go back to navigating.
+func (m *Model) interactKey(key tea.KeyMsg) tea.Cmd {
+	switch key.Type {
+	case tea.KeyRunes:
+		m.stdinLine += string(key.Runes)
+	case tea.KeySpace:
+		m.stdinLine += " "
+	case tea.KeyTab:
+		m.stdinLine += "\t"
+	case tea.KeyBackspace:
+		if r := []rune(m.stdinLine); len(r) > 0 {
+			m.stdinLine = string(r[:len(r)-1])
+		}
+	case tea.KeyEnter:
+		line := []byte(m.stdinLine + "\n")
+		m.stdinLine = ""
+		m.autoScroll = true
+		return m.control(func(task string) error {
+			return m.sendInput(task, line)
+		})
+	case tea.KeyCtrlD:
+		// The task reads end of file; there is nothing left to type into
+		m.interacting, m.stdinLine = false, ""
+		return m.control(m.closeInput)
+	case tea.KeyCtrlZ, tea.KeyEsc:
+		m.interacting, m.stdinLine = false, ""
+	case tea.KeyCtrlC:
+		return tea.Quit
+	}
+	return nil
+}
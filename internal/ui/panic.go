@@ -0,0 +1,42 @@
+package ui
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// program is the running TUI, for ReleaseTerminal
+var program atomic.Pointer[tea.Program]
+
+// ReleaseTerminal leaves the alternate screen and raw mode if the TUI is
+// running. prun calls it before reporting a panic outside the TUI, which
+// would otherwise leave the terminal without echo.
+func ReleaseTerminal() {
+	if p := program.Load(); p != nil {
+		p.ReleaseTerminal()
+	}
+}
+
+// PanicError is returned by Start when the TUI panicked. bubbletea has given
+// the terminal back by then. Stack is nil for a panic in a command, whose
+// stack bubbletea prints itself.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// notePanic keeps a panic of Update or View for Start to return, then
+// panics again for bubbletea to restore the terminal
+func (m *Model) notePanic() {
+	if v := recover(); v != nil {
+		m.panicked = &PanicError{Value: v, Stack: debug.Stack()}
+		panic(v)
+	}
+}
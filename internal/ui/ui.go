@@ -1,11 +1,17 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"prun/internal/config"
 	"prun/internal/runner"
+	"prun/internal/theme"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -14,27 +20,163 @@ import (
 // Model implements a simple TUI with left task list and right log pane
 type Model struct {
 	tasks       []string
-	statuses    map[string]string // "idle", "running", "done", "failed"
-	logs        []string
+	statuses    map[string]string            // "idle", "running", "done", "failed"
+	logs        map[string][]runner.LogEvent // last maxLogLines lines per task
+	pending     map[string]int               // events per task queued behind a flood
 	selected    int
-	interacting bool
+	interacting bool   // keys go to the selected task's stdin, typed into stdinLine
+	stdinLine   string // the line being typed, sent on enter
 	width       int
 	height      int
-	autoScroll  bool // auto-scroll to bottom of logs
-	logOffset   int  // scroll offset for logs pane
+	autoScroll  bool                       // auto-scroll to bottom of logs
+	logOffset   int                        // scroll offset for logs pane
+	details     map[string]runner.LogEvent // last status event per task
+	compact     bool                       // hide detail lines in the task list
+	stream      streamFilter               // which output streams the log pane shows
+	frame       int                        // spinner frame, advanced on every tick
+	tick        time.Duration              // interval of ticks while something animates
+	ticking     time.Duration              // interval of the scheduled tick; 0 if none
+	tickID      int                        // the scheduled tick; earlier ones are stale
+	noSpinner   bool                       // show a static placeholder instead of the spinner
+	markers     bool                       // prefix log lines with their stream marker
+	stderrMark  string
+	stdoutMark  string
+	icons       theme.Icons      // status glyphs of the task list
+	prefixes    *runner.Prefixes // labels of tasks in the list and correlation view; full names if nil
+	groups      []string         // groups named on the command line, shown in the title
+
+	// A watch-mode restart waiting for the user, and the picker for
+	// restarting some of its tasks
+	prompt     *runner.PendingRestart
+	picking    bool
+	picked     map[string]bool
+	pickCursor int
+	resolve    func(id int, tasks []string) bool
+
+	// The filter typed after T, and the view of matching lines across all
+	// tasks once it is applied
+	typing      bool
+	input       string
+	correlation *correlation
+
+	// The details panel of the selected task, opened with d, in place of
+	// its logs
+	describing   bool
+	detailOffset int
+	describe     func(task string) []config.Detail
+	runs         map[string][]runner.LogEvent // the last maxRuns ends of each task's runs
+
+	// Restarting, stopping and starting the selected task with r, x and enter
+	restartTask func(task string) error
+	stopTask    func(task string) error
+	startTask   func(task string) error
+
+	// Sending what is typed in interact mode to the selected task's stdin
+	sendInput  func(task string, input []byte) error
+	closeInput func(task string) error
+
+	// prun's own diagnostics get an entry at the bottom of the task list
+	// once the first arrives; unseen counts warnings not looked at yet
+	unseen       int
+	lastSelected int // task to go back to when s leaves the system entry
+
+	panicked *PanicError // a panic of Update or View, for Start to return
+}
+
+// spinnerFrames animate the placeholder of a running task with no output yet
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// streamFilter selects which output streams are shown in the log pane
+type streamFilter int
+
+const (
+	streamBoth streamFilter = iota
+	streamStderr
+	streamStdout
+)
+
+// next returns the filter that follows f in the toggle cycle
+func (f streamFilter) next() streamFilter {
+	return (f + 1) % 3
+}
+
+// matches reports whether an event passes the filter
+func (f streamFilter) matches(ev runner.LogEvent) bool {
+	switch f {
+	case streamStderr:
+		return ev.IsErr
+	case streamStdout:
+		return !ev.IsErr
+	}
+	return true
+}
+
+// label is shown in the log pane title when the filter is active
+func (f streamFilter) label() string {
+	switch f {
+	case streamStderr:
+		return "stderr"
+	case streamStdout:
+		return "stdout"
+	}
+	return ""
 }
 
-// StatusIcon returns the visual indicator for a task status
-func StatusIcon(status string) string {
-	switch status {
-	case "running":
-		return "▲" // triangle up
-	case "done":
-		return "✓" // checkmark
-	case "failed":
-		return "✗" // cross
+// Options configures the TUI
+type Options struct {
+	CompactList bool             // start with detail lines hidden
+	NoSpinner   bool             // show "(no logs yet)" instead of a spinner
+	Icons       theme.Icons      // status glyphs; the locale's default set if nil
+	Prefixes    *runner.Prefixes // shortened task names, as in console output
+	Groups      []string         // groups the tasks were selected by, named in the list's title
+
+	// ResolveRestart settles a restart pending confirmation with the tasks
+	// to restart, none to cancel it
+	ResolveRestart func(id int, tasks []string) bool
+	FPS            int           // redraws per second while something animates; 5 if 0
+	Resume         *State        // view state to restore, from a previous process
+	Quit           chan struct{} // closing it quits the TUI
+
+	// Describe returns the settings the details panel shows for a task
+	Describe func(task string) []config.Detail
+
+	// RestartTask, StopTask and StartTask control the selected task from
+	// the r, x and enter keys; an error they return is shown under prun
+	RestartTask func(task string) error
+	StopTask    func(task string) error
+	StartTask   func(task string) error
+
+	// SendInput and CloseInput pass what is typed in interact mode, entered
+	// with i, to the selected task's stdin, and end it on ctrl+d
+	SendInput  func(task string, input []byte) error
+	CloseInput func(task string) error
+
+	// Stream markers put in front of log lines, shown from the start if
+	// ShowMarkers is set and toggled with the m key
+	ShowMarkers  bool
+	StderrMarker string
+	StdoutMarker string
+}
+
+// State is the part of the TUI's view that survives prun re-executing itself
+type State struct {
+	Selected string `json:"selected,omitempty"`
+	Compact  bool   `json:"compact,omitempty"`
+	Stream   int    `json:"stream,omitempty"`
+	Markers  bool   `json:"markers,omitempty"`
+}
+
+// levelStyle returns the log pane style for a structured log level
+func levelStyle(level string) lipgloss.Style {
+	switch level {
+	case "error", "fatal", "panic", "critical":
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	case "warn", "warning":
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("226"))
+	case "debug", "trace":
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
 	default:
-		return " " // idle/pending
+		return lipgloss.NewStyle()
 	}
 }
 
@@ -47,43 +189,445 @@ func NewModel(tasks []string) *Model {
 	return &Model{
 		tasks:      tasks,
 		statuses:   st,
-		logs:       []string{},
+		logs:       make(map[string][]runner.LogEvent),
+		pending:    make(map[string]int),
 		width:      80, // default width
 		height:     24, // default height
 		autoScroll: true,
 		logOffset:  0,
+		details:    make(map[string]runner.LogEvent),
+		runs:       make(map[string][]runner.LogEvent),
+		icons:      theme.Default(),
+	}
+}
+
+// formatCount abbreviates a count as in "950", "1.2k" or "34k"
+func formatCount(n int) string {
+	switch {
+	case n < 1000:
+		return strconv.Itoa(n)
+	case n < 10000:
+		return strconv.FormatFloat(float64(n)/1000, 'f', 1, 64) + "k"
+	default:
+		return strconv.Itoa(n/1000) + "k"
+	}
+}
+
+// statusDetail returns the dim sub-state line shown under a task, or "" if none
+func statusDetail(ev runner.LogEvent, now time.Time) string {
+	switch ev.Status {
+	case runner.StatusQueued:
+		if ev.Reason != "" {
+			return ev.Reason
+		}
+		if ev.Ahead > 0 {
+			noun := "tasks"
+			if ev.Ahead == 1 {
+				noun = "task"
+			}
+			return fmt.Sprintf("queued behind %d %s", ev.Ahead, noun)
+		}
+		return "queued"
+	case runner.StatusRetrying:
+		detail := "retrying"
+		if !ev.Until.IsZero() {
+			remaining := ev.Until.Sub(now)
+			if remaining < 0 {
+				remaining = 0
+			}
+			// Round up so the countdown never shows 0s while still waiting
+			secs := int((remaining + time.Second - 1) / time.Second)
+			detail = fmt.Sprintf("retrying in %ds", secs)
+		}
+		if ev.Attempt > 0 {
+			if ev.MaxAttempts > 0 {
+				detail += fmt.Sprintf(" (attempt %d/%d)", ev.Attempt, ev.MaxAttempts)
+			} else {
+				detail += fmt.Sprintf(" (attempt %d)", ev.Attempt)
+			}
+		}
+		return detail
+	case runner.StatusDraining:
+		noun := "processes"
+		if ev.Survivors == 1 {
+			noun = "process"
+		}
+		return fmt.Sprintf("exited, %d %s left", ev.Survivors, noun)
+	case runner.StatusStopped:
+		if ev.Auto {
+			return "auto-stopped: " + ev.Reason
+		}
+		if ev.Reason != "" {
+			return "skipped: " + ev.Reason
+		}
+	case runner.StatusDone:
+		return "in " + runner.FormatElapsed(ev.Elapsed)
+	case runner.StatusFailed:
+		var parts []string
+		if ev.Steps > 0 {
+			parts = append(parts, fmt.Sprintf("step %d/%d", ev.Step, ev.Steps))
+		}
+		parts = append(parts, exitDetail(ev))
+		if ev.Allowed {
+			parts = append(parts, "allowed")
+		}
+		return strings.Join(parts, ", ")
+	case runner.StatusReady:
+		return "ready after " + runner.FormatElapsed(ev.Elapsed)
+	case runner.StatusRunning:
+		if ev.Steps > 0 {
+			return fmt.Sprintf("step %d/%d", ev.Step, ev.Steps)
+		}
+	}
+	return ""
+}
+
+// exitDetail describes how a failed run ended, e.g. "exit 1 after 2.3s"
+func exitDetail(ev runner.LogEvent) string {
+	if ev.ExitCode == -1 && ev.Elapsed == 0 {
+		return "failed to start"
+	}
+	return fmt.Sprintf("exit %d after %s", ev.ExitCode, runner.FormatElapsed(ev.Elapsed))
+}
+
+// title heads the task list, naming the groups the tasks were selected by
+func (m *Model) title() string {
+	if len(m.groups) == 0 {
+		return "Tasks"
+	}
+	return "Tasks (" + strings.Join(m.groups, ", ") + ")"
+}
+
+// visibleWindow picks the range of entries [start, end) to display so that the
+// selected entry is visible and the summed heights fit within budget lines.
+// Entries are added alternately below and above the selection to keep it centered.
+func visibleWindow(heights []int, selected, budget int) (int, int) {
+	if len(heights) == 0 {
+		return 0, 0
+	}
+	start, end := selected, selected+1
+	used := heights[selected]
+	for {
+		grew := false
+		if end < len(heights) && used+heights[end] <= budget {
+			used += heights[end]
+			end++
+			grew = true
+		}
+		if start > 0 && used+heights[start-1] <= budget {
+			start--
+			used += heights[start]
+			grew = true
+		}
+		if !grew {
+			return start, end
+		}
+	}
+}
+
+// waiting returns the spinner line for a running task that hasn't printed
+// anything yet, with the time since it started, or "" for any other task
+func (m *Model) waiting(task string, now time.Time) string {
+	ev := m.details[task]
+	if m.noSpinner || m.statuses[task] != runner.StatusRunning || ev.Status != runner.StatusRunning || ev.Time.IsZero() {
+		return ""
 	}
+	elapsed := now.Sub(ev.Time).Truncate(time.Second)
+	return fmt.Sprintf("%s starting… %s", spinnerFrames[m.frame%len(spinnerFrames)], elapsed)
 }
 
+// maxLogLines is how many lines the log pane keeps per task
+const maxLogLines = 500
+
+// catchingUpAt is the queue length from which a task shows how far behind
+// its log pane is
+const catchingUpAt = 100
+
 // Msg types
 type logMsg runner.LogEvent
-type tickMsg time.Time
+type tickMsg int // the tickID it was scheduled as
+
+// defaultTick is the tick interval when Options.FPS is not set
+const defaultTick = 200 * time.Millisecond
+
+// heartbeat is the tick interval while the only thing changing by itself is
+// the resource usage shown for the selected running task
+const heartbeat = 2 * time.Second
 
 func (m *Model) Init() tea.Cmd {
-	// send a tick to refresh UI every 200ms
-	return tea.Batch(
-		tea.Tick(time.Millisecond*200, func(t time.Time) tea.Msg { return tickMsg(t) }),
-		tea.WindowSize(),
-	)
+	return tea.Batch(m.scheduleTick(), tea.WindowSize())
+}
+
+// scheduleTick returns a command for the next tick if something on screen
+// changes with time and no tick that soon is pending. A tick replaced by a
+// sooner one is ignored when it fires. Everything else redraws only on new
+// events, keys and resizes, so an idle TUI costs next to nothing.
+func (m *Model) scheduleTick() tea.Cmd {
+	tick := m.tickInterval()
+	if tick == 0 || (m.ticking != 0 && m.ticking <= tick) {
+		return nil
+	}
+	m.ticking = tick
+	m.tickID++
+	id := m.tickID
+	return tea.Tick(tick, func(time.Time) tea.Msg { return tickMsg(id) })
+}
+
+// tickInterval returns how soon the view changes by itself: a frame while
+// something animates, a heartbeat while the selected task's usage is shown,
+// and 0 if it doesn't
+func (m *Model) tickInterval() time.Duration {
+	if m.animating() {
+		if m.tick > 0 {
+			return m.tick
+		}
+		return defaultTick
+	}
+	// usage is sampled in the background, without events
+	if len(m.tasks) > 0 && runner.IsRunning(m.statuses[m.tasks[m.selected]]) {
+		return heartbeat
+	}
+	return 0
+}
+
+// animating reports whether the view shows anything that changes with time:
+// the spinner of the selected task or a retry countdown
+func (m *Model) animating() bool {
+	if len(m.tasks) > 0 && m.waiting(m.tasks[m.selected], time.Now()) != "" {
+		return true
+	}
+	if m.compact {
+		return false
+	}
+	for _, t := range m.tasks {
+		if ev := m.details[t]; ev.Status == runner.StatusRetrying && !ev.Until.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// apply records an event in the model
+func (m *Model) apply(ev runner.LogEvent) {
+	if p := ev.Pending; p != nil {
+		if !p.Done {
+			m.prompt, m.picking = p, false
+		} else if m.prompt != nil && m.prompt.ID == p.ID {
+			m.prompt, m.picking = nil, false
+		}
+	}
+	if ev.Task == runner.SystemTask {
+		m.addSystem(ev)
+		if len(ev.Added)+len(ev.Removed) > 0 {
+			m.changeTasks(ev.Added, ev.Removed)
+		}
+	}
+	if ev.IsStatus() {
+		m.statuses[ev.Task] = ev.Status
+		m.details[ev.Task] = ev
+		m.recordRun(ev)
+		return
+	}
+	// append to the task's logs, keeping them bounded, and update status
+	if m.correlation != nil {
+		m.correlation.add(ev)
+	}
+	logs := append(m.logs[ev.Task], ev)
+	if len(logs) > maxLogLines {
+		logs = logs[len(logs)-maxLogLines:]
+	}
+	m.logs[ev.Task] = logs
+	if !runner.IsRunning(m.statuses[ev.Task]) {
+		m.statuses[ev.Task] = runner.StatusRunning
+	}
+}
+
+// promptKey handles a key while a restart waits for confirmation: y restarts
+// all its tasks, n none, and s opens a picker for some of them
+func (m *Model) promptKey(key string) (tea.Cmd, bool) {
+	tasks := m.prompt.Tasks
+	if m.picking {
+		switch key {
+		case "left", "h":
+			m.pickCursor = (m.pickCursor + len(tasks) - 1) % len(tasks)
+		case "right", "l", "tab":
+			m.pickCursor = (m.pickCursor + 1) % len(tasks)
+		case " ", "x":
+			m.picked[tasks[m.pickCursor]] = !m.picked[tasks[m.pickCursor]]
+		case "enter":
+			var chosen []string
+			for _, t := range tasks {
+				if m.picked[t] {
+					chosen = append(chosen, t)
+				}
+			}
+			return m.resolveRestart(chosen), true
+		case "esc":
+			m.picking = false
+		case "ctrl+c":
+			return nil, false
+		}
+		return nil, true
+	}
+
+	switch key {
+	case "y":
+		return m.resolveRestart(tasks), true
+	case "n":
+		return m.resolveRestart(nil), true
+	case "s":
+		m.picking, m.pickCursor = true, 0
+		m.picked = make(map[string]bool, len(tasks))
+		for _, t := range tasks {
+			m.picked[t] = true
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+// resolveRestart closes the prompt and passes the answer to the watcher
+func (m *Model) resolveRestart(tasks []string) tea.Cmd {
+	id := m.prompt.ID
+	m.prompt, m.picking = nil, false
+	resolve := m.resolve
+	if resolve == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		resolve(id, tasks)
+		return nil
+	}
+}
+
+// promptLine renders the pending restart prompt shown in place of the help line
+func (m *Model) promptLine() string {
+	p := m.prompt
+	if !m.picking {
+		return fmt.Sprintf("%d tasks affected by %s change — restart all? [y/n/s(elect)]", len(p.Tasks), filepath.Base(p.Path))
+	}
+	parts := make([]string, len(p.Tasks))
+	for i, t := range p.Tasks {
+		box := "[ ]"
+		if m.picked[t] {
+			box = "[x]"
+		}
+		parts[i] = box + " " + t
+		if i == m.pickCursor {
+			parts[i] = lipgloss.NewStyle().Underline(true).Render(parts[i])
+		}
+	}
+	return "restart: " + strings.Join(parts, "  ") + " — ←/→ move, space toggle, enter restart, esc back"
+}
+
+// addSystem makes room for one of prun's own diagnostics in the task list,
+// and counts it as unseen if it is a warning and the entry isn't selected
+func (m *Model) addSystem(ev runner.LogEvent) {
+	if !slices.Contains(m.tasks, runner.SystemTask) {
+		m.tasks = append(slices.Clip(m.tasks), runner.SystemTask)
+		m.statuses[runner.SystemTask] = runner.StatusIdle
+	}
+	if m.tasks[m.selected] != runner.SystemTask && (ev.Level == runner.LevelWarn || ev.Level == runner.LevelError) {
+		m.unseen++
+	}
+}
+
+// changeTasks follows a config reload in the task list: removed tasks go,
+// with what is known of them, and added ones join above prun's own entry.
+// The selection stays on the same task, or its place if it was removed.
+func (m *Model) changeTasks(added, removed []string) {
+	selected := m.tasks[m.selected]
+	tasks := slices.DeleteFunc(slices.Clone(m.tasks), func(t string) bool {
+		return slices.Contains(removed, t)
+	})
+	for _, t := range removed {
+		delete(m.statuses, t)
+		delete(m.details, t)
+		delete(m.logs, t)
+		delete(m.runs, t)
+	}
+	for _, t := range added {
+		if slices.Contains(tasks, t) {
+			continue
+		}
+		if _, ok := m.statuses[t]; !ok {
+			m.statuses[t] = runner.StatusIdle
+		}
+		i := slices.Index(tasks, runner.SystemTask)
+		if i < 0 {
+			i = len(tasks)
+		}
+		tasks = slices.Insert(tasks, i, t)
+	}
+	m.tasks = tasks
+	if i := slices.Index(tasks, selected); i >= 0 {
+		m.selected = i
+	} else {
+		m.selected = min(m.selected, len(tasks)-1)
+	}
+	m.lastSelected = min(m.lastSelected, len(tasks)-1)
+}
+
+// toggleSystem selects the entry of prun's diagnostics, or goes back to the
+// task selected before
+func (m *Model) toggleSystem() {
+	i := slices.Index(m.tasks, runner.SystemTask)
+	if i < 0 {
+		return
+	}
+	if m.selected == i {
+		m.selected = min(m.lastSelected, len(m.tasks)-1)
+	} else {
+		m.lastSelected, m.selected = m.selected, i
+	}
+	m.autoScroll = true
 }
 
 // Update handles messages
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	defer m.notePanic()
 	switch md := msg.(type) {
 	case logMsg:
-		ev := runner.LogEvent(md)
-		// append to logs and update status
-		m.logs = append(m.logs, fmt.Sprintf("[%s] %s", ev.Task, ev.Line))
-		m.statuses[ev.Task] = "running"
-		// keep logs bounded
-		if len(m.logs) > 500 {
-			m.logs = m.logs[len(m.logs)-500:]
-		}
-		return m, nil
+		m.apply(runner.LogEvent(md))
+		return m, m.scheduleTick()
+	case batchMsg:
+		for _, ev := range md.events {
+			m.apply(ev)
+		}
+		m.pending = md.pending
+		return m, m.scheduleTick()
 	case tea.KeyMsg:
+		if m.typing {
+			return m, m.correlationKey(md)
+		}
+		if m.interacting {
+			return m, m.interactKey(md)
+		}
+		if m.prompt != nil {
+			if cmd, handled := m.promptKey(md.String()); handled {
+				return m, cmd
+			}
+		}
+		if m.describing && m.detailsKey(md.String()) {
+			return m, nil
+		}
 		switch md.String() {
-		case "q", "esc", "ctrl+c":
+		case "esc":
+			if m.correlation == nil {
+				return m, tea.Quit
+			}
+			// Leave the correlation view
+			m.correlation = nil
+			m.autoScroll = true
+		case "q", "ctrl+c":
 			return m, tea.Quit
+		case "T":
+			// Filter the lines of every task by a trace ID or pattern
+			m.typing = true
+			m.input = ""
+			if m.correlation != nil {
+				m.input = m.correlation.filter
+			}
 		case "up", "k":
 			if m.selected > 0 {
 				m.selected--
@@ -115,25 +659,66 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Jump to bottom of logs
 			m.autoScroll = true
 			m.logOffset = 0
+		case "c":
+			// Toggle detail lines in the task list
+			m.compact = !m.compact
+		case "m":
+			// Toggle the stdout/stderr markers in front of log lines
+			m.markers = !m.markers
+		case "e":
+			// Cycle the log pane between both streams, stderr only, and stdout only
+			m.stream = m.stream.next()
+			m.autoScroll = true
+		case "s":
+			// Jump to prun's own diagnostics and back
+			m.toggleSystem()
+		case "d":
+			// Show the selected task's settings and last runs
+			if m.tasks[m.selected] != runner.SystemTask {
+				m.describing, m.detailOffset = true, 0
+			}
+		case "r":
+			return m, m.control(m.restartTask)
+		case "x":
+			// Stop the selected task, or start it again once stopped
+			if active(m.statuses[m.tasks[m.selected]]) {
+				return m, m.control(m.stopTask)
+			}
+			return m, m.control(m.startTask)
+		case "enter":
+			return m, m.control(m.startTask)
+		case "i":
+			// Type into the selected task's stdin
+			if m.sendInput != nil && m.tasks[m.selected] != runner.SystemTask {
+				m.interacting, m.stdinLine = true, ""
+				m.autoScroll = true
+			}
+		}
+		if m.tasks[m.selected] == runner.SystemTask {
+			m.unseen = 0
 		}
-		return m, nil
+		// selecting a task or expanding the list may reveal a spinner or countdown
+		return m, m.scheduleTick()
 	case tickMsg:
-		// schedule next tick
-		return m, tea.Tick(time.Millisecond*200, func(t time.Time) tea.Msg { return tickMsg(t) })
+		if int(md) != m.tickID {
+			return m, nil
+		}
+		m.ticking = 0
+		m.frame++
+		// keep ticking only while something changes with time
+		return m, m.scheduleTick()
 	case tea.WindowSizeMsg:
 		m.width = md.Width
 		m.height = md.Height
-		// Force a full redraw on resize by returning a batch command
-		return m, tea.Batch(
-			tea.ClearScreen,
-			tea.Tick(time.Millisecond*50, func(t time.Time) tea.Msg { return tickMsg(t) }),
-		)
+		// Force a full redraw on resize
+		return m, tea.ClearScreen
 	}
 	return m, nil
 }
 
 // View renders the UI
 func (m *Model) View() string {
+	defer m.notePanic()
 	// Handle very small terminal sizes gracefully
 	minWidth := 60
 	minHeight := 10
@@ -151,6 +736,7 @@ func (m *Model) View() string {
 	yellow := lipgloss.Color("226")
 	green := lipgloss.Color("10")
 	red := lipgloss.Color("9")
+	amber := lipgloss.Color("214")
 	gray := lipgloss.Color("240")
 	cyan := lipgloss.Color("14")
 
@@ -160,14 +746,16 @@ func (m *Model) View() string {
 		Foreground(lipgloss.Color("15")).
 		Padding(0, 1)
 
-	// build left column with task list
-	var leftLines []string
-	leftLines = append(leftLines, titleStyle.Render("Tasks"))
-	leftLines = append(leftLines, "")
+	// build left column with task list; each task is an entry of one or two lines
+	now := time.Now()
+	detailStyle := lipgloss.NewStyle().Foreground(gray)
+	detailIndent := strings.Repeat(" ", 4+m.icons.Width())
+	entries := make([][]string, len(m.tasks))
+	heights := make([]int, len(m.tasks))
 
 	for i, t := range m.tasks {
 		status := m.statuses[t]
-		icon := StatusIcon(status)
+		icon := m.icons.Padded(status)
 
 		// Color the icon based on status
 		var iconStyled string
@@ -177,7 +765,11 @@ func (m *Model) View() string {
 		case "done":
 			iconStyled = lipgloss.NewStyle().Foreground(green).Render(icon)
 		case "failed":
-			iconStyled = lipgloss.NewStyle().Foreground(red).Render(icon)
+			color := red
+			if m.details[t].Allowed {
+				color = amber
+			}
+			iconStyled = lipgloss.NewStyle().Foreground(color).Render(icon)
 		default:
 			iconStyled = lipgloss.NewStyle().Foreground(gray).Render(icon)
 		}
@@ -190,9 +782,24 @@ func (m *Model) View() string {
 			taskColor = cyan
 		}
 
-		taskStyled := lipgloss.NewStyle().Foreground(taskColor).Render(t)
-		line := fmt.Sprintf(" %s %s %s", iconStyled, prefix, taskStyled)
-		leftLines = append(leftLines, line)
+		taskStyled := lipgloss.NewStyle().Foreground(taskColor).Render(m.prefixes.Label(t))
+		if t == runner.SystemTask && m.unseen > 0 {
+			taskStyled += lipgloss.NewStyle().Foreground(yellow).Bold(true).Render(fmt.Sprintf(" (%d new)", m.unseen))
+		}
+		entry := []string{fmt.Sprintf(" %s %s %s", iconStyled, prefix, taskStyled)}
+
+		// Sub-state detail line for tasks that are waiting to run
+		if !m.compact {
+			detail := statusDetail(m.details[t], now)
+			if n := m.pending[t]; n >= catchingUpAt {
+				detail = fmt.Sprintf("…catching up (%s)", formatCount(n))
+			}
+			if detail != "" {
+				entry = append(entry, detailIndent+detailStyle.Render(detail))
+			}
+		}
+		entries[i] = entry
+		heights[i] = len(entry)
 	}
 
 	// Calculate how many task lines can fit in available height
@@ -202,38 +809,30 @@ func (m *Model) View() string {
 		availableTaskHeight = 3 // Minimum to show at least some tasks
 	}
 
-	// If we have more tasks than can fit, show tasks around the selected one
-	displayedLeftLines := leftLines
-	if len(leftLines) > availableTaskHeight+2 { // +2 for title and empty line
-		// Calculate window around selected task
-		// leftLines[0] = title, leftLines[1] = empty, leftLines[2+] = tasks
-		selectedLineIndex := m.selected + 2 // +2 to account for title and empty line
-
-		// Try to center the selected task in the view
-		halfWindow := availableTaskHeight / 2
-		startIdx := selectedLineIndex - halfWindow
-		if startIdx < 2 { // Don't go before title and empty line
-			startIdx = 2
-		}
-		endIdx := startIdx + availableTaskHeight
-		if endIdx > len(leftLines) {
-			endIdx = len(leftLines)
-			startIdx = endIdx - availableTaskHeight
-			if startIdx < 2 {
-				startIdx = 2
-			}
-		}
-
-		// Build display with title, then visible tasks
-		displayedLeftLines = []string{leftLines[0], leftLines[1]}
-		displayedLeftLines = append(displayedLeftLines, leftLines[startIdx:endIdx]...)
+	// Show the window of entries around the selected task that fits the height
+	startIdx, endIdx := visibleWindow(heights, m.selected, availableTaskHeight)
+	displayedLeftLines := []string{titleStyle.Render(m.title()), ""}
+	for _, entry := range entries[startIdx:endIdx] {
+		displayedLeftLines = append(displayedLeftLines, entry...)
 	}
 
 	left := strings.Join(displayedLeftLines, "\n")
 
 	// build right pane with recent logs
 	var rightLines []string
-	rightLines = append(rightLines, titleStyle.Render(fmt.Sprintf("Logs for %s", m.tasks[m.selected])))
+	title := fmt.Sprintf("Logs for %s", m.tasks[m.selected])
+	if m.correlation != nil {
+		title = fmt.Sprintf("All tasks matching %q", m.correlation.filter)
+	}
+	if m.describing {
+		title = fmt.Sprintf("Details of %s", m.tasks[m.selected])
+	} else if label := m.stream.label(); label != "" {
+		title += fmt.Sprintf(" [%s]", label)
+	}
+	rightLines = append(rightLines, titleStyle.Render(title))
+	if u, ok := runner.TaskUsage(m.tasks[m.selected]); ok && m.correlation == nil && runner.IsRunning(m.statuses[m.tasks[m.selected]]) {
+		rightLines[len(rightLines)-1] += lipgloss.NewStyle().Foreground(gray).Render(fmt.Sprintf("%d processes · %d fds", u.Processes, u.FDs))
+	}
 	rightLines = append(rightLines, "")
 
 	// Calculate available height for logs (total height - borders - padding - title - footer)
@@ -262,38 +861,73 @@ func (m *Model) View() string {
 		maxLineWidth = 10
 	}
 
-	if len(m.logs) == 0 {
-		rightLines = append(rightLines, lipgloss.NewStyle().Foreground(gray).Render("(no logs yet)"))
+	if m.describing {
+		lines := m.detailLines(m.tasks[m.selected], maxLineWidth)
+		m.detailOffset = max(min(m.detailOffset, len(lines)-availableHeight), 0)
+		end := min(m.detailOffset+availableHeight, len(lines))
+		rightLines = append(rightLines, lines[m.detailOffset:end]...)
+	} else if len(m.logs) == 0 {
+		empty := "(no logs yet)"
+		if waiting := m.waiting(m.tasks[m.selected], now); waiting != "" {
+			empty = waiting
+		}
+		rightLines = append(rightLines, lipgloss.NewStyle().Foreground(gray).Render(empty))
 	} else {
-		// Filter logs for selected task
+		// Filter logs for selected task and stream
 		selectedTask := m.tasks[m.selected]
+		source := m.logs[selectedTask]
+		if m.correlation != nil {
+			source = m.correlation.lines
+		}
 		var filteredLogs []string
-		for _, log := range m.logs {
-			// Check if log starts with [taskname]
-			if strings.HasPrefix(log, "["+selectedTask+"]") {
-				// Strip the prefix for cleaner display
-				cleaned := strings.TrimPrefix(log, "["+selectedTask+"] ")
-				filteredLogs = append(filteredLogs, cleaned)
+		var filteredStyles []lipgloss.Style
+		for _, ev := range source {
+			if m.stream.matches(ev) {
+				line := ev.Line
+				if ev.Message != "" {
+					line = ev.Message
+				}
+				line = paneLine(line)
+				if ev.Ref != "" {
+					line = "[" + ev.Ref + "] " + line
+				}
+				if m.markers {
+					mark := m.stdoutMark
+					if ev.IsErr {
+						mark = m.stderrMark
+					}
+					line = mark + " " + line
+				}
+				style := levelStyle(ev.Level)
+				if m.correlation != nil {
+					line = m.prefixes.Padded(ev.Task) + " " + line
+					style = m.taskStyle(ev.Task)
+				}
+				filteredLogs = append(filteredLogs, line)
+				filteredStyles = append(filteredStyles, style)
 			}
 		}
 
 		if len(filteredLogs) == 0 {
-			rightLines = append(rightLines, lipgloss.NewStyle().Foreground(gray).Render("(no logs for this task yet)"))
+			empty := "(no logs for this task yet)"
+			if m.correlation != nil {
+				empty = "(no matching lines yet)"
+			} else if label := m.stream.label(); label != "" {
+				empty = fmt.Sprintf("(no %s output for this task)", label)
+			} else if waiting := m.waiting(selectedTask, now); waiting != "" {
+				empty = waiting
+			}
+			rightLines = append(rightLines, lipgloss.NewStyle().Foreground(gray).Render(empty))
 		} else {
-			// Word wrap each log line to fit in the pane width
+			// Wrap each log line to fit in the pane width, counting display
+			// cells, keeping the style of the line so every wrapped piece is
+			// colored
 			var wrappedLogs []string
-			for _, line := range filteredLogs {
-				if len(line) <= maxLineWidth {
-					wrappedLogs = append(wrappedLogs, line)
-				} else {
-					// Wrap long lines
-					for len(line) > maxLineWidth {
-						wrappedLogs = append(wrappedLogs, line[:maxLineWidth])
-						line = line[maxLineWidth:]
-					}
-					if len(line) > 0 {
-						wrappedLogs = append(wrappedLogs, line)
-					}
+			var wrappedStyles []lipgloss.Style
+			for i, line := range filteredLogs {
+				for _, piece := range wrapLine(line, maxLineWidth) {
+					wrappedLogs = append(wrappedLogs, piece)
+					wrappedStyles = append(wrappedStyles, filteredStyles[i])
 				}
 			}
 
@@ -321,7 +955,9 @@ func (m *Model) View() string {
 			if end > len(wrappedLogs) {
 				end = len(wrappedLogs)
 			}
-			rightLines = append(rightLines, wrappedLogs[start:end]...)
+			for i := start; i < end; i++ {
+				rightLines = append(rightLines, wrappedStyles[i].Render(wrappedLogs[i]))
+			}
 		}
 	}
 
@@ -352,37 +988,106 @@ func (m *Model) View() string {
 
 	cols := lipgloss.JoinHorizontal(lipgloss.Top, leftStyle.Render(left), rightStyle.Render(right))
 
-	help := "q/esc: quit | ↑/↓: navigate tasks | PgUp/PgDn: scroll logs | Home/End: jump"
-	if m.interacting {
-		help = "Ctrl-z - Stop interacting"
+	help := "q/esc: quit | ↑/↓: navigate tasks | PgUp/PgDn: scroll logs | Home/End: jump | e: stdout/stderr | m: markers | c: compact | s: prun | d: details | r: restart | x: stop/start | i: input | T: trace"
+	if m.describing {
+		help = "esc/d: back to logs | ↑/↓, PgUp/PgDn: scroll | q: quit"
+	} else if m.correlation != nil {
+		help = "esc: back to task logs | T: change filter | PgUp/PgDn: scroll | e: stdout/stderr | m: markers | q: quit"
 	}
 
 	footer := lipgloss.NewStyle().Foreground(gray).Padding(0, 2).Render(help)
+	if m.prompt != nil {
+		footer = lipgloss.NewStyle().Foreground(yellow).Bold(true).Padding(0, 2).Render(m.promptLine())
+	}
+	if m.typing {
+		footer = lipgloss.NewStyle().Foreground(cyan).Padding(0, 2).Render("trace: " + m.input + "█  (enter: show matching lines of all tasks, esc: cancel)")
+	}
+	if m.interacting {
+		footer = lipgloss.NewStyle().Foreground(cyan).Padding(0, 2).Render("stdin: " + m.stdinLine + "█  (enter: send line, ctrl+d: end input, ctrl+z/esc: stop interacting)")
+	}
 
 	return cols + "\n" + footer
 }
 
-// Start starts the TUI and returns when it's finished. It accepts an events channel
-// which should receive runner.LogEvent values. It runs the TUI and returns any error.
-func Start(tasks []string, events <-chan runner.LogEvent) error {
+// EventSource provides the events emitted so far followed by live events
+type EventSource interface {
+	Subscribe() ([]runner.LogEvent, <-chan runner.LogEvent)
+}
+
+// Start starts the TUI and returns when it's finished. Events that happened
+// before the TUI attached are replayed from the source's snapshot first, so
+// nothing emitted during startup is lost. It returns the final view state.
+func Start(tasks []string, source EventSource, opts Options) (State, error) {
 	m := NewModel(tasks)
+	m.compact = opts.CompactList
+	m.noSpinner = opts.NoSpinner
+	if opts.Icons != nil {
+		m.icons = opts.Icons
+	}
+	m.prefixes = opts.Prefixes
+	m.groups = opts.Groups
+	m.resolve = opts.ResolveRestart
+	m.describe = opts.Describe
+	m.restartTask, m.stopTask, m.startTask = opts.RestartTask, opts.StopTask, opts.StartTask
+	m.sendInput, m.closeInput = opts.SendInput, opts.CloseInput
+	if opts.FPS > 0 {
+		m.tick = time.Second / time.Duration(opts.FPS)
+	}
+	m.markers = opts.ShowMarkers
+	m.stderrMark, m.stdoutMark = opts.StderrMarker, opts.StdoutMarker
+	if s := opts.Resume; s != nil {
+		for i, t := range tasks {
+			if t == s.Selected {
+				m.selected = i
+			}
+		}
+		m.compact = s.Compact
+		m.stream = streamFilter(s.Stream) % 3
+		m.markers = s.Markers
+	}
+
+	snapshot, events := source.Subscribe()
+	for _, ev := range snapshot {
+		m.Update(logMsg(ev))
+	}
+	m.ticking = 0 // ticks scheduled while replaying were dropped; Init schedules again
 
 	// Use alt screen mode for cleaner rendering and resize handling
-	p := tea.NewProgram(
-		m,
-		tea.WithAltScreen(),
-		tea.WithMouseCellMotion(),
-	)
+	programOpts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithMouseCellMotion()}
+	if opts.FPS > 0 {
+		programOpts = append(programOpts, tea.WithFPS(opts.FPS))
+	}
+	p := tea.NewProgram(m, programOpts...)
 
-	// feed events into the TUI
+	// feed events into the TUI, fairly across tasks
 	go func() {
-		for ev := range events {
-			p.Send(logMsg(ev))
-		}
+		defer runner.RecoverPanic()
+		newFeed().run(events, p.Send)
 	}()
 
-	if _, err := p.Run(); err != nil {
-		return err
+	if opts.Quit != nil {
+		go func() {
+			<-opts.Quit
+			p.Quit()
+		}()
+	}
+
+	program.Store(p)
+	_, err := p.Run()
+	program.Store(nil)
+	if m.panicked != nil {
+		return State{}, m.panicked
+	}
+	if errors.Is(err, tea.ErrProgramPanic) {
+		return State{}, &PanicError{Value: err}
+	}
+	if err != nil {
+		return State{}, err
 	}
-	return nil
+	return State{
+		Selected: m.tasks[m.selected],
+		Compact:  m.compact,
+		Stream:   int(m.stream),
+		Markers:  m.markers,
+	}, nil
 }
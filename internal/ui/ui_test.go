@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"slices"
+	"testing"
+
+	"prun/internal/runner"
+)
+
+func TestModelFollowsConfigReload(t *testing.T) {
+	m := NewModel([]string{"api", "web", "worker"})
+	m.apply(runner.LogEvent{Task: "web", Status: runner.StatusRunning})
+	m.apply(runner.LogEvent{Task: "web", Line: "listening"})
+	m.selected = 1 // web
+
+	m.apply(runner.LogEvent{Task: runner.SystemTask, Line: "Config reloaded: added db; removed api", Added: []string{"db"}, Removed: []string{"api"}})
+	if want := []string{"web", "worker", "db", runner.SystemTask}; !slices.Equal(m.tasks, want) {
+		t.Fatalf("tasks = %v, want %v", m.tasks, want)
+	}
+	if m.tasks[m.selected] != "web" {
+		t.Errorf("selected %s, want web still", m.tasks[m.selected])
+	}
+	if _, ok := m.statuses["api"]; ok {
+		t.Errorf("removed task api keeps a status")
+	}
+	if m.statuses["db"] != runner.StatusIdle {
+		t.Errorf("added task db is %q, want idle", m.statuses["db"])
+	}
+	if len(m.logs["web"]) != 1 {
+		t.Errorf("web has %d lines after the reload, want 1", len(m.logs["web"]))
+	}
+
+	// Removing the selected task selects the one in its place
+	m.apply(runner.LogEvent{Task: runner.SystemTask, Line: "Config reloaded: removed web", Removed: []string{"web"}})
+	if m.tasks[m.selected] != "worker" {
+		t.Errorf("selected %s after removing web, want worker", m.tasks[m.selected])
+	}
+	m.View()
+}
+
+func TestIdleModelTicksOncePerHeartbeat(t *testing.T) {
+	m := NewModel([]string{"api", "web"})
+	m.apply(runner.LogEvent{Task: "api", Status: runner.StatusRunning})
+
+	// The first line schedules a heartbeat for the usage of the running task
+	_, cmd := m.Update(logMsg(runner.LogEvent{Task: "api", Line: "listening"}))
+	if cmd == nil || m.ticking != heartbeat {
+		t.Fatalf("no heartbeat scheduled for a running task (ticking %s)", m.ticking)
+	}
+
+	for beat := range 5 {
+		// Events in between don't schedule more ticks
+		for i := range 20 {
+			if _, cmd := m.Update(logMsg(runner.LogEvent{Task: "api", Line: "request"})); cmd != nil {
+				t.Fatalf("heartbeat %d: event %d scheduled another tick", beat, i)
+			}
+		}
+		// A tick replaced by another is ignored
+		if _, cmd := m.Update(tickMsg(m.tickID - 1)); cmd != nil {
+			t.Fatalf("heartbeat %d: a stale tick scheduled another", beat)
+		}
+		// The tick itself schedules exactly the next one
+		if _, cmd := m.Update(tickMsg(m.tickID)); cmd == nil || m.ticking != heartbeat {
+			t.Fatalf("heartbeat %d: tick didn't schedule the next heartbeat (ticking %s)", beat, m.ticking)
+		}
+	}
+
+	// Once nothing runs, the last tick schedules nothing
+	m.apply(runner.LogEvent{Task: "api", Status: runner.StatusDone})
+	if _, cmd := m.Update(tickMsg(m.tickID)); cmd != nil || m.ticking != 0 {
+		t.Errorf("an idle model with nothing running kept ticking (ticking %s)", m.ticking)
+	}
+	if _, cmd := m.Update(logMsg(runner.LogEvent{Task: "web", Line: "late line"})); cmd != nil {
+		t.Errorf("a line of a task not selected scheduled a tick")
+	}
+}
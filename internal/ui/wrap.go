@@ -0,0 +1,39 @@
+package ui
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// tabWidth is how many spaces a tab in task output takes in the log pane
+const tabWidth = 4
+
+// paneLine readies a line of task output for the log pane. Escape sequences
+// are stripped, since the pane colors each line by its level and a color
+// left open would bleed into the borders. Of a line redrawn with carriage
+// returns, as progress bars do, only the last drawing is kept.
+func paneLine(line string) string {
+	line = ansi.Strip(strings.TrimSuffix(line, "\r"))
+	if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	line = strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabWidth))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// wrapLine breaks a line into pieces at most width cells wide. It breaks
+// between characters, never inside one, and counts wide characters such as
+// emoji and CJK as two cells.
+func wrapLine(line string, width int) []string {
+	if ansi.StringWidth(line) <= width {
+		return []string{line}
+	}
+	return strings.Split(ansi.Hardwrap(line, width, true), "\n")
+}
@@ -0,0 +1,266 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"prun/internal/runner"
+)
+
+//go:embed static
+var static embed.FS
+
+// EventSource provides a snapshot of past events followed by live ones
+type EventSource interface {
+	SubscribeDetachable() ([]runner.LogEvent, <-chan runner.LogEvent, func())
+	Snapshot() []runner.LogEvent
+}
+
+// Controller restarts, stops and starts tasks, as runner.Orchestrator does
+type Controller interface {
+	RestartTask(taskName string) error
+	StopTask(taskName string) error
+	StartTask(taskName string) error
+}
+
+// Server serves a supervision page for the running tasks. With a token and
+// a Controller, the page can also restart, stop and start them.
+type Server struct {
+	tasks   []string
+	source  EventSource
+	token   string
+	control Controller
+}
+
+// New creates a web UI server. If token is set, every request must carry it
+// as a bearer token or a ?token= query parameter.
+func New(tasks []string, source EventSource, token string) *Server {
+	return &Server{tasks: tasks, source: source, token: token}
+}
+
+// SetController lets the page restart, stop and start tasks through c. The
+// controls only work with a token, as anyone reaching the page could use
+// them otherwise.
+func (s *Server) SetController(c Controller) {
+	s.control = c
+}
+
+// Start listens on addr and serves in the background until ctx is cancelled.
+// Request contexts derive from ctx, so open event streams end with it.
+func (s *Server) Start(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:     s.Handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	return nil
+}
+
+// Handler returns the HTTP handler for the page and its API
+func (s *Server) Handler() http.Handler {
+	assets, _ := fs.Sub(static, "static")
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(assets))
+	mux.HandleFunc("GET /api/tasks", s.handleTasks)
+	mux.HandleFunc("GET /api/events", s.handleEvents)
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("POST /api/tasks/{task}/{action}", s.handleControl)
+	return s.authorize(mux)
+}
+
+// authorize rejects requests without the configured token
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.tasks)
+}
+
+// TaskStatus is one task's entry in the /api/status response
+type TaskStatus struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Allowed  bool   `json:"allowed,omitempty"` // failed, but the task has allow_failure
+
+	// The process group of a running task at its last sample, where the
+	// platform reports it
+	Processes int `json:"processes,omitempty"`
+	FDs       int `json:"fds,omitempty"`
+}
+
+// handleStatus reports the latest status of every task
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	latest := make(map[string]runner.LogEvent)
+	for _, ev := range s.source.Snapshot() {
+		if ev.IsStatus() {
+			latest[ev.Task] = ev
+		}
+	}
+
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, name := range s.tasks {
+		st := TaskStatus{Name: name, Status: runner.StatusIdle}
+		if ev, ok := latest[name]; ok {
+			st.Status = ev.Status
+			st.ExitCode = ev.ExitCode
+			st.Allowed = ev.Allowed
+		}
+		if u, ok := runner.TaskUsage(name); ok && runner.IsRunning(st.Status) {
+			st.Processes, st.FDs = u.Processes, u.FDs
+		}
+		statuses = append(statuses, st)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+// handleControl restarts, stops or starts a task, answering 204 once the
+// task was told to, or 409 with the reason it can't be
+func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
+	if s.control == nil || s.token == "" {
+		http.Error(w, "task controls need a [web] token", http.StatusForbidden)
+		return
+	}
+	var do func(string) error
+	switch r.PathValue("action") {
+	case "restart":
+		do = s.control.RestartTask
+	case "stop":
+		do = s.control.StopTask
+	case "start":
+		do = s.control.StartTask
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err := do(r.PathValue("task")); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// event is the JSON form of a runner.LogEvent sent to the page
+type event struct {
+	Task      string    `json:"task"`
+	Line      string    `json:"line,omitempty"`
+	IsErr     bool      `json:"err,omitempty"`
+	Time      time.Time `json:"time"`
+	Status    string    `json:"status,omitempty"`
+	ExitCode  int       `json:"exit_code,omitempty"`
+	Allowed   bool      `json:"allowed,omitempty"`
+	Survivors int       `json:"survivors,omitempty"`
+	Level     string    `json:"level,omitempty"`
+	LogFile   string    `json:"log_file,omitempty"`
+}
+
+func newEvent(ev runner.LogEvent) event {
+	line := ev.Line
+	if ev.Message != "" {
+		line = ev.Message
+	}
+	return event{
+		Task:      ev.Task,
+		Line:      line,
+		IsErr:     ev.IsErr,
+		Time:      ev.Time,
+		Status:    ev.Status,
+		ExitCode:  ev.ExitCode,
+		Allowed:   ev.Allowed,
+		Survivors: ev.Survivors,
+		Level:     ev.Level,
+		LogFile:   ev.LogFile,
+	}
+}
+
+// handleEvents streams the event snapshot and then live events as
+// server-sent events. When the stream ends because the client fell behind,
+// the browser reconnects and receives a fresh snapshot.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	snapshot, events, unsubscribe := s.source.SubscribeDetachable()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// A reset tells the page to drop what it has before the snapshot
+	fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	for _, ev := range snapshot {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev runner.LogEvent) error {
+	data, err := json.Marshal(newEvent(ev))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
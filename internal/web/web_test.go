@@ -0,0 +1,131 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"prun/internal/runner"
+)
+
+// fakeSource serves a fixed snapshot and counts subscriptions
+type fakeSource struct {
+	events     []runner.LogEvent
+	subscribed int
+}
+
+func (f *fakeSource) SubscribeDetachable() ([]runner.LogEvent, <-chan runner.LogEvent, func()) {
+	f.subscribed++
+	return f.events, make(chan runner.LogEvent), func() {}
+}
+
+func (f *fakeSource) Snapshot() []runner.LogEvent {
+	return f.events
+}
+
+// fakeControl records the controls used
+type fakeControl struct {
+	calls []string
+}
+
+func (f *fakeControl) RestartTask(name string) error { return f.do("restart " + name) }
+func (f *fakeControl) StopTask(name string) error    { return f.do("stop " + name) }
+func (f *fakeControl) StartTask(name string) error   { return f.do("start " + name) }
+
+func (f *fakeControl) do(call string) error {
+	f.calls = append(f.calls, call)
+	if call == "stop idle" {
+		return errors.New("task 'idle' is not running")
+	}
+	return nil
+}
+
+func request(t *testing.T, h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestControl(t *testing.T) {
+	control := &fakeControl{}
+	s := New([]string{"api", "idle"}, &fakeSource{}, "secret")
+	s.SetController(control)
+	h := s.Handler()
+
+	tests := []struct {
+		method, target, token string
+		code                  int
+	}{
+		{"POST", "/api/tasks/api/restart", "secret", http.StatusNoContent},
+		{"POST", "/api/tasks/api/stop", "secret", http.StatusNoContent},
+		{"POST", "/api/tasks/api/start", "secret", http.StatusNoContent},
+		{"POST", "/api/tasks/idle/stop", "secret", http.StatusConflict},
+		{"POST", "/api/tasks/api/kill", "secret", http.StatusNotFound},
+		{"POST", "/api/tasks/api/restart", "", http.StatusUnauthorized},
+		{"POST", "/api/tasks/api/restart", "wrong", http.StatusUnauthorized},
+		{"GET", "/api/tasks/api/restart", "secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := request(t, h, tt.method, tt.target, tt.token); rec.Code != tt.code {
+			t.Errorf("%s %s with token %q = %d, want %d", tt.method, tt.target, tt.token, rec.Code, tt.code)
+		}
+	}
+	want := []string{"restart api", "stop api", "start api", "stop idle"}
+	if len(control.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", control.calls, want)
+	}
+	for i := range want {
+		if control.calls[i] != want[i] {
+			t.Errorf("call %d = %q, want %q", i, control.calls[i], want[i])
+		}
+	}
+}
+
+func TestControlNeedsToken(t *testing.T) {
+	control := &fakeControl{}
+	s := New([]string{"api"}, &fakeSource{}, "")
+	s.SetController(control)
+	if rec := request(t, s.Handler(), "POST", "/api/tasks/api/restart", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("restart without a token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if len(control.calls) != 0 {
+		t.Errorf("controls used without a token: %v", control.calls)
+	}
+}
+
+func TestStatusReadsSnapshot(t *testing.T) {
+	source := &fakeSource{events: []runner.LogEvent{
+		{Task: "api", Status: runner.StatusRunning},
+		{Task: "api", Line: "listening"},
+		{Task: "job", Status: runner.StatusFailed, ExitCode: 2, Allowed: true},
+	}}
+	rec := request(t, New([]string{"api", "job", "web"}, source, "").Handler(), "GET", "/api/status", "")
+
+	var statuses []TaskStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	want := []TaskStatus{
+		{Name: "api", Status: runner.StatusRunning},
+		{Name: "job", Status: runner.StatusFailed, ExitCode: 2, Allowed: true},
+		{Name: "web", Status: runner.StatusIdle},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %+v, want %+v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("status %d = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+	if source.subscribed != 0 {
+		t.Errorf("status subscribed %d times to read a snapshot", source.subscribed)
+	}
+}
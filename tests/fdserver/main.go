@@ -0,0 +1,33 @@
+// fdserver is a test fixture for pass_fds: it serves one connection on the
+// listening socket inherited through the fd named by $LISTEN_FD, then exits.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+func main() {
+	fd, err := strconv.Atoi(os.Getenv("LISTEN_FD"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fdserver: LISTEN_FD is not set")
+		os.Exit(1)
+	}
+
+	ln, err := net.FileListener(os.NewFile(uintptr(fd), "listener"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fdserver: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("listening on", ln.Addr())
+
+	conn, err := ln.Accept()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fdserver: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(conn, "served on inherited fd %d\n", fd)
+	conn.Close()
+}
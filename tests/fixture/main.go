@@ -0,0 +1,109 @@
+// fixture is a scriptable task for the integration tests. Its flags replace
+// shell one-liners whose behavior varies between systems. In order, it waits
+// -delay, writes -touch, prints -text and -lines numbered lines, starts
+// -children copies of itself that sleep as long as it does, writes its own
+// and their pids to -pidfile, sleeps -sleep and exits with -exit, or with 0
+// once it has been restarted -exit-runs times. With -term-delay, SIGTERM makes it clean up
+// for that long and exit 0. With -echo, it first repeats each line read
+// from stdin as "got: <line>" until end of file, then prints "eof".
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	delay := flag.Duration("delay", 0, "wait this long before doing anything")
+	touch := flag.String("touch", "", "write this file")
+	text := flag.String("text", "", "print this line")
+	lines := flag.Int("lines", 0, "print this many numbered lines")
+	stderr := flag.Bool("stderr", false, "print the lines to stderr")
+	children := flag.Int("children", 0, "start this many sleeping copies of the fixture")
+	pidfile := flag.String("pidfile", "", "write the pids of the fixture and its children here")
+	ignore := flag.Bool("ignore-signals", false, "ignore SIGINT and SIGTERM")
+	sleep := flag.Duration("sleep", 0, "sleep this long before exiting")
+	exit := flag.Int("exit", 0, "exit code")
+	exitRuns := flag.Int("exit-runs", 0, "exit with -exit only on the first n runs, counted by PRUN_RESTART_COUNT")
+	termDelay := flag.Duration("term-delay", 0, "on SIGTERM, take this long to clean up and exit 0")
+	echo := flag.Bool("echo", false, "repeat the lines read from stdin until end of file")
+	flag.Parse()
+
+	if *ignore {
+		signal.Ignore(syscall.SIGINT, syscall.SIGTERM)
+	}
+	if *termDelay > 0 {
+		terms := make(chan os.Signal, 1)
+		signal.Notify(terms, syscall.SIGTERM)
+		go func() {
+			<-terms
+			fmt.Println("cleaning up")
+			time.Sleep(*termDelay)
+			fmt.Println("clean exit")
+			os.Exit(0)
+		}()
+	}
+	time.Sleep(*delay)
+
+	if *echo {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fmt.Println("got: " + scanner.Text())
+		}
+		fmt.Println("eof")
+	}
+
+	if *touch != "" {
+		if err := os.WriteFile(*touch, []byte(time.Now().String()), 0o644); err != nil {
+			fail(err)
+		}
+	}
+
+	out := os.Stdout
+	if *stderr {
+		out = os.Stderr
+	}
+	if *text != "" {
+		fmt.Fprintln(out, *text)
+	}
+	for i := 1; i <= *lines; i++ {
+		fmt.Fprintf(out, "line %d\n", i)
+	}
+
+	pids := []string{strconv.Itoa(os.Getpid())}
+	for range *children {
+		args := []string{"-sleep", sleep.String()}
+		if *ignore {
+			args = append(args, "-ignore-signals")
+		}
+		child := exec.Command(os.Args[0], args...)
+		if err := child.Start(); err != nil {
+			fail(err)
+		}
+		pids = append(pids, strconv.Itoa(child.Process.Pid))
+	}
+	if *pidfile != "" {
+		if err := os.WriteFile(*pidfile, []byte(strings.Join(pids, "\n")+"\n"), 0o644); err != nil {
+			fail(err)
+		}
+	}
+
+	time.Sleep(*sleep)
+	if restarts, _ := strconv.Atoi(os.Getenv("PRUN_RESTART_COUNT")); *exitRuns > 0 && restarts >= *exitRuns {
+		os.Exit(0)
+	}
+	os.Exit(*exit)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "fixture: %v\n", err)
+	os.Exit(1)
+}
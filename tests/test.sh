@@ -67,4 +67,1010 @@ else
 fi
 echo ""
 
+# Test 7: Shared log file
+echo "Test 7: Tasks sharing a log_file"
+rm -f /tmp/prun-shared.log
+"$PRUN" -c "$SCRIPT_DIR/shared-log.toml" > /dev/null 2>&1
+if [ "$(wc -l < /tmp/prun-shared.log)" -eq 400 ] && ! grep -vqE '^\[(alpha|beta)\] \1 line [0-9]+$' /tmp/prun-shared.log; then
+    echo "✓ Shared log file has whole, correctly attributed lines"
+else
+    echo "✗ Shared log file has torn or misattributed lines"
+    exit 1
+fi
+rm -rf /tmp/prun-rotate
+"$PRUN" -c "$SCRIPT_DIR/rotate-log.toml" > /dev/null 2>&1
+rotated=(/tmp/prun-rotate/shared.log /tmp/prun-rotate/shared.log.1 /tmp/prun-rotate/shared.log.2)
+if [ -s "${rotated[1]}" ] && [ -s "${rotated[2]}" ] && [ ! -e /tmp/prun-rotate/shared.log.3 ] &&
+    [ "$(cat "${rotated[@]}" | wc -c)" -le $((3 * 16384)) ] && ! cat "${rotated[@]}" | grep -vqE '^\[(alpha|beta)\] \1 line [0-9]+$' &&
+    grep -qh '^\[alpha\] alpha line 2000$' "${rotated[@]}" && grep -qh '^\[beta\] beta line 2000$' "${rotated[@]}"; then
+    echo "✓ The shared log file rotated between whole lines, keeping two backups"
+else
+    echo "✗ Shared log rotation tore lines or kept the wrong files:"
+    ls -l /tmp/prun-rotate
+    exit 1
+fi
+echo ""
+
+# Test 8: Instantly exiting tasks
+echo "Test 8: Output of 100 instantly exiting tasks"
+{
+    printf 'tasks = ['
+    for i in $(seq 1 100); do printf '"t%d",' "$i"; done
+    printf ']\n'
+    for i in $(seq 1 100); do printf '[task.t%d]\ncmd = "echo done-%d; echo err-%d >&2"\n' "$i" "$i" "$i"; done
+} > /tmp/prun-instant.toml
+"$PRUN" -c /tmp/prun-instant.toml > /tmp/prun-instant.txt 2>&1
+missing=0
+for i in $(seq 1 100); do
+    grep -qE "^\[t$i\] +done-$i$" /tmp/prun-instant.txt || missing=$((missing + 1))
+    grep -qE "^\[t$i\] +err-$i$" /tmp/prun-instant.txt || missing=$((missing + 1))
+done
+if [ "$missing" -eq 0 ]; then
+    echo "✓ Every instant task's output was captured"
+else
+    echo "✗ $missing lines missing from instant tasks"
+    exit 1
+fi
+echo ""
+
+# Test 9: Repeated task names
+echo "Test 9: Repeated task names run once"
+order=$("$PRUN" -c "$SCRIPT_DIR/sample.toml" --dry-run task3 task1 task3 2>/dev/null | cut -d: -f1 | tr '\n' ' ')
+config_order=$("$PRUN" -c "$SCRIPT_DIR/sample.toml" --dry-run --order config task3 task1 task3 2>/dev/null | cut -d: -f1 | tr '\n' ' ')
+if [ "$order" = "task3 task1 " ] && [ "$config_order" = "task1 task3 " ]; then
+    echo "✓ Duplicates dropped, order follows --order"
+else
+    echo "✗ Unexpected task order: '$order' / '$config_order'"
+    exit 1
+fi
+echo ""
+
+# Test 10: Draining process groups
+echo "Test 10: Tasks wait for processes left in their group"
+rm -f /tmp/prun-drain-grandchild
+"$PRUN" -v -c "$SCRIPT_DIR/drain.toml" > /tmp/prun-drain.txt 2>&1
+if [ -f /tmp/prun-drain-grandchild ] && grep -q "\[stuck\] killing 1 process(es)" /tmp/prun-drain.txt; then
+    echo "✓ Grandchildren were waited for, and killed after drain_timeout"
+else
+    echo "✗ Task finished before its process group was empty"
+    exit 1
+fi
+echo ""
+
+# Test 11: Inherited sockets and umask
+echo "Test 11: pass_fds and umask"
+(cd "$PROJECT_ROOT" && go build -o /tmp/prun-fdserver ./tests/fdserver)
+rm -f /tmp/prun-umask.txt
+"$PRUN" -c "$SCRIPT_DIR/pass-fds.toml" > /tmp/prun-fds.txt 2>&1 &
+prun_pid=$!
+reply=""
+for _ in $(seq 1 50); do
+    if { exec 3<>/dev/tcp/127.0.0.1/47123; } 2>/dev/null; then
+        reply=$(cat <&3)
+        exec 3<&-
+        break
+    fi
+    sleep 0.1
+done
+wait "$prun_pid" || true
+if [ "$reply" = "served on inherited fd 3" ] && [ "$(cat /tmp/prun-umask.txt)" = "0027" ]; then
+    echo "✓ Task served on the socket prun passed and ran with its umask"
+else
+    echo "✗ Unexpected reply '$reply' or umask '$(cat /tmp/prun-umask.txt 2>/dev/null)'"
+    exit 1
+fi
+echo ""
+
+# Test 12: Per-task debounce
+echo "Test 12: Churn in one task's directory doesn't delay another task's restart"
+rm -rf /tmp/prun-churn
+mkdir -p /tmp/prun-churn/a /tmp/prun-churn/b
+cat > /tmp/prun-churn/prun.toml <<'TOML'
+tasks = ["a", "b"]
+
+[task.a]
+cmd = "sleep 30"
+path = "/tmp/prun-churn/a"
+watch = true
+
+[task.b]
+cmd = "sleep 30"
+path = "/tmp/prun-churn/b"
+watch = true
+TOML
+"$PRUN" -v -c /tmp/prun-churn/prun.toml > /tmp/prun-churn.txt 2>&1 &
+prun_pid=$!
+sleep 0.5
+# a changes every 100ms throughout, b once
+for i in $(seq 1 25); do
+    echo "$i" > /tmp/prun-churn/a/file
+    [ "$i" -eq 5 ] && echo change > /tmp/prun-churn/b/file
+    sleep 0.1
+done
+kill -INT "$prun_pid"
+wait "$prun_pid" || true
+if grep -q "^\[b\] Restarting due to file change" /tmp/prun-churn.txt && ! grep -q "^\[a\] Restarting" /tmp/prun-churn.txt; then
+    echo "✓ Quiet task restarted while the churning one kept debouncing"
+else
+    echo "✗ Restarts did not follow each task's own changes"
+    exit 1
+fi
+echo ""
+
+# Test 13: Lifecycle lines
+echo "Test 13: Lifecycle lines match the golden output"
+{
+    LC_ALL=C.UTF-8 "$PRUN" -c "$SCRIPT_DIR/lifecycle.toml" ok 2>/dev/null || true
+    LC_ALL=C.UTF-8 "$PRUN" -c "$SCRIPT_DIR/lifecycle.toml" bad 2>&1 | grep -v '^\[prun\]' || true
+} | sed -E 's/\(pid [0-9]+\)/(pid N)/; s/ [0-9.]+s$/ Ns/' > /tmp/prun-lifecycle.txt
+quiet=$("$PRUN" --quiet -c "$SCRIPT_DIR/lifecycle.toml" ok 2>&1)
+ascii=$(LC_ALL=C "$PRUN" -c "$SCRIPT_DIR/lifecycle.toml" ok 2>&1 | tail -1)
+if diff -u "$SCRIPT_DIR/lifecycle.golden" /tmp/prun-lifecycle.txt && [ "$quiet" = "[ok] hi" ] && [[ "$ascii" == "[ok] ok finished in "* ]]; then
+    echo "✓ Lifecycle lines are stable, --quiet leaves them out, and a non-UTF-8 locale gets ASCII glyphs"
+else
+    echo "✗ Lifecycle lines differ from tests/lifecycle.golden"
+    exit 1
+fi
+echo ""
+
+# Test 14: NDJSON console output
+echo "Test 14: --log-format json prints one versioned record per line"
+json=$("$PRUN" --log-format json -c "$SCRIPT_DIR/lifecycle.toml" ok 2>&1)
+if [ "$(echo "$json" | wc -l)" -eq 3 ] && \
+   echo "$json" | grep -q '^{"v":1,.*"task":"ok","stream":"stdout","line":"hi","pid":[0-9]*,"run":1}$' && \
+   echo "$json" | grep -q '"status":"done",.*"exit_code":0'; then
+    echo "✓ Lines carry the pid and run of their process"
+else
+    echo "✗ Unexpected NDJSON output:"
+    echo "$json"
+    exit 1
+fi
+echo ""
+
+# Test 15: Profiling prun itself
+echo "Test 15: --profile-cpu writes CPU and heap profiles"
+rm -f /tmp/prun-cpu.pb.gz /tmp/prun-cpu.heap.pb.gz
+"$PRUN" --quiet --profile-cpu /tmp/prun-cpu.pb.gz -c "$SCRIPT_DIR/lifecycle.toml" ok > /dev/null 2>&1
+if [ -s /tmp/prun-cpu.pb.gz ] && [ -s /tmp/prun-cpu.heap.pb.gz ]; then
+    echo "✓ Both profiles were written and are non-empty"
+else
+    echo "✗ Missing or empty profile files"
+    exit 1
+fi
+echo ""
+
+# Test 16: A log file per run
+echo "Test 16: log_file_per_run keeps the latest runs behind current.log"
+rm -rf /tmp/prun-runs
+for n in 1 2 3; do "$PRUN" -e N=$n -c "$SCRIPT_DIR/per-run-log.toml" > /dev/null 2>&1; done
+if [ "$(ls /tmp/prun-runs/*T*.log | wc -l)" -eq 2 ] && [ "$(cat /tmp/prun-runs/current.log)" = "run 3" ]; then
+    echo "✓ Old runs were pruned and current.log holds the last one"
+else
+    echo "✗ Unexpected run log directory:"
+    ls -l /tmp/prun-runs
+    exit 1
+fi
+echo ""
+
+# Tests 17-19 script their tasks with the tests/fixture binary
+(cd "$PROJECT_ROOT" && go build -o /tmp/prun-fixture ./tests/fixture)
+
+# Test 17: Completion, failure and event order
+echo "Test 17: A failing task fails the run, and each task's events stay in order"
+set +e
+json=$("$PRUN" --log-format json -c "$SCRIPT_DIR/fixture.toml" 2>/dev/null)
+code=$?
+set -e
+order=$(echo "$json" | grep '"task":"ok"' | sed -E 's/.*"(status|line)":"([^"]*)".*/\2/' | tr '\n' ' ')
+if [ "$code" -ne 0 ] && [ "$order" = "running line 1 line 2 line 3 done " ] && \
+   echo "$json" | grep -q '"task":"bad","status":"failed".*"exit_code":4'; then
+    echo "✓ prun exited $code and ok reported: $order"
+else
+    echo "✗ Unexpected exit code $code or event order '$order'"
+    exit 1
+fi
+echo ""
+
+# Test 18: Cancellation
+echo "Test 18: Ctrl-C stops a task that ignores signals, with its children"
+rm -f /tmp/prun-fixture.pids
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" stubborn > /tmp/prun-stubborn.txt 2>&1 &
+prun_pid=$!
+for _ in $(seq 1 50); do
+    [ -s /tmp/prun-fixture.pids ] && break
+    sleep 0.1
+done
+kill -INT "$prun_pid"
+wait "$prun_pid" || true
+survivors=0
+for pid in $(cat /tmp/prun-fixture.pids); do
+    # Killed children may linger as zombies until init reaps them
+    state=$(ps -o stat= -p "$pid" 2>/dev/null || true)
+    [ -n "$state" ] && [ "${state#Z}" = "$state" ] && survivors=$((survivors + 1))
+done
+if [ "$(wc -l < /tmp/prun-fixture.pids)" -eq 3 ] && [ "$survivors" -eq 0 ] &&
+    grep -q '^\[stubborn\] killed, still running 1s after SIGTERM$' /tmp/prun-stubborn.txt; then
+    echo "✓ The task and both children are gone, killed after shutdown_timeout"
+else
+    echo "✗ $survivors of the task's processes survived prun"
+    cat /tmp/prun-stubborn.txt
+    exit 1
+fi
+echo ""
+
+# Test 19: Restart on change
+echo "Test 19: A file written into a watched directory restarts its task"
+rm -rf /tmp/prun-fixture-src
+mkdir -p /tmp/prun-fixture-src
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" watched toucher > /tmp/prun-fixture-watch.txt 2>&1 &
+prun_pid=$!
+for _ in $(seq 1 50); do
+    grep -q "restarted" /tmp/prun-fixture-watch.txt && break
+    sleep 0.1
+done
+kill -INT "$prun_pid"
+wait "$prun_pid" || true
+if grep -q "^\[watched\] .* restarted (#1, file change: .*change)" /tmp/prun-fixture-watch.txt && \
+   [ "$(grep -c '^\[watched\] line 1$' /tmp/prun-fixture-watch.txt)" -eq 2 ]; then
+    echo "✓ watched ran again after toucher's write"
+else
+    echo "✗ watched did not restart:"
+    cat /tmp/prun-fixture-watch.txt
+    exit 1
+fi
+echo ""
+
+# Test 20: Failure hints
+echo "Test 20: A familiar failure gets one hint after its exit line"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" missing > /tmp/prun-fixture-hint.txt 2>&1 || true
+if [ "$(grep -c '^\[missing\] hint: prun-no-such-command isn.t installed' /tmp/prun-fixture-hint.txt)" -eq 1 ] && \
+   grep -A1 '^\[missing\] .* exited 127' /tmp/prun-fixture-hint.txt | grep -q 'hint:'; then
+    echo "✓ The hint follows the exit line"
+else
+    echo "✗ No hint for the missing command:"
+    cat /tmp/prun-fixture-hint.txt
+    exit 1
+fi
+echo ""
+
+# Test 21: Whole lines
+echo "Test 21: Concurrent tasks and prun's own messages never tear a line"
+"$PRUN" -v -w -c "$SCRIPT_DIR/fixture.toml" flood1 flood2 > /tmp/prun-fixture-flood.txt 2>&1 &
+prun_pid=$!
+for _ in $(seq 1 50); do
+    [ "$(grep -c 'finished in' /tmp/prun-fixture-flood.txt)" -eq 2 ] && break
+    sleep 0.1
+done
+kill -INT "$prun_pid"
+wait "$prun_pid" || true
+torn=$(grep -cvE '^\[(flood[12]|prun)\] [^[]*$' /tmp/prun-fixture-flood.txt || true)
+if [ "$(grep -c '^\[flood[12]\] line ' /tmp/prun-fixture-flood.txt)" -eq 6000 ] && [ "$torn" -eq 0 ]; then
+    echo "✓ All 6000 lines arrived whole"
+else
+    echo "✗ $torn torn lines:"
+    grep -vE '^\[(flood[12]|prun)\] [^[]*$' /tmp/prun-fixture-flood.txt | head
+    exit 1
+fi
+echo ""
+
+# Test 22: Prefix width
+echo "Test 22: Long task names are shortened to unique, aligned prefixes"
+"$PRUN" --quiet -c "$SCRIPT_DIR/prefix.toml" | sort > /tmp/prun-prefix.txt
+if diff -u "$SCRIPT_DIR/prefix.golden" /tmp/prun-prefix.txt; then
+    echo "✓ Prefixes match tests/prefix.golden"
+else
+    echo "✗ Prefixes differ from tests/prefix.golden"
+    exit 1
+fi
+echo ""
+
+# Test 23: Sandbox
+echo "Test 23: A sandboxed task can only write to its write_paths"
+if ! unshare --user true 2>/dev/null; then
+    echo "- Skipped: user namespaces are unavailable here"
+else
+    rm -rf /tmp/prun-sandbox
+    mkdir -p /tmp/prun-sandbox/allowed
+    "$PRUN" -c "$SCRIPT_DIR/fixture.toml" confined > /tmp/prun-sandbox.txt 2>&1 || true
+    if [ -e /tmp/prun-sandbox/allowed/ok ] && [ ! -e /tmp/prun-sandbox/escaped ] && grep -q "read-only file system" /tmp/prun-sandbox.txt; then
+        echo "✓ The write inside allowed/ succeeded and the one outside failed"
+    else
+        echo "✗ The sandbox didn't confine the task's writes:"
+        cat /tmp/prun-sandbox.txt
+        exit 1
+    fi
+fi
+echo ""
+
+# Test 24: Web UI with and without a watcher
+echo "Test 24: --web serves statuses and the console still prints, with and without -w"
+for mode in "" "-w"; do
+    "$PRUN" $mode --web 127.0.0.1:17931 -c "$SCRIPT_DIR/fixture.toml" sleeper > /tmp/prun-web.txt 2>&1 &
+    prun_pid=$!
+    status=1
+    for _ in $(seq 1 50); do
+        "$PRUN" status --web 127.0.0.1:17931 > /tmp/prun-web-status.txt 2>&1 && grep -q '^\[sleeper\] line 1$' /tmp/prun-web.txt && status=0 && break
+        sleep 0.1
+    done
+    kill -INT "$prun_pid"
+    wait "$prun_pid" || true
+    if [ "$status" -eq 0 ] && grep -q "sleeper" /tmp/prun-web-status.txt && grep -q '^\[sleeper\] line 1$' /tmp/prun-web.txt; then
+        echo "✓ prun ${mode:-without -w} reported sleeper as running"
+    else
+        echo "✗ prun ${mode:-without -w} with --web:"
+        cat /tmp/prun-web-status.txt /tmp/prun-web.txt
+        exit 1
+    fi
+done
+echo ""
+
+# Test 25: Included configs
+echo "Test 25: remote_config tasks are namespaced and resolve against their own directory"
+"$PRUN" --quiet -c "$SCRIPT_DIR/remote.toml" > /tmp/prun-remote.txt 2>&1
+"$PRUN" --quiet -c "$SCRIPT_DIR/remote.toml" 'fx:*' > /tmp/prun-remote-glob.txt 2>&1
+if grep -q '^\[local\] *local local$' /tmp/prun-remote.txt &&
+    grep -q '^\[fx:where\] *where remote local remote-file$' /tmp/prun-remote.txt &&
+    grep -q '^\[fx:step\] *step done$' /tmp/prun-remote-glob.txt && ! grep -q '^\[local\]' /tmp/prun-remote-glob.txt; then
+    echo "✓ fx:where ran in tests/remote with its env_file, and fx:* selected only fx tasks"
+else
+    echo "✗ Included tasks ran wrong:"
+    cat /tmp/prun-remote.txt /tmp/prun-remote-glob.txt
+    exit 1
+fi
+echo ""
+
+# Test 26: Steps
+echo "Test 26: Steps run in order under their own prefix and stop at the failing one"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" stepped > /tmp/prun-steps.txt 2>&1 && status=0 || status=$?
+if [ "$status" -ne 0 ] &&
+    [ "$(grep -c '^\[stepped:1/3\] line 1$' /tmp/prun-steps.txt)" -eq 1 ] &&
+    [ "$(grep -c '^\[stepped:2/3\] line 1$' /tmp/prun-steps.txt)" -eq 2 ] &&
+    grep -q '^\[stepped:2/3\] .* exited 3 after' /tmp/prun-steps.txt &&
+    ! grep -q '^\[stepped:3/3\]' /tmp/prun-steps.txt; then
+    echo "✓ Step 2 ran twice, failed with exit code 3, and step 3 never ran"
+else
+    echo "✗ Steps ran wrong (exit $status):"
+    cat /tmp/prun-steps.txt
+    exit 1
+fi
+echo ""
+
+# Test 27: Summary after the TUI closes
+echo "Test 27: Quitting the TUI leaves a summary and the run's exit code"
+if ! command -v script > /dev/null; then
+    echo "- Skipped: script(1) is unavailable here"
+else
+    # Drive the TUI in a pseudo-terminal and keep what is printed after it
+    # leaves the alternate screen. TERM=screen spares the wait on the
+    # terminal's background color, which script never answers.
+    for tasks in "ok" "ok bad" "ok sleeper"; do
+        echo "\$ prun -i $tasks"
+        (sleep 2; printf q; sleep 1) |
+            TERM=screen NO_COLOR=1 LC_ALL=C.UTF-8 script -qfec "stty rows 30 cols 120; $PRUN -c $SCRIPT_DIR/fixture.toml -i --tui always $tasks; echo exit \$?" /dev/null |
+            awk 'BEGIN { RS = "\x1b\\[\\?1049l" } { last = $0 } END { printf "%s", last }' |
+            sed -E 's/\x1b\[[0-9;?]*[a-zA-Z]//g; s/\r$//; s/ [0-9.]+s$/ Ns/'
+    done > /tmp/prun-summary.txt
+    if diff -u "$SCRIPT_DIR/summary.golden" /tmp/prun-summary.txt; then
+        echo "✓ Summaries match tests/summary.golden"
+    else
+        echo "✗ Summaries differ from tests/summary.golden"
+        exit 1
+    fi
+fi
+echo ""
+
+# Test 28: Restart policy
+echo "Test 28: restart = \"on-failure\" reruns a crashing task until it succeeds"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" crasher > /tmp/prun-restart.txt 2>&1 && status=0 || status=$?
+printf '[task.x]\ncmd = "true"\nrestart = "sometimes"\n' > /tmp/prun-restart.toml
+"$PRUN" -c /tmp/prun-restart.toml > /tmp/prun-restart-invalid.txt 2>&1 && invalid=0 || invalid=$?
+if [ "$status" -eq 0 ] &&
+    [ "$(grep -c '^\[crasher\] line 1$' /tmp/prun-restart.txt)" -eq 3 ] &&
+    grep -q '^\[crasher\] .* restarted (#2, restart = on-failure)$' /tmp/prun-restart.txt &&
+    [ "$invalid" -ne 0 ] && grep -q "invalid restart 'sometimes'" /tmp/prun-restart-invalid.txt; then
+    echo "✓ crasher ran three times and exited 0, and an unknown policy is rejected"
+else
+    echo "✗ Restart policy misbehaved (exit $status):"
+    cat /tmp/prun-restart.txt /tmp/prun-restart-invalid.txt
+    exit 1
+fi
+echo ""
+
+# Test 29: Restart limit
+echo "Test 29: max_restarts gives up on a task that keeps crashing"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" crashloop > /tmp/prun-crashloop.txt 2>&1 && status=0 || status=$?
+if [ "$status" -ne 0 ] &&
+    [ "$(grep -c '^\[crashloop\] line 1$' /tmp/prun-crashloop.txt)" -eq 3 ] &&
+    grep -q '^\[crashloop\] exited with code 2, restarting in 0.5s (attempt 1/2)$' /tmp/prun-crashloop.txt &&
+    grep -q '^\[crashloop\] exited with code 2, restarting in 1s (attempt 2/2)$' /tmp/prun-crashloop.txt &&
+    grep -q '^\[crashloop\] giving up after 2 restarts$' /tmp/prun-crashloop.txt &&
+    ! "$PRUN" -c "$SCRIPT_DIR/fixture.toml" crashdelay > /tmp/prun-crashdelay.txt 2>&1 &&
+    [ "$(grep -c '^\[crashdelay\] exited with code 2, restarting in 0.2s (attempt [12]/2)$' /tmp/prun-crashdelay.txt)" -eq 2 ]; then
+    echo "✓ crashloop ran three times, backing off, then failed; crashdelay waited its restart_delay each time"
+else
+    echo "✗ max_restarts misbehaved (exit $status):"
+    cat /tmp/prun-crashloop.txt /tmp/prun-crashdelay.txt
+    exit 1
+fi
+echo ""
+
+# Test 30: Render files
+echo "Test 30: render entries write files from templates before tasks start"
+rm -rf /tmp/prun-render
+"$PRUN" -c "$SCRIPT_DIR/render.toml" > /tmp/prun-render.txt 2>&1
+"$PRUN" -c "$SCRIPT_DIR/render.toml" > /tmp/prun-render-again.txt 2>&1
+if [ "$(cat /tmp/prun-render/session.txt)" = "$(printf 'stage=dev\napi port=4100')" ] &&
+    [ "$(cat /tmp/prun-render/api.txt)" = "api http://localhost:4100" ] &&
+    grep -q '^\[prun\] rendered /tmp/prun-render/session.txt, /tmp/prun-render/api.txt$' /tmp/prun-render.txt &&
+    ! grep -q 'rendered' /tmp/prun-render-again.txt; then
+    echo "✓ Both files were rendered, and left alone when unchanged"
+else
+    echo "✗ Render files are wrong:"
+    cat /tmp/prun-render.txt /tmp/prun-render-again.txt /tmp/prun-render/*
+    exit 1
+fi
+echo ""
+
+# Test 31: Task dependencies
+echo "Test 31: depends_on starts a task after its dependency and skips it when that fails"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" server migrate > /tmp/prun-depends.txt 2>&1 && status=0 || status=$?
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" bad orphan > /tmp/prun-depends-failed.txt 2>&1 || true
+printf 'tasks = ["a", "b"]\n[task.a]\ncmd = "true"\ndepends_on = "b"\n[task.b]\ncmd = "true"\ndepends_on = "a"\n' > /tmp/prun-depends.toml
+"$PRUN" -c /tmp/prun-depends.toml > /tmp/prun-depends-cycle.txt 2>&1 || true
+migrated=$(grep -n '^\[migrate\] line 1$' /tmp/prun-depends.txt | cut -d: -f1)
+served=$(grep -n '^\[server\] *line 1$' /tmp/prun-depends.txt | cut -d: -f1)
+if [ "$status" -eq 0 ] && [ -n "$migrated" ] && [ -n "$served" ] && [ "$migrated" -lt "$served" ] &&
+    grep -q '^\[orphan\] .* skipped (dependency bad failed)$' /tmp/prun-depends-failed.txt &&
+    ! grep -q '^\[orphan\] line 1$' /tmp/prun-depends-failed.txt &&
+    grep -q 'task dependencies form a cycle: a → b → a' /tmp/prun-depends-cycle.txt; then
+    echo "✓ server waited for migrate, orphan was skipped, and a cycle is rejected"
+else
+    echo "✗ Dependencies misbehaved (exit $status):"
+    cat /tmp/prun-depends.txt /tmp/prun-depends-failed.txt /tmp/prun-depends-cycle.txt
+    exit 1
+fi
+echo ""
+
+# Test 32: shell = false
+echo "Test 32: shell = false runs the command directly, split like a shell would"
+direct=$("$PRUN" --quiet -c "$SCRIPT_DIR/fixture.toml" direct 2>&1)
+printf '[task.x]\ncmd = "echo \x27oops"\nshell = false\n' > /tmp/prun-direct.toml
+"$PRUN" -c /tmp/prun-direct.toml x > /tmp/prun-direct.txt 2>&1 && status=0 || status=$?
+if [ "$direct" = '[direct] two  spaces $HOME' ] && [ "$status" -ne 0 ] && grep -q "unterminated ' quote" /tmp/prun-direct.txt; then
+    echo "✓ Quotes grouped one argument, \$HOME was not expanded, and an unterminated quote is rejected"
+else
+    echo "✗ shell = false misbehaved: $direct"
+    cat /tmp/prun-direct.txt
+    exit 1
+fi
+echo ""
+
+# Test 33: Graceful shutdown
+echo "Test 33: Ctrl-C gives a task time to exit after SIGTERM"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" graceful > /tmp/prun-graceful.txt 2>&1 &
+prun_pid=$!
+for _ in $(seq 1 50); do
+    grep -q "line 1" /tmp/prun-graceful.txt && break
+    sleep 0.1
+done
+kill -INT "$prun_pid"
+wait "$prun_pid" || true
+if grep -q '^\[graceful\] clean exit$' /tmp/prun-graceful.txt && ! grep -q 'killed' /tmp/prun-graceful.txt; then
+    echo "✓ The task cleaned up and exited on its own"
+else
+    echo "✗ The task was not given time to exit:"
+    cat /tmp/prun-graceful.txt
+    exit 1
+fi
+echo ""
+
+# Test 34: allow_failure
+echo "Test 34: A task with allow_failure fails without failing the run"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" flaky after-flaky ok > /tmp/prun-allowed.txt 2>&1 && status=0 || status=$?
+if [ "$status" -eq 0 ] &&
+    [ "$(grep -c '^\[flaky\] *line 1$' /tmp/prun-allowed.txt)" -eq 2 ] &&
+    grep -q '^\[flaky\] .* exited 4 after .* (allowed)$' /tmp/prun-allowed.txt &&
+    grep -q '^\[after-flaky\] .* skipped (dependency flaky failed)$' /tmp/prun-allowed.txt &&
+    grep -q '^\[ok\] .* finished in' /tmp/prun-allowed.txt; then
+    echo "✓ flaky was restarted once, then failed (allowed); after-flaky was skipped and prun exited 0"
+else
+    echo "✗ allow_failure misbehaved (exit $status):"
+    cat /tmp/prun-allowed.txt
+    exit 1
+fi
+echo ""
+
+# Test 35: Watch paths and patterns
+echo "Test 35: watch_paths, watch_include and watch_exclude pick the files that restart a task"
+rm -rf /tmp/prun-fixture-picky
+mkdir -p /tmp/prun-fixture-picky/src
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" picky > /tmp/prun-picky.txt 2>&1 &
+prun_pid=$!
+for _ in $(seq 1 50); do
+    grep -q "line 1" /tmp/prun-picky.txt && break
+    sleep 0.1
+done
+sleep 0.5
+echo x > /tmp/prun-fixture-picky/main.go
+echo x > /tmp/prun-fixture-picky/src/notes.txt
+echo x > /tmp/prun-fixture-picky/src/main_test.go
+mkdir /tmp/prun-fixture-picky/src/pkg
+sleep 0.5
+echo x > /tmp/prun-fixture-picky/src/pkg/lib.go
+for _ in $(seq 1 50); do
+    grep -q "restarted" /tmp/prun-picky.txt && break
+    sleep 0.1
+done
+sleep 1
+kill -INT "$prun_pid"
+wait "$prun_pid" || true
+if [ "$(grep -c 'restarted' /tmp/prun-picky.txt)" -eq 1 ] &&
+    grep -q '^\[picky\] .* restarted (#1, file change: .*src/pkg/lib.go)' /tmp/prun-picky.txt; then
+    echo "✓ Only src/pkg/lib.go, in a directory created after start, restarted the task"
+else
+    echo "✗ Unexpected restarts:"
+    cat /tmp/prun-picky.txt
+    exit 1
+fi
+echo ""
+
+# Test 36: Auto-stop
+echo "Test 36: auto_stop_when_idle and auto_stop_after stop a task without failing it"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" idler capped > /tmp/prun-autostop.txt 2>&1 && status=0 || status=$?
+if [ "$status" -eq 0 ] &&
+    grep -q '^\[idler\] .* auto-stopped (no output for 0.5s)$' /tmp/prun-autostop.txt &&
+    grep -q '^\[capped\] .* auto-stopped (ran for 1s)$' /tmp/prun-autostop.txt; then
+    echo "✓ Both tasks were auto-stopped and prun exited 0"
+else
+    echo "✗ Auto-stop misbehaved (exit $status):"
+    cat /tmp/prun-autostop.txt
+    exit 1
+fi
+echo ""
+
+# Test 37: --log-dir
+echo "Test 37: --log-dir writes each task's stdout and stderr to <dir>/<task>.log"
+rm -rf /tmp/prun-logdir
+mkdir -p /tmp/prun-logdir
+echo "from a previous run" > /tmp/prun-logdir/ok.log
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" --log-dir /tmp/prun-logdir ok bad > /dev/null 2>&1 || true
+if [ "$(cat /tmp/prun-logdir/ok.log)" = "$(printf 'line 1\nline 2\nline 3')" ] &&
+    [ "$(cat /tmp/prun-logdir/bad.log)" = "line 1" ]; then
+    echo "✓ Each task has its own log file, emptied when prun started"
+else
+    echo "✗ --log-dir files are wrong:"
+    tail -n +1 /tmp/prun-logdir/*.log
+    exit 1
+fi
+echo ""
+
+# Test 38: SIGQUIT under the TUI
+echo "Test 38: SIGQUIT under the TUI saves goroutine stacks to .prun and keeps running"
+if ! command -v script > /dev/null; then
+    echo "- Skipped: script(1) is unavailable here"
+else
+    rm -rf "$SCRIPT_DIR/.prun" /tmp/prun-quit.pid
+    (sleep 2; kill -QUIT "$(cat /tmp/prun-quit.pid)"; sleep 1; printf q; sleep 1) |
+        TERM=screen NO_COLOR=1 LC_ALL=C.UTF-8 script -qfec "stty rows 30 cols 120; sh -c 'echo \$\$ > /tmp/prun-quit.pid; exec $PRUN -c $SCRIPT_DIR/fixture.toml -i --tui always sleeper'; echo exit \$?" /dev/null > /tmp/prun-quit.txt
+    if grep -q "exit 0" /tmp/prun-quit.txt && grep -qs "^goroutine " "$SCRIPT_DIR"/.prun/goroutines-*.log; then
+        echo "✓ Stacks were saved and the TUI quit normally"
+    else
+        echo "✗ SIGQUIT was not handled:"
+        ls "$SCRIPT_DIR/.prun" 2> /dev/null
+        tail -c 500 /tmp/prun-quit.txt
+        exit 1
+    fi
+    rm -rf "$SCRIPT_DIR/.prun"
+fi
+echo ""
+
+# Test 39: --timestamps, --no-prefix and --color
+echo "Test 39: --timestamps, --no-prefix and --color shape console lines"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" --timestamps --no-prefix ok > /tmp/prun-stamps.txt 2>&1
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" --color always ok bad > /tmp/prun-color.txt 2>&1 || true
+if grep -qE '^[0-9]{2}:[0-9]{2}:[0-9]{2}\.[0-9]{3} line 1$' /tmp/prun-stamps.txt &&
+    ! grep -q '\[ok\]' /tmp/prun-stamps.txt &&
+    grep -q "$(printf '\033\[2mline 1\033\[0m')" /tmp/prun-color.txt; then
+    echo "✓ Lines carry the time without a prefix, and forced colors dim stderr"
+else
+    echo "✗ Console lines are wrong:"
+    cat -v /tmp/prun-stamps.txt /tmp/prun-color.txt
+    exit 1
+fi
+echo ""
+
+# Test 40: [groups] and --exclude
+echo "Test 40: a group runs its tasks, nested groups included, less those excluded"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" --exclude bad nested > /tmp/prun-groups.txt 2>&1
+status=$?
+if [ $status -eq 0 ] && grep -q '^\[ok\] ' /tmp/prun-groups.txt &&
+    grep -q '^\[direct\] ' /tmp/prun-groups.txt && ! grep -q '\[bad\]' /tmp/prun-groups.txt &&
+    ! grep -q "repeated" /tmp/prun-groups.txt; then
+    echo "✓ ok and direct ran once each, bad was left out"
+else
+    echo "✗ Groups misbehaved (exit $status):"
+    cat /tmp/prun-groups.txt
+    exit 1
+fi
+echo ""
+
+# Test 41: typing into a task from the TUI
+echo "Test 41: interact mode sends typed lines to the selected task's stdin"
+if ! command -v script > /dev/null; then
+    echo "- Skipped: script(1) is unavailable here"
+else
+    (sleep 1.5; printf i; sleep 0.3; printf hello; sleep 0.3; printf '\r'; sleep 0.5; printf '\004'; sleep 1; printf q; sleep 1) |
+        TERM=screen NO_COLOR=1 LC_ALL=C.UTF-8 script -qfec "stty rows 30 cols 120; $PRUN -c $SCRIPT_DIR/fixture.toml -i --tui always echoer; echo exit \$?" /dev/null > /tmp/prun-input.txt
+    if grep -q "exit 0" /tmp/prun-input.txt && grep -aq "got: hello" /tmp/prun-input.txt && grep -aq "eof" /tmp/prun-input.txt; then
+        echo "✓ The line reached the task, and ctrl+d ended its input"
+    else
+        echo "✗ Input didn't reach the task:"
+        tail -c 500 /tmp/prun-input.txt
+        exit 1
+    fi
+fi
+echo ""
+
+# Test 42: prun watch-plan --explain
+echo "Test 42: watch-plan --explain gives the watcher's verdict on a path"
+mkdir -p /tmp/prun-fixture-picky/src
+explain() {
+    "$PRUN" -c "$SCRIPT_DIR/fixture.toml" watch-plan --explain "/tmp/prun-fixture-picky/$1" picky 2>&1
+}
+if explain src/pkg/lib.go | grep -q "restarts.*matches watch_include pattern" &&
+    explain src/main_test.go | grep -q "no .*matches watch_exclude pattern" &&
+    explain src/notes.txt | grep -q "no .*matches no watch_include pattern" &&
+    explain src/.cache/x.go | grep -q "no .*a directory watching skips" &&
+    explain main.go | grep -q "no .*outside the directories it watches" &&
+    ! explain main.go > /dev/null; then
+    echo "✓ Each path got the verdict the watcher would reach"
+else
+    echo "✗ Wrong verdicts:"
+    for f in src/pkg/lib.go src/main_test.go src/notes.txt src/.cache/x.go main.go; do explain "$f"; done
+    exit 1
+fi
+echo ""
+
+# Test 43: --continue-on-error
+echo "Test 43: --continue-on-error runs every task and exits with the failure's code"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" --continue-on-error ok bad orphan > /tmp/prun-continue.txt 2>&1 && status=0 || status=$?
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" --continue-on-error ok bad stepped > /tmp/prun-continue2.txt 2>&1 && status2=0 || status2=$?
+if [ "$status" -eq 4 ] && [ "$status2" -eq 1 ] &&
+    grep -qE '^\[prun\] +ok +succeeded +0$' /tmp/prun-continue.txt &&
+    grep -qE '^\[prun\] +bad +failed +4$' /tmp/prun-continue.txt &&
+    grep -qE '^\[prun\] +orphan +cancelled +-$' /tmp/prun-continue.txt &&
+    grep -qE '^\[prun\] +stepped +failed +3$' /tmp/prun-continue2.txt &&
+    grep -q '2 tasks failed: bad, stepped' /tmp/prun-continue2.txt; then
+    echo "✓ One failure exits 4 with a result per task, two exit 1"
+else
+    echo "✗ --continue-on-error misbehaved (exit $status, then $status2):"
+    cat /tmp/prun-continue.txt /tmp/prun-continue2.txt
+    exit 1
+fi
+echo ""
+
+# Test 44: timeout
+echo "Test 44: a task running past its timeout is killed and fails"
+"$PRUN" -c "$SCRIPT_DIR/fixture.toml" hanger > /tmp/prun-timeout.txt 2>&1 && status=0 || status=$?
+if [ "$status" -eq 1 ] &&
+    [ "$(grep -c '^\[hanger\] line 1$' /tmp/prun-timeout.txt)" -eq 1 ] &&
+    grep -q '^\[hanger\] timed out after 1s$' /tmp/prun-timeout.txt &&
+    grep -q '^\[hanger\] not restarting, timed out$' /tmp/prun-timeout.txt; then
+    echo "✓ hanger was killed after 1s and not restarted"
+else
+    echo "✗ timeout misbehaved (exit $status):"
+    cat /tmp/prun-timeout.txt
+    exit 1
+fi
+echo ""
+
+# Test 45: wide characters and colors in the TUI's log pane
+echo "Test 45: the TUI wraps long lines between characters and strips colors"
+if ! command -v script > /dev/null; then
+    echo "- Skipped: script(1) is unavailable here"
+else
+    (sleep 2; printf q; sleep 1) |
+        TERM=screen NO_COLOR=1 LC_ALL=C.UTF-8 script -qfec "stty rows 30 cols 120; $PRUN -c $SCRIPT_DIR/fixture.toml -i --tui always wide; echo exit \$?" /dev/null > /tmp/prun-wide.txt
+    if grep -q "exit 0" /tmp/prun-wide.txt && grep -aq "red éé" /tmp/prun-wide.txt &&
+        iconv -f UTF-8 -t UTF-8 /tmp/prun-wide.txt > /dev/null 2>&1 &&
+        ! grep -aq "$(printf '\033\[31m')" /tmp/prun-wide.txt; then
+        echo "✓ Every wrapped piece is valid UTF-8, without the task's escape codes"
+    else
+        echo "✗ The line was cut mid-character or kept its colors:"
+        tail -c 500 /tmp/prun-wide.txt
+        exit 1
+    fi
+fi
+echo ""
+
+# Test 46: ${VAR} interpolation
+echo "Test 46: \${VAR} in cmd and env resolves from the environment, [env] and env files"
+"$PRUN" --quiet -c "$SCRIPT_DIR/interpolate.toml" > /tmp/prun-interpolate.txt 2>&1
+GREETING=hi TASK_PORT=5000 "$PRUN" --quiet -c "$SCRIPT_DIR/interpolate.toml" > /tmp/prun-interpolate-env.txt 2>&1
+printf '[task.x]\ncmd = "echo ${PRUN_TEST_UNDEFINED}"\n' > /tmp/prun-interpolate.toml
+"$PRUN" -c /tmp/prun-interpolate.toml > /tmp/prun-interpolate-undefined.txt 2>&1 && status=0 || status=$?
+if grep -q '^\[literal\] *hello \$HOME http://localhost:4000$' /tmp/prun-interpolate.txt &&
+    grep -q '^\[port\] *port 4000$' /tmp/prun-interpolate.txt &&
+    grep -q '^\[literal\] *hi \$HOME http://localhost:4000$' /tmp/prun-interpolate-env.txt &&
+    grep -q '^\[port\] *port 5000$' /tmp/prun-interpolate-env.txt &&
+    [ "$status" -ne 0 ] && grep -q 'undefined variable \${PRUN_TEST_UNDEFINED}' /tmp/prun-interpolate-undefined.txt; then
+    echo "✓ Defaults, \$\$ and env files resolved, and an undefined variable is an error"
+else
+    echo "✗ Interpolation misbehaved:"
+    cat /tmp/prun-interpolate.txt /tmp/prun-interpolate-env.txt /tmp/prun-interpolate-undefined.txt
+    exit 1
+fi
+echo ""
+
+# Test 47: sessions
+echo "Test 47: a saved session replays its flags, config and tasks"
+rm -rf /tmp/prun-session
+mkdir -p /tmp/prun-session
+printf 'tasks = ["show"]\n[task.show]\ncmd = "echo A=$A B=$B"\n[task.gone]\ncmd = "echo gone"\n[task.extra]\ncmd = "echo extra"\n' > /tmp/prun-session/tasks.toml
+(
+    cd /tmp/prun-session
+    "$PRUN" -c tasks.toml --quiet -e A=1 -e B=2 --exclude extra --kill-timeout 3s --fps 7 --order config session save dev show gone extra
+    "$PRUN" session list
+    "$PRUN" -e B=3 @dev
+    sed -i.bak 's/task.gone/task.renamed/' tasks.toml
+    "$PRUN" session run dev --quiet=false
+) > /tmp/prun-session.txt 2>&1 && status=0 || status=$?
+if [ "$status" -eq 0 ] &&
+    grep -q '^@dev  prun -c tasks.toml -e A=1 -e B=2 --exclude extra --fps 7 --kill-timeout 3s --order config --quiet show gone extra$' /tmp/prun-session.txt &&
+    grep -q '^\[show\] A=1 B=3$' /tmp/prun-session.txt &&
+    grep -q "^\[prun\] session 'dev': skipping task 'gone' not defined in config$" /tmp/prun-session.txt &&
+    grep -q '^\[show\] A=1 B=2$' /tmp/prun-session.txt &&
+    ! grep -q '^\[extra\]' /tmp/prun-session.txt && grep -q '^\[show\] .* finished in' /tmp/prun-session.txt; then
+    echo "✓ Every flag type round-tripped, and the replay's own flags and a renamed task were handled"
+else
+    echo "✗ Sessions misbehaved (exit $status):"
+    cat /tmp/prun-session.txt
+    exit 1
+fi
+echo ""
+
+# Test 48: bare $VAR in env values
+echo "Test 48: env values expand bare \$VAR references, to each other too"
+USER_NAME=ada "$PRUN" --quiet -c "$SCRIPT_DIR/expand.toml" > /tmp/prun-expand.txt 2>&1
+USER_NAME=ada "$PRUN" -v -c "$SCRIPT_DIR/expand.toml" > /tmp/prun-expand-verbose.txt 2>&1
+printf '[task.x]\ncmd = "true"\nenv = { A = "$B", B = "x$A" }\n' > /tmp/prun-expand.toml
+"$PRUN" -c /tmp/prun-expand.toml > /tmp/prun-expand-loop.txt 2>&1 && status=0 || status=$?
+if grep -q '^\[api\] *url=http://localhost:4000 port=4000 user=ada missing=\[\]$' /tmp/prun-expand.txt &&
+    ! grep -q 'PRUN_TEST_UNDEFINED' /tmp/prun-expand.txt &&
+    grep -q "task 'api' env MISSING: \$PRUN_TEST_UNDEFINED is undefined" /tmp/prun-expand-verbose.txt &&
+    [ "$status" -ne 0 ] && grep -q 'loop: A → B → A' /tmp/prun-expand-loop.txt; then
+    echo "✓ References resolved, an undefined one was empty and noted with -v, and a loop is an error"
+else
+    echo "✗ Env expansion misbehaved:"
+    cat /tmp/prun-expand.txt /tmp/prun-expand-verbose.txt /tmp/prun-expand-loop.txt
+    exit 1
+fi
+echo ""
+
+# Test 49: profiles
+echo "Test 49: -p runs a profile's tasks, narrowed by the tasks named"
+printf 'tasks = ["app"]\n[profiles]\ndev = ["app", "api"]\ntest = ["backend", "runner"]\n[groups]\nbackend = ["db", "api"]\n[task.app]\ncmd = "echo app"\n[task.api]\ncmd = "echo api"\n[task.db]\ncmd = "echo db"\n[task.runner]\ncmd = "echo runner"\n' > /tmp/prun-profiles.toml
+"$PRUN" --quiet -c /tmp/prun-profiles.toml -p test > /tmp/prun-profiles.txt 2>&1
+"$PRUN" --quiet -c /tmp/prun-profiles.toml --profile dev api > /tmp/prun-profiles-narrow.txt 2>&1
+"$PRUN" -c /tmp/prun-profiles.toml -p dev runner > /tmp/prun-profiles-outside.txt 2>&1 && outside=0 || outside=$?
+"$PRUN" -c /tmp/prun-profiles.toml -p e2e > /tmp/prun-profiles-unknown.txt 2>&1 && unknown=0 || unknown=$?
+"$PRUN" -c /tmp/prun-profiles.toml -l > /tmp/prun-profiles-list.txt 2>&1
+"$PRUN" -c /tmp/prun-profiles.toml -l -p test > /tmp/prun-profiles-list-test.txt 2>&1
+if [ "$(grep -c '^\[' /tmp/prun-profiles.txt)" -eq 3 ] && grep -q '^\[db\] *db$' /tmp/prun-profiles.txt &&
+    grep -q '^\[api\] *api$' /tmp/prun-profiles.txt && grep -q '^\[runner\] *runner$' /tmp/prun-profiles.txt &&
+    [ "$(cat /tmp/prun-profiles-narrow.txt)" = "[api] api" ] &&
+    [ "$outside" -ne 0 ] && grep -q "task 'runner' is not in profile 'dev' (app, api)" /tmp/prun-profiles-outside.txt &&
+    [ "$unknown" -ne 0 ] && grep -q "unknown profile 'e2e' (available: dev, test)" /tmp/prun-profiles-unknown.txt &&
+    grep -q '^  dev: app, api$' /tmp/prun-profiles-list.txt && grep -q '^  test: backend, runner$' /tmp/prun-profiles-list.txt &&
+    grep -q '^Tasks of profile test:$' /tmp/prun-profiles-list-test.txt &&
+    [ "$(grep -c '^  [a-z]*: echo' /tmp/prun-profiles-list-test.txt)" -eq 3 ] && ! grep -q '^Profiles:' /tmp/prun-profiles-list-test.txt; then
+    echo "✓ Profiles select, narrow, list and reject unknown names and tasks outside them"
+else
+    echo "✗ Profiles misbehaved:"
+    cat /tmp/prun-profiles*.txt
+    exit 1
+fi
+echo ""
+
+# Test 50: rescan
+# A write through a hard link outside the watched tree changes a watched
+# file without fsnotify reporting it, as when its queue overflows
+echo "Test 50: [watch] rescan restarts a task for a change fsnotify missed"
+rm -rf /tmp/prun-rescan
+mkdir -p /tmp/prun-rescan/src /tmp/prun-rescan/outside
+echo one > /tmp/prun-rescan/src/app.txt
+ln /tmp/prun-rescan/src/app.txt /tmp/prun-rescan/outside/app.txt
+printf 'tasks = ["app"]\n[watch]\nrescan = "1s"\n[task.app]\ncmd = "cat src/app.txt; sleep 30"\nwatch = true\nwatch_paths = ["src"]\n' > /tmp/prun-rescan/prun.toml
+(cd /tmp/prun-rescan && exec "$PRUN" -w > /tmp/prun-rescan.txt 2>&1) &
+RESCAN_PID=$!
+sleep 2
+echo two >> /tmp/prun-rescan/outside/app.txt
+for _ in $(seq 1 40); do
+    grep -q '^\[app\] two$' /tmp/prun-rescan.txt && break
+    sleep 0.25
+done
+kill -INT "$RESCAN_PID" 2>/dev/null || true
+wait "$RESCAN_PID" 2>/dev/null || true
+if grep -q '^\[prun\] detected missed changes under src/ (rescan)$' /tmp/prun-rescan.txt &&
+    grep -q 'restarted (#1, file change: .*app.txt)' /tmp/prun-rescan.txt && grep -q '^\[app\] two$' /tmp/prun-rescan.txt; then
+    echo "✓ The missed change was found and restarted the task"
+else
+    echo "✗ Rescan didn't catch the missed change:"
+    cat /tmp/prun-rescan.txt
+    exit 1
+fi
+echo ""
+
+# Test 51: blocked terminal
+echo "Test 51: A terminal that stops reading truncates output instead of blocking the task"
+rm -f /tmp/prun-blocked.log /tmp/prun-blocked-done /tmp/prun-blocked-exit
+printf 'tasks = ["flood"]\n[task.flood]\ncmd = "seq 1 200000; date +%%s > /tmp/prun-blocked-done"\nlog_file = "/tmp/prun-blocked.log"\n' > /tmp/prun-blocked.toml
+# A reader back before prun gives up gets the marker and the last line
+"$PRUN" --quiet -c /tmp/prun-blocked.toml | { sleep 1.5; cat > /tmp/prun-blocked.txt; }
+if [ "$(wc -l < /tmp/prun-blocked.log)" -eq 200000 ] &&
+    grep -q '^\[flood\] \[… output truncated while terminal was blocked …\]$' /tmp/prun-blocked.txt &&
+    grep -q '^\[flood\] 1$' /tmp/prun-blocked.txt && grep -q '^\[flood\] 200000$' /tmp/prun-blocked.txt; then
+    echo "✓ The reader got the start, a marker and the end, and the log file got every line"
+else
+    echo "✗ Output was lost:"
+    wc -l /tmp/prun-blocked.log /tmp/prun-blocked.txt
+    grep -c 'truncated' /tmp/prun-blocked.txt
+    exit 1
+fi
+# A reader that stays away holds up neither the task nor prun's exit
+started=$(date +%s)
+{ "$PRUN" --quiet -c /tmp/prun-blocked.toml; date +%s > /tmp/prun-blocked-exit; } | { sleep 8; cat > /tmp/prun-blocked.txt; }
+done_at=$(cat /tmp/prun-blocked-done)
+exit_at=$(cat /tmp/prun-blocked-exit)
+if [ $((done_at - started)) -le 4 ] && [ $((exit_at - started)) -le 7 ] &&
+    grep -q '^\[flood\] 1$' /tmp/prun-blocked.txt; then
+    echo "✓ The task finished in $((done_at - started))s and prun exited in $((exit_at - started))s while the reader slept"
+else
+    echo "✗ The task or prun waited for the terminal (done after $((done_at - started))s, exited after $((exit_at - started))s):"
+    wc -l /tmp/prun-blocked.txt
+    exit 1
+fi
+echo ""
+
+# Test 52: rename, remove and new directories
+echo "Test 52: Renames, removals and files in new directories restart watched tasks"
+rm -rf /tmp/prun-renames
+mkdir -p /tmp/prun-renames/src
+echo one > /tmp/prun-renames/src/app.txt
+echo one > /tmp/prun-renames/src/old.txt
+printf 'tasks = ["app"]\n[watch]\nrescan = "0"\n[task.app]\ncmd = "echo started; sleep 30"\nwatch = true\nwatch_paths = ["src"]\n' > /tmp/prun-renames/prun.toml
+(cd /tmp/prun-renames && exec "$PRUN" -w --watch-events > /tmp/prun-renames.txt 2>&1) &
+RENAMES_PID=$!
+wait_restarts() {
+    for _ in $(seq 1 40); do
+        [ "$(grep -c 'restarted (#' /tmp/prun-renames.txt)" -ge "$1" ] && return 0
+        sleep 0.25
+    done
+    return 1
+}
+sleep 1
+# A file renamed out of the tree, which is only a RENAME
+mv /tmp/prun-renames/src/app.txt /tmp/prun-renames/app.txt
+wait_restarts 1 && sleep 1
+# A file removed
+rm /tmp/prun-renames/src/old.txt
+wait_restarts 2 && sleep 1
+# A file in a directory created after prun started
+mkdir -p /tmp/prun-renames/src/gen/deep
+wait_restarts 3 && sleep 1
+echo three > /tmp/prun-renames/src/gen/deep/out.txt
+wait_restarts 4 && sleep 1
+rm -rf /tmp/prun-renames/src/gen
+wait_restarts 5
+kill -INT "$RENAMES_PID" 2>/dev/null || true
+wait "$RENAMES_PID" 2>/dev/null || true
+if grep -q 'restarted (#1, file change: .*app.txt)' /tmp/prun-renames.txt &&
+    grep -q 'restarted (#2, file change: .*old.txt)' /tmp/prun-renames.txt &&
+    grep -q 'restarted (#4, file change: .*out.txt)' /tmp/prun-renames.txt &&
+    grep -q 'restarted (#5, ' /tmp/prun-renames.txt &&
+    grep -q 'stopped watching removed directory src/gen$' /tmp/prun-renames.txt; then
+    echo "✓ A rename, a removal and a file in a new directory each restarted the task, and the removed directories were unwatched"
+else
+    echo "✗ Watching missed renames, removals or new directories:"
+    cat /tmp/prun-renames.txt
+    exit 1
+fi
+echo ""
+
+# Test 53: ready checks
+echo "Test 53: wait_for waits for a ready check, and a task not ready in time fails"
+LC_ALL=C.UTF-8 "$PRUN" -c "$SCRIPT_DIR/ready.toml" > /tmp/prun-ready.txt 2>&1
+ready_line=$(grep -n '^\[db\] *● ready after ' /tmp/prun-ready.txt | cut -d: -f1)
+api_line=$(grep -n '^\[api\] *api up$' /tmp/prun-ready.txt | cut -d: -f1)
+ready_db=$(grep -n '^\[db\] *database system is ready$' /tmp/prun-ready.txt | cut -d: -f1)
+set +e
+LC_ALL=C.UTF-8 "$PRUN" -c "$SCRIPT_DIR/ready.toml" stuck after > /tmp/prun-not-ready.txt 2>&1
+stuck_code=$?
+set -e
+if [ -n "$ready_line" ] && [ -n "$api_line" ] && [ "$ready_db" -lt "$ready_line" ] && [ "$ready_line" -lt "$api_line" ] &&
+    [ "$stuck_code" -ne 0 ] && grep -q '^\[stuck\] *not ready within 1s$' /tmp/prun-not-ready.txt &&
+    grep -q '^\[after\] *■ skipped (dependency stuck failed)$' /tmp/prun-not-ready.txt &&
+    ! grep -q 'should not run' /tmp/prun-not-ready.txt; then
+    echo "✓ api started once db printed its ready line, and stuck failed after its timeout"
+else
+    echo "✗ Ready checks didn't hold tasks back or time out:"
+    cat /tmp/prun-ready.txt /tmp/prun-not-ready.txt
+    exit 1
+fi
+echo ""
+
+# Test 54: log files inside the watched tree
+echo "Test 54: A watched task writing its log inside its own path doesn't restart itself"
+rm -rf /tmp/prun-selflog
+mkdir -p /tmp/prun-selflog/app
+printf 'tasks = ["a", "b", "c"]\n[watch]\nrescan = "1s"\n[task.a]\ncmd = "while true; do echo tick; sleep 0.2; done"\npath = "app"\nwatch = true\nlog_file = "app/logs/a.log"\n[task.b]\ncmd = "while true; do echo tock; sleep 0.2; done"\npath = "app"\nwatch = true\nlog_file = "app/runs"\nlog_file_per_run = true\n[task.c]\ncmd = "while true; do echo tack; sleep 0.2; done"\npath = "app"\nwatch = true\n' > /tmp/prun-selflog/prun.toml
+(cd /tmp/prun-selflog && exec "$PRUN" --log-dir app/all > /tmp/prun-selflog.txt 2>&1) &
+SELFLOG_PID=$!
+sleep 4
+kill -INT "$SELFLOG_PID" 2>/dev/null || true
+wait "$SELFLOG_PID" 2>/dev/null || true
+if grep -q '^\[a\] *tick$' /tmp/prun-selflog.txt && [ -s /tmp/prun-selflog/app/logs/a.log ] && [ -s /tmp/prun-selflog/app/runs/current.log ] &&
+    [ -s /tmp/prun-selflog/app/all/c.log ] && ! grep -q 'restarted' /tmp/prun-selflog.txt; then
+    echo "✓ log_file, log_file_per_run and --log-dir writes under the watched path restarted nothing"
+else
+    echo "✗ A task restarted for its own log output:"
+    grep 'restarted' /tmp/prun-selflog.txt | head
+    exit 1
+fi
+echo ""
+
+# Test 55: the output of a {task:name} reference
+echo "Test 55: A referenced task's lines carry its own name and see --env and --log-dir"
+rm -rf /tmp/prun-taskref-logs
+"$PRUN" -c "$SCRIPT_DIR/taskref.toml" -e MODE=release --log-dir /tmp/prun-taskref-logs > /tmp/prun-taskref.txt 2>&1
+json=$("$PRUN" -c "$SCRIPT_DIR/taskref.toml" --log-format json 2>&1)
+if grep -q '^\[build\] *building release$' /tmp/prun-taskref.txt && grep -q '^\[build\] *warning$' /tmp/prun-taskref.txt &&
+    grep -q '^\[app\] *app up$' /tmp/prun-taskref.txt && ! grep -q '\[app\] *\[build\]' /tmp/prun-taskref.txt &&
+    grep -q '^building release$' /tmp/prun-taskref-logs/build.log &&
+    echo "$json" | grep -q '"task":"build","stream":"stderr","line":"warning".*"via":"app"'; then
+    echo "✓ build's lines came under [build] once, with MODE from -e and its own --log-dir file"
+else
+    echo "✗ A referenced task's output was misrouted:"
+    cat /tmp/prun-taskref.txt
+    echo "$json"
+    exit 1
+fi
+echo ""
+
+# Test 56: a panic of prun itself, set off by the PRUN_TEST_PANIC hook that
+# only builds tagged pruntest have
+echo "Test 56: A panic exits 5, saves .prun/panic-*.log and gives the terminal back"
+rm -rf /tmp/prun-panic
+mkdir -p /tmp/prun-panic
+(cd "$PROJECT_ROOT" && go build -tags pruntest -o /tmp/prun-panic/prun ./cmd/prun)
+printf 'tasks = ["wait", "boom"]\n[task.wait]\ncmd = "sleep 1"\n[task.boom]\ncmd = "echo never"\ndepends_on = ["wait"]\n' > /tmp/prun-panic/prun.toml
+set +e
+(cd /tmp/prun-panic && PRUN_TEST_PANIC=boom exec ./prun) > /tmp/prun-panic.txt 2>&1
+panic_code=$?
+set -e
+panic_logs=$(ls /tmp/prun-panic/.prun/panic-*.log 2>/dev/null | wc -l)
+if [ "$panic_code" -ne 5 ] || [ "$panic_logs" -ne 1 ] || ! grep -q 'panic requested by PRUN_TEST_PANIC' /tmp/prun-panic/.prun/panic-*.log ||
+    ! grep -q '^prun: panic saved to ' /tmp/prun-panic.txt || grep -q never /tmp/prun-panic.txt; then
+    echo "✗ A panic didn't exit 5 with a saved log (exit $panic_code):"
+    cat /tmp/prun-panic.txt
+    exit 1
+fi
+# Under the TUI, the alternate screen is left before the panic is printed
+if command -v script > /dev/null 2>&1; then
+    rm -rf /tmp/prun-panic/.prun /tmp/prun-panic.tty
+    set +e
+    (cd /tmp/prun-panic && TERM=xterm script -qec "env PRUN_TEST_PANIC=boom ./prun -i" /tmp/prun-panic.tty > /dev/null 2>&1)
+    panic_code=$?
+    set -e
+    left=$(grep -abo $'\e\\[?1049l' /tmp/prun-panic.tty | head -1 | cut -d: -f1)
+    printed=$(grep -abo 'prun: panic:' /tmp/prun-panic.tty | head -1 | cut -d: -f1)
+    if [ "$panic_code" -ne 5 ] || [ -z "$left" ] || [ -z "$printed" ] || [ "$left" -gt "$printed" ] ||
+        ! ls /tmp/prun-panic/.prun/panic-*.log > /dev/null 2>&1; then
+        echo "✗ The TUI kept the terminal through a panic (exit $panic_code)"
+        exit 1
+    fi
+fi
+echo "✓ prun exited 5 with .prun/panic-*.log, after leaving the TUI's screen"
+echo ""
+
 echo "=== All tests passed! ==="